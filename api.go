@@ -0,0 +1,819 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+)
+
+// apiServer is the JSON API of the daemon, it manages the torrents of a
+// session
+//
+//	GET    /api/torrents                list every torrent
+//	POST   /api/torrents                add a torrent, see add
+//	GET    /api/torrents/{hash}         torrent details with files and peers
+//	POST   /api/torrents/{hash}/pause   stop downloading, keeping the data
+//	POST   /api/torrents/{hash}/resume  start downloading again
+//	PUT    /api/torrents/{hash}/files   select and prioritize files
+//	PUT    /api/torrents/{hash}/limits  download limit of a torrent
+//	DELETE /api/torrents/{hash}         remove, ?delete=true deletes the data
+//	GET    /api/limits                  download limit of every torrent
+//	PUT    /api/limits                  change them, see limitsJSON
+//	GET    /api/schedule                what the schedule is doing
+//	GET    /api/events                  stream events as JSON lines
+//	POST   /transmission/rpc            Transmission's RPC, see transmissionRPC
+//	GET    /metrics                     Prometheus metrics of the session
+//	GET    /                            the web dashboard
+//
+// {hash} is the hex info hash, or a prefix of it matching a single torrent.
+// JSON bodies must be sent as application/json, and requests that change
+// something are refused when a browser sends them from another site, see
+// sameOrigin. Every request must be sent to an allowed host, see allowHosts,
+// and carry the token when one is set, see requireToken
+type apiServer struct {
+	session   *bittorrent.Session
+	schedule  *scheduler   // nil without a schedule
+	outDir    string       // where torrents are downloaded unless told otherwise
+	uploadDir string       // where uploaded torrent files are kept
+	dirs      []string     // see apiConfig
+	fetch     *http.Client // fetches torrent files for torrent-add
+
+	rpcSessionID string // see transmissionRPC
+
+	mut       sync.Mutex
+	uploads   map[[20]byte]string // uploaded torrent files, deleted on remove
+	rpcIDs    map[[20]byte]int    // the numeric IDs of the Transmission RPC
+	nextRPCID int
+}
+
+// apiConfig configures the API of the daemon
+type apiConfig struct {
+	OutDir    string // where torrents are downloaded unless told otherwise
+	UploadDir string // where uploaded torrent files are kept
+	// Dirs are the directories torrent files can be added from by path and
+	// downloaded into, along with OutDir
+	Dirs []string
+	// Token is required of every request when set, see requireToken
+	Token string
+	// Hosts are the host names requests can be sent to besides localhost and
+	// IP addresses, see allowHosts
+	Hosts []string
+	// FetchPrivate lets torrent-add fetch torrent files from private
+	// addresses, see newFetchClient
+	FetchPrivate bool
+}
+
+func newAPIServer(session *bittorrent.Session, schedule *scheduler, config apiConfig) http.Handler {
+	s := &apiServer{
+		session:   session,
+		schedule:  schedule,
+		outDir:    config.OutDir,
+		uploadDir: config.UploadDir,
+		dirs:      append([]string{config.OutDir}, config.Dirs...),
+		fetch:     newFetchClient(config.FetchPrivate),
+		uploads:   map[[20]byte]string{},
+
+		rpcSessionID: newRPCSessionID(),
+		rpcIDs:       map[[20]byte]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/torrents", s.list)
+	mux.HandleFunc("POST /api/torrents", s.add)
+	mux.HandleFunc("GET /api/torrents/{hash}", s.get)
+	mux.HandleFunc("POST /api/torrents/{hash}/pause", s.pause)
+	mux.HandleFunc("POST /api/torrents/{hash}/resume", s.resume)
+	mux.HandleFunc("PUT /api/torrents/{hash}/files", s.selectFiles)
+	mux.HandleFunc("PUT /api/torrents/{hash}/limits", s.setTorrentLimits)
+	mux.HandleFunc("DELETE /api/torrents/{hash}", s.remove)
+	mux.HandleFunc("GET /api/limits", s.limits)
+	mux.HandleFunc("PUT /api/limits", s.setLimits)
+	mux.HandleFunc("GET /api/schedule", s.scheduleStatus)
+	mux.HandleFunc("GET /api/events", s.events)
+	mux.HandleFunc("/transmission/rpc", s.transmissionRPC)
+	mux.Handle("GET /metrics", session.Metrics())
+	mux.Handle("/", webUI())
+	return allowHosts(config.Hosts, requireToken(config.Token, sameOrigin(mux)))
+}
+
+// allowHosts refuses requests sent to a host other than localhost, an IP
+// address or one of hosts. A web page could otherwise point its own domain
+// at 127.0.0.1 and read the API as a page of the same origin
+func allowHosts(hosts []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hostAllowed(r.Host, hosts) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not allowed, see -api-host", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostAllowed reports whether host, a Host header, is localhost, an IP
+// address or one of hosts. Names under localhost never leave the machine
+func hostAllowed(host string, hosts []string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return false
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return true
+	}
+	for _, allowed := range hosts {
+		if strings.EqualFold(host, strings.TrimSuffix(allowed, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireToken refuses requests without token as a bearer token or as the
+// password of basic auth, which browsers ask for on the dashboard and
+// Transmission clients send. An empty token lets every request through
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, given, ok = r.BasicAuth()
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="bittorrent-client-go"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin refuses requests that change something when a browser sent them
+// from a page of another site. Any web page could otherwise drive a daemon on
+// localhost with forms, which browsers send across sites without asking.
+// Clients other than browsers send neither header and are let through
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		site := r.Header.Get("Sec-Fetch-Site")
+		if site != "" && site != "same-origin" && site != "none" {
+			writeError(w, http.StatusForbidden, errors.New("cross-site requests are not allowed"))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, fmt.Errorf("requests from origin %s are not allowed", origin))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSON decodes a JSON request body into v, which must be sent as
+// application/json so browsers can't send it across sites as a simple form
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("expected an application/json body"))
+		return false
+	}
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return false
+	}
+	return true
+}
+
+// torrentJSON is the status of a torrent, the details are only included for
+// a single torrent
+type torrentJSON struct {
+	InfoHash     string     `json:"info_hash"`
+	Name         string     `json:"name"`
+	State        string     `json:"state"`
+	Error        string     `json:"error,omitempty"`
+	OutDir       string     `json:"out_dir"`
+	Size         int64      `json:"size"`      // bytes of the selected files
+	Completed    int64      `json:"completed"` // verified bytes of the selected files
+	Progress     float64    `json:"progress"`  // 0 to 1
+	Downloaded   int64      `json:"downloaded"`
+	DownloadRate float64    `json:"download_rate"` // bytes per second
+	ETA          int64      `json:"eta"`           // seconds, -1 when unknown
+	Peers        int        `json:"peers"`
+	Pieces       int        `json:"pieces"`
+	Limits       limitsJSON `json:"limits"`
+
+	Files    []fileJSON `json:"files,omitempty"`
+	PeerList []peerJSON `json:"peer_list,omitempty"`
+	PieceMap string     `json:"piece_map,omitempty"` // a 0 or 1 per piece
+	Trackers []string   `json:"trackers,omitempty"`
+}
+
+type fileJSON struct {
+	Index     int    `json:"index"`
+	Path      string `json:"path"`
+	Length    int64  `json:"length"`
+	Completed int64  `json:"completed"`
+	Priority  string `json:"priority"`
+}
+
+type peerJSON struct {
+	Addr         string  `json:"addr"`
+	Kind         string  `json:"kind"`
+	Client       string  `json:"client,omitempty"`
+	Downloaded   int64   `json:"downloaded"`
+	DownloadRate float64 `json:"download_rate"`
+}
+
+// torrentStatus returns the status of a torrent, with details if asked
+func torrentStatus(t *bittorrent.Torrent, details bool) torrentJSON {
+	infoHash := t.InfoHash()
+	state, err := t.State()
+	status := torrentJSON{
+		InfoHash: hex.EncodeToString(infoHash[:]),
+		Name:     t.Name(),
+		State:    state.String(),
+		OutDir:   t.OutDir(),
+		ETA:      -1,
+	}
+	status.Limits.Download = t.DownloadLimit()
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	d := t.Download()
+	if d == nil {
+		return status
+	}
+	stats := d.Stats()
+	status.Size = stats.WantedBytes
+	status.Completed = stats.VerifiedBytes
+	if stats.WantedBytes > 0 {
+		status.Progress = float64(stats.VerifiedBytes) / float64(stats.WantedBytes)
+	}
+	status.Downloaded = stats.Downloaded
+	status.DownloadRate = stats.DownloadRate
+	if eta, ok := stats.ETA(); ok {
+		status.ETA = int64(eta.Seconds())
+	}
+	status.Peers = len(stats.Peers)
+	status.Pieces = len(stats.Pieces)
+	if !details {
+		return status
+	}
+
+	for i, f := range stats.Files {
+		if f.Padding {
+			continue
+		}
+		status.Files = append(status.Files, fileJSON{
+			Index:     i,
+			Path:      filepath.ToSlash(f.Path),
+			Length:    f.Length,
+			Completed: f.Completed,
+			Priority:  f.Priority.String(),
+		})
+	}
+	for _, p := range stats.Peers {
+		status.PeerList = append(status.PeerList, peerJSON{
+			Addr:         p.Addr,
+			Kind:         p.Kind.String(),
+			Client:       p.Client,
+			Downloaded:   p.Downloaded,
+			DownloadRate: p.DownloadRate,
+		})
+	}
+	var pieceMap strings.Builder
+	for _, have := range stats.Pieces {
+		if have {
+			pieceMap.WriteByte('1')
+		} else {
+			pieceMap.WriteByte('0')
+		}
+	}
+	status.PieceMap = pieceMap.String()
+	status.Trackers = d.Torrent.TrackerURLs
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// torrent looks up the torrent of the {hash} path value
+func (s *apiServer) torrent(w http.ResponseWriter, r *http.Request) (*bittorrent.Torrent, bool) {
+	prefix := strings.ToLower(r.PathValue("hash"))
+	var found *bittorrent.Torrent
+	for _, t := range s.session.Torrents() {
+		infoHash := t.InfoHash()
+		if !strings.HasPrefix(hex.EncodeToString(infoHash[:]), prefix) {
+			continue
+		}
+		if found != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("info hash prefix %q matches more than one torrent", prefix))
+			return nil, false
+		}
+		found = t
+	}
+	if found == nil || prefix == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no torrent with info hash %q", prefix))
+		return nil, false
+	}
+	return found, true
+}
+
+func (s *apiServer) list(w http.ResponseWriter, r *http.Request) {
+	torrents := []torrentJSON{}
+	for _, t := range s.session.Torrents() {
+		torrents = append(torrents, torrentStatus(t, false))
+	}
+	writeJSON(w, http.StatusOK, torrents)
+}
+
+func (s *apiServer) get(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, torrentStatus(t, true))
+}
+
+// addRequest adds a torrent by magnet link or by the path of a torrent file
+// on the daemon's machine. The path and out_dir must be in the directories of
+// apiConfig
+type addRequest struct {
+	Magnet string `json:"magnet,omitempty"`
+	Path   string `json:"path,omitempty"`
+	OutDir string `json:"out_dir,omitempty"`
+}
+
+// add adds a torrent from a JSON addRequest, a multipart form with a
+// `torrent` file field and an optional `out_dir` field, or a raw
+// application/x-bittorrent body with an optional out_dir query parameter
+func (s *apiServer) add(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+
+	var source, outDir string
+	var uploaded bool
+	switch mediaType {
+	case "multipart/form-data":
+		file, _, err := r.FormFile("torrent")
+		if err != nil {
+			writeUploadError(w, fmt.Errorf("reading torrent field: %w", err), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		outDir = r.FormValue("out_dir")
+		if !s.checkOutDir(w, outDir) {
+			return
+		}
+		source, err = s.saveUpload(file)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		uploaded = true
+	case "application/x-bittorrent":
+		outDir = r.URL.Query().Get("out_dir")
+		if !s.checkOutDir(w, outDir) {
+			return
+		}
+		var err error
+		source, err = s.saveUpload(r.Body)
+		if err != nil {
+			writeUploadError(w, err, http.StatusInternalServerError)
+			return
+		}
+		uploaded = true
+	default:
+		var req addRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		switch {
+		case req.Magnet != "" && req.Path == "":
+			if !strings.HasPrefix(req.Magnet, "magnet:") {
+				writeError(w, http.StatusBadRequest, errors.New("magnet must be a magnet link"))
+				return
+			}
+			source = req.Magnet
+		case req.Path != "" && req.Magnet == "":
+			// torrent files are read with environment variables expanded
+			if strings.Contains(req.Path, "$") {
+				writeError(w, http.StatusBadRequest, errors.New("path can't contain $"))
+				return
+			}
+			path, err := s.inDirs(req.Path)
+			if err != nil {
+				writeError(w, http.StatusForbidden, err)
+				return
+			}
+			source = path
+		default:
+			writeError(w, http.StatusBadRequest, errors.New("one of magnet or path is required"))
+			return
+		}
+		outDir = req.OutDir
+		if !s.checkOutDir(w, outDir) {
+			return
+		}
+	}
+
+	if outDir == "" {
+		outDir = s.outDir
+	}
+	t, err := s.addTorrent(source, outDir, uploaded)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, bittorrent.ErrTorrentExists) {
+			code = http.StatusConflict
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, torrentStatus(t, false))
+}
+
+// addTorrent adds a torrent to the session, an uploaded torrent file is
+// deleted when the torrent is removed, or right away if it can't be added
+func (s *apiServer) addTorrent(source, outDir string, uploaded bool) (*bittorrent.Torrent, error) {
+	t, err := s.session.Add(source, outDir)
+	if err != nil {
+		if uploaded {
+			os.Remove(source)
+		}
+		return nil, err
+	}
+	if uploaded {
+		s.mut.Lock()
+		s.uploads[t.InfoHash()] = source
+		s.mut.Unlock()
+	}
+	return t, nil
+}
+
+// checkOutDir answers with an error unless outDir is empty, for the default
+// one, or in the directories of apiConfig
+func (s *apiServer) checkOutDir(w http.ResponseWriter, outDir string) bool {
+	if outDir == "" {
+		return true
+	}
+	_, err := s.inDirs(outDir)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
+// inDirs returns the absolute path of path if it's in one of the directories
+// of apiConfig, once the symlinks of its existing part are resolved
+func (s *apiServer) inDirs(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved := resolveExisting(abs)
+	for _, dir := range s.dirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(resolveExisting(dir), resolved)
+		if err == nil && filepath.IsLocal(rel) {
+			return abs, nil
+		}
+	}
+	return "", fmt.Errorf("%s isn't in the directories the API can use, see -allow-dir", path)
+}
+
+// resolveExisting resolves the symlinks of the longest part of an absolute
+// path that exists, the rest is kept as it is
+func resolveExisting(path string) string {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, rest...)...)
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// maxUpload limits the size of the requests uploading torrent files
+const maxUpload = 16 << 20
+
+// writeUploadError answers with err, with 413 if the upload was too large and
+// otherwise code
+func writeUploadError(w http.ResponseWriter, err error, code int) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("uploads can't be larger than %d bytes", maxUpload))
+		return
+	}
+	writeError(w, code, err)
+}
+
+// saveUpload writes an uploaded torrent file to the upload directory, the
+// session reads torrents from disk. The request body is limited to maxUpload
+func (s *apiServer) saveUpload(r io.Reader) (string, error) {
+	err := os.MkdirAll(s.uploadDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("creating upload directory: %w", err)
+	}
+	f, err := os.CreateTemp(s.uploadDir, "upload-*.torrent")
+	if err != nil {
+		return "", fmt.Errorf("saving upload: %w", err)
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("saving upload: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (s *apiServer) pause(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	t.Pause()
+	writeJSON(w, http.StatusOK, torrentStatus(t, false))
+}
+
+func (s *apiServer) resume(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	t.Resume()
+	writeJSON(w, http.StatusOK, torrentStatus(t, false))
+}
+
+// filesRequest selects the files matching Select, every file when empty, and
+// prioritizes the files matching High or Low. Patterns are indexes or globs
+// like the -file, -high and -low flags
+type filesRequest struct {
+	Select     []string `json:"select"`
+	High       []string `json:"high"`
+	Low        []string `json:"low"`
+	Sequential *bool    `json:"sequential,omitempty"`
+}
+
+func (s *apiServer) selectFiles(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	var req filesRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	d := t.Download()
+	if d == nil {
+		writeError(w, http.StatusConflict, errors.New("the torrent's files aren't known until it has connected"))
+		return
+	}
+
+	err := d.SelectFiles(req.Select...)
+	if err == nil {
+		err = d.SetPriority(bittorrent.PriorityHigh, req.High...)
+	}
+	if err == nil {
+		err = d.SetPriority(bittorrent.PriorityLow, req.Low...)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Sequential != nil {
+		d.SetSequential(*req.Sequential)
+	}
+	// newly selected files of a finished torrent still need downloading
+	if state, _ := t.State(); state == bittorrent.StateFinished {
+		t.Resume()
+	}
+	writeJSON(w, http.StatusOK, torrentStatus(t, true))
+}
+
+// limitsJSON is the download limit in bytes per second, 0 is unlimited. A
+// limit left out of a request is kept. There is no upload limit, pieces are
+// never uploaded
+type limitsJSON struct {
+	Download int64 `json:"download"`
+}
+
+// errUploadLimit is returned for requests setting an upload limit
+var errUploadLimit = errors.New("upload limits aren't supported, pieces are never uploaded")
+
+// decodeLimits decodes a limitsJSON request over the current limit
+func decodeLimits(w http.ResponseWriter, r *http.Request, download int64) (limitsJSON, bool) {
+	var req struct {
+		limitsJSON
+		Upload *int64 `json:"upload"`
+	}
+	req.Download = download
+	if !decodeJSON(w, r, &req) {
+		return req.limitsJSON, false
+	}
+	if req.Upload != nil {
+		writeError(w, http.StatusBadRequest, errUploadLimit)
+		return req.limitsJSON, false
+	}
+	if req.Download < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("limits can't be negative"))
+		return req.limitsJSON, false
+	}
+	return req.limitsJSON, true
+}
+
+func (s *apiServer) limits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, limitsJSON{Download: s.session.DownloadLimit()})
+}
+
+func (s *apiServer) setLimits(w http.ResponseWriter, r *http.Request) {
+	limits, ok := decodeLimits(w, r, s.session.DownloadLimit())
+	if !ok {
+		return
+	}
+	s.session.SetDownloadLimit(limits.Download)
+	writeJSON(w, http.StatusOK, limits)
+}
+
+func (s *apiServer) setTorrentLimits(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	limits, ok := decodeLimits(w, r, t.DownloadLimit())
+	if !ok {
+		return
+	}
+	t.SetDownloadLimit(limits.Download)
+	writeJSON(w, http.StatusOK, torrentStatus(t, false))
+}
+
+func (s *apiServer) scheduleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.schedule.status())
+}
+
+func (s *apiServer) remove(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.torrent(w, r)
+	if !ok {
+		return
+	}
+	deleteData := r.URL.Query().Get("delete") == "true"
+	err := s.removeTorrent(t, deleteData)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeTorrent removes a torrent from the session along with its uploaded
+// torrent file
+func (s *apiServer) removeTorrent(t *bittorrent.Torrent, deleteData bool) error {
+	err := s.session.Remove(t.InfoHash(), deleteData)
+	if err != nil {
+		return err
+	}
+	s.mut.Lock()
+	upload, ok := s.uploads[t.InfoHash()]
+	delete(s.uploads, t.InfoHash())
+	delete(s.rpcIDs, t.InfoHash())
+	s.mut.Unlock()
+	if ok {
+		os.Remove(upload)
+	}
+	return nil
+}
+
+// eventJSON is an event of a torrent in the event stream
+type eventJSON struct {
+	Time     time.Time `json:"time"`
+	InfoHash string    `json:"info_hash"`
+	Type     string    `json:"type"`
+	Event    any       `json:"event"`
+}
+
+// events streams the events of every torrent as JSON lines until the client
+// disconnects, ?hash= only streams the torrents whose info hash starts with it
+func (s *apiServer) events(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.ToLower(r.URL.Query().Get("hash"))
+	sub, unsubscribe := s.session.Events().Subscribe()
+	defer func() {
+		unsubscribe()
+		for range sub {
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case ev := <-sub:
+			te, ok := ev.(bittorrent.TorrentEvent)
+			if !ok {
+				continue
+			}
+			infoHash := hex.EncodeToString(te.InfoHash[:])
+			if !strings.HasPrefix(infoHash, prefix) {
+				continue
+			}
+			typ, fields := eventFields(te.Event)
+			err := enc.Encode(eventJSON{Time: time.Now(), InfoHash: infoHash, Type: typ, Event: fields})
+			if err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// errString is the message of an error for JSON, empty for nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// eventFields returns the name of an event's type and its fields as JSON
+// friendly values, errors as their messages
+func eventFields(ev bittorrent.Event) (string, any) {
+	switch ev := ev.(type) {
+	case bittorrent.TrackerAnnounced:
+		return "tracker_announced", map[string]any{"url": ev.URL, "event": ev.Event.String(), "peers": ev.Peers, "error": errString(ev.Err)}
+	case bittorrent.PeerConnected:
+		return "peer_connected", map[string]any{"addr": ev.Addr, "kind": ev.Kind.String(), "client": ev.Client}
+	case bittorrent.PeerDisconnected:
+		return "peer_disconnected", map[string]any{"addr": ev.Addr, "kind": ev.Kind.String(), "reason": errString(ev.Reason)}
+	case bittorrent.MetadataReceived:
+		return "metadata_received", map[string]any{"source": ev.Source}
+	case bittorrent.PieceRequested:
+		return "piece_requested", map[string]any{"index": ev.Index, "source": ev.Source}
+	case bittorrent.PieceVerified:
+		return "piece_verified", map[string]any{"index": ev.Index, "source": ev.Source, "verified": ev.Verified, "wanted": ev.Wanted}
+	case bittorrent.PieceFailed:
+		return "piece_failed", map[string]any{"index": ev.Index, "source": ev.Source, "error": errString(ev.Err)}
+	case bittorrent.FileCompleted:
+		return "file_completed", map[string]any{"index": ev.Index, "path": filepath.ToSlash(ev.Path), "length": ev.Length}
+	case bittorrent.DownloadFinished:
+		return "download_finished", map[string]any{"error": errString(ev.Err)}
+	case bittorrent.Warning:
+		return "warning", map[string]any{"error": errString(ev.Err)}
+	case bittorrent.TorrentStateChanged:
+		return "state_changed", map[string]any{"state": ev.State.String(), "error": errString(ev.Err)}
+	default:
+		return fmt.Sprintf("%T", ev), ev
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/internal/prometheus"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func TestSameOrigin(t *testing.T) {
+	handler := sameOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"no browser headers", "POST", nil, http.StatusNoContent},
+		{"same origin", "POST", map[string]string{"Origin": "http://127.0.0.1:9090", "Sec-Fetch-Site": "same-origin"}, http.StatusNoContent},
+		{"typed into the address bar", "POST", map[string]string{"Sec-Fetch-Site": "none"}, http.StatusNoContent},
+		{"other origin", "POST", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other port", "DELETE", map[string]string{"Origin": "http://127.0.0.1:8080"}, http.StatusForbidden},
+		{"opaque origin", "PUT", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross site", "POST", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same site", "POST", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"cross site read", "GET", map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://127.0.0.1:9090/api/torrents", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+		code        int
+	}{
+		{"application/json", true, http.StatusOK},
+		{"application/json; charset=utf-8", true, http.StatusOK},
+		{"text/plain", false, http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", false, http.StatusUnsupportedMediaType},
+		{"", false, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/torrents", strings.NewReader(`{"magnet": "magnet:?xt=urn:btih:00"}`))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		var body addRequest
+		ok := decodeJSON(rec, req, &body)
+		if ok != tt.want || rec.Code != tt.code {
+			t.Errorf("%q: got %t and status %d, want %t and %d", tt.contentType, ok, rec.Code, tt.want, tt.code)
+		}
+		if ok && body.Magnet == "" {
+			t.Errorf("%q: body wasn't decoded", tt.contentType)
+		}
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	session, err := bittorrent.NewSession(bittorrent.SessionConfig{ListenAddr: "127.0.0.1:0", NoDHT: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	srv := httptest.NewServer(newAPIServer(session, nil, apiConfig{OutDir: t.TempDir(), UploadDir: t.TempDir()}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != prometheus.ContentType {
+		t.Fatalf("got status %d and content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "# TYPE bittorrent_connections_active gauge\nbittorrent_connections_active 0\n") {
+		t.Fatalf("unexpected metrics:\n%s", body)
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	hosts := []string{"seedbox.lan"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost:9090", true},
+		{"LOCALHOST", true},
+		{"dash.localhost:9090", true},
+		{"127.0.0.1:9090", true},
+		{"[::1]:9090", true},
+		{"[::1]", true},
+		{"192.168.1.5:9090", true},
+		{"seedbox.lan:9090", true},
+		{"SeedBox.LAN.", true},
+		{"evil.example:9090", false},
+		{"localhost.evil.example", false},
+		{"seedbox.lan.evil.example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(tt.host, hosts); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %t, want %t", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name string
+		set  func(r *http.Request)
+		want int
+	}{
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusNoContent},
+		{"wrong bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secreT") }, http.StatusUnauthorized},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("anyone", "secret") }, http.StatusNoContent},
+		{"wrong basic", func(r *http.Request) { r.SetBasicAuth("secret", "") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		// reads need the token too
+		req := httptest.NewRequest("GET", "/api/torrents", nil)
+		tt.set(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"localhost:9090", true},
+		{"127.0.0.1:9090", true},
+		{"[::1]:9090", true},
+		{":9090", false},
+		{"0.0.0.0:9090", false},
+		{"192.168.1.5:9090", false},
+		{"seedbox.lan:9090", false},
+		{"localhost", false},
+	}
+	for _, tt := range tests {
+		if got := loopbackAddr(tt.addr); got != tt.want {
+			t.Errorf("loopbackAddr(%q) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestInDirs(t *testing.T) {
+	allowed := t.TempDir()
+	other := t.TempDir()
+	err := os.Mkdir(filepath.Join(allowed, "sub"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(other, filepath.Join(allowed, "escape"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &apiServer{dirs: []string{allowed}}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{allowed, true},
+		{filepath.Join(allowed, "sub"), true},
+		{filepath.Join(allowed, "sub", "not", "made", "yet"), true},
+		{filepath.Join(allowed, "sub", "..", "..", filepath.Base(other)), false},
+		{filepath.Join(allowed, "escape"), false},
+		{filepath.Join(allowed, "escape", "new"), false},
+		{other, false},
+		{filepath.Dir(allowed), false},
+		{allowed + "-sibling", false},
+	}
+	for _, tt := range tests {
+		_, err := s.inDirs(tt.path)
+		if (err == nil) != tt.want {
+			t.Errorf("inDirs(%s): got error %v, want allowed %t", tt.path, err, tt.want)
+		}
+	}
+}
+
+// testDaemon is the API of a session whose torrents download from a web seed
+// that never answers, so they keep running until paused
+type testDaemon struct {
+	url       string
+	outDir    string
+	uploadDir string
+	torrent   string // a torrent file the API can add by path
+	raw       []byte // its content
+
+	rpcSessionID string // of the Transmission RPC, learned from its first 409
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	t.Helper()
+	stall := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(stall.Close)
+
+	data := filepath.Join(t.TempDir(), "data")
+	for name, size := range map[string]int{"a.bin": 40 << 10, "b.bin": 10 << 10} {
+		err := os.MkdirAll(data, 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(data, name), bytes.Repeat([]byte(name), size/len(name)), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := torrentparser.Create(data, torrentparser.CreateOptions{WebSeeds: []string{stall.URL + "/"}, PieceLength: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrentDir := t.TempDir()
+	d := &testDaemon{
+		outDir:    t.TempDir(),
+		uploadDir: t.TempDir(),
+		torrent:   filepath.Join(torrentDir, "data.torrent"),
+		raw:       raw,
+	}
+	err = os.WriteFile(d.torrent, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := bittorrent.NewSession(bittorrent.SessionConfig{ListenAddr: "127.0.0.1:0", NoDHT: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newAPIServer(session, nil, apiConfig{
+		OutDir:    d.outDir,
+		UploadDir: d.uploadDir,
+		Dirs:      []string{torrentDir},
+	}))
+	t.Cleanup(srv.Close)
+	// the session is closed first, stopping the requests to the web seed
+	t.Cleanup(func() { session.Close() })
+	d.url = srv.URL
+	return d
+}
+
+// do sends a request and decodes a successful JSON response into out unless
+// it's nil, returning the status
+func (d *testDaemon) do(t *testing.T, method, path, contentType string, body io.Reader, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, d.url+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			t.Fatalf("%s %s: decoding response: %s", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (d *testDaemon) doJSON(t *testing.T, method, path, body string, out any) int {
+	t.Helper()
+	return d.do(t, method, path, "application/json", strings.NewReader(body), out)
+}
+
+// waitFiles waits until the files of a torrent are known, once it's set up
+func (d *testDaemon) waitFiles(t *testing.T, hash string) torrentJSON {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status torrentJSON
+		if code := d.do(t, "GET", "/api/torrents/"+hash, "", nil, &status); code != http.StatusOK {
+			t.Fatalf("got status %d", code)
+		}
+		if len(status.Files) > 0 {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("files of %s aren't known, torrent is %s %s", hash, status.State, status.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAPITorrents(t *testing.T) {
+	d := newTestDaemon(t)
+	add := func(body string) (torrentJSON, int) {
+		var status torrentJSON
+		code := d.doJSON(t, "POST", "/api/torrents", body, &status)
+		return status, code
+	}
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+
+	outside := filepath.Join(t.TempDir(), "other.torrent")
+	os.WriteFile(outside, d.raw, 0644)
+	if _, code := add(`{"path": ` + quote(outside) + `}`); code != http.StatusForbidden {
+		t.Fatalf("adding a path outside of the directories: got status %d", code)
+	}
+	if _, code := add(`{"path": "$HOME/x.torrent"}`); code != http.StatusBadRequest {
+		t.Fatalf("adding a path with a variable: got status %d", code)
+	}
+	if _, code := add(`{"path": ` + quote(d.torrent) + `, "out_dir": ` + quote(t.TempDir()) + `}`); code != http.StatusForbidden {
+		t.Fatalf("downloading outside of the directories: got status %d", code)
+	}
+
+	status, code := add(`{"path": ` + quote(d.torrent) + `, "out_dir": ` + quote(filepath.Join(d.outDir, "sub")) + `}`)
+	if code != http.StatusCreated {
+		t.Fatalf("adding: got status %d", code)
+	}
+	hash := status.InfoHash
+	if _, code := add(`{"path": ` + quote(d.torrent) + `}`); code != http.StatusConflict {
+		t.Fatalf("adding again: got status %d", code)
+	}
+
+	var list []torrentJSON
+	d.do(t, "GET", "/api/torrents", "", nil, &list)
+	if len(list) != 1 || list[0].InfoHash != hash || list[0].Files != nil {
+		t.Fatalf("got list %+v", list)
+	}
+
+	status = d.waitFiles(t, hash[:8])
+	if len(status.Files) != 2 || status.Files[0].Path != "data/a.bin" {
+		t.Fatalf("got files %+v", status.Files)
+	}
+	code = d.doJSON(t, "PUT", "/api/torrents/"+hash+"/files", `{"select": ["b.bin"], "high": ["b.bin"]}`, &status)
+	if code != http.StatusOK {
+		t.Fatalf("selecting: got status %d", code)
+	}
+	if status.Files[0].Priority != "skip" || status.Files[1].Priority != "high" {
+		t.Fatalf("got files %+v after selecting", status.Files)
+	}
+	if code := d.doJSON(t, "PUT", "/api/torrents/"+hash+"/files", `{"select": ["nothing.bin"]}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("selecting no file: got status %d", code)
+	}
+
+	d.do(t, "POST", "/api/torrents/"+hash+"/pause", "", nil, &status)
+	if status.State != "paused" {
+		t.Fatalf("got state %s after pausing", status.State)
+	}
+	d.do(t, "POST", "/api/torrents/"+hash+"/resume", "", nil, &status)
+	if status.State != "connecting" && status.State != "downloading" {
+		t.Fatalf("got state %s after resuming", status.State)
+	}
+
+	if code := d.do(t, "DELETE", "/api/torrents/"+hash, "", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("removing: got status %d", code)
+	}
+	if code := d.do(t, "GET", "/api/torrents/"+hash, "", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("getting a removed torrent: got status %d", code)
+	}
+	d.do(t, "GET", "/api/torrents", "", nil, &list)
+	if len(list) != 0 {
+		t.Fatalf("got list %+v after removing", list)
+	}
+}
+
+func TestAPIUpload(t *testing.T) {
+	d := newTestDaemon(t)
+	var status torrentJSON
+	code := d.do(t, "POST", "/api/torrents?out_dir="+url.QueryEscape(d.outDir), "application/x-bittorrent", bytes.NewReader(d.raw), &status)
+	if code != http.StatusCreated {
+		t.Fatalf("uploading: got status %d", code)
+	}
+	uploads, _ := os.ReadDir(d.uploadDir)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploaded files, want 1", len(uploads))
+	}
+	if code := d.do(t, "DELETE", "/api/torrents/"+status.InfoHash, "", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("removing: got status %d", code)
+	}
+	uploads, _ = os.ReadDir(d.uploadDir)
+	if len(uploads) != 0 {
+		t.Fatalf("the uploaded file was kept after removing")
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("torrent", "data.torrent")
+	fw.Write(d.raw)
+	mw.WriteField("out_dir", t.TempDir())
+	mw.Close()
+	if code := d.do(t, "POST", "/api/torrents", mw.FormDataContentType(), &form, nil); code != http.StatusForbidden {
+		t.Fatalf("uploading into another directory: got status %d", code)
+	}
+}
+
+func TestAPIUploadTooLarge(t *testing.T) {
+	d := newTestDaemon(t)
+	large := make([]byte, maxUpload+1)
+	if code := d.do(t, "POST", "/api/torrents", "application/x-bittorrent", bytes.NewReader(large), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("raw upload: got status %d", code)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("torrent", "large.torrent")
+	fw.Write(large)
+	mw.Close()
+	if code := d.do(t, "POST", "/api/torrents", mw.FormDataContentType(), &form, nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("form upload: got status %d", code)
+	}
+	uploads, _ := os.ReadDir(d.uploadDir)
+	if len(uploads) != 0 {
+		t.Fatalf("got %d uploaded files, want none", len(uploads))
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	d := newTestDaemon(t)
+	torrent, err := torrentparser.ParseTorrentFile(d.torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := hex.EncodeToString(torrent.InfoHash[:])
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// only the events of the torrent
+	req, _ := http.NewRequestWithContext(ctx, "GET", d.url+"/api/events?hash="+hash[:6], nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("got content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	code := d.do(t, "POST", "/api/torrents", "application/x-bittorrent", bytes.NewReader(d.raw), nil)
+	if code != http.StatusCreated {
+		t.Fatalf("adding: got status %d", code)
+	}
+
+	time.AfterFunc(5*time.Second, cancel)
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		var ev struct {
+			InfoHash string         `json:"info_hash"`
+			Type     string         `json:"type"`
+			Event    map[string]any `json:"event"`
+		}
+		err := json.Unmarshal(lines.Bytes(), &ev)
+		if err != nil {
+			t.Fatalf("decoding %s: %s", lines.Bytes(), err)
+		}
+		if ev.InfoHash != hash {
+			t.Fatalf("got an event of %s", ev.InfoHash)
+		}
+		if ev.Type == "state_changed" && ev.Event["state"] == "connecting" {
+			return
+		}
+	}
+	t.Fatal("the stream ended without the torrent connecting")
+}
+
+func TestAPILimits(t *testing.T) {
+	d := newTestDaemon(t)
+	var limits limitsJSON
+	if code := d.doJSON(t, "PUT", "/api/limits", `{"download": 2048}`, &limits); code != http.StatusOK || limits.Download != 2048 {
+		t.Fatalf("got status %d and limits %+v", code, limits)
+	}
+	// pieces are never uploaded
+	if code := d.doJSON(t, "PUT", "/api/limits", `{"upload": 1024}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("setting an upload limit: got status %d", code)
+	}
+	if code := d.doJSON(t, "PUT", "/api/limits", `{"download": -1}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("setting a negative limit: got status %d", code)
+	}
+	limits = limitsJSON{}
+	d.do(t, "GET", "/api/limits", "", nil, &limits)
+	if limits.Download != 2048 {
+		t.Fatalf("got limits %+v", limits)
+	}
+}
@@ -0,0 +1,369 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/peer"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+	"github.com/givxl33t/bittorrent-client-go/tracker"
+)
+
+const (
+	// defaultMaxPeers is how many peers a download connects to when
+	// Options.MaxPeers is 0
+	defaultMaxPeers = 50
+	// defaultMaxHalfOpen is how many peers are dialed at once when
+	// Options.MaxHalfOpen is 0
+	defaultMaxHalfOpen = 8
+
+	// retryBackoff is how long a peer that failed is left alone, doubled
+	// for every failure in a row up to maxRetryBackoff
+	retryBackoff    = 15 * time.Second
+	maxRetryBackoff = 30 * time.Minute
+	// maxPeerFailures is how many failures in a row a peer is forgotten after
+	maxPeerFailures = 8
+	// hopelessAfter is how long a download goes on without any peer
+	// connected while the ones it knows of are backing off, several retries
+	// of each but far less than the maxPeerFailures it takes to forget them
+	hopelessAfter = 5 * time.Minute
+
+	// snubTimeout is how long a peer can go without sending piece data
+	// before it's dropped for another
+	snubTimeout = time.Minute
+	// reannounceInterval is how often trackers are asked for more peers while
+	// there are none left to dial
+	reannounceInterval = 2 * time.Minute
+	// manageInterval is how often a running download dials peers and drops
+	// the ones not sending anything
+	manageInterval = time.Second
+)
+
+var (
+	errSnubbed = errors.New("peer sent no piece data for too long")
+	errUseless = errors.New("peer has none of the wanted pieces")
+)
+
+type candidateState int
+
+const (
+	candidateIdle candidateState = iota
+	candidateDialing
+	candidateConnected
+)
+
+// candidate is a peer address a download knows of
+type candidate struct {
+	addr     net.TCPAddr
+	state    candidateState
+	inbound  bool      // connected to us, its address can't be dialed
+	failures int       // in a row, reset once it connects
+	retryAt  time.Time // when it can be dialed again after failing
+}
+
+// connManager owns the peers a download can connect to. It dials them within
+// the download's connection limit and the swarm's half-open limit, retries
+// those that fail with exponential backoff, and asks trackers for more once
+// it runs out
+type connManager struct {
+	infoHash [20]byte
+	sw       swarm
+	r        reporter
+
+	mut          sync.Mutex
+	candidates   map[string]*candidate
+	connected    int
+	dialing      int
+	announcing   bool
+	lastAnnounce time.Time
+	// lastConnected is when a peer was last connected, or when the manager
+	// was made before any was
+	lastConnected time.Time
+}
+
+func newConnManager(infoHash [20]byte, sw swarm, r reporter) *connManager {
+	if sw.maxPeers == 0 {
+		sw.maxPeers = defaultMaxPeers
+	}
+	return &connManager{
+		infoHash:      infoHash,
+		sw:            sw,
+		r:             r,
+		candidates:    map[string]*candidate{},
+		lastConnected: time.Now(),
+	}
+}
+
+// add adds peer addresses that aren't known yet
+func (m *connManager) add(addrs []net.TCPAddr) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for _, addr := range addrs {
+		key := addr.String()
+		if _, ok := m.candidates[key]; !ok {
+			m.candidates[key] = &candidate{addr: addr}
+		}
+	}
+}
+
+// next returns the candidates to dial now as far as the limits allow, they
+// are marked as dialing and hold a connection slot and a half-open slot
+func (m *connManager) next(now time.Time) []*candidate {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	var dial []*candidate
+	for _, c := range m.candidates {
+		if m.connected+m.dialing >= m.sw.maxPeers {
+			break
+		}
+		if c.state != candidateIdle || c.inbound || now.Before(c.retryAt) {
+			continue
+		}
+		if !m.sw.halfOpen.tryAcquire() {
+			break
+		}
+		if !m.sw.slots.tryAcquire() {
+			m.sw.halfOpen.release()
+			break
+		}
+		c.state = candidateDialing
+		m.dialing++
+		dial = append(dial, c)
+	}
+	return dial
+}
+
+// dial connects to a candidate from next, nil when it failed
+func (m *connManager) dial(ctx context.Context, c *candidate) *peer.Client {
+	client, err := dialPeer(ctx, c.addr, m.infoHash, m.sw, m.r)
+	m.sw.halfOpen.release()
+
+	m.mut.Lock()
+	m.dialing--
+	switch {
+	case err == nil:
+		c.state = candidateConnected
+		c.failures = 0
+		m.connected++
+		m.lastConnected = time.Now()
+	case ctx.Err() != nil:
+		// not the peer's fault, but dialing it again with a canceled ctx
+		// would only fail again. The next announce finds it again
+		delete(m.candidates, c.addr.String())
+	default:
+		m.failedLocked(c.addr.String(), c, time.Now())
+	}
+	m.mut.Unlock()
+
+	if err != nil {
+		if ctx.Err() == nil {
+			m.r.publish(PeerDisconnected{Addr: c.addr.String(), Kind: SourcePeer, Reason: err})
+		}
+		return nil
+	}
+	m.r.publish(PeerConnected{Addr: c.addr.String(), Kind: SourcePeer, Client: client.ClientName})
+	return client
+}
+
+// failedLocked backs a candidate off, or forgets it after too many failures
+// in a row. The caller holds the lock
+func (m *connManager) failedLocked(key string, c *candidate, now time.Time) {
+	c.state = candidateIdle
+	c.failures++
+	if c.failures >= maxPeerFailures || c.inbound {
+		delete(m.candidates, key)
+		return
+	}
+	backoff := min(retryBackoff<<(c.failures-1), maxRetryBackoff)
+	c.retryAt = now.Add(backoff)
+}
+
+// accept counts a peer that connected to us, false when the download has as
+// many peers as it takes or is connected to it already
+func (m *connManager) accept(client *peer.Client) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.connected+m.dialing >= m.sw.maxPeers {
+		return false
+	}
+	key := client.String()
+	c, ok := m.candidates[key]
+	if !ok {
+		c = &candidate{inbound: true}
+		if addr, ok := client.Addr().(*net.TCPAddr); ok {
+			c.addr = *addr
+		}
+		m.candidates[key] = c
+	}
+	if c.state != candidateIdle {
+		return false
+	}
+	c.state = candidateConnected
+	m.connected++
+	m.lastConnected = time.Now()
+	return true
+}
+
+// closed counts a connected peer that was closed, reason is why it failed or
+// was dropped, nil when it was simply no longer needed
+func (m *connManager) closed(addr string, reason error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	c, ok := m.candidates[addr]
+	if !ok || c.state != candidateConnected {
+		return
+	}
+	m.connected--
+	m.lastConnected = time.Now()
+	if reason != nil {
+		m.failedLocked(addr, c, time.Now())
+		return
+	}
+	c.state = candidateIdle
+	if c.inbound {
+		delete(m.candidates, addr)
+	}
+}
+
+// connect dials candidates until the download has as many peers as it takes
+// or every candidate was tried, for setting up a download before it runs. Once
+// ctx is canceled it waits for the dials under way and returns nothing
+func (m *connManager) connect(ctx context.Context) []*peer.Client {
+	results := make(chan *peer.Client)
+	var dialing int
+	var clients []*peer.Client
+	for {
+		if ctx.Err() == nil {
+			for _, c := range m.next(time.Now()) {
+				dialing++
+				go func() { results <- m.dial(ctx, c) }()
+			}
+		}
+		if dialing == 0 {
+			break
+		}
+		if client := <-results; client != nil {
+			clients = append(clients, client)
+		}
+		dialing--
+	}
+	if ctx.Err() != nil {
+		for _, client := range clients {
+			client.Close()
+			m.closed(client.String(), nil)
+		}
+		return nil
+	}
+	return clients
+}
+
+// hopeless reports whether no peer is connected, being dialed or ready to
+// dial and no tracker is being asked for more. Peers backing off only keep
+// the download going until none was connected for hopelessAfter, as each
+// could take over an hour to be forgotten
+func (m *connManager) hopeless(now time.Time) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.connected+m.dialing != 0 || m.announcing {
+		return false
+	}
+	for _, c := range m.candidates {
+		if c.state == candidateIdle && !c.inbound && !now.Before(c.retryAt) {
+			return false
+		}
+	}
+	return len(m.candidates) == 0 || now.Sub(m.lastConnected) > hopelessAfter
+}
+
+// wantsPeers reports whether trackers should be asked for more peers, there
+// is room for more and none left to dial
+func (m *connManager) wantsPeers(now time.Time) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.announcing || now.Sub(m.lastAnnounce) < reannounceInterval || m.connected+m.dialing >= m.sw.maxPeers {
+		return false
+	}
+	for _, c := range m.candidates {
+		if c.state == candidateIdle && !c.inbound && !now.Before(c.retryAt) {
+			return false
+		}
+	}
+	m.announcing = true
+	m.lastAnnounce = now
+	return true
+}
+
+// findPeers asks every tracker and the DHT for peers and adds them
+func (m *connManager) findPeers(ctx context.Context, torrent torrentparser.TorrentFile, a tracker.Announce) {
+	addrs := findPeers(ctx, torrent, m.sw, m.r, a)
+	m.add(addrs)
+	m.mut.Lock()
+	m.announcing = false
+	m.lastAnnounce = time.Now()
+	m.mut.Unlock()
+}
+
+// manage keeps a running download connected to peers until ctx is done: it
+// dials candidates as connections free up, drops peers that don't send
+// anything and asks trackers for more peers when it runs out. done is closed
+// once it stopped and every peer it dialed was handed over or closed
+func (m *connManager) manage(ctx context.Context, d *Download) (done <-chan struct{}) {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		ticker := time.NewTicker(manageInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			d.dropSnubbed(now)
+			for _, c := range m.next(now) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					client := m.dial(ctx, c)
+					if client != nil && (ctx.Err() != nil || !d.use(client)) {
+						// the run ended while dialing
+						client.Close()
+						m.closed(client.String(), nil)
+						m.r.publish(PeerDisconnected{Addr: client.String(), Kind: SourcePeer})
+					}
+				}()
+			}
+			if m.wantsPeers(now) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.findPeers(ctx, d.Torrent, d.announcement(tracker.EventNone))
+				}()
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stopped
+}
+
+// dropSnubbed drops the peers that sent no piece data for snubTimeout, their
+// workers stop and the connection manager dials others
+func (d *Download) dropSnubbed(now time.Time) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	for s := range d.sources {
+		requested := s.requested.Load()
+		if s.received == nil || requested == 0 {
+			continue
+		}
+		last := time.Unix(0, max(requested, s.received.Load()))
+		if now.Sub(last) > snubTimeout {
+			s.drop(errSnubbed)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package bittorrent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// stalledPeers listens on loopback and accepts connections without ever
+// answering the handshake, so dials to them only end with their ctx
+func stalledPeers(t *testing.T, n int) []net.TCPAddr {
+	t.Helper()
+	var addrs []net.TCPAddr
+	for range n {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				t.Cleanup(func() { conn.Close() })
+			}
+		}()
+		addrs = append(addrs, *ln.Addr().(*net.TCPAddr))
+	}
+	return addrs
+}
+
+func TestConnectStopsOnCancel(t *testing.T) {
+	sw := Options{MaxHalfOpen: 2}.swarm()
+	m := newConnManager([20]byte{1}, sw, reporter{})
+	m.add(stalledPeers(t, 5))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan int, 1)
+	go func() { done <- len(m.connect(ctx)) }()
+	select {
+	case n := <-done:
+		if n != 0 {
+			t.Fatalf("got %d clients after cancel, want none", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connect didn't return after ctx was canceled")
+	}
+
+	// the canceled dials aren't handed out again
+	m.mut.Lock()
+	dialing, connected := m.dialing, m.connected
+	m.mut.Unlock()
+	if dialing != 0 || connected != 0 {
+		t.Fatalf("dialing %d, connected %d after cancel, want 0 and 0", dialing, connected)
+	}
+	if got := m.connect(ctx); got != nil {
+		t.Fatalf("connect with a canceled ctx returned %d clients", len(got))
+	}
+}
+
+func TestFailedPeersBackOff(t *testing.T) {
+	m := newConnManager([20]byte{1}, Options{}.swarm(), reporter{})
+	addr := net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	m.add([]net.TCPAddr{addr})
+
+	now := time.Now()
+	c := m.candidates[addr.String()]
+	for i := 1; i < maxPeerFailures; i++ {
+		m.failedLocked(addr.String(), c, now)
+		want := min(retryBackoff<<(i-1), maxRetryBackoff)
+		if got := c.retryAt.Sub(now); got != want {
+			t.Fatalf("backoff after %d failures is %s, want %s", i, got, want)
+		}
+		if len(m.next(now)) != 0 {
+			t.Fatalf("candidate handed out while backing off after %d failures", i)
+		}
+	}
+	m.failedLocked(addr.String(), c, now)
+	if _, ok := m.candidates[addr.String()]; ok {
+		t.Fatalf("candidate kept after %d failures", maxPeerFailures)
+	}
+}
+
+func TestHopelessWhileBackingOff(t *testing.T) {
+	m := newConnManager([20]byte{1}, Options{}.swarm(), reporter{})
+	now := time.Now()
+	if !m.hopeless(now) {
+		t.Fatal("not hopeless without any peer")
+	}
+
+	addrs := []net.TCPAddr{{IP: net.IPv4(127, 0, 0, 1), Port: 1}, {IP: net.IPv4(127, 0, 0, 1), Port: 2}}
+	m.add(addrs)
+	if m.hopeless(now.Add(time.Hour)) {
+		t.Fatal("hopeless with peers ready to dial")
+	}
+
+	// peers that keep failing are retried for a while, but the download
+	// doesn't wait for them to be forgotten
+	for _, addr := range addrs {
+		m.failedLocked(addr.String(), m.candidates[addr.String()], now)
+	}
+	if m.hopeless(now.Add(time.Second)) {
+		t.Fatal("hopeless right after the peers failed")
+	}
+	if len(m.candidates) != len(addrs) {
+		t.Fatal("peers were forgotten")
+	}
+	later := now.Add(hopelessAfter)
+	for _, addr := range addrs {
+		m.failedLocked(addr.String(), m.candidates[addr.String()], later)
+	}
+	if !m.hopeless(later.Add(time.Second)) {
+		t.Fatalf("not hopeless after %s with every peer backing off", hopelessAfter)
+	}
+
+	// a peer ready to retry, being dialed or asked trackers for more peers
+	// keeps it going
+	m.mut.Lock()
+	m.announcing = true
+	m.mut.Unlock()
+	if m.hopeless(later.Add(time.Second)) {
+		t.Fatal("hopeless while announcing")
+	}
+	m.mut.Lock()
+	m.announcing = false
+	m.mut.Unlock()
+	if m.hopeless(now.Add(24 * time.Hour)) {
+		t.Fatal("hopeless with peers done backing off")
+	}
+	dial := m.next(now.Add(24 * time.Hour))
+	if len(dial) != len(addrs) || m.hopeless(now.Add(24*time.Hour)) {
+		t.Fatal("hopeless while dialing")
+	}
+}
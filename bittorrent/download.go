@@ -1,36 +1,403 @@
 package bittorrent
 
 import (
+	"cmp"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
 	"github.com/givxl33t/bittorrent-client-go/peer"
 	"github.com/givxl33t/bittorrent-client-go/torrentparser"
 	"github.com/givxl33t/bittorrent-client-go/tracker"
+	"github.com/givxl33t/bittorrent-client-go/webseed"
 )
 
 type Download struct {
 	Torrent     torrentparser.TorrentFile
 	PeerId      [20]byte
 	PeerClients []*peer.Client
+	WebSeeds    []*webseed.Client   // BEP0019 `url-list` servers
+	HTTPSeeds   []*webseed.HTTPSeed // BEP0017 `httpseeds` servers
+	Events      *Events             // progress of the download, nil discards it
+
+	log        *slog.Logger // with the infohash attribute
+	swarm      swarm
+	conns      *connManager // peers to connect to, see connManager
+	mut        sync.Mutex
+	priorities []Priority // per file, nil downloads every file normally
+	sequential bool
+	changed    chan struct{} // signals a running download to apply priorities
+	picker     *picker       // shared by Run and readers of the torrent's files
+	store      *storage      // set by Run, where pieces are written and read
+	downloaded atomic.Int64  // verified bytes, reported to trackers
+	added      chan struct{} // signals a running download to use pending peers
+	pending    []*peer.Client
+	meter      *rateMeter            // download rate of the current or last run
+	sources    map[*sourceStats]bool // sources the current run downloads from
+	metrics    *torrentMetrics       // nil counts nothing
+}
+
+// defaultPort is the port announced to trackers when none is set
+const defaultPort = 6881
+
+// swarm is how a download finds and connects to peers, a Session shares it
+// between all of its downloads
+type swarm struct {
+	peerID   [20]byte
+	port     int
+	dht      DHT
+	slots    *connSlots   // nil is unlimited
+	halfOpen *connSlots   // connections being dialed, nil is unlimited
+	maxPeers int          // connections of this torrent
+	global   *rateLimiter // download limit of every torrent of a Session
+	limit    *rateLimiter // download limit of this torrent
+}
+
+// addDHTNode tells the DHT about the node of a peer that sent its port
+func (sw swarm) addDHTNode(client *peer.Client) {
+	if sw.dht != nil && client.DHTPort != 0 {
+		sw.dht.AddNode(net.JoinHostPort(client.Address.IP.String(), strconv.Itoa(client.DHTPort)))
+	}
+}
+
+// downloadLimiters are what piece data received from the swarm pays to
+func (sw swarm) downloadLimiters() limiters {
+	return limiters{sw.limit, sw.global}
+}
+
+// Options configures how a download is set up
+type Options struct {
+	// MetadataCacheDir caches the metadata fetched for magnet links by info
+	// hash, so later runs don't need to fetch it again. Empty disables it
+	MetadataCacheDir string
+
+	// Events receives the events of the download, including the ones sent
+	// while it is set up. A new one is made when nil
+	Events *Events
+
+	// Logger logs the download with an infohash attribute, along with the
+	// trackers, peers and seeds it talks to. nil discards the logs
+	Logger *slog.Logger
+
+	// PeerID identifies the client to trackers and peers, a random one is
+	// used when it's zero
+	PeerID [20]byte
+
+	// Port is announced to trackers as where peers can connect, 6881 when 0
+	Port int
+
+	// DHT finds peers along with the trackers when set
+	DHT DHT
+
+	// Metrics counts what the download does when set, see Metrics
+	Metrics *Metrics
+
+	// MaxPeers limits the peers the download is connected to at once, 50
+	// when 0. Peers that fail are retried later and replaced with others in
+	// the meantime
+	MaxPeers int
+
+	// MaxHalfOpen limits how many peers are dialed at once, 8 when 0
+	MaxHalfOpen int
+
+	// set by a Session to share its limits between downloads, and to keep
+	// the limits of a torrent across its downloads
+	slots    *connSlots
+	halfOpen *connSlots
+	global   *rateLimiter
+	limit    *rateLimiter
+}
+
+// swarm returns how a download with the options connects to peers
+func (o Options) swarm() swarm {
+	sw := swarm{
+		peerID:   o.PeerID,
+		port:     o.Port,
+		dht:      o.DHT,
+		slots:    o.slots,
+		halfOpen: o.halfOpen,
+		maxPeers: o.MaxPeers,
+		global:   o.global,
+		limit:    o.limit,
+	}
+	if sw.peerID == ([20]byte{}) {
+		rand.Read(sw.peerID[:])
+	}
+	if sw.port == 0 {
+		sw.port = defaultPort
+	}
+	if sw.maxPeers == 0 {
+		sw.maxPeers = defaultMaxPeers
+	}
+	if sw.halfOpen == nil {
+		sw.halfOpen = newConnSlots(cmp.Or(o.MaxHalfOpen, defaultMaxHalfOpen))
+	}
+	if sw.limit == nil {
+		sw.limit = newRateLimiter(0)
+	}
+	return sw
+}
+
+// DefaultOptions returns the options used by NewDownload
+func DefaultOptions() Options {
+	return Options{
+		MetadataCacheDir: DefaultMetadataCacheDir(),
+	}
 }
 
 // sets up worker threads to download the torrent
 // whether it be a torrentfile or magnet links
 // parse off the infohash and tracker urls
 func NewDownload(source string) (*Download, error) {
+	return NewDownloadWithOptions(source, DefaultOptions())
+}
+
+// NewDownloadWithOptions is NewDownload with custom options
+func NewDownloadWithOptions(source string, opts Options) (*Download, error) {
+	return NewDownloadContext(context.Background(), source, opts)
+}
+
+// NewDownloadContext is NewDownloadWithOptions that stops contacting trackers
+// and peers when ctx is canceled
+func NewDownloadContext(ctx context.Context, source string, opts Options) (*Download, error) {
 	torrent, err := torrentparser.New(source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse torrent file: %w", err)
 	}
+	// pieces are downloaded and checked against v1 hashes only
+	if torrent.MetaVersion == 2 && len(torrent.PieceHashes) == 0 {
+		return nil, errV2Only
+	}
+
+	sw := opts.swarm()
+
+	events := opts.Events
+	if events == nil {
+		events = NewEvents()
+	}
+	events.setLogger(opts.Logger)
+	r := reporter{
+		events:  events,
+		log:     logging.OrDiscard(opts.Logger).With(logging.HashAttr(torrent.InfoHash)),
+		metrics: opts.Metrics.torrent(torrent.InfoHash),
+	}
+
+	// web seeds can serve the whole torrent by themselves, but can't serve
+	// the metadata of a magnet link
+	isMagnet := strings.HasPrefix(source, "magnet")
+	var webSeeds []*webseed.Client
+	var httpSeeds []*webseed.HTTPSeed
+	if !isMagnet {
+		webSeeds = newWebSeeds(torrent, sw, r)
+		httpSeeds = newHTTPSeeds(torrent, sw, r)
+	}
+
+	conns := newConnManager(torrent.InfoHash, sw, r)
+	peerClients, err := connectPeers(ctx, torrent, conns)
+	if ctx.Err() != nil {
+		closePeers(peerClients)
+		return nil, ctx.Err()
+	}
+	if err != nil && len(webSeeds)+len(httpSeeds) == 0 {
+		return nil, err
+	}
+
+	// get metadata if it was a magnet link
+	if isMagnet {
+		err = appendMetadata(ctx, &torrent, peerClients, opts.MetadataCacheDir, r)
+		if err != nil {
+			closePeers(peerClients)
+			return nil, err
+		}
+		if len(torrent.PieceHashes) == 0 {
+			closePeers(peerClients)
+			return nil, errV2Only
+		}
+		webSeeds = newWebSeeds(torrent, sw, r)
+		httpSeeds = newHTTPSeeds(torrent, sw, r)
+	}
+
+	d := &Download{
+		Torrent:     torrent,
+		PeerClients: peerClients,
+		PeerId:      sw.peerID,
+		WebSeeds:    webSeeds,
+		HTTPSeeds:   httpSeeds,
+		Events:      events,
+		log:         r.log,
+		swarm:       sw,
+		conns:       conns,
+		metrics:     r.metrics,
+	}
+	r.metrics.setDownload(d)
+	return d, nil
+}
+
+var errV2Only = errors.New("v2 only torrents are not supported, use a hybrid torrent")
+
+// newWebSeeds creates a client for every supported web seed of the torrent
+func newWebSeeds(torrent torrentparser.TorrentFile, sw swarm, r reporter) []*webseed.Client {
+	var webSeeds []*webseed.Client
+	for _, u := range torrent.WebSeeds {
+		client, err := webseed.NewClient(u, torrent)
+		if err != nil {
+			r.publish(PeerDisconnected{Addr: u, Kind: SourceWebSeed, Reason: err})
+			continue
+		}
+		client.Logger = r.log
+		limitHTTPClient(client.HTTPClient, sw.downloadLimiters())
+		r.publish(PeerConnected{Addr: client.String(), Kind: SourceWebSeed})
+		webSeeds = append(webSeeds, client)
+	}
+	return webSeeds
+}
+
+// newHTTPSeeds creates a client for every supported http seed of the torrent
+func newHTTPSeeds(torrent torrentparser.TorrentFile, sw swarm, r reporter) []*webseed.HTTPSeed {
+	var httpSeeds []*webseed.HTTPSeed
+	for _, u := range torrent.HTTPSeeds {
+		client, err := webseed.NewHTTPSeed(u, torrent.InfoHash)
+		if err != nil {
+			r.publish(PeerDisconnected{Addr: u, Kind: SourceHTTPSeed, Reason: err})
+			continue
+		}
+		client.Logger = r.log
+		limitHTTPClient(client.HTTPClient, sw.downloadLimiters())
+		r.publish(PeerConnected{Addr: client.String(), Kind: SourceHTTPSeed})
+		httpSeeds = append(httpSeeds, client)
+	}
+	return httpSeeds
+}
+
+// announce sends an event to every tracker of the torrent, waiting for all
+// of them to answer or time out
+func (d *Download) announce(ctx context.Context, event tracker.Event) {
+	announceTrackers(ctx, d.Torrent, d.report(), d.announcement(event))
+}
+
+// announcement is what the download tells trackers along with an event
+func (d *Download) announcement(event tracker.Event) tracker.Announce {
+	left := int64(d.Torrent.Length)
+	d.mut.Lock()
+	pk := d.picker
+	d.mut.Unlock()
+	if pk != nil {
+		left = 0
+		for i := range d.Torrent.PieceHashes {
+			if pk.State(i) != pieceVerified {
+				left += int64(d.Torrent.PieceSize(i))
+			}
+		}
+	}
+
+	return tracker.Announce{
+		PeerID:     d.PeerId,
+		Port:       d.swarm.port,
+		Downloaded: d.downloaded.Load(),
+		Left:       left,
+		Event:      event,
+	}
+}
+
+// announceTrackers sends an announce to every tracker of the torrent, waiting
+// for all of them to answer or time out. The info hash is filled in
+func announceTrackers(ctx context.Context, torrent torrentparser.TorrentFile, r reporter, a tracker.Announce) {
+	a.InfoHash = torrent.InfoHash
+	a.Logger = r.log
+
+	var wg sync.WaitGroup
+	for _, trackerURL := range torrent.TrackerURLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			announceTo(ctx, trackerURL, r, a)
+		}()
+	}
+	wg.Wait()
+}
+
+// announceTo announces to a tracker and reports how it went
+func announceTo(ctx context.Context, trackerURL string, r reporter, a tracker.Announce) ([]net.TCPAddr, error) {
+	start := time.Now()
+	addrs, err := tracker.AnnounceContext(ctx, trackerURL, a)
+	if ctx.Err() == nil {
+		r.metrics.all().observeAnnounce(trackerURL, time.Since(start), err)
+	}
+	r.publish(TrackerAnnounced{URL: trackerURL, Event: a.Event, Peers: len(addrs), Err: err})
+	return addrs, err
+}
+
+// reconnect finds and connects to peers and seeds again, replacing the ones
+// of an earlier run which closes its connections when it ends
+func (d *Download) reconnect(ctx context.Context) error {
+	r := d.report()
+	webSeeds := newWebSeeds(d.Torrent, d.swarm, r)
+	httpSeeds := newHTTPSeeds(d.Torrent, d.swarm, r)
+	peerClients, err := connectPeers(ctx, d.Torrent, d.connManager())
+	if ctx.Err() != nil {
+		closePeers(peerClients)
+		return ctx.Err()
+	}
+
+	d.mut.Lock()
+	d.PeerClients = peerClients
+	d.WebSeeds = webSeeds
+	d.HTTPSeeds = httpSeeds
+	d.mut.Unlock()
+	if err != nil && len(webSeeds)+len(httpSeeds) == 0 {
+		return err
+	}
+	return nil
+}
+
+// connManager returns the connection manager of the download, one is made for
+// downloads that weren't set up by NewDownload
+func (d *Download) connManager() *connManager {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.conns == nil {
+		d.conns = newConnManager(d.Torrent.InfoHash, d.swarm, d.report())
+	}
+	return d.conns
+}
+
+// closePeers closes the connections of a download that won't be used
+func closePeers(peerClients []*peer.Client) {
+	for _, p := range peerClients {
+		p.Close()
+	}
+}
 
-	var peerID [20]byte
-	rand.Read(peerID[:])
-	// random port
-	port := 6881
+// connectPeers gets peer addresses from every tracker and the DHT, and
+// connects to as many of them as the limits allow
+func connectPeers(ctx context.Context, torrent torrentparser.TorrentFile, conns *connManager) ([]*peer.Client, error) {
+	conns.findPeers(ctx, torrent, tracker.Announce{
+		PeerID: conns.sw.peerID,
+		Port:   conns.sw.port,
+		Left:   int64(torrent.Length),
+		Event:  tracker.EventStarted,
+	})
+	peerClients := conns.connect(ctx)
+	if len(peerClients) == 0 {
+		return nil, fmt.Errorf("no peers found")
+	}
+	return peerClients, nil
+}
+
+// findPeers announces to every tracker and asks the DHT for peers, returning
+// the addresses of all of them. The info hash is filled in
+func findPeers(ctx context.Context, torrent torrentparser.TorrentFile, sw swarm, r reporter, a tracker.Announce) []net.TCPAddr {
+	a.InfoHash = torrent.InfoHash
+	a.Logger = r.log
 
 	var peerAddrs []net.TCPAddr
 	var wg sync.WaitGroup
@@ -39,81 +406,52 @@ func NewDownload(source string) (*Download, error) {
 	// get peer addresses from trackers
 	wg.Add(len(torrent.TrackerURLs))
 	for _, trackerURL := range torrent.TrackerURLs {
-		trackerURL := trackerURL
 		go func() {
 			defer wg.Done()
-			addrs, err := tracker.GetPeers(trackerURL, torrent.InfoHash, peerID, port)
+			addrs, err := announceTo(ctx, trackerURL, r, a)
 			if err != nil {
-				fmt.Printf("failed to get peers from tracker %s: %s\n", trackerURL, err.Error())
 				return
 			}
 
 			mut.Lock()
-			fmt.Printf("peers from %s: %d\n", trackerURL, len(addrs))
 			peerAddrs = append(peerAddrs, addrs...)
 			mut.Unlock()
 		}()
 	}
-	wg.Wait()
-
-	// dedupe peer addresses
-	peerAddrs = dedupeAddrs(peerAddrs)
-
-	// create all peer clients
-	var peerClients []*peer.Client
-	wg.Add(len(peerAddrs))
-	for _, addr := range peerAddrs {
-		addr := addr
+	// private torrents only get peers from their trackers
+	if sw.dht != nil && !torrent.Private {
+		for _, node := range torrent.Nodes {
+			sw.dht.AddNode(node)
+		}
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			client, err := peer.NewClient(addr, torrent.InfoHash, peerID)
-			if err != nil {
-				fmt.Printf("failed connecting to peer at %s: %s\n", addr.String(), err.Error())
-				return
-			}
+			addrs, err := sw.dht.GetPeers(ctx, torrent.InfoHash, sw.port)
+			r.publish(TrackerAnnounced{URL: dhtURL, Event: a.Event, Peers: len(addrs), Err: err})
 
 			mut.Lock()
-			peerClients = append(peerClients, client)
+			peerAddrs = append(peerAddrs, addrs...)
 			mut.Unlock()
 		}()
 	}
 	wg.Wait()
 
-	fmt.Println("total peer count:", len(peerClients))
-
-	if len(peerClients) == 0 {
-		return nil, fmt.Errorf("no peers found")
-	}
-
-	// get metadata if it was a magnet link
-	if strings.HasPrefix(source, "magnet") {
-		var err error
-		var metadataBytes []byte
-		for _, client := range peerClients {
-			metadataBytes, err = client.GetMetadata(torrent.InfoHash)
-			if err != nil {
-				fmt.Printf("failed to get metadata from peer %s: %s\n", client.Addr().String(), err.Error())
-			}
-			if err == nil {
-				break
-			}
-		}
-
-		if len(metadataBytes) == 0 {
-			return nil, fmt.Errorf("failed to get metadata from any peer")
-		}
+	return dedupeAddrs(peerAddrs)
+}
 
-		err = torrent.AppendMetadata(metadataBytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to append metadata: %w", err)
-		}
+// dialPeer connects to a peer holding a connection slot that was already
+// taken, the slot is released when the connection is closed
+func dialPeer(ctx context.Context, addr net.TCPAddr, infoHash [20]byte, sw swarm, r reporter) (*peer.Client, error) {
+	done := r.metrics.all().dialing()
+	client, err := peer.NewClientWithLogger(ctx, addr, infoHash, sw.peerID, r.log)
+	done()
+	if err != nil {
+		sw.slots.release()
+		return nil, err
 	}
-
-	return &Download{
-		Torrent:     torrent,
-		PeerClients: peerClients,
-		PeerId:      peerID,
-	}, nil
+	limitPeer(client, sw, r.metrics)
+	sw.addDHTNode(client)
+	return client, nil
 }
 
 // helper function to dedupe all the addresses from multiple tracker responses
@@ -0,0 +1,250 @@
+package bittorrent
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/givxl33t/bittorrent-client-go/tracker"
+)
+
+// Event is something that happened during a download, one of the event
+// types below
+type Event interface {
+	event()
+}
+
+// SourceKind is the kind of source pieces are downloaded from
+type SourceKind int
+
+const (
+	SourcePeer     SourceKind = iota
+	SourceWebSeed             // BEP0019 `url-list`
+	SourceHTTPSeed            // BEP0017 `httpseeds`
+)
+
+var sourceKindStrings = map[SourceKind]string{
+	SourcePeer:     "peer",
+	SourceWebSeed:  "web seed",
+	SourceHTTPSeed: "http seed",
+}
+
+func (k SourceKind) String() string {
+	return sourceKindStrings[k]
+}
+
+// TrackerAnnounced is the result of an announce to a tracker
+type TrackerAnnounced struct {
+	URL   string
+	Event tracker.Event
+	Peers int // peers the tracker answered with
+	Err   error
+}
+
+// PeerConnected is sent when a peer is connected or a seed is added
+type PeerConnected struct {
+	Addr   string // peer address or seed URL
+	Kind   SourceKind
+	Client string // client name the peer sent, if any
+}
+
+// PeerDisconnected is sent when a source stops being used, or couldn't be
+// connected to in the first place
+type PeerDisconnected struct {
+	Addr   string
+	Kind   SourceKind
+	Reason error // nil once the download is over
+}
+
+// MetadataReceived is sent when a magnet link's metadata is found, Source is
+// the peer it came from or "cache"
+type MetadataReceived struct {
+	Source string
+}
+
+// PieceRequested is sent when a source is asked for a piece
+type PieceRequested struct {
+	Index  int
+	Source string
+}
+
+// PieceVerified is sent when a piece passes its hash check and is written,
+// Verified and Wanted count the pieces of the selected files
+type PieceVerified struct {
+	Index    int
+	Source   string
+	Verified int
+	Wanted   int
+}
+
+// PieceFailed is sent when a source fails to deliver a piece, the piece is
+// requested again
+type PieceFailed struct {
+	Index  int
+	Source string
+	Err    error
+}
+
+// FileCompleted is sent when a selected file is complete on disk
+type FileCompleted struct {
+	Index  int
+	Path   string
+	Length int
+}
+
+// DownloadFinished is the last event of a run, Err is what Run returns
+type DownloadFinished struct {
+	Err error
+}
+
+// Warning is a problem that doesn't stop the download
+type Warning struct {
+	Err error
+}
+
+func (TrackerAnnounced) event() {}
+func (PeerConnected) event()    {}
+func (PeerDisconnected) event() {}
+func (MetadataReceived) event() {}
+func (PieceRequested) event()   {}
+func (PieceVerified) event()    {}
+func (PieceFailed) event()      {}
+func (FileCompleted) event()    {}
+func (DownloadFinished) event() {}
+func (Warning) event()          {}
+
+// maxQueuedEvents is how many events are queued for a subscriber, on top of
+// the ones being sent to it, before new ones are dropped
+const maxQueuedEvents = 8192
+
+// Events fans out the events of downloads to every subscriber. Each
+// subscriber gets the events in order, they are queued rather than blocking
+// the download when a subscriber falls behind. Once maxQueuedEvents are
+// queued for a subscriber the next ones are dropped, and it gets a Warning
+// saying how many when it catches up. DownloadFinished is queued even then,
+// so a subscriber always learns that a run ended
+//
+// A nil *Events discards events
+type Events struct {
+	mut  sync.Mutex
+	subs map[*subscriber]bool
+	log  *slog.Logger // warns about subscribers that fall behind, nil doesn't
+}
+
+func NewEvents() *Events {
+	return &Events{subs: map[*subscriber]bool{}}
+}
+
+// setLogger sets where subscribers falling behind are logged, unless it
+// already was
+func (e *Events) setLogger(log *slog.Logger) {
+	if e == nil || log == nil {
+		return
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.log == nil {
+		e.log = log
+	}
+}
+
+type subscriber struct {
+	mut     sync.Mutex
+	queue   []Event
+	dropped int // events dropped since the queue was last taken
+	notify  chan struct{}
+	done    chan struct{}
+}
+
+// take returns the queued events, after a Warning if some were dropped
+func (sub *subscriber) take() []Event {
+	sub.mut.Lock()
+	defer sub.mut.Unlock()
+	queue := sub.queue
+	sub.queue = nil
+	if sub.dropped > 0 {
+		err := fmt.Errorf("%d events were dropped, the subscriber fell behind", sub.dropped)
+		queue = append([]Event{Warning{Err: err}}, queue...)
+		sub.dropped = 0
+	}
+	return queue
+}
+
+// Subscribe returns a channel receiving every event published from now on,
+// and a function that unsubscribes. After unsubscribing the events already
+// queued are still sent before the channel is closed, so it must be received
+// from until it is closed. Subscribing to a nil *Events gets no events
+func (e *Events) Subscribe() (<-chan Event, func()) {
+	if e == nil {
+		out := make(chan Event)
+		var once sync.Once
+		return out, func() { once.Do(func() { close(out) }) }
+	}
+
+	sub := &subscriber{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	out := make(chan Event)
+
+	e.mut.Lock()
+	e.subs[sub] = true
+	e.mut.Unlock()
+
+	go func() {
+		defer close(out)
+		for {
+			for _, ev := range sub.take() {
+				out <- ev
+			}
+
+			select {
+			case <-sub.notify:
+			case <-sub.done:
+				// nothing is published after done is closed
+				for _, ev := range sub.take() {
+					out <- ev
+				}
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			e.mut.Lock()
+			delete(e.subs, sub)
+			e.mut.Unlock()
+			close(sub.done)
+		})
+	}
+	return out, unsubscribe
+}
+
+// publish queues an event for every subscriber
+func (e *Events) publish(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	for sub := range e.subs {
+		sub.mut.Lock()
+		// DownloadFinished is never dropped, subscribers wait for it
+		_, finished := ev.(DownloadFinished)
+		if len(sub.queue) >= maxQueuedEvents && !finished {
+			if sub.dropped == 0 && e.log != nil {
+				e.log.Warn("events subscriber fell behind, dropping events", "queued", len(sub.queue))
+			}
+			sub.dropped++
+		} else {
+			sub.queue = append(sub.queue, ev)
+		}
+		sub.mut.Unlock()
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+		}
+	}
+}
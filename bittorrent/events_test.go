@@ -0,0 +1,82 @@
+package bittorrent
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSubscriberFallingBehindDropsEvents(t *testing.T) {
+	var logs bytes.Buffer
+	e := NewEvents()
+	e.setLogger(slog.New(slog.NewTextHandler(&logs, nil)))
+	sub, unsubscribe := e.Subscribe()
+
+	// nothing is received while publishing, so besides the events already
+	// taken to be sent at most maxQueuedEvents are kept
+	published := 3 * maxQueuedEvents
+	for i := range published {
+		e.publish(PieceVerified{Index: i})
+	}
+	unsubscribe()
+
+	var received, dropped int
+	last := -1
+	for ev := range sub {
+		switch ev := ev.(type) {
+		case PieceVerified:
+			if ev.Index <= last {
+				t.Fatalf("piece %d received after %d", ev.Index, last)
+			}
+			last = ev.Index
+			received++
+		case Warning:
+			var n int
+			_, err := fmt.Sscanf(ev.Err.Error(), "%d events were dropped", &n)
+			if err != nil {
+				t.Fatalf("unexpected warning: %s", ev.Err)
+			}
+			dropped += n
+		}
+	}
+	if received > 2*maxQueuedEvents {
+		t.Errorf("received %d of %d events, want at most %d", received, published, 2*maxQueuedEvents)
+	}
+	if received+dropped != published {
+		t.Errorf("received %d and were told of %d dropped, want %d together", received, dropped, published)
+	}
+	if !strings.Contains(logs.String(), "dropping events") {
+		t.Error("dropping events wasn't logged")
+	}
+}
+
+func TestSubscribeNilEvents(t *testing.T) {
+	var e *Events
+	sub, unsubscribe := e.Subscribe()
+	e.publish(Warning{})
+	unsubscribe()
+	unsubscribe()
+	for ev := range sub {
+		t.Fatalf("got %T from nil Events", ev)
+	}
+}
+
+func TestDownloadFinishedIsNeverDropped(t *testing.T) {
+	e := NewEvents()
+	sub, unsubscribe := e.Subscribe()
+	for i := range 2*maxQueuedEvents + 10 {
+		e.publish(PieceVerified{Index: i})
+	}
+	e.publish(DownloadFinished{})
+	unsubscribe()
+
+	var last Event
+	for ev := range sub {
+		last = ev
+	}
+	if _, ok := last.(DownloadFinished); !ok {
+		t.Fatalf("last event is %T, want DownloadFinished", last)
+	}
+}
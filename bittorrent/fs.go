@@ -0,0 +1,152 @@
+package bittorrent
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// FS returns the torrent's files as a file system, whose files implement
+// io.ReaderAt and io.Seeker. Reads block until the pieces they cover are
+// verified and raise their priority like a FileReader, so files can be read
+// before or while Run is in progress
+//
+// Padding files and symlinks are left out, directories are made up from the
+// file paths
+func (d *Download) FS() fs.FS {
+	tfs := &torrentFS{
+		d:       d,
+		files:   map[string]int{},
+		dirs:    map[string][]string{".": nil},
+		modTime: d.Torrent.CreationDate,
+	}
+	for i, f := range d.Torrent.Files {
+		name := filepath.ToSlash(f.Path)
+		if f.Padding || f.SymlinkPath != "" || !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		tfs.files[name] = i
+
+		// add the file to its directory and every directory to its parent
+		for name != "." {
+			dir := path.Dir(name)
+			_, seen := tfs.dirs[dir]
+			if !slices.Contains(tfs.dirs[dir], name) {
+				tfs.dirs[dir] = append(tfs.dirs[dir], name)
+			}
+			if seen && dir != "." {
+				break
+			}
+			name = dir
+		}
+	}
+	for dir := range tfs.dirs {
+		slices.Sort(tfs.dirs[dir])
+	}
+	return tfs
+}
+
+type torrentFS struct {
+	d       *Download
+	files   map[string]int      // file path to file index
+	dirs    map[string][]string // directory path to the sorted paths in it
+	modTime time.Time
+}
+
+func (tfs *torrentFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if i, ok := tfs.files[name]; ok {
+		r, err := tfs.d.OpenFile(i)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &torrentFSFile{FileReader: r, info: tfs.stat(name)}, nil
+	}
+	if _, ok := tfs.dirs[name]; ok {
+		return &torrentFSDir{tfs: tfs, info: tfs.stat(name), entries: tfs.dirs[name]}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// stat describes a file or directory that exists
+func (tfs *torrentFS) stat(name string) fileInfo {
+	info := fileInfo{name: path.Base(name), modTime: tfs.modTime}
+	i, ok := tfs.files[name]
+	if !ok {
+		info.mode = fs.ModeDir | 0555
+		return info
+	}
+	f := tfs.d.Torrent.Files[i]
+	info.size = int64(f.Length)
+	info.mode = 0444
+	if f.Executable {
+		info.mode = 0555
+	}
+	return info
+}
+
+// torrentFSFile is a file of the torrent opened from its FS
+type torrentFSFile struct {
+	*FileReader
+	info fileInfo
+}
+
+func (f *torrentFSFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// torrentFSDir is a directory made up from the torrent's file paths
+type torrentFSDir struct {
+	tfs     *torrentFS
+	info    fileInfo
+	entries []string
+	read    int // entries already returned by ReadDir
+}
+
+func (d *torrentFSDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *torrentFSDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *torrentFSDir) Close() error {
+	return nil
+}
+
+func (d *torrentFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.read:]
+	if n > 0 {
+		if len(remaining) == 0 {
+			return nil, io.EOF
+		}
+		remaining = remaining[:min(n, len(remaining))]
+	}
+	entries := make([]fs.DirEntry, len(remaining))
+	for i, name := range remaining {
+		entries[i] = fs.FileInfoToDirEntry(d.tfs.stat(name))
+	}
+	d.read += len(remaining)
+	return entries, nil
+}
+
+// fileInfo implements fs.FileInfo for files and directories of a torrent
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
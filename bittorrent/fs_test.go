@@ -0,0 +1,104 @@
+package bittorrent
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// completedDownload creates a torrent of files with a web seed serving them,
+// and downloads it, returning the finished download
+func completedDownload(t *testing.T, version torrentparser.MetaVersion, files map[string][]byte) *Download {
+	t.Helper()
+	src := t.TempDir()
+	root := filepath.Join(src, "data")
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(src)))
+	t.Cleanup(srv.Close)
+
+	raw, err := torrentparser.Create(root, torrentparser.CreateOptions{
+		Version:     version,
+		PieceLength: 16 << 10,
+		WebSeeds:    []string{srv.URL + "/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDownload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Run(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+var fsTestFiles = map[string][]byte{
+	"readme.txt":          []byte("hello"),
+	"empty":               {},
+	"dir/big.bin":         pattern(40 << 10), // spans three pieces
+	"dir/sub/small.bin":   pattern(100),
+	"dir/sub/deeper/last": pattern(20 << 10),
+}
+
+func TestFS(t *testing.T) {
+	for _, version := range []torrentparser.MetaVersion{torrentparser.MetaV1, torrentparser.MetaHybrid} {
+		t.Run(version.String(), func(t *testing.T) {
+			d := completedDownload(t, version, fsTestFiles)
+			fsys, err := fs.Sub(d.FS(), "data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for name := range fsTestFiles {
+				names = append(names, name)
+			}
+			err = fstest.TestFS(fsys, names...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range fsTestFiles {
+				got, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != string(want) {
+					t.Errorf("%s has %d bytes that differ from the %d written", name, len(got), len(want))
+				}
+			}
+			// hybrid torrents have padding files, which are left out
+			padded := slices.ContainsFunc(d.Torrent.Files, func(f torrentparser.File) bool { return f.Padding })
+			if padded != (version == torrentparser.MetaHybrid) {
+				t.Fatalf("torrent has padding files: %t", padded)
+			}
+			if _, err := fs.Stat(d.FS(), "data/.pad"); err == nil {
+				t.Error("padding directory is in the FS")
+			}
+		})
+	}
+}
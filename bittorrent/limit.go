@@ -0,0 +1,263 @@
+package bittorrent
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/peer"
+)
+
+// rateLimiter is a token bucket of bytes shared by every connection it's
+// applied to, bytes are paid for after they're read so a read never waits
+// for tokens it may not use. Its rate can change while it's used, nil is
+// unlimited
+type rateLimiter struct {
+	mut     sync.Mutex
+	rate    float64 // bytes per second, 0 is unlimited
+	tokens  float64 // negative while readers wait for their debt to refill
+	last    time.Time
+	changed chan struct{} // closed when the rate changes, for waiters
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(bytesPerSecond),
+		tokens:  float64(bytesPerSecond),
+		last:    time.Now(),
+		changed: make(chan struct{}),
+	}
+}
+
+// limit returns the rate in bytes per second, 0 is unlimited
+func (l *rateLimiter) limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return int64(l.rate)
+}
+
+// setLimit changes the rate, waiters are woken to wait for what they still
+// owe at the new rate
+func (l *rateLimiter) setLimit(bytesPerSecond int64) {
+	if l == nil {
+		return
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	now := time.Now()
+	if l.rate > 0 {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	} else {
+		// an unlimited bucket starts over full
+		l.tokens = float64(bytesPerSecond)
+	}
+	l.rate = float64(bytesPerSecond)
+	l.tokens = min(l.tokens, l.rate)
+	l.last = now
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// wait takes n tokens and waits until the bucket isn't in debt anymore, or
+// done is closed
+func (l *rateLimiter) wait(n int, done <-chan struct{}) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mut.Lock()
+	if l.rate <= 0 {
+		l.mut.Unlock()
+		return
+	}
+	now := time.Now()
+	// the bucket holds at most a second of tokens
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	rate := l.rate
+	changed := l.changed
+	l.mut.Unlock()
+
+	for debt > 0 {
+		until := time.Now().Add(time.Duration(debt / rate * float64(time.Second)))
+		timer := time.NewTimer(time.Until(until))
+		select {
+		case <-timer.C:
+			return
+		case <-done:
+			timer.Stop()
+			return
+		case <-changed:
+			timer.Stop()
+			// what is still owed is refilled at the new rate
+			l.mut.Lock()
+			debt = time.Until(until).Seconds() * rate
+			rate, changed = l.rate, l.changed
+			l.mut.Unlock()
+			if rate <= 0 {
+				return
+			}
+		}
+	}
+}
+
+// DownloadLimit returns the bytes per second the download downloads at most,
+// 0 is unlimited. A download of a Session is also limited by the session.
+// There is no upload limit, pieces are never uploaded
+func (d *Download) DownloadLimit() int64 {
+	return d.swarm.limit.limit()
+}
+
+// SetDownloadLimit changes the bytes per second the download downloads at
+// most, 0 is unlimited. Only piece data counts, it applies right away to the
+// running download
+func (d *Download) SetDownloadLimit(bytesPerSecond int64) {
+	d.swarm.limit.setLimit(bytesPerSecond)
+}
+
+// limiters pays to a few rate limiters at once, usually those of a torrent
+// and of every torrent
+type limiters []*rateLimiter
+
+func (ls limiters) wait(n int, done <-chan struct{}) {
+	for _, l := range ls {
+		l.wait(n, done)
+	}
+}
+
+// peerLimiter paces the piece messages of a peer connection, see
+// peer.Client.DownloadLimiter. It stops waiting once the connection is closed
+type peerLimiter struct {
+	limiters limiters
+	done     <-chan struct{}
+	received *atomic.Int64 // when piece data last came in, nil doesn't record it
+}
+
+func (l peerLimiter) Wait(n int) {
+	if l.received != nil {
+		l.received.Store(time.Now().UnixNano())
+	}
+	l.limiters.wait(n, l.done)
+}
+
+// connSlots limits how many peer connections are open at once, nil is
+// unlimited
+type connSlots struct {
+	mut   sync.Mutex
+	max   int
+	inUse int
+}
+
+func newConnSlots(max int) *connSlots {
+	return &connSlots{max: max}
+}
+
+// tryAcquire takes a slot if one is free
+func (s *connSlots) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.max > 0 && s.inUse >= s.max {
+		return false
+	}
+	s.inUse++
+	return true
+}
+
+func (s *connSlots) release() {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	s.inUse--
+	s.mut.Unlock()
+}
+
+// limitedConn is a peer connection that holds a connection slot until it is
+// closed, it's counted in the metrics of its torrent
+type limitedConn struct {
+	net.Conn
+	slots   *connSlots
+	metrics *torrentMetrics
+	once    sync.Once
+	closed  chan struct{}
+
+	received atomic.Int64 // unix nanos of the last piece data, for spotting snubbing peers
+}
+
+func newLimitedConn(conn net.Conn, slots *connSlots, metrics *torrentMetrics) *limitedConn {
+	metrics.all().connOpened()
+	return &limitedConn{Conn: conn, slots: slots, metrics: metrics, closed: make(chan struct{})}
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.metrics.addSent(n)
+	return n, err
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		close(c.closed)
+		c.slots.release()
+		c.metrics.all().connClosed()
+	})
+	return err
+}
+
+// limitPeer wraps the connection of a peer that holds a connection slot, and
+// makes the piece messages it sends pay to the download limiters of sw.
+// Other messages are never held back, so a slow download doesn't delay the
+// requests and haves that keep it going
+func limitPeer(client *peer.Client, sw swarm, metrics *torrentMetrics) {
+	conn := newLimitedConn(client.Conn, sw.slots, metrics)
+	client.Conn = conn
+	client.DownloadLimiter = peerLimiter{limiters: sw.downloadLimiters(), done: conn.closed, received: &conn.received}
+}
+
+// limitedTransport pays for the response bodies of web seeds to rate
+// limiters
+type limitedTransport struct {
+	base     http.RoundTripper
+	limiters limiters
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, limiters: t.limiters, done: req.Context().Done()}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	limiters limiters
+	done     <-chan struct{}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.limiters.wait(n, b.done)
+	return n, err
+}
+
+// limitHTTPClient makes an http client's responses pay to rate limiters
+func limitHTTPClient(client *http.Client, ls limiters) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = limitedTransport{base: base, limiters: ls}
+}
@@ -0,0 +1,178 @@
+package bittorrent
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/peer"
+)
+
+const (
+	msgIDUnchoke = 1
+	msgIDRequest = 6
+	msgIDPiece   = 7
+)
+
+// loopbackSeed connects a peer client to a loopback seed that unchokes it and
+// answers every request with zeroed blocks
+func loopbackSeed(t *testing.T) *peer.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{0, 0, 0, 1, msgIDUnchoke})
+		for {
+			var length uint32
+			err := binary.Read(conn, binary.BigEndian, &length)
+			if err != nil {
+				return
+			}
+			msg := make([]byte, length)
+			_, err = io.ReadFull(conn, msg)
+			if err != nil {
+				return
+			}
+			if length != 13 || msg[0] != msgIDRequest {
+				continue
+			}
+			n := binary.BigEndian.Uint32(msg[9:13])
+			reply := make([]byte, 4+9+n)
+			binary.BigEndian.PutUint32(reply[0:4], 9+n)
+			reply[4] = msgIDPiece
+			copy(reply[5:13], msg[1:9])
+			_, err = conn.Write(reply)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &peer.Client{
+		Conn:     conn,
+		Choked:   true,
+		Bitfield: []byte{0xff, 0xff},
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// fetch downloads n zeroed pieces of length bytes from a client
+func fetch(t *testing.T, client *peer.Client, n, length int) {
+	t.Helper()
+	hash := sha1.Sum(make([]byte, length))
+	for i := range n {
+		_, err := client.GetPiece(i, length, hash)
+		if err != nil {
+			t.Errorf("piece %d: %s", i, err)
+			return
+		}
+	}
+}
+
+func within(t *testing.T, got, low, high time.Duration) {
+	t.Helper()
+	if got < low || got > high {
+		t.Fatalf("took %s, want between %s and %s", got, low, high)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(500_000)
+	start := time.Now()
+	// a second's worth is in the bucket, the second one is waited for
+	for range 100 {
+		l.wait(10_000, nil)
+	}
+	within(t, time.Since(start), 800*time.Millisecond, 1500*time.Millisecond)
+}
+
+func TestRateLimiterSetLimitWakesWaiters(t *testing.T) {
+	l := newRateLimiter(1000)
+	l.wait(1000, nil)
+
+	// owing 10s at the old rate, 10ms at the new one
+	time.AfterFunc(100*time.Millisecond, func() { l.setLimit(1_000_000) })
+	start := time.Now()
+	l.wait(10_000, nil)
+	within(t, time.Since(start), 50*time.Millisecond, time.Second)
+
+	// unlimited stops waiting right away
+	l.setLimit(1000)
+	l.wait(1000, nil)
+	time.AfterFunc(100*time.Millisecond, func() { l.setLimit(0) })
+	start = time.Now()
+	l.wait(10_000, nil)
+	within(t, time.Since(start), 50*time.Millisecond, time.Second)
+}
+
+func TestRateLimiterWaitStopsWhenDone(t *testing.T) {
+	l := newRateLimiter(1000)
+	done := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	l.wait(100_000, done)
+	within(t, time.Since(start), 50*time.Millisecond, time.Second)
+}
+
+func TestLimitPeerRate(t *testing.T) {
+	sw := Options{}.swarm()
+	sw.limit = newRateLimiter(512 << 10)
+	client := loopbackSeed(t)
+	limitPeer(client, sw, nil)
+
+	// 512 KiB is in the bucket, the other 512 KiB takes a second
+	start := time.Now()
+	fetch(t, client, 4, 256<<10)
+	within(t, time.Since(start), 800*time.Millisecond, 1800*time.Millisecond)
+}
+
+func TestLimitPeerGlobalRate(t *testing.T) {
+	sw := Options{global: newRateLimiter(512 << 10)}.swarm()
+	client := loopbackSeed(t)
+	limitPeer(client, sw, nil)
+
+	start := time.Now()
+	fetch(t, client, 4, 256<<10)
+	within(t, time.Since(start), 800*time.Millisecond, 1800*time.Millisecond)
+}
+
+func TestSetDownloadLimitMidTransfer(t *testing.T) {
+	sw := Options{}.swarm()
+	sw.limit = newRateLimiter(128 << 10)
+	client := loopbackSeed(t)
+	limitPeer(client, sw, nil)
+	d := &Download{swarm: sw}
+
+	// 2 MiB at 128 KiB/s takes 15s, raised to 2 MiB/s after half a second
+	// the rest takes about a second
+	time.AfterFunc(500*time.Millisecond, func() { d.SetDownloadLimit(2 << 20) })
+	start := time.Now()
+	fetch(t, client, 8, 256<<10)
+	within(t, time.Since(start), time.Second, 3*time.Second)
+	if download := d.DownloadLimit(); download != 2<<20 {
+		t.Fatalf("download limit is %d, want %d", download, 2<<20)
+	}
+
+	// lowered again it slows down, the bucket was emptied by the last
+	// transfer so 512 KiB at 256 KiB/s takes two seconds
+	d.SetDownloadLimit(256 << 10)
+	start = time.Now()
+	fetch(t, client, 2, 256<<10)
+	within(t, time.Since(start), 1500*time.Millisecond, 3*time.Second)
+}
@@ -0,0 +1,71 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+)
+
+// reporter publishes the events of a download and logs them, user-facing
+// progress at info level and swarm chatter at debug level, and counts what
+// the download does in its metrics
+type reporter struct {
+	events  *Events
+	log     *slog.Logger
+	metrics *torrentMetrics // nil counts nothing
+}
+
+func (r reporter) publish(ev Event) {
+	r.events.publish(ev)
+	logEvent(r.log, ev)
+}
+
+// report returns the reporter of the download
+func (d *Download) report() reporter {
+	return reporter{events: d.Events, log: d.log, metrics: d.metrics}
+}
+
+// logEvent logs an event with the attributes every package uses
+func logEvent(log *slog.Logger, ev Event) {
+	switch ev := ev.(type) {
+	case TrackerAnnounced:
+		if ev.Err != nil {
+			log.Warn("announce failed", logging.Tracker, ev.URL, "event", ev.Event.String(), "err", ev.Err)
+			return
+		}
+		log.Info("announced", logging.Tracker, ev.URL, "event", ev.Event.String(), "peers", ev.Peers)
+	case PeerConnected:
+		if ev.Client != "" {
+			log = log.With(logging.Client, ev.Client)
+		}
+		log.Info("connected", logging.Peer, ev.Addr, "kind", ev.Kind.String())
+	case PeerDisconnected:
+		log.Debug("disconnected", logging.Peer, ev.Addr, "kind", ev.Kind.String(), "err", ev.Reason)
+	case MetadataReceived:
+		log.Info("received metadata", logging.Peer, ev.Source)
+	case PieceRequested:
+		log.Debug("requested piece", logging.Piece, ev.Index, logging.Peer, ev.Source)
+	case PieceVerified:
+		log.Info("verified piece", logging.Piece, ev.Index, logging.Peer, ev.Source,
+			"verified", ev.Verified,
+			"wanted", ev.Wanted,
+		)
+	case PieceFailed:
+		log.Warn("piece failed", logging.Piece, ev.Index, logging.Peer, ev.Source, "err", ev.Err)
+	case FileCompleted:
+		log.Info("completed file", "path", ev.Path, "length", ev.Length)
+	case DownloadFinished:
+		switch {
+		case ev.Err == nil:
+			log.Info("download finished")
+		case errors.Is(ev.Err, context.Canceled):
+			log.Info("download stopped")
+		default:
+			log.Error("download failed", "err", ev.Err)
+		}
+	case Warning:
+		log.Warn(ev.Err.Error())
+	}
+}
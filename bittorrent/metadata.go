@@ -0,0 +1,164 @@
+package bittorrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+	"github.com/givxl33t/bittorrent-client-go/peer"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+	"github.com/givxl33t/bittorrent-client-go/tracker"
+)
+
+// DefaultMetadataCacheDir is where fetched magnet link metadata is cached,
+// inside the user's cache directory
+func DefaultMetadataCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bittorrent-client-go", "metadata")
+}
+
+// metadataCachePath is the cached info dictionary for an info hash
+func metadataCachePath(cacheDir string, infoHash [20]byte) string {
+	return filepath.Join(cacheDir, hex.EncodeToString(infoHash[:])+".info")
+}
+
+// loadCachedMetadata returns the cached info dictionary of an info hash,
+// entries that don't hash to the info hash are ignored
+func loadCachedMetadata(cacheDir string, infoHash [20]byte) ([]byte, bool) {
+	if cacheDir == "" {
+		return nil, false
+	}
+	metadata, err := os.ReadFile(metadataCachePath(cacheDir, infoHash))
+	if err != nil {
+		return nil, false
+	}
+	if sha1.Sum(metadata) != infoHash {
+		return nil, false
+	}
+	return metadata, true
+}
+
+// saveCachedMetadata writes an info dictionary to the cache, written to a
+// temporary file first so a partial write is never read back
+func saveCachedMetadata(cacheDir string, infoHash [20]byte, metadata []byte) error {
+	if cacheDir == "" {
+		return nil
+	}
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating metadata cache: %w", err)
+	}
+
+	path := metadataCachePath(cacheDir, infoHash)
+	tmp, err := os.CreateTemp(cacheDir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating metadata cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(metadata)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing metadata cache file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// appendMetadata adds metadata to a magnet link torrent, from the cache if
+// possible and otherwise from the first peer that sends it
+func appendMetadata(ctx context.Context, torrent *torrentparser.TorrentFile, peerClients []*peer.Client, cacheDir string, r reporter) error {
+	metadataBytes, ok := loadCachedMetadata(cacheDir, torrent.InfoHash)
+	if ok {
+		r.publish(MetadataReceived{Source: "cache"})
+	} else {
+		var err error
+		for _, client := range peerClients {
+			metadataBytes, err = client.GetMetadataContext(ctx, torrent.InfoHash)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				r.publish(Warning{Err: fmt.Errorf("failed to get metadata from peer %s: %w", client, err)})
+				continue
+			}
+			r.publish(MetadataReceived{Source: client.String()})
+			break
+		}
+
+		if len(metadataBytes) == 0 {
+			return fmt.Errorf("failed to get metadata from any peer")
+		}
+	}
+
+	err := torrent.AppendMetadata(metadataBytes)
+	if err != nil {
+		return fmt.Errorf("failed to append metadata: %w", err)
+	}
+
+	if !ok {
+		err = saveCachedMetadata(cacheDir, torrent.InfoHash, metadataBytes)
+		if err != nil {
+			// the download can go on without the cache
+			r.publish(Warning{Err: fmt.Errorf("failed to cache metadata: %w", err)})
+		}
+	}
+
+	return nil
+}
+
+// FetchMetadata resolves a magnet link into a complete torrent, using the
+// metadata cache or the swarm without downloading any pieces
+func FetchMetadata(magnetLink string, opts Options) (torrentparser.TorrentFile, error) {
+	return FetchMetadataContext(context.Background(), magnetLink, opts)
+}
+
+// FetchMetadataContext is FetchMetadata that gives up when ctx is canceled
+func FetchMetadataContext(ctx context.Context, magnetLink string, opts Options) (torrentparser.TorrentFile, error) {
+	torrent, err := torrentparser.ParseMagnetLink(magnetLink)
+	if err != nil {
+		return torrentparser.TorrentFile{}, fmt.Errorf("failed to parse magnet link: %w", err)
+	}
+
+	if metadata, ok := loadCachedMetadata(opts.MetadataCacheDir, torrent.InfoHash); ok {
+		err = torrent.AppendMetadata(metadata)
+		if err != nil {
+			return torrentparser.TorrentFile{}, fmt.Errorf("failed to append metadata: %w", err)
+		}
+		return torrent, nil
+	}
+
+	sw := opts.swarm()
+	r := reporter{
+		events:  opts.Events,
+		log:     logging.OrDiscard(opts.Logger).With(logging.HashAttr(torrent.InfoHash)),
+		metrics: opts.Metrics.torrent(torrent.InfoHash),
+	}
+	// the swarm is left as soon as the metadata is fetched
+	defer announceTrackers(context.WithoutCancel(ctx), torrent, r, tracker.Announce{
+		PeerID: sw.peerID,
+		Port:   sw.port,
+		Event:  tracker.EventStopped,
+	})
+	peerClients, err := connectPeers(ctx, torrent, newConnManager(torrent.InfoHash, sw, r))
+	defer closePeers(peerClients)
+	if ctx.Err() != nil {
+		return torrentparser.TorrentFile{}, ctx.Err()
+	}
+	if err != nil {
+		return torrentparser.TorrentFile{}, err
+	}
+
+	err = appendMetadata(ctx, &torrent, peerClients, opts.MetadataCacheDir, r)
+	if err != nil {
+		return torrentparser.TorrentFile{}, err
+	}
+	return torrent, nil
+}
@@ -0,0 +1,143 @@
+package bittorrent
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// testMetadata returns the info dict and info hash of a torrent of one file
+func testMetadata(t *testing.T) ([]byte, [20]byte) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "file.bin")
+	err := os.WriteFile(root, pattern(20<<10), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := torrentparser.Create(root, torrentparser.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "file.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := torrentparser.ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf.Metadata, tf.InfoHash
+}
+
+func TestMetadataCache(t *testing.T) {
+	metadata, infoHash := testMetadata(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	// miss
+	if _, ok := loadCachedMetadata(dir, infoHash); ok {
+		t.Fatal("hit in a missing cache dir")
+	}
+	if _, ok := loadCachedMetadata("", infoHash); ok {
+		t.Fatal("hit with the cache turned off")
+	}
+	err := saveCachedMetadata("", infoHash, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hit
+	err = saveCachedMetadata(dir, infoHash, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := loadCachedMetadata(dir, infoHash)
+	if !ok || !bytes.Equal(got, metadata) {
+		t.Fatalf("cached metadata is %d bytes, found %t", len(got), ok)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != hex.EncodeToString(infoHash[:])+".info" {
+		t.Errorf("cache dir has %v", entries)
+	}
+	// another info hash doesn't hit
+	if _, ok := loadCachedMetadata(dir, [20]byte{1}); ok {
+		t.Error("hit for another info hash")
+	}
+
+	// corrupt
+	err = os.WriteFile(metadataCachePath(dir, infoHash), metadata[:len(metadata)-1], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadCachedMetadata(dir, infoHash); ok {
+		t.Error("hit for a truncated entry")
+	}
+}
+
+func TestAppendMetadataFromCache(t *testing.T) {
+	metadata, infoHash := testMetadata(t)
+	dir := t.TempDir()
+	magnet := "magnet:?xt=urn:btih:" + hex.EncodeToString(infoHash[:]) + "&tr=http%3A%2F%2F127.0.0.1%3A1%2Fannounce"
+
+	// with nothing cached and no peers there is no metadata
+	torrent, err := torrentparser.ParseMagnetLink(magnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = appendMetadata(context.Background(), &torrent, nil, dir, reporter{log: logging.OrDiscard(nil)})
+	if err == nil {
+		t.Fatal("got metadata from nowhere")
+	}
+
+	// nor with a corrupt entry
+	err = os.WriteFile(metadataCachePath(dir, infoHash), []byte("d4:name1:xe"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = appendMetadata(context.Background(), &torrent, nil, dir, reporter{log: logging.OrDiscard(nil)})
+	if err == nil {
+		t.Fatal("used a corrupt cache entry")
+	}
+
+	err = saveCachedMetadata(dir, infoHash, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := NewEvents()
+	sub, unsubscribe := events.Subscribe()
+	err = appendMetadata(context.Background(), &torrent, nil, dir, reporter{events: events, log: logging.OrDiscard(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+	var sources []string
+	for ev := range sub {
+		if ev, ok := ev.(MetadataReceived); ok {
+			sources = append(sources, ev.Source)
+		}
+	}
+	if len(sources) != 1 || sources[0] != "cache" {
+		t.Errorf("metadata received from %q, want the cache", sources)
+	}
+	if torrent.Name != "file.bin" || torrent.Length != 20<<10 || !bytes.Equal(torrent.Metadata, metadata) {
+		t.Errorf("torrent is %q of %d bytes", torrent.Name, torrent.Length)
+	}
+
+	// fetching never touches the swarm on a hit
+	fetched, err := FetchMetadataContext(context.Background(), magnet, Options{MetadataCacheDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.InfoHash != infoHash || len(fetched.PieceHashes) != 2 {
+		t.Errorf("fetched %x with %d pieces", fetched.InfoHash, len(fetched.PieceHashes))
+	}
+}
@@ -0,0 +1,269 @@
+package bittorrent
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/prometheus"
+)
+
+// announce and disk write latency buckets, in seconds
+var (
+	announceBuckets  = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	diskWriteBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+// Metrics counts what downloads do for monitoring, one Metrics can be shared
+// by many downloads through Options or a Session. It serves the counts in
+// the Prometheus text format
+type Metrics struct {
+	activeConns   atomic.Int64
+	halfOpenConns atomic.Int64
+	diskWrites    *prometheus.HistogramValue
+
+	mut      sync.Mutex
+	torrents map[[20]byte]*torrentMetrics
+	trackers map[string]*trackerMetrics
+}
+
+// torrentMetrics are the counts of a single torrent
+type torrentMetrics struct {
+	shared         *Metrics
+	infoHash       [20]byte
+	downloaded     atomic.Int64 // piece data received, including failed pieces
+	sent           atomic.Int64 // bytes sent to peers, all of it protocol messages
+	wasted         atomic.Int64 // piece data that failed its hash
+	piecesVerified atomic.Int64
+	piecesFailed   atomic.Int64
+
+	mut      sync.Mutex
+	download *Download // set once the download is set up, for its name and rate
+}
+
+type trackerMetrics struct {
+	latency *prometheus.HistogramValue
+	errors  atomic.Int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		diskWrites: prometheus.NewHistogram(diskWriteBuckets...),
+		torrents:   map[[20]byte]*torrentMetrics{},
+		trackers:   map[string]*trackerMetrics{},
+	}
+}
+
+// torrent returns the counts of a torrent, nil when m is nil
+func (m *Metrics) torrent(infoHash [20]byte) *torrentMetrics {
+	if m == nil {
+		return nil
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	tm, ok := m.torrents[infoHash]
+	if !ok {
+		tm = &torrentMetrics{shared: m, infoHash: infoHash}
+		m.torrents[infoHash] = tm
+	}
+	return tm
+}
+
+// forget drops the counts of a torrent that was removed
+func (m *Metrics) forget(infoHash [20]byte) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	delete(m.torrents, infoHash)
+	m.mut.Unlock()
+}
+
+// observeAnnounce records how long an announce to a tracker took
+func (m *Metrics) observeAnnounce(trackerURL string, took time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	label := trackerLabel(trackerURL)
+	m.mut.Lock()
+	tr, ok := m.trackers[label]
+	if !ok {
+		tr = &trackerMetrics{latency: prometheus.NewHistogram(announceBuckets...)}
+		m.trackers[label] = tr
+	}
+	m.mut.Unlock()
+	tr.latency.Observe(took.Seconds())
+	if err != nil {
+		tr.errors.Add(1)
+	}
+}
+
+// trackerLabel identifies a tracker by its scheme and host, the path and
+// query of private trackers hold passkeys that shouldn't end up in metrics
+func trackerLabel(trackerURL string) string {
+	u, err := url.Parse(trackerURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// observeDiskWrite records how long writing a piece took
+func (m *Metrics) observeDiskWrite(took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.diskWrites.Observe(took.Seconds())
+}
+
+// dialing counts a connection being set up until the returned func is called
+func (m *Metrics) dialing() (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	m.halfOpenConns.Add(1)
+	return func() { m.halfOpenConns.Add(-1) }
+}
+
+func (m *Metrics) connOpened() {
+	if m != nil {
+		m.activeConns.Add(1)
+	}
+}
+
+func (m *Metrics) connClosed() {
+	if m != nil {
+		m.activeConns.Add(-1)
+	}
+}
+
+// all returns the metrics shared with other torrents, nil when tm is nil
+func (tm *torrentMetrics) all() *Metrics {
+	if tm == nil {
+		return nil
+	}
+	return tm.shared
+}
+
+func (tm *torrentMetrics) setDownload(d *Download) {
+	if tm == nil {
+		return
+	}
+	tm.mut.Lock()
+	tm.download = d
+	tm.mut.Unlock()
+}
+
+// addSent counts bytes written to a peer connection
+func (tm *torrentMetrics) addSent(n int) {
+	if tm != nil {
+		tm.sent.Add(int64(n))
+	}
+}
+
+// pieceDownloaded counts a piece received from a source, verified or not
+func (tm *torrentMetrics) pieceDownloaded(n int, verified bool) {
+	if tm == nil {
+		return
+	}
+	tm.downloaded.Add(int64(n))
+	if verified {
+		tm.piecesVerified.Add(1)
+	} else {
+		tm.wasted.Add(int64(n))
+		tm.piecesFailed.Add(1)
+	}
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prometheus.ContentType)
+	m.WritePrometheus(w)
+}
+
+// torrentSample is the labels and counts of a torrent for WritePrometheus
+type torrentSample struct {
+	labels []string
+	tm     *torrentMetrics
+	rate   float64
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mut.Lock()
+	var torrents []torrentSample
+	for _, tm := range m.torrents {
+		torrents = append(torrents, torrentSample{tm: tm})
+	}
+	type trackerSample struct {
+		label string
+		tr    *trackerMetrics
+	}
+	var trackers []trackerSample
+	for label, tr := range m.trackers {
+		trackers = append(trackers, trackerSample{label, tr})
+	}
+	m.mut.Unlock()
+	for i, s := range torrents {
+		s.tm.mut.Lock()
+		d := s.tm.download
+		s.tm.mut.Unlock()
+		var name string
+		if d != nil {
+			name = d.Torrent.Name
+			torrents[i].rate = d.downloadRate()
+		}
+		torrents[i].labels = []string{"infohash", hex.EncodeToString(s.tm.infoHash[:]), "name", name}
+	}
+	sort.Slice(torrents, func(i, j int) bool { return torrents[i].labels[1] < torrents[j].labels[1] })
+	sort.Slice(trackers, func(i, j int) bool { return trackers[i].label < trackers[j].label })
+
+	pw := prometheus.NewWriter(w)
+	perTorrent := func(name, typ, help string, value func(s torrentSample) float64) {
+		pw.Family(name, typ, help)
+		for _, s := range torrents {
+			pw.Sample(name, value(s), s.labels...)
+		}
+	}
+	perTorrent("bittorrent_downloaded_bytes_total", prometheus.Counter,
+		"Piece data received from peers and seeds, including pieces that failed their hash.",
+		func(s torrentSample) float64 { return float64(s.tm.downloaded.Load()) })
+	perTorrent("bittorrent_sent_bytes_total", prometheus.Counter,
+		"Bytes sent to peers including protocol overhead: handshakes, requests and other messages. No piece data is uploaded.",
+		func(s torrentSample) float64 { return float64(s.tm.sent.Load()) })
+	perTorrent("bittorrent_wasted_bytes_total", prometheus.Counter,
+		"Piece data thrown away because it failed its hash.",
+		func(s torrentSample) float64 { return float64(s.tm.wasted.Load()) })
+	perTorrent("bittorrent_pieces_verified_total", prometheus.Counter,
+		"Pieces downloaded that matched their hash.",
+		func(s torrentSample) float64 { return float64(s.tm.piecesVerified.Load()) })
+	perTorrent("bittorrent_pieces_failed_total", prometheus.Counter,
+		"Pieces downloaded that failed their hash.",
+		func(s torrentSample) float64 { return float64(s.tm.piecesFailed.Load()) })
+	perTorrent("bittorrent_download_rate_bytes", prometheus.Gauge,
+		"Bytes per second downloaded over the last few seconds.",
+		func(s torrentSample) float64 { return s.rate })
+
+	pw.Family("bittorrent_connections_active", prometheus.Gauge, "Open peer connections.")
+	pw.Sample("bittorrent_connections_active", float64(m.activeConns.Load()))
+	pw.Family("bittorrent_connections_half_open", prometheus.Gauge, "Peer connections being dialed or handshaked.")
+	pw.Sample("bittorrent_connections_half_open", float64(m.halfOpenConns.Load()))
+
+	pw.Family("bittorrent_tracker_announce_duration_seconds", prometheus.Histogram, "How long announces to a tracker took, including failed ones.")
+	for _, t := range trackers {
+		pw.Histogram("bittorrent_tracker_announce_duration_seconds", t.tr.latency, "tracker", t.label)
+	}
+	pw.Family("bittorrent_tracker_announce_errors_total", prometheus.Counter, "Announces to a tracker that failed.")
+	for _, t := range trackers {
+		pw.Sample("bittorrent_tracker_announce_errors_total", float64(t.tr.errors.Load()), "tracker", t.label)
+	}
+
+	pw.Family("bittorrent_disk_write_duration_seconds", prometheus.Histogram, "How long writing a verified piece to disk took.")
+	pw.Histogram("bittorrent_disk_write_duration_seconds", m.diskWrites)
+	return pw.Flush()
+}
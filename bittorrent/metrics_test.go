@@ -0,0 +1,107 @@
+package bittorrent
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/prometheus"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := NewMetrics()
+	tm := m.torrent([20]byte{0xab})
+	tm.setDownload(&Download{Torrent: torrentparser.TorrentFile{Name: `a "quoted" name`}})
+	tm.pieceDownloaded(100, true)
+	tm.pieceDownloaded(40, false)
+	tm.addSent(7)
+	m.observeAnnounce("https://tracker.example/announce?passkey=secret", 300*time.Millisecond, nil)
+	m.observeAnnounce("https://tracker.example/announce?passkey=secret", 20*time.Second, errors.New("timeout"))
+	m.observeDiskWrite(3 * time.Millisecond)
+	m.connOpened()
+	done := m.dialing()
+	defer done()
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != prometheus.ContentType {
+		t.Fatalf("content type %q, want %q", ct, prometheus.ContentType)
+	}
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(raw)
+
+	labels := `{infohash="ab00000000000000000000000000000000000000",name="a \"quoted\" name"}`
+	for _, want := range []string{
+		"bittorrent_downloaded_bytes_total" + labels + " 140",
+		"bittorrent_sent_bytes_total" + labels + " 7",
+		"bittorrent_wasted_bytes_total" + labels + " 40",
+		"bittorrent_pieces_verified_total" + labels + " 1",
+		"bittorrent_pieces_failed_total" + labels + " 1",
+		"bittorrent_connections_active 1",
+		"bittorrent_connections_half_open 1",
+		`bittorrent_tracker_announce_duration_seconds_bucket{tracker="https://tracker.example",le="0.5"} 1`,
+		`bittorrent_tracker_announce_duration_seconds_bucket{tracker="https://tracker.example",le="+Inf"} 2`,
+		`bittorrent_tracker_announce_duration_seconds_count{tracker="https://tracker.example"} 2`,
+		`bittorrent_tracker_announce_errors_total{tracker="https://tracker.example"} 1`,
+		`bittorrent_disk_write_duration_seconds_bucket{le="0.0025"} 0`,
+		`bittorrent_disk_write_duration_seconds_bucket{le="0.005"} 1`,
+		"bittorrent_disk_write_duration_seconds_count 1",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	// tracker paths and queries hold passkeys
+	if strings.Contains(body, "secret") {
+		t.Error("tracker passkey is in the metrics")
+	}
+
+	// every sample belongs to a family declared before it
+	types := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, typ, _ := strings.Cut(rest, " ")
+			types[name] = typ
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, _ := strings.Cut(line, " ")
+		name, _, _ = strings.Cut(name, "{")
+		if _, ok := types[name]; ok {
+			continue
+		}
+		family := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if types[family] != prometheus.Histogram {
+			t.Errorf("sample %s has no family", name)
+		}
+	}
+
+	// removed torrents are no longer served
+	m.forget([20]byte{0xab})
+	var b strings.Builder
+	err = m.WritePrometheus(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "infohash=") {
+		t.Error("forgotten torrent is still in the metrics")
+	}
+}
@@ -0,0 +1,285 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// pieceState tracks a piece through the download
+type pieceState int
+
+const (
+	pieceMissing pieceState = iota
+	pieceRequested
+	pieceVerified
+)
+
+// picker hands out the pieces workers should download next. Pieces someone is
+// waiting to read have a deadline and are picked first, earliest deadline
+// first, then the highest priority missing piece. Pieces of equal priority are
+// picked at random so sources spread over the torrent, or in order in
+// sequential mode
+type picker struct {
+	mut        sync.Mutex
+	cond       *sync.Cond
+	priority   []Priority // per piece, skipped pieces are only picked with a deadline
+	deadline   []time.Time
+	state      []pieceState
+	sequential bool
+	finished   chan struct{}
+	closed     bool
+}
+
+func newPicker(numPieces int) *picker {
+	pk := &picker{
+		priority: make([]Priority, numPieces),
+		deadline: make([]time.Time, numPieces),
+		state:    make([]pieceState, numPieces),
+		finished: make(chan struct{}),
+	}
+	pk.cond = sync.NewCond(&pk.mut)
+	return pk
+}
+
+// Next blocks until there is a piece to download that the caller's source
+// has. It returns false once the download is finished, ctx is done or the
+// source has none of the pieces left to download
+func (pk *picker) Next(ctx context.Context, has func(int) bool) (int, bool) {
+	stop := context.AfterFunc(ctx, func() {
+		pk.mut.Lock()
+		pk.cond.Broadcast()
+		pk.mut.Unlock()
+	})
+	defer stop()
+
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	for {
+		if pk.closed || ctx.Err() != nil {
+			return 0, false
+		}
+		index, ok := pk.pick(has)
+		if ok {
+			pk.state[index] = pieceRequested
+			return index, true
+		}
+		if !pk.wants(has) {
+			return 0, false
+		}
+		pk.cond.Wait()
+	}
+}
+
+// pick chooses the best missing piece the source has, the caller holds the
+// lock
+func (pk *picker) pick(has func(int) bool) (int, bool) {
+	// the earliest deadline is picked whatever its priority
+	best := -1
+	for i, deadline := range pk.deadline {
+		if deadline.IsZero() || pk.state[i] != pieceMissing || !has(i) {
+			continue
+		}
+		if best < 0 || deadline.Before(pk.deadline[best]) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return best, true
+	}
+
+	candidates := 0
+	bestPriority := PrioritySkip
+	for i, state := range pk.state {
+		p := pk.priority[i]
+		if state != pieceMissing || p == PrioritySkip || p < bestPriority || !has(i) {
+			continue
+		}
+		if p > bestPriority {
+			best, bestPriority, candidates = i, p, 0
+		}
+		if pk.sequential {
+			// the lowest index is already kept
+			continue
+		}
+		// reservoir sample a random piece of the best priority
+		candidates++
+		if rand.Intn(candidates) == 0 {
+			best = i
+		}
+	}
+	return best, best >= 0
+}
+
+// wants reports whether a source has any wanted piece that isn't verified,
+// including the ones being downloaded from other sources. The caller holds
+// the lock
+func (pk *picker) wants(has func(int) bool) bool {
+	for i, state := range pk.state {
+		if state == pieceVerified || pk.priority[i] == PrioritySkip && pk.deadline[i].IsZero() {
+			continue
+		}
+		if has(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// Requeue makes a requested piece available again after its source failed
+func (pk *picker) Requeue(index int) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	if pk.state[index] == pieceRequested {
+		pk.state[index] = pieceMissing
+	}
+	pk.cond.Broadcast()
+	pk.checkFinished()
+}
+
+// Verified marks a piece as downloaded and stored
+func (pk *picker) Verified(index int) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	pk.state[index] = pieceVerified
+	pk.deadline[index] = time.Time{}
+	pk.cond.Broadcast()
+	pk.checkFinished()
+}
+
+// SetDeadline asks for a piece to be downloaded before others, by the time it
+// is needed. A zero time removes the deadline
+func (pk *picker) SetDeadline(index int, deadline time.Time) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	if pk.state[index] == pieceVerified {
+		return
+	}
+	pk.deadline[index] = deadline
+	pk.cond.Broadcast()
+}
+
+var errPieceUnavailable = errors.New("piece wasn't downloaded before the download stopped")
+
+// Wait blocks until a piece is verified, or fails if the download stops
+// without it or ctx is done
+func (pk *picker) Wait(ctx context.Context, index int) error {
+	stop := context.AfterFunc(ctx, func() {
+		pk.mut.Lock()
+		pk.cond.Broadcast()
+		pk.mut.Unlock()
+	})
+	defer stop()
+
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	for pk.state[index] != pieceVerified {
+		if pk.closed {
+			return errPieceUnavailable
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		pk.cond.Wait()
+	}
+	return nil
+}
+
+// SetState overrides a piece's state, for pieces found on disk or lost from it
+func (pk *picker) SetState(index int, state pieceState) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	pk.state[index] = state
+	if state == pieceVerified {
+		pk.deadline[index] = time.Time{}
+	}
+	pk.cond.Broadcast()
+}
+
+// State returns a piece's state
+func (pk *picker) State(index int) pieceState {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	return pk.state[index]
+}
+
+// Update replaces the priority of every piece and the picking order
+func (pk *picker) Update(priority []Priority, sequential bool) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	copy(pk.priority, priority)
+	pk.sequential = sequential
+	pk.cond.Broadcast()
+}
+
+// Check finishes the download if nothing is left to download, for when that
+// is the case from the start or after priorities change
+func (pk *picker) Check() {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	pk.checkFinished()
+}
+
+// checkFinished closes finished once every wanted piece and every piece with
+// a deadline is verified and no piece is being downloaded, the caller holds
+// the lock
+func (pk *picker) checkFinished() {
+	if pk.closed {
+		return
+	}
+	for i, state := range pk.state {
+		if state == pieceRequested {
+			return
+		}
+		if state == pieceMissing && (pk.priority[i] != PrioritySkip || !pk.deadline[i].IsZero()) {
+			return
+		}
+	}
+	pk.close()
+}
+
+// Progress returns how many wanted pieces are verified out of all of them
+func (pk *picker) Progress() (verified, wanted int) {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	for i, state := range pk.state {
+		if pk.priority[i] == PrioritySkip {
+			continue
+		}
+		wanted++
+		if state == pieceVerified {
+			verified++
+		}
+	}
+	return verified, wanted
+}
+
+// Finished is closed when the download is done or stopped
+func (pk *picker) Finished() <-chan struct{} {
+	return pk.finished
+}
+
+// Closed reports whether the download is done or stopped
+func (pk *picker) Closed() bool {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	return pk.closed
+}
+
+// Close stops the download, waking every worker waiting for a piece
+func (pk *picker) Close() {
+	pk.mut.Lock()
+	defer pk.mut.Unlock()
+	pk.close()
+}
+
+func (pk *picker) close() {
+	if pk.closed {
+		return
+	}
+	pk.closed = true
+	close(pk.finished)
+	pk.cond.Broadcast()
+}
@@ -0,0 +1,139 @@
+package bittorrent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func all(int) bool { return true }
+
+// drain picks every piece it can from pk, in order. Nothing is verified so
+// the source stops having pieces once they are requested, rather than
+// waiting on them
+func drain(t *testing.T, pk *picker, has func(int) bool) []int {
+	t.Helper()
+	var picked []int
+	for {
+		index, ok := pk.Next(context.Background(), func(i int) bool {
+			return has(i) && pk.state[i] == pieceMissing
+		})
+		if !ok {
+			return picked
+		}
+		picked = append(picked, index)
+	}
+}
+
+func TestPickerSequential(t *testing.T) {
+	pk := newPicker(6)
+	pk.Update([]Priority{PriorityNormal, PriorityNormal, PrioritySkip, PriorityNormal, PriorityHigh, PriorityNormal}, true)
+	// higher priorities first, then in order, skipped pieces never
+	if got := fmt.Sprint(drain(t, pk, all)); got != "[4 0 1 3 5]" {
+		t.Errorf("picked %s", got)
+	}
+}
+
+func TestPickerPriority(t *testing.T) {
+	priorities := []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityNormal, PriorityLow, PriorityHigh, PrioritySkip}
+	seen := map[string]bool{}
+	for range 50 {
+		pk := newPicker(len(priorities))
+		pk.Update(priorities, false)
+		picked := drain(t, pk, all)
+		if len(picked) != 6 {
+			t.Fatalf("picked %v", picked)
+		}
+		for i := 1; i < len(picked); i++ {
+			if priorities[picked[i]] > priorities[picked[i-1]] {
+				t.Fatalf("picked %v, %s before %s", picked, priorities[picked[i-1]], priorities[picked[i]])
+			}
+		}
+		seen[fmt.Sprint(picked)] = true
+	}
+	// pieces of equal priority are spread out
+	if len(seen) == 1 {
+		t.Error("random mode always picked the same order")
+	}
+}
+
+func TestPickerSourcePieces(t *testing.T) {
+	pk := newPicker(4)
+	pk.Update([]Priority{PriorityNormal, PriorityNormal, PriorityNormal, PrioritySkip}, true)
+	odd := func(i int) bool { return i%2 == 1 }
+	if got := fmt.Sprint(drain(t, pk, odd)); got != "[1]" {
+		t.Fatalf("picked %s from a source with odd pieces", got)
+	}
+
+	// a source that only has pieces being downloaded elsewhere waits for
+	// them to be requeued
+	picked := make(chan int)
+	go func() {
+		index, _ := pk.Next(context.Background(), odd)
+		picked <- index
+	}()
+	select {
+	case index := <-picked:
+		t.Fatalf("picked %d while it was requested", index)
+	case <-time.After(50 * time.Millisecond):
+	}
+	pk.Requeue(1)
+	if index := <-picked; index != 1 {
+		t.Fatalf("picked %d after requeueing 1", index)
+	}
+
+	// until ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan bool)
+	go func() {
+		_, ok := pk.Next(ctx, odd)
+		stopped <- !ok
+	}()
+	cancel()
+	if !<-stopped {
+		t.Fatal("Next picked after ctx was canceled")
+	}
+
+	pk.Verified(1)
+	if _, ok := pk.Next(context.Background(), odd); ok {
+		t.Fatal("picked from a source without wanted pieces")
+	}
+	select {
+	case <-pk.Finished():
+		t.Fatal("finished with pieces missing")
+	default:
+	}
+	pk.Verified(0)
+	pk.Verified(2)
+	<-pk.Finished()
+	if _, ok := pk.Next(context.Background(), all); ok {
+		t.Fatal("picked after finishing")
+	}
+	if verified, wanted := pk.Progress(); verified != 3 || wanted != 3 {
+		t.Errorf("progress %d of %d", verified, wanted)
+	}
+}
+
+func TestPickerDeadline(t *testing.T) {
+	now := time.Now()
+	pk := newPicker(5)
+	pk.Update([]Priority{PriorityHigh, PriorityNormal, PriorityNormal, PrioritySkip, PriorityLow}, true)
+	pk.SetDeadline(4, now.Add(2*time.Second))
+	pk.SetDeadline(3, now.Add(time.Second))
+	pk.SetDeadline(2, now.Add(3*time.Second))
+	// earliest deadline first whatever the priority, even skipped pieces
+	if got := fmt.Sprint(drain(t, pk, all)); got != "[3 4 2 0 1]" {
+		t.Errorf("picked %s", got)
+	}
+
+	// a deadline is ignored once the piece is verified
+	pk = newPicker(3)
+	pk.Update([]Priority{PriorityNormal, PriorityNormal, PriorityNormal}, true)
+	pk.Verified(2)
+	pk.SetDeadline(2, now)
+	pk.SetDeadline(1, now)
+	if got := fmt.Sprint(drain(t, pk, all)); got != "[1 0]" {
+		t.Errorf("picked %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package bittorrent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Priority decides whether and how soon a file is downloaded
+type Priority int
+
+const (
+	PrioritySkip Priority = iota // not downloaded
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+var priorityNames = []string{"skip", "low", "normal", "high"}
+
+func (p Priority) String() string {
+	if p < PrioritySkip || p > PriorityHigh {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority parses a priority by name, "skip", "low", "normal" or "high"
+func ParsePriority(name string) (Priority, error) {
+	for i, n := range priorityNames {
+		if strings.EqualFold(name, n) {
+			return Priority(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", name)
+}
+
+// SelectFiles limits Run to the files matched by patterns, see MatchFiles.
+// Matched files get PriorityNormal and every other file PrioritySkip, no
+// patterns selects every file
+func (d *Download) SelectFiles(patterns ...string) error {
+	indexes, err := MatchFiles(d.Torrent, patterns)
+	if err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	d.priorities = make([]Priority, len(d.Torrent.Files))
+	for i := range d.priorities {
+		if len(patterns) == 0 {
+			d.priorities[i] = PriorityNormal
+		}
+	}
+	for _, i := range indexes {
+		d.priorities[i] = PriorityNormal
+	}
+	d.mut.Unlock()
+
+	d.notifyChanged()
+	return nil
+}
+
+// SetPriority sets the priority of every file matched by patterns, it can be
+// called while Run is in progress
+func (d *Download) SetPriority(p Priority, patterns ...string) error {
+	indexes, err := MatchFiles(d.Torrent, patterns)
+	if err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		err = d.SetFilePriority(i, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetFilePriority sets the priority of a file, it can be called while Run is
+// in progress
+func (d *Download) SetFilePriority(fileIndex int, p Priority) error {
+	if fileIndex < 0 || fileIndex >= len(d.Torrent.Files) {
+		return fmt.Errorf("file index %d out of range, torrent has %d files", fileIndex, len(d.Torrent.Files))
+	}
+	if p < PrioritySkip || p > PriorityHigh {
+		return fmt.Errorf("invalid priority %d", p)
+	}
+
+	d.mut.Lock()
+	d.initPriorities()
+	d.priorities[fileIndex] = p
+	d.mut.Unlock()
+
+	d.notifyChanged()
+	return nil
+}
+
+// FilePriority returns the priority of a file
+func (d *Download) FilePriority(fileIndex int) Priority {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.priorities == nil {
+		return PriorityNormal
+	}
+	return d.priorities[fileIndex]
+}
+
+// SetSequential switches between requesting pieces of the same priority in
+// order, so files can be used while they download, or at random
+func (d *Download) SetSequential(sequential bool) {
+	d.mut.Lock()
+	d.sequential = sequential
+	d.mut.Unlock()
+
+	d.notifyChanged()
+}
+
+// initPriorities gives every file PriorityNormal the first time a priority
+// is set, the caller holds the lock
+func (d *Download) initPriorities() {
+	if d.priorities != nil {
+		return
+	}
+	d.priorities = make([]Priority, len(d.Torrent.Files))
+	for i := range d.priorities {
+		d.priorities[i] = PriorityNormal
+	}
+}
+
+// notifyChanged tells a running download to apply new priorities
+func (d *Download) notifyChanged() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.changed == nil {
+		return
+	}
+	select {
+	case d.changed <- struct{}{}:
+	default:
+		// a change is already waiting to be applied
+	}
+}
+
+// isSelected reports whether a file is written by Run
+func (d *Download) isSelected(fileIndex int) bool {
+	return d.FilePriority(fileIndex) != PrioritySkip
+}
+
+// piecePriorities returns the priority of every piece, the highest priority of
+// the files it overlaps, and whether pieces are picked in order
+func (d *Download) piecePriorities() ([]Priority, bool) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	priorities := make([]Priority, len(d.Torrent.PieceHashes))
+	var offset int
+	for i, f := range d.Torrent.Files {
+		start := offset
+		offset += f.Length
+		if f.Padding || f.Length == 0 {
+			continue
+		}
+		p := PriorityNormal
+		if d.priorities != nil {
+			p = d.priorities[i]
+		}
+		for piece := start / d.Torrent.PieceLength; piece <= (offset-1)/d.Torrent.PieceLength; piece++ {
+			priorities[piece] = max(priorities[piece], p)
+		}
+	}
+	return priorities, d.sequential
+}
@@ -0,0 +1,172 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// readahead is how much of a file past a reader's position is downloaded
+// before anything else, so sequential reads rarely wait
+const readahead = 8 << 20
+
+// FileReader reads a file of the torrent while it downloads. Reads block
+// until the pieces they cover are verified, and those pieces and the ones
+// just ahead of them are picked before any other
+type FileReader struct {
+	d         *Download
+	ctx       context.Context // reads stop waiting for pieces once it's done
+	fileIndex int
+	start     int // offset of the file in the torrent
+	length    int
+
+	mut       sync.Mutex
+	pos       int64
+	deadlines []int // pieces this reader asked to be downloaded first
+}
+
+// OpenFile returns a reader for a file of the torrent, it can be opened
+// before or while Run is in progress
+func (d *Download) OpenFile(fileIndex int) (*FileReader, error) {
+	return d.OpenFileContext(context.Background(), fileIndex)
+}
+
+// OpenFileContext is OpenFile whose reads stop waiting for pieces and return
+// ctx's error once ctx is done, like when the request reading it is gone
+func (d *Download) OpenFileContext(ctx context.Context, fileIndex int) (*FileReader, error) {
+	if fileIndex < 0 || fileIndex >= len(d.Torrent.Files) {
+		return nil, fmt.Errorf("file index %d out of range, torrent has %d files", fileIndex, len(d.Torrent.Files))
+	}
+	var start int
+	for _, f := range d.Torrent.Files[:fileIndex] {
+		start += f.Length
+	}
+	return &FileReader{
+		d:         d,
+		ctx:       ctx,
+		fileIndex: fileIndex,
+		start:     start,
+		length:    d.Torrent.Files[fileIndex].Length,
+	}, nil
+}
+
+// Size returns the length of the file
+func (r *FileReader) Size() int64 {
+	return int64(r.length)
+}
+
+// ReadAt reads len(p) bytes from off, waiting for the pieces it covers
+func (r *FileReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(r.length) {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.length-int(off))
+	if n == 0 {
+		return 0, nil
+	}
+
+	pk := r.d.pieces()
+	r.prioritize(pk, int(off))
+
+	pieceLength := r.d.Torrent.PieceLength
+	first := (r.start + int(off)) / pieceLength
+	last := (r.start + int(off) + n - 1) / pieceLength
+	for i := first; i <= last; i++ {
+		err := pk.Wait(r.ctx, i)
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", r.d.Torrent.Files[r.fileIndex].Path, err)
+		}
+	}
+
+	r.d.mut.Lock()
+	store := r.d.store
+	r.d.mut.Unlock()
+	err := store.ReadFileAt(r.fileIndex, p[:n], int(off))
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads from the current position, see ReadAt
+func (r *FileReader) Read(p []byte) (int, error) {
+	r.mut.Lock()
+	pos := r.pos
+	r.mut.Unlock()
+
+	n, err := r.ReadAt(p, pos)
+	if n > 0 {
+		r.mut.Lock()
+		r.pos = pos + int64(n)
+		r.mut.Unlock()
+	}
+	// a short read at the end of the file is returned without an error
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the position of the next Read
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += int64(r.length)
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// Close removes the deadlines of the pieces the reader was waiting for
+func (r *FileReader) Close() error {
+	pk := r.d.pieces()
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, i := range r.deadlines {
+		pk.SetDeadline(i, time.Time{})
+	}
+	r.deadlines = nil
+	return nil
+}
+
+// prioritize gives deadlines to the pieces from off to readahead bytes past
+// it, the nearest piece first, and removes the ones of an earlier position
+func (r *FileReader) prioritize(pk *picker, off int) {
+	pieceLength := r.d.Torrent.PieceLength
+	first := (r.start + off) / pieceLength
+	last := (r.start + min(off+readahead, r.length) - 1) / pieceLength
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, i := range r.deadlines {
+		if i < first || i > last {
+			pk.SetDeadline(i, time.Time{})
+		}
+	}
+	r.deadlines = r.deadlines[:0]
+
+	now := time.Now()
+	for i := first; i <= last; i++ {
+		pk.SetDeadline(i, now.Add(time.Duration(i-first)*time.Second))
+		r.deadlines = append(r.deadlines, i)
+	}
+}
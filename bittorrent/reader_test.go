@@ -0,0 +1,41 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func TestReadStopsWhenContextDone(t *testing.T) {
+	d := &Download{Torrent: torrentparser.TorrentFile{
+		PieceLength: 16,
+		PieceHashes: make([][20]byte, 2),
+		Length:      32,
+		Files:       []torrentparser.File{{Path: "t", Length: 32}},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r, err := d.OpenFileContext(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// nothing downloads the pieces, the read only ends with ctx
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.ReadAt(make([]byte, 8), 20)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want the ctx's", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read didn't return after ctx was done")
+	}
+}
@@ -1,141 +1,523 @@
 package bittorrent
 
 import (
-	"bytes"
-	"crypto/md5"
-	"crypto/sha1"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
 	"github.com/givxl33t/bittorrent-client-go/peer"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+	"github.com/givxl33t/bittorrent-client-go/tracker"
+	"github.com/givxl33t/bittorrent-client-go/webseed"
 )
 
-// pieceJob includes metadatas on a single piece to be downloaded
-type pieceJob struct {
-	Index  int
-	Length int
-	Hash   [20]byte
+// pieceSource is anything pieces can be downloaded from, a peer or a web seed
+//
+// GetPiece returns peer.ErrNotInBitfield if the source doesn't have the piece
+// and *webseed.RetryAfterError if it is busy, any other error stops the source
+// from being used
+type pieceSource interface {
+	GetPieceContext(ctx context.Context, index, length int, hash [20]byte) ([]byte, error)
+	String() string
+	Close() error
 }
 
 // pieceResult contains the downloaded piece bytes and its index
 type pieceResult struct {
 	Index     int
 	FilePiece []byte
+	Source    string
+}
+
+// sourceKind tells which kind of source a pieceSource is, for events
+func sourceKind(p pieceSource) SourceKind {
+	switch p.(type) {
+	case *webseed.Client:
+		return SourceWebSeed
+	case *webseed.HTTPSeed:
+		return SourceHTTPSeed
+	default:
+		return SourcePeer
+	}
 }
 
 // Run the peer to peer download process concurrently getting the pieces
 // outDir defaults to the current directory, "./"
 func (d *Download) Run(outDir string) error {
+	return d.RunContext(context.Background(), outDir)
+}
+
+// RunContext is Run that stops when ctx is canceled
+//
+// Pieces are written to disk as they are verified, the highest priority first
+// (see SetFilePriority), files with PrioritySkip aren't downloaded and pieces
+// already on disk from an earlier run are kept
+//
+// On cancel no new pieces are requested, pieces already downloaded are
+// written and synced to disk, trackers are sent a stopped announce and every
+// connection is closed before ctx's error is returned
+//
+// Progress is published to d.Events and logged, ending with DownloadFinished
+func (d *Download) RunContext(ctx context.Context, outDir string) error {
+	err := d.run(ctx, outDir)
+	d.report().publish(DownloadFinished{Err: err})
+	return err
+}
+
+func (d *Download) run(ctx context.Context, outDir string) error {
 	if outDir == "" {
 		outDir = "./"
 	}
 
-	// make job queue that matches the size of the number of pieces
-	jobQueue := make(chan pieceJob, len(d.Torrent.PieceHashes))
-	results := make(chan pieceResult)
+	store := newStorage(d.Torrent, outDir, d.isSelected)
+	defer store.Close()
+
+	changed := make(chan struct{}, 1)
+	d.mut.Lock()
+	// a new run starts over from what is on disk
+	if d.picker == nil || d.picker.Closed() {
+		d.picker = newPicker(len(d.Torrent.PieceHashes))
+	}
+	pk := d.picker
+	d.changed = changed
+	d.store = store
+	d.mut.Unlock()
+	defer func() {
+		d.mut.Lock()
+		d.changed = nil
+		d.mut.Unlock()
+	}()
+	defer pk.Close()
+
+	// trackers are told the download stopped whichever way it ends, even
+	// after ctx is canceled
+	defer d.announce(context.WithoutCancel(ctx), tracker.EventStopped)
+
+	priorities, sequential := d.piecePriorities()
+	pk.Update(priorities, sequential)
+
+	// pieces already on disk from an earlier run aren't downloaded again
+	selected := d.selectedFiles()
+	for i, p := range priorities {
+		if p != PrioritySkip && store.HavePiece(i) {
+			pk.SetState(i, pieceVerified)
+		}
+	}
+	pk.Check()
+
+	err := d.fetchPieces(ctx, pk, store, changed, selected)
+	if err != nil {
+		return err
+	}
+
+	reader := newFileReader(d.Torrent, outDir)
+	defer reader.Close()
+
+	selected = d.selectedFiles()
+	allSelected := true
+	for i, file := range d.Torrent.Files {
+		// padding files only align pieces, they're never written (BEP0047)
+		if file.Padding {
+			continue
+		}
+		if !selected[i] {
+			allSelected = false
+			continue
+		}
+		// symlinks have no data, they're created after all files are written
+		if file.SymlinkPath != "" {
+			continue
+		}
+
+		outPath := filepath.Join(outDir, file.Path)
+
+		// empty files have no pieces, a file left longer by an earlier
+		// download is cut to size
+		err := store.CreateEmpty(i)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		err = os.Truncate(outPath, int64(file.Length))
+		if err != nil {
+			return fmt.Errorf("failed to truncate file: %w", err)
+		}
+
+		// check integrity if hashes were provided
+		sha1Mismatch, md5Mismatch := verifyFileHashes(reader, i, file)
+		if sha1Mismatch {
+			return fmt.Errorf("%q failed SHA-1 hash mismatch", file.Path)
+		}
+		if md5Mismatch {
+			return fmt.Errorf("%q failed MD5 hash mismatch", file.Path)
+		}
+		d.report().publish(FileCompleted{Index: i, Path: file.Path, Length: file.Length})
+	}
 
-	// start "worker" goroutine for each peer client to grap jobs off of the queue
+	// apply file attributes now that all the data is on disk
+	for i, file := range d.Torrent.Files {
+		if !selected[i] {
+			continue
+		}
+		err := finalizeFile(outDir, file)
+		if err != nil {
+			return fmt.Errorf("failed to finalize %q: %w", file.Path, err)
+		}
+	}
+
+	if allSelected {
+		d.announce(ctx, tracker.EventCompleted)
+		// unselected parts are only worth keeping for a later selection
+		return store.RemoveParts()
+	}
+	return nil
+}
+
+// fetchPieces downloads pieces from every source until the picker has none
+// left, ctx is canceled or every source failed with no peers left to try.
+// Peers added while it runs are used too, and the connection manager keeps
+// replacing the ones that fail
+func (d *Download) fetchPieces(ctx context.Context, pk *picker, store *storage, changed <-chan struct{}, selected []bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conns := d.connManager()
+
+	added := make(chan struct{}, 1)
+	meter := newRateMeter()
+	d.mut.Lock()
+	var sources []pieceSource
 	for _, p := range d.PeerClients {
-		p := p
+		sources = append(sources, p)
+	}
+	for _, ws := range d.WebSeeds {
+		sources = append(sources, ws)
+	}
+	for _, hs := range d.HTTPSeeds {
+		sources = append(sources, hs)
+	}
+	d.added = added
+	d.pending = nil
+	d.meter = meter
+	d.mut.Unlock()
+	defer func() {
+		d.mut.Lock()
+		d.added = nil
+		for _, p := range d.pending {
+			p.Close()
+			conns.closed(p.String(), nil)
+		}
+		d.pending = nil
+		d.meter = nil
+		d.mut.Unlock()
+	}()
+
+	// start "worker" goroutine for each source to grab pieces from the picker
+	results := make(chan pieceResult)
+	exited := make(chan struct{})
+	var active int
+	start := func(p pieceSource) {
+		active++
 		go func() {
-			defer p.Close()
-			for job := range jobQueue {
-				pieceBuf, err := p.GetPiece(job.Index, job.Length, job.Hash)
-				if err != nil {
-					// place job back on queue
-					jobQueue <- job
-					// iff the client didn't have the piece, just continue
-					if errors.Is(err, peer.ErrNotInBitfield) {
-						continue
-					}
-					// otherwise stop listening to jobQueue, defer will cleanup cliennt
-					fmt.Printf("disconnecting from %s after error: %s\n", p.Addr().String(), err.Error())
-					return
-				}
-				results <- pieceResult{
-					Index:     job.Index,
-					FilePiece: pieceBuf,
-				}
-			}
+			d.downloadFrom(ctx, p, pk, results)
+			exited <- struct{}{}
 		}()
 	}
+	for _, p := range sources {
+		start(p)
+	}
 
-	// send all jobs to jobQueue channel
-	for i, hash := range d.Torrent.PieceHashes {
-		// all pieces are the full size except for the last piece
-		length := d.Torrent.PieceLength
-		if i == len(d.Torrent.PieceHashes)-1 {
-			length = d.Torrent.Length - d.Torrent.PieceLength*(len(d.Torrent.PieceHashes)-1)
+	write := func(piece pieceResult) error {
+		start := time.Now()
+		err := store.WritePiece(piece.Index, piece.FilePiece)
+		d.metrics.all().observeDiskWrite(time.Since(start))
+		if err != nil {
+			return fmt.Errorf("writing piece %d: %w", piece.Index, err)
 		}
-		jobQueue <- pieceJob{
-			Index:  i,
-			Length: length,
-			Hash:   hash,
+		d.downloaded.Add(int64(len(piece.FilePiece)))
+		meter.add(int64(len(piece.FilePiece)))
+		pk.Verified(piece.Index)
+		verified, wanted := pk.Progress()
+		d.report().publish(PieceVerified{
+			Index:    piece.Index,
+			Source:   piece.Source,
+			Verified: verified,
+			Wanted:   wanted,
+		})
+		return nil
+	}
+
+	manageCtx, stopManaging := context.WithCancel(ctx)
+	managed := conns.manage(manageCtx, d)
+	ticker := time.NewTicker(manageInterval)
+	defer ticker.Stop()
+
+	// the download fails once no source is left and the connection manager
+	// has no peer left to try
+	failed := func(msg string) error {
+		if active > 0 || !conns.hopeless(time.Now()) {
+			return nil
+		}
+		select {
+		case <-pk.Finished():
+			return nil
+		default:
+			return errors.New(msg)
 		}
 	}
 
-	// assemble pieces into a single file through buffer
-	buf := make([]byte, d.Torrent.Length)
-	for i := 0; i < len(d.Torrent.PieceHashes); i++ {
-		piece := <-results
+	// write pieces to their files as they arrive
+	err := failed("no peers or seeds to download from")
+loop:
+	for err == nil {
+		select {
+		case piece := <-results:
+			err = write(piece)
+		case <-changed:
+			selected = d.applyPriorities(pk, store, selected)
+		case <-added:
+			d.mut.Lock()
+			pending := d.pending
+			d.pending = nil
+			d.mut.Unlock()
+			for _, p := range pending {
+				start(p)
+			}
+		case <-pk.Finished():
+			break loop
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-exited:
+			active--
+			err = failed("every peer and seed failed before the download finished")
+		case <-ticker.C:
+			err = failed("every peer and seed failed before the download finished")
+		}
+	}
 
-		// copy into final buffer
-		copy(buf[piece.Index*d.Torrent.PieceLength:], piece.FilePiece)
+	// stop every worker, pieces they already downloaded are still written.
+	// Peers the connection manager is dialing are closed
+	stopManaging()
+	<-managed
+	pk.Close()
+	if err != nil {
+		cancel()
+	}
+	for active > 0 {
+		select {
+		case piece := <-results:
+			if err == nil || errors.Is(err, context.Canceled) {
+				write(piece)
+			}
+		case <-exited:
+			active--
+		}
+	}
+	store.Sync()
+	return err
+}
 
-		// get the current date and time
-		currentTime := time.Now().Format("2006/01/02 15:04:05")
-		fmt.Printf("%s (%0.2f%%) downloaded piece #%d from %d peers\n",
-			currentTime,
-			float64(i)/float64(len(d.Torrent.PieceHashes))*100,
-			piece.Index+1,
-			len(d.PeerClients),
-		)
+// AddPeer adds a connected peer to the download, such as one that connected
+// to a Session. A running download starts downloading from it right away. The
+// peer is closed when the download is connected to as many peers as it takes
+func (d *Download) AddPeer(p *peer.Client) {
+	if !d.connManager().accept(p) {
+		d.log.Debug("peer limit reached, refusing peer", logging.Peer, p.String())
+		p.Close()
+		return
 	}
+	d.mut.Lock()
+	d.PeerClients = append(d.PeerClients, p)
+	if d.added != nil {
+		d.pending = append(d.pending, p)
+		select {
+		case d.added <- struct{}{}:
+		default:
+		}
+	}
+	d.mut.Unlock()
+	d.report().publish(PeerConnected{Addr: p.String(), Kind: SourcePeer, Client: p.ClientName})
+}
 
-	// close job queue
-	close(jobQueue)
+// use hands a peer the connection manager connected to the running download,
+// false when it isn't running
+func (d *Download) use(p *peer.Client) bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.added == nil {
+		return false
+	}
+	d.PeerClients = append(d.PeerClients, p)
+	d.pending = append(d.pending, p)
+	select {
+	case d.added <- struct{}{}:
+	default:
+	}
+	return true
+}
 
-	// write buffer to separate files
-	var usedBytes int
-	for _, file := range d.Torrent.Files {
-		outPath := filepath.Join(outDir, file.Path)
+// pieces returns the picker readers wait on, the one of the current run, or
+// of the last run once it's over
+func (d *Download) pieces() *picker {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.picker == nil {
+		d.picker = newPicker(len(d.Torrent.PieceHashes))
+	}
+	return d.picker
+}
 
-		fmt.Printf("writing %d bytes to %s\n", file.Length, outPath)
-		// ensure directory exists
-		dir := filepath.Dir(outPath)
-		_, err := os.Stat(dir)
-		if os.IsNotExist(err) {
-			err := os.MkdirAll(dir, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
+// downloadFrom downloads the pieces the picker hands out from a source until
+// the download finishes, the source fails or it's dropped for having nothing
+// to give
+func (d *Download) downloadFrom(parent context.Context, p pieceSource, pk *picker, results chan<- pieceResult) {
+	ctx, drop := context.WithCancelCause(parent)
+	defer drop(nil)
+	stats, untrack := d.trackSource(p, drop)
+	defer untrack()
+	var reason error
+	defer func() {
+		p.Close()
+		if ctx.Err() != nil && parent.Err() == nil {
+			// dropped by the download, not stopped with it
+			reason = context.Cause(ctx)
+		}
+		if client, ok := p.(*peer.Client); ok {
+			d.conns.closed(client.String(), reason)
+		}
+		d.report().publish(PeerDisconnected{Addr: p.String(), Kind: sourceKind(p), Reason: reason})
+	}()
+	// pieces the source doesn't have
+	missing := map[int]bool{}
+	has := func(index int) bool { return !missing[index] }
+	if client, ok := p.(*peer.Client); ok {
+		has = func(index int) bool { return !missing[index] && client.Bitfield.HasPiece(index) }
+	}
+	for {
+		index, ok := pk.Next(ctx, has)
+		if !ok {
+			if !pk.Closed() && ctx.Err() == nil {
+				reason = errUseless
 			}
+			return
 		}
-
-		// check integrity if hashes were provided
-		fileRaw := buf[usedBytes : usedBytes+file.Length]
-		if file.SHA1Hash != "" {
-			hash := sha1.Sum(fileRaw)
-			if !bytes.Equal(hash[:], []byte(file.SHA1Hash)) {
-				return fmt.Errorf("%q failed SHA-1 hash mismatch", file.Path)
+		d.report().publish(PieceRequested{Index: index, Source: p.String()})
+		stats.requested.Store(time.Now().UnixNano())
+		pieceBuf, err := p.GetPieceContext(ctx, index, d.Torrent.PieceSize(index), d.Torrent.PieceHashes[index])
+		stats.requested.Store(0)
+		if err != nil && !errors.Is(err, peer.ErrNotInBitfield) && ctx.Err() == nil {
+			d.report().publish(PieceFailed{Index: index, Source: p.String(), Err: err})
+		}
+		if errors.Is(err, peer.ErrIntegrity) || errors.Is(err, webseed.ErrIntegrity) {
+			d.metrics.pieceDownloaded(d.Torrent.PieceSize(index), false)
+		}
+		if err != nil {
+			// place piece back in the picker
+			pk.Requeue(index)
+			if ctx.Err() != nil {
+				return
+			}
+			// iff the client didn't have the piece, pick another one
+			if errors.Is(err, peer.ErrNotInBitfield) {
+				missing[index] = true
+				continue
+			}
+			// a busy seed is asked again once it's ready
+			var retry *webseed.RetryAfterError
+			if errors.As(err, &retry) {
+				select {
+				case <-time.After(retry.Wait):
+				case <-pk.Finished():
+				case <-ctx.Done():
+				}
+				continue
 			}
+			// otherwise stop downloading, defer will cleanup client
+			reason = err
+			return
 		}
-		if file.MD5Hash != "" {
-			hash := md5.Sum(fileRaw)
-			if !bytes.Equal(hash[:], []byte(file.MD5Hash)) {
-				return fmt.Errorf("%q failed MD5 hash mismatch", file.Path)
+		stats.downloaded.Add(int64(len(pieceBuf)))
+		stats.meter.add(int64(len(pieceBuf)))
+		d.metrics.pieceDownloaded(len(pieceBuf), true)
+		// the piece is always handed over, even when stopping
+		results <- pieceResult{Index: index, FilePiece: pieceBuf, Source: p.String()}
+	}
+}
+
+// applyPriorities updates the picker after priorities change during Run.
+// Pieces of files that were selected or unselected are looked for on disk
+// again, which moves their data between the files and the parts file
+func (d *Download) applyPriorities(pk *picker, store *storage, prev []bool) []bool {
+	priorities, sequential := d.piecePriorities()
+	pk.Update(priorities, sequential)
+
+	selected := d.selectedFiles()
+	for i := range selected {
+		if selected[i] == prev[i] {
+			continue
+		}
+		first, last := d.Torrent.FilePieces(i)
+		for piece := first; piece <= last; piece++ {
+			if priorities[piece] == PrioritySkip || pk.State(piece) == pieceRequested {
+				continue
+			}
+			state := pieceMissing
+			if store.HavePiece(piece) {
+				state = pieceVerified
 			}
+			pk.SetState(piece, state)
 		}
+	}
+	pk.Check()
+	return selected
+}
 
-		// write to file
-		err = os.WriteFile(outPath, fileRaw, os.ModePerm)
-		usedBytes += file.Length
+// selectedFiles returns which files are currently selected
+func (d *Download) selectedFiles() []bool {
+	selected := make([]bool, len(d.Torrent.Files))
+	for i := range selected {
+		selected[i] = d.isSelected(i)
+	}
+	return selected
+}
+
+// fileMode returns the permissions a downloaded file is written with
+func fileMode(file torrentparser.File) os.FileMode {
+	if file.Executable {
+		return 0755
+	}
+	return 0644
+}
+
+// finalizeFile applies the BEP0047 attributes of a file that is already on disk,
+// os.WriteFile only applies permissions on creation so they're set again here
+func finalizeFile(outDir string, file torrentparser.File) error {
+	if file.Padding {
+		return nil
+	}
+
+	outPath := filepath.Join(outDir, file.Path)
+	if file.SymlinkPath != "" {
+		// symlink path is relative to the torrent root, which is the first
+		// element of every path in a multi file torrent
+		root := strings.SplitN(file.Path, string(filepath.Separator), 2)[0]
+		target := filepath.Join(outDir, root, file.SymlinkPath)
+		relTarget, err := filepath.Rel(filepath.Dir(outPath), target)
 		if err != nil {
-			return fmt.Errorf("failed to write file: %w", err)
+			return fmt.Errorf("resolving symlink target: %w", err)
 		}
+		err = os.MkdirAll(filepath.Dir(outPath), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		// replace a stale link from a previous run
+		os.Remove(outPath)
+		return os.Symlink(relTarget, outPath)
 	}
 
-	return nil
+	return os.Chmod(outPath, fileMode(file))
 }
@@ -0,0 +1,64 @@
+package bittorrent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// MatchFiles returns the indexes into torrent.Files selected by patterns, each
+// one either a file index or a glob (filepath.Match) over the file's path. A
+// glob matches the full path or the path within the torrent's directory.
+// Padding files are never selected
+func MatchFiles(torrent torrentparser.TorrentFile, patterns []string) ([]int, error) {
+	matched := make([]bool, len(torrent.Files))
+	for _, pattern := range patterns {
+		if index, err := strconv.Atoi(pattern); err == nil {
+			if index < 0 || index >= len(torrent.Files) {
+				return nil, fmt.Errorf("file index %d out of range, torrent has %d files", index, len(torrent.Files))
+			}
+			matched[index] = true
+			continue
+		}
+
+		var found bool
+		for i, f := range torrent.Files {
+			ok, err := matchPath(pattern, f.Path)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("pattern %q matches no files", pattern)
+		}
+	}
+
+	var indexes []int
+	for i, ok := range matched {
+		if ok && !torrent.Files[i].Padding {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// matchPath matches a glob against a file path with and without its first
+// element, which is the torrent's name in multi file torrents
+func matchPath(pattern, path string) (bool, error) {
+	ok, err := filepath.Match(pattern, path)
+	if ok || err != nil {
+		return ok, err
+	}
+	_, rel, found := strings.Cut(path, string(filepath.Separator))
+	if !found {
+		return false, nil
+	}
+	return filepath.Match(pattern, rel)
+}
@@ -0,0 +1,687 @@
+package bittorrent
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/givxl33t/bittorrent-client-go/dht"
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+	"github.com/givxl33t/bittorrent-client-go/peer"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// DHT finds peers of a torrent on the mainline DHT (BEP0005) and announces
+// that we are downloading it on port. A Session runs a dht.Node for its
+// torrents, a Download only uses one set in its options
+type DHT interface {
+	GetPeers(ctx context.Context, infoHash [20]byte, port int) ([]net.TCPAddr, error)
+	// AddNode is told about the nodes a torrent file lists and the DHT port
+	// peers send, as "host:port"
+	AddNode(addr string)
+}
+
+// dhtURL is the URL of TrackerAnnounced events for DHT lookups
+const dhtURL = "dht"
+
+// SessionConfig configures the resources a Session shares between torrents
+type SessionConfig struct {
+	// ListenAddr is where peers connect to, ":6881" when empty. Its port is
+	// announced to trackers
+	ListenAddr string
+
+	// MaxConnections limits the peer connections of all torrents together,
+	// 0 is unlimited
+	MaxConnections int
+
+	// MaxPeersPerTorrent limits the peers each torrent connects to, 50 when 0
+	MaxPeersPerTorrent int
+
+	// MaxHalfOpen limits how many peers all torrents dial at once together, 8
+	// when 0
+	MaxHalfOpen int
+
+	// DownloadLimit limits how many bytes of pieces per second all torrents
+	// download together, 0 is unlimited. It can be changed with
+	// SetDownloadLimit. Nothing needs an upload limit, pieces are never
+	// uploaded
+	DownloadLimit int64
+
+	// MetadataCacheDir caches the metadata of magnet links, see Options
+	MetadataCacheDir string
+
+	// DHTAddr is the UDP address of the session's DHT node, the host of
+	// ListenAddr with the port peers connect to when empty
+	DHTAddr string
+
+	// DHTRouters are asked for the first nodes of the DHT, dht.DefaultRouters
+	// when nil. Torrent files can list more
+	DHTRouters []string
+
+	// NoDHT turns the DHT node off, peers only come from trackers and seeds
+	NoDHT bool
+
+	// Logger logs the session and its torrents, nil discards the logs
+	Logger *slog.Logger
+}
+
+// Session downloads many torrents in one process, sharing a peer ID, a
+// listening socket, the DHT and connection and bandwidth limits between them
+type Session struct {
+	config   SessionConfig
+	peerID   [20]byte
+	ln       net.Listener
+	events   *Events
+	log      *slog.Logger
+	slots    *connSlots
+	halfOpen *connSlots
+	limit    *rateLimiter
+	metrics  *Metrics
+	dht      *dht.Node // nil with NoDHT
+
+	ctx    context.Context // canceled when the session is closed
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // inbound connections being set up
+
+	mut      sync.Mutex
+	torrents map[[20]byte]*Torrent
+	order    []*Torrent // in the order they were added
+}
+
+// ErrTorrentExists is returned when adding a torrent the session already has
+var ErrTorrentExists = errors.New("torrent already added")
+
+// ErrUnknownTorrent is returned for info hashes the session doesn't have
+var ErrUnknownTorrent = errors.New("unknown torrent")
+
+// NewSession starts listening for peers, torrents are added with Add
+func NewSession(config SessionConfig) (*Session, error) {
+	if config.ListenAddr == "" {
+		config.ListenAddr = fmt.Sprintf(":%d", defaultPort)
+	}
+	config.MaxPeersPerTorrent = cmp.Or(config.MaxPeersPerTorrent, defaultMaxPeers)
+	config.MaxHalfOpen = cmp.Or(config.MaxHalfOpen, defaultMaxHalfOpen)
+	ln, err := net.Listen("tcp", config.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for peers: %w", err)
+	}
+
+	s := &Session{
+		config:   config,
+		ln:       ln,
+		events:   NewEvents(),
+		log:      logging.OrDiscard(config.Logger),
+		limit:    newRateLimiter(config.DownloadLimit),
+		metrics:  NewMetrics(),
+		torrents: map[[20]byte]*Torrent{},
+	}
+	s.events.setLogger(s.log)
+	rand.Read(s.peerID[:])
+	if config.MaxConnections > 0 {
+		s.slots = newConnSlots(config.MaxConnections)
+	}
+	s.halfOpen = newConnSlots(config.MaxHalfOpen)
+	if !config.NoDHT {
+		s.dht, err = newSessionDHT(config, s.port(), s.log)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.wg.Add(1)
+	go s.acceptPeers()
+	s.log.Info("session started", "addr", ln.Addr().String())
+	return s, nil
+}
+
+// newSessionDHT starts the DHT node of a session listening on port
+func newSessionDHT(config SessionConfig, port int, log *slog.Logger) (*dht.Node, error) {
+	addr := config.DHTAddr
+	if addr == "" {
+		host, _, err := net.SplitHostPort(config.ListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("listen address %s: %w", config.ListenAddr, err)
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	routers := config.DHTRouters
+	if routers == nil {
+		routers = dht.DefaultRouters
+	}
+	node, err := dht.New(dht.Config{Addr: addr, Routers: routers, Logger: log})
+	if err != nil {
+		return nil, fmt.Errorf("starting the DHT node: %w", err)
+	}
+	return node, nil
+}
+
+// Config returns the configuration of the session, with defaults filled in
+// and the current download limit
+func (s *Session) Config() SessionConfig {
+	config := s.config
+	config.DownloadLimit = s.DownloadLimit()
+	return config
+}
+
+// DownloadLimit returns the bytes per second all torrents download together,
+// 0 is unlimited
+func (s *Session) DownloadLimit() int64 {
+	return s.limit.limit()
+}
+
+// SetDownloadLimit changes the bytes per second all torrents download
+// together, 0 is unlimited. Running torrents slow down or speed up right away
+func (s *Session) SetDownloadLimit(bytesPerSecond int64) {
+	s.limit.setLimit(bytesPerSecond)
+}
+
+// Metrics counts what the torrents of the session do, it can be served as
+// Prometheus metrics
+func (s *Session) Metrics() *Metrics {
+	return s.metrics
+}
+
+// DHT is the DHT node the torrents of the session find peers with, nil with
+// NoDHT
+func (s *Session) DHT() *dht.Node {
+	return s.dht
+}
+
+// PeerID is the peer ID every torrent of the session uses
+func (s *Session) PeerID() [20]byte {
+	return s.peerID
+}
+
+// Addr is the address peers connect to
+func (s *Session) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Events receives the events of every torrent as TorrentEvent
+func (s *Session) Events() *Events {
+	return s.events
+}
+
+// port is the listening port announced to trackers
+func (s *Session) port() int {
+	if addr, ok := s.ln.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return defaultPort
+}
+
+// options are the download options of a torrent of the session
+func (s *Session) options(events *Events, limit *rateLimiter) Options {
+	o := Options{
+		MetadataCacheDir: s.config.MetadataCacheDir,
+		Events:           events,
+		Logger:           s.config.Logger,
+		PeerID:           s.peerID,
+		Port:             s.port(),
+		Metrics:          s.metrics,
+		MaxPeers:         s.config.MaxPeersPerTorrent,
+		MaxHalfOpen:      s.config.MaxHalfOpen,
+		slots:            s.slots,
+		halfOpen:         s.halfOpen,
+		global:           s.limit,
+		limit:            limit,
+	}
+	// a nil node would be a DHT that isn't nil
+	if s.dht != nil {
+		o.DHT = s.dht
+	}
+	return o
+}
+
+// Add adds a torrent file or magnet link to download into outDir, and starts
+// it in the background
+func (s *Session) Add(source, outDir string) (*Torrent, error) {
+	torrent, err := torrentparser.New(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse torrent file: %w", err)
+	}
+	if outDir == "" {
+		outDir = "./"
+	}
+
+	t := &Torrent{
+		session:  s,
+		infoHash: torrent.InfoHash,
+		name:     torrent.Name,
+		source:   source,
+		outDir:   outDir,
+		events:   NewEvents(),
+		limit:    newRateLimiter(0),
+	}
+	t.events.setLogger(s.log.With(logging.HashAttr(t.infoHash)))
+
+	// the events of every torrent are forwarded to the session's
+	sub, unsubscribe := t.events.Subscribe()
+	t.unsubscribe = unsubscribe
+	go func() {
+		for ev := range sub {
+			s.events.publish(TorrentEvent{InfoHash: t.infoHash, Event: ev})
+		}
+	}()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.ctx.Err() != nil {
+		unsubscribe()
+		return nil, errors.New("session is closed")
+	}
+	if _, ok := s.torrents[t.infoHash]; ok {
+		unsubscribe()
+		return nil, fmt.Errorf("%w: %x", ErrTorrentExists, t.infoHash)
+	}
+	s.torrents[t.infoHash] = t
+	s.order = append(s.order, t)
+
+	t.start()
+	return t, nil
+}
+
+// Torrent returns the torrent with an info hash
+func (s *Session) Torrent(infoHash [20]byte) (*Torrent, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	t, ok := s.torrents[infoHash]
+	return t, ok
+}
+
+// Torrents returns every torrent in the order they were added
+func (s *Session) Torrents() []*Torrent {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*Torrent(nil), s.order...)
+}
+
+// Remove stops a torrent and removes it from the session, deleteData also
+// deletes its downloaded files
+func (s *Session) Remove(infoHash [20]byte, deleteData bool) error {
+	s.mut.Lock()
+	t, ok := s.torrents[infoHash]
+	if ok {
+		delete(s.torrents, infoHash)
+		for i, o := range s.order {
+			if o == t {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+	s.mut.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrUnknownTorrent, infoHash)
+	}
+
+	t.Pause()
+	t.unsubscribe()
+	s.metrics.forget(infoHash)
+	if deleteData {
+		return t.deleteData()
+	}
+	return nil
+}
+
+// Close stops every torrent, the DHT node and listening for peers
+func (s *Session) Close() error {
+	s.mut.Lock()
+	s.cancel()
+	torrents := append([]*Torrent(nil), s.order...)
+	s.mut.Unlock()
+
+	err := s.ln.Close()
+	for _, t := range torrents {
+		t.Pause()
+		t.unsubscribe()
+	}
+	if s.dht != nil {
+		s.dht.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// acceptPeers hands inbound peers to the torrent their handshake is for
+func (s *Session) acceptPeers() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Debug("accepting peer failed", "err", err)
+			continue
+		}
+		if !s.slots.tryAcquire() {
+			s.log.Debug("connection limit reached, refusing peer", logging.Peer, conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.acceptPeer(conn)
+		}()
+	}
+}
+
+// acceptPeer runs the handshakes of an inbound connection and adds it to its
+// torrent, it holds a connection slot that is released when it's closed
+func (s *Session) acceptPeer(conn net.Conn) {
+	client, infoHash, err := peer.Accept(s.ctx, conn, s.peerID, func(infoHash [20]byte) bool {
+		_, ok := s.downloading(infoHash)
+		return ok
+	}, s.log)
+	if err != nil {
+		s.slots.release()
+		return
+	}
+	d, ok := s.downloading(infoHash)
+	if !ok {
+		s.slots.release()
+		client.Close()
+		return
+	}
+	limitPeer(client, d.swarm, d.metrics)
+	d.swarm.addDHTNode(client)
+	d.AddPeer(client)
+}
+
+// downloading returns the download of a torrent that is downloading
+func (s *Session) downloading(infoHash [20]byte) (*Download, bool) {
+	t, ok := s.Torrent(infoHash)
+	if !ok {
+		return nil, false
+	}
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.state != StateDownloading {
+		return nil, false
+	}
+	return t.download, true
+}
+
+// TorrentState is where a torrent of a Session is in its life
+type TorrentState int
+
+const (
+	StateConnecting  TorrentState = iota // finding peers, and the metadata of a magnet link
+	StateDownloading                     // downloading pieces
+	StatePaused                          // stopped by Pause or the session closing
+	StateFinished                        // every selected file is complete
+	StateFailed                          // stopped by an error, Resume tries again
+)
+
+var torrentStateStrings = map[TorrentState]string{
+	StateConnecting:  "connecting",
+	StateDownloading: "downloading",
+	StatePaused:      "paused",
+	StateFinished:    "finished",
+	StateFailed:      "failed",
+}
+
+func (s TorrentState) String() string {
+	return torrentStateStrings[s]
+}
+
+// TorrentStateChanged is sent when a torrent of a Session changes state, Err
+// is set for StateFailed
+type TorrentStateChanged struct {
+	State TorrentState
+	Err   error
+}
+
+// TorrentEvent is an event of one of the torrents of a Session
+type TorrentEvent struct {
+	InfoHash [20]byte
+	Event    Event
+}
+
+func (TorrentStateChanged) event() {}
+func (TorrentEvent) event()        {}
+
+// Torrent is a torrent of a Session
+type Torrent struct {
+	session     *Session
+	infoHash    [20]byte
+	name        string
+	source      string
+	outDir      string
+	events      *Events
+	limit       *rateLimiter // on top of the session's
+	unsubscribe func()
+
+	mut      sync.Mutex
+	download *Download // nil until connected for the first time
+	state    TorrentState
+	err      error
+	cancel   context.CancelFunc // stops the current run
+	done     chan struct{}      // closed when the current run returns
+}
+
+func (t *Torrent) InfoHash() [20]byte {
+	return t.infoHash
+}
+
+// Name is the name of the torrent, or of its magnet link until the metadata
+// is found
+func (t *Torrent) Name() string {
+	if d := t.Download(); d != nil {
+		return d.Torrent.Name
+	}
+	return t.name
+}
+
+// OutDir is the directory the torrent is downloaded into
+func (t *Torrent) OutDir() string {
+	return t.outDir
+}
+
+// Events receives the events of the torrent
+func (t *Torrent) Events() *Events {
+	return t.events
+}
+
+// DownloadLimit returns the bytes per second the torrent downloads at most, 0
+// is only limited by the session
+func (t *Torrent) DownloadLimit() int64 {
+	return t.limit.limit()
+}
+
+// SetDownloadLimit changes the bytes per second the torrent downloads at
+// most, on top of the limit of the session. 0 is only limited by the session
+func (t *Torrent) SetDownloadLimit(bytesPerSecond int64) {
+	t.limit.setLimit(bytesPerSecond)
+}
+
+// Download returns the download of the torrent, nil until it connected to the
+// swarm for the first time. Its files can be selected and prioritized while
+// it runs
+func (t *Torrent) Download() *Download {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.download
+}
+
+// State returns the state of the torrent, and the error it failed with
+func (t *Torrent) State() (TorrentState, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.state, t.err
+}
+
+// Pause stops the torrent and waits for it to disconnect, downloaded pieces
+// are kept for Resume
+func (t *Torrent) Pause() {
+	t.mut.Lock()
+	cancel, done := t.cancel, t.done
+	t.mut.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Resume starts a paused, finished or failed torrent again, connecting to
+// new peers
+func (t *Torrent) Resume() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.done != nil {
+		select {
+		case <-t.done:
+		default:
+			// still running
+			return
+		}
+	}
+	if t.session.ctx.Err() == nil {
+		t.startLocked()
+	}
+}
+
+// start runs the torrent in the background
+func (t *Torrent) start() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.startLocked()
+}
+
+func (t *Torrent) startLocked() {
+	ctx, cancel := context.WithCancel(t.session.ctx)
+	done := make(chan struct{})
+	t.cancel, t.done = cancel, done
+	// the state changes right away so it's never stale after Resume returns
+	t.state, t.err = StateConnecting, nil
+	t.events.publish(TorrentStateChanged{State: StateConnecting})
+	go func() {
+		defer close(done)
+		defer cancel()
+		t.run(ctx)
+	}()
+}
+
+// run connects to the swarm and downloads the torrent until it finishes, ctx
+// is canceled or it fails
+func (t *Torrent) run(ctx context.Context) {
+	d := t.Download()
+	var err error
+	if d == nil {
+		d, err = NewDownloadContext(ctx, t.source, t.session.options(t.events, t.limit))
+		if err == nil {
+			t.mut.Lock()
+			t.download = d
+			t.mut.Unlock()
+		}
+	} else {
+		// the connections of the last run were closed when it ended
+		err = d.reconnect(ctx)
+	}
+	if err == nil {
+		t.setState(StateDownloading, nil)
+		err = d.RunContext(ctx, t.outDir)
+	}
+
+	switch {
+	case err == nil:
+		t.setState(StateFinished, nil)
+	case ctx.Err() != nil:
+		t.setState(StatePaused, nil)
+	default:
+		t.setState(StateFailed, err)
+	}
+}
+
+func (t *Torrent) setState(state TorrentState, err error) {
+	t.mut.Lock()
+	t.state, t.err = state, err
+	t.mut.Unlock()
+	t.events.publish(TorrentStateChanged{State: state, Err: err})
+}
+
+// deleteData deletes the files of a stopped torrent, the parts file and the
+// directories left empty
+func (t *Torrent) deleteData() error {
+	d := t.Download()
+	if d == nil {
+		return nil
+	}
+
+	var errs []error
+	dirs := map[string]bool{}
+	for _, file := range d.Torrent.Files {
+		if file.Padding {
+			continue
+		}
+		// paths are checked when the metadata is parsed, this makes sure
+		// one that slipped through can't delete anything outside of outDir
+		path, err := insideDir(t.outDir, file.Path)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		for dir := filepath.Dir(file.Path); dir != "."; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	err := newStorage(d.Torrent, t.outDir, d.isSelected).RemoveParts()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// deepest first, so parents are empty by the time they're removed
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, dir := range sorted {
+		path, err := insideDir(t.outDir, dir)
+		if err != nil {
+			continue
+		}
+		// directories with other files in them are kept
+		os.Remove(path)
+	}
+	return errors.Join(errs...)
+}
+
+// insideDir resolves the symlinks of the directories leading to name, a path
+// relative to dir, and returns where it is if that's still inside dir. name
+// itself isn't resolved, so removing a symlink removes the link
+func insideDir(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("not deleting %q, it's outside of %s", name, dir)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.Dir(name)))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, parent)
+	if err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+		return "", fmt.Errorf("not deleting %q, it's outside of %s", name, dir)
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
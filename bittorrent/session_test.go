@@ -0,0 +1,170 @@
+package bittorrent
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/dht"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestDeleteDataStaysInOutDir(t *testing.T) {
+	base := t.TempDir()
+	outDir := filepath.Join(base, "out")
+	outside := filepath.Join(base, "outside")
+	writeFile(t, filepath.Join(outDir, "t", "dir", "a"))
+	writeFile(t, filepath.Join(outDir, "t", "b"))
+	writeFile(t, filepath.Join(outside, "secret"))
+	writeFile(t, filepath.Join(outside, "linked", "c"))
+	// a directory of the torrent replaced by a link to somewhere else
+	err := os.Symlink(filepath.Join(outside, "linked"), filepath.Join(outDir, "t", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tf := torrentparser.TorrentFile{Files: []torrentparser.File{
+		{Path: filepath.Join("t", "dir", "a")},
+		{Path: filepath.Join("t", "b")},
+		{Path: filepath.Join("t", "missing")},
+		{Path: filepath.Join("..", "outside", "secret")},
+		{Path: filepath.Join(outside, "secret")},
+		{Path: filepath.Join("t", "link", "c")},
+	}}
+	tor := &Torrent{outDir: outDir, download: &Download{Torrent: tf}}
+	err = tor.deleteData()
+	if err == nil {
+		t.Fatal("deleting paths outside of the download directory didn't fail")
+	}
+
+	for _, path := range []string{"dir/a", "b", "dir"} {
+		if exists(filepath.Join(outDir, "t", path)) {
+			t.Errorf("%s wasn't deleted", path)
+		}
+	}
+	for _, path := range []string{"secret", "linked/c"} {
+		if !exists(filepath.Join(outside, path)) {
+			t.Errorf("%s outside of the download directory was deleted", path)
+		}
+	}
+	// the link is what keeps t from being empty
+	if !exists(filepath.Join(outDir, "t", "link")) {
+		t.Error("the link was deleted")
+	}
+}
+
+// trackerlessTorrent writes a torrent without trackers or seeds and returns
+// its path and info hash
+func trackerlessTorrent(t *testing.T, private bool) (string, [20]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "data", "a"))
+	raw, err := torrentparser.Create(filepath.Join(dir, "data"), torrentparser.CreateOptions{Private: private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "data.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := torrentparser.ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, torrent.InfoHash
+}
+
+// dhtAnnounces returns how many DHT lookups a torrent of the session made
+// once it failed to find peers
+func dhtAnnounces(t *testing.T, s *Session, path string) int {
+	t.Helper()
+	events, unsubscribe := s.Events().Subscribe()
+	defer unsubscribe()
+	_, err := s.Add(path, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	announces := 0
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			switch ev := ev.(TorrentEvent).Event.(type) {
+			case TrackerAnnounced:
+				if ev.URL == dhtURL {
+					if ev.Err != nil {
+						t.Fatalf("DHT lookup failed: %s", ev.Err)
+					}
+					announces++
+				}
+			case TorrentStateChanged:
+				if ev.State == StateFailed {
+					return announces
+				}
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the torrent to fail")
+		}
+	}
+}
+
+func TestSessionAnnouncesOnDHT(t *testing.T) {
+	router, err := dht.New(dht.Config{Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	s, err := NewSession(SessionConfig{ListenAddr: "127.0.0.1:0", DHTRouters: []string{router.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	path, infoHash := trackerlessTorrent(t, false)
+	if got := dhtAnnounces(t, s, path); got != 1 {
+		t.Fatalf("got %d DHT lookups, want 1", got)
+	}
+	// private torrents stay off the DHT
+	private, _ := trackerlessTorrent(t, true)
+	if got := dhtAnnounces(t, s, private); got != 0 {
+		t.Fatalf("got %d DHT lookups of a private torrent", got)
+	}
+
+	// other nodes find the session on the DHT
+	other, err := dht.New(dht.Config{Addr: "127.0.0.1:0", Routers: []string{router.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	peers, err := other.GetPeers(ctx, infoHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := s.Addr().(*net.TCPAddr).Port
+	if len(peers) != 1 || peers[0].Port != port {
+		t.Fatalf("got peers %v, want the session on port %d", peers, port)
+	}
+}
@@ -0,0 +1,219 @@
+package bittorrent
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/peer"
+)
+
+// rateWindow is how far back download rates are averaged over
+const rateWindow = 10 * time.Second
+
+// rateMeter measures a rate of bytes over the last rateWindow
+type rateMeter struct {
+	mut     sync.Mutex
+	start   time.Time
+	samples []rateSample
+}
+
+type rateSample struct {
+	at time.Time
+	n  int64
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{start: time.Now()}
+}
+
+func (m *rateMeter) add(n int64) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	now := time.Now()
+	m.samples = append(m.samples, rateSample{at: now, n: n})
+	m.trim(now)
+}
+
+// trim drops the samples that are out of the window
+func (m *rateMeter) trim(now time.Time) {
+	var old int
+	for old < len(m.samples) && now.Sub(m.samples[old].at) > rateWindow {
+		old++
+	}
+	m.samples = m.samples[old:]
+}
+
+// rate returns bytes per second over the window, or since the meter started
+// when that was more recent
+func (m *rateMeter) rate() float64 {
+	if m == nil {
+		return 0
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	now := time.Now()
+	m.trim(now)
+	var total int64
+	for _, s := range m.samples {
+		total += s.n
+	}
+	elapsed := min(rateWindow, now.Sub(m.start))
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	return float64(total) / elapsed.Seconds()
+}
+
+// sourceStats counts what a source downloaded while it is used by Run
+type sourceStats struct {
+	addr       string
+	kind       SourceKind
+	client     string
+	downloaded atomic.Int64
+	meter      *rateMeter
+
+	drop      context.CancelCauseFunc // stops the source's worker
+	requested atomic.Int64            // unix nanos a request started, 0 while none is
+	received  *atomic.Int64           // unix nanos of the last piece data of a peer
+}
+
+// Stats is a snapshot of the progress of a download
+type Stats struct {
+	Pieces        []bool // verified pieces, as far as the download knows
+	Verified      int    // verified pieces of the selected files
+	Wanted        int    // pieces of the selected files
+	VerifiedBytes int64
+	WantedBytes   int64
+	Downloaded    int64   // bytes downloaded by this process
+	DownloadRate  float64 // bytes per second over the last few seconds
+	Files         []FileStats
+	Peers         []PeerStats // sources currently downloaded from
+}
+
+// FileStats is the progress of a file of the torrent
+type FileStats struct {
+	Path      string
+	Length    int64
+	Completed int64 // bytes of the file in verified pieces
+	Priority  Priority
+	Padding   bool
+}
+
+// PeerStats is what a peer or seed downloaded during the current run
+type PeerStats struct {
+	Addr         string
+	Kind         SourceKind
+	Client       string
+	Downloaded   int64
+	DownloadRate float64
+}
+
+// ETA estimates how long the rest of the selected files take to download at
+// the current rate, false when nothing is being downloaded
+func (s Stats) ETA() (time.Duration, bool) {
+	left := s.WantedBytes - s.VerifiedBytes
+	if left <= 0 {
+		return 0, true
+	}
+	if s.DownloadRate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(left) / s.DownloadRate * float64(time.Second)), true
+}
+
+// Stats returns the progress of the download, it can be called at any time
+// including while Run is downloading
+func (d *Download) Stats() Stats {
+	priorities, _ := d.piecePriorities()
+
+	d.mut.Lock()
+	pk := d.picker
+	meter := d.meter
+	var peers []PeerStats
+	for s := range d.sources {
+		peers = append(peers, PeerStats{
+			Addr:         s.addr,
+			Kind:         s.kind,
+			Client:       s.client,
+			Downloaded:   s.downloaded.Load(),
+			DownloadRate: s.meter.rate(),
+		})
+	}
+	d.mut.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Addr < peers[j].Addr })
+
+	stats := Stats{
+		Pieces:       make([]bool, len(d.Torrent.PieceHashes)),
+		Downloaded:   d.downloaded.Load(),
+		DownloadRate: meter.rate(),
+		Files:        make([]FileStats, len(d.Torrent.Files)),
+		Peers:        peers,
+	}
+	for i, file := range d.Torrent.Files {
+		stats.Files[i] = FileStats{
+			Path:     file.Path,
+			Length:   int64(file.Length),
+			Priority: d.FilePriority(i),
+			Padding:  file.Padding,
+		}
+	}
+	for i := range stats.Pieces {
+		verified := pk != nil && pk.State(i) == pieceVerified
+		stats.Pieces[i] = verified
+		size := int64(d.Torrent.PieceSize(i))
+		if priorities[i] != PrioritySkip {
+			stats.Wanted++
+			stats.WantedBytes += size
+			if verified {
+				stats.Verified++
+				stats.VerifiedBytes += size
+			}
+		}
+		if verified {
+			for _, span := range d.Torrent.PieceSpans(i) {
+				stats.Files[span.FileIndex].Completed += int64(span.Length)
+			}
+		}
+	}
+	return stats
+}
+
+// downloadRate is the rate of the current or last run
+func (d *Download) downloadRate() float64 {
+	d.mut.Lock()
+	meter := d.meter
+	d.mut.Unlock()
+	return meter.rate()
+}
+
+// trackSource registers a source downloaded from by Run, until untrack is
+// called. drop stops its worker
+func (d *Download) trackSource(p pieceSource, drop context.CancelCauseFunc) (stats *sourceStats, untrack func()) {
+	stats = &sourceStats{
+		addr:  p.String(),
+		kind:  sourceKind(p),
+		meter: newRateMeter(),
+		drop:  drop,
+	}
+	if client, ok := p.(*peer.Client); ok {
+		stats.client = client.ClientName
+		if conn, ok := client.Conn.(*limitedConn); ok {
+			stats.received = &conn.received
+		}
+	}
+
+	d.mut.Lock()
+	if d.sources == nil {
+		d.sources = map[*sourceStats]bool{}
+	}
+	d.sources[stats] = true
+	d.mut.Unlock()
+	return stats, func() {
+		d.mut.Lock()
+		delete(d.sources, stats)
+		d.mut.Unlock()
+	}
+}
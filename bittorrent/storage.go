@@ -0,0 +1,287 @@
+package bittorrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// storage writes verified pieces to the files they belong to as they arrive
+//
+// The parts of a piece that fall in unselected files are kept in a hidden
+// parts file instead, a sparse file laid out like the whole torrent, so a
+// later run selecting those files doesn't need to download them again
+type storage struct {
+	torrent  torrentparser.TorrentFile
+	dir      string
+	selected func(fileIndex int) bool
+	mut      sync.Mutex
+	files    map[int]*os.File
+	parts    *os.File
+}
+
+func newStorage(torrent torrentparser.TorrentFile, dir string, selected func(int) bool) *storage {
+	return &storage{
+		torrent:  torrent,
+		dir:      dir,
+		selected: selected,
+		files:    map[int]*os.File{},
+	}
+}
+
+// partsPath is the hidden file unselected parts of pieces are kept in
+func (s *storage) partsPath() string {
+	return filepath.Join(s.dir, "."+hex.EncodeToString(s.torrent.InfoHash[:])+".parts")
+}
+
+// openFile opens a torrent file for writing, creating it and its directory
+// when create is set
+func (s *storage) openFile(fileIndex int, create bool) (*os.File, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if f, ok := s.files[fileIndex]; ok {
+		return f, nil
+	}
+
+	file := s.torrent.Files[fileIndex]
+	path := filepath.Join(s.dir, file.Path)
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, flags, fileMode(file))
+	if err != nil {
+		return nil, err
+	}
+	s.files[fileIndex] = f
+	return f, nil
+}
+
+func (s *storage) openParts(create bool) (*os.File, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.parts != nil {
+		return s.parts, nil
+	}
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+		err := os.MkdirAll(s.dir, os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(s.partsPath(), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.parts = f
+	return f, nil
+}
+
+// WritePiece stores a verified piece, spans of selected files go to the files
+// and the rest to the parts file. Padding files are never written
+func (s *storage) WritePiece(index int, data []byte) error {
+	pieceOffset := index * s.torrent.PieceLength
+	var written int
+	for _, span := range s.torrent.PieceSpans(index) {
+		part := data[written : written+span.Length]
+		written += span.Length
+		file := s.torrent.Files[span.FileIndex]
+		if file.Padding || file.SymlinkPath != "" {
+			continue
+		}
+
+		if s.selected(span.FileIndex) {
+			f, err := s.openFile(span.FileIndex, true)
+			if err != nil {
+				return err
+			}
+			_, err = f.WriteAt(part, int64(span.Offset))
+			if err != nil {
+				return fmt.Errorf("failed to write file: %w", err)
+			}
+			continue
+		}
+
+		parts, err := s.openParts(true)
+		if err != nil {
+			return err
+		}
+		_, err = parts.WriteAt(part, int64(pieceOffset+written-span.Length))
+		if err != nil {
+			return fmt.Errorf("failed to write parts file: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxLayoutSpans limits the pieces HavePiece looks for in every combination
+// of files and parts file, each span doubles the combinations
+const maxLayoutSpans = 4
+
+// readPiece reads a piece back from disk, fromParts decides for each of the
+// piece's spans whether it is read from the parts file or its own file
+func (s *storage) readPiece(index int, fromParts func(span int) bool) ([]byte, error) {
+	buf := make([]byte, s.torrent.PieceSize(index))
+	pieceOffset := index * s.torrent.PieceLength
+	var read int
+	for i, span := range s.torrent.PieceSpans(index) {
+		part := buf[read : read+span.Length]
+		read += span.Length
+		if s.torrent.Files[span.FileIndex].Padding {
+			continue
+		}
+
+		var err error
+		if fromParts(i) {
+			var parts *os.File
+			parts, err = s.openParts(false)
+			if err == nil {
+				_, err = parts.ReadAt(part, int64(pieceOffset+read-span.Length))
+			}
+		} else {
+			var f *os.File
+			f, err = s.openFile(span.FileIndex, false)
+			if err == nil {
+				_, err = f.ReadAt(part, int64(span.Offset))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// HavePiece reports whether a piece is already on disk, so it doesn't need to
+// be downloaded again
+//
+// The piece is looked for where WritePiece would put it for the current
+// selection first. A previous run with another selection could have left each
+// span in its file or in the parts file, so the other combinations are tried
+// next, or only all files and all parts for pieces spanning many files. A
+// piece found elsewhere is rewritten where the current selection expects it
+func (s *storage) HavePiece(index int) bool {
+	spans := s.torrent.PieceSpans(index)
+	var current int
+	for i, span := range spans {
+		if !s.selected(span.FileIndex) {
+			current |= 1 << i
+		}
+	}
+
+	masks := []int{current}
+	if len(spans) <= maxLayoutSpans {
+		for mask := 0; mask < 1<<len(spans); mask++ {
+			if mask != current {
+				masks = append(masks, mask)
+			}
+		}
+	} else {
+		masks = append(masks, 0, -1)
+	}
+
+	for i, mask := range masks {
+		data, err := s.readPiece(index, func(span int) bool { return mask&(1<<span) != 0 })
+		if err != nil || sha1.Sum(data) != s.torrent.PieceHashes[index] {
+			continue
+		}
+		if i == 0 {
+			return true
+		}
+		return s.WritePiece(index, data) == nil
+	}
+	return false
+}
+
+// CreateEmpty creates a selected file that no piece overlaps
+func (s *storage) CreateEmpty(fileIndex int) error {
+	_, err := s.openFile(fileIndex, true)
+	return err
+}
+
+// RemoveParts deletes the parts file, once every file is selected and
+// complete nothing in it can be reused
+func (s *storage) RemoveParts() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.parts != nil {
+		s.parts.Close()
+		s.parts = nil
+	}
+	err := os.Remove(s.partsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ReadFileAt fills buf from a file starting at offset, from the parts file if
+// the file isn't selected. The pieces covering it must be verified
+func (s *storage) ReadFileAt(fileIndex int, buf []byte, offset int) error {
+	file := s.torrent.Files[fileIndex]
+	if file.Padding {
+		clear(buf)
+		return nil
+	}
+
+	if s.selected(fileIndex) {
+		f, err := s.openFile(fileIndex, false)
+		if err != nil {
+			return err
+		}
+		_, err = f.ReadAt(buf, int64(offset))
+		return err
+	}
+
+	var fileStart int
+	for _, f := range s.torrent.Files[:fileIndex] {
+		fileStart += f.Length
+	}
+	parts, err := s.openParts(false)
+	if err != nil {
+		return err
+	}
+	_, err = parts.ReadAt(buf, int64(fileStart+offset))
+	return err
+}
+
+// Sync flushes every open file to disk
+func (s *storage) Sync() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Sync())
+	}
+	if s.parts != nil {
+		errs = append(errs, s.parts.Sync())
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every open file, they are opened again if storage is used after
+func (s *storage) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, f := range s.files {
+		f.Close()
+	}
+	s.files = map[int]*os.File{}
+	if s.parts != nil {
+		s.parts.Close()
+		s.parts = nil
+	}
+	return nil
+}
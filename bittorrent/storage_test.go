@@ -0,0 +1,157 @@
+package bittorrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// pieceData reads a piece of a created torrent from its source dir
+func pieceData(t *testing.T, torrent torrentparser.TorrentFile, src string, index int) []byte {
+	t.Helper()
+	r := newFileReader(torrent, src)
+	defer r.Close()
+	buf := make([]byte, torrent.PieceSize(index))
+	err := r.ReadAt(buf, index*torrent.PieceLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func selectAllBut(unselected ...int) func(int) bool {
+	return func(i int) bool {
+		for _, u := range unselected {
+			if i == u {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestHavePieceAcrossSpans(t *testing.T) {
+	src := t.TempDir()
+	// piece 0 spans a, b and the start of c
+	torrent := createdTorrent(t, src, torrentparser.MetaV1, map[string][]byte{
+		"a": pattern(10 << 10),
+		"b": pattern(4 << 10),
+		"c": pattern(20 << 10),
+	})
+	piece := pieceData(t, torrent, src, 0)
+	if n := len(torrent.PieceSpans(0)); n != 3 {
+		t.Fatalf("piece 0 spans %d files", n)
+	}
+
+	tests := []struct {
+		name        string
+		written     []int // files unselected when the piece was written
+		reading     []int // files unselected when it is looked for
+		corrupt     string
+		have        bool
+		movedToFile string // a span found in the parts file is written to its file
+	}{
+		{"nothing written", nil, nil, "", false, ""},
+		{"same selection", nil, nil, "", true, ""},
+		{"same selection with parts", []int{1}, []int{1}, "", true, ""},
+		{"span now selected", []int{1}, nil, "", true, "b"},
+		{"spans now selected", []int{0, 2}, []int{1}, "", true, "a"},
+		{"span now unselected", nil, []int{0}, "", true, ""},
+		{"damaged file", nil, nil, "c", false, ""},
+		{"damaged parts", []int{1}, nil, "parts", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.name != "nothing written" {
+				s := newStorage(torrent, dir, selectAllBut(tt.written...))
+				err := s.WritePiece(0, piece)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.Close()
+			}
+			switch tt.corrupt {
+			case "c":
+				err := os.WriteFile(filepath.Join(dir, "data", "c"), []byte("x"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			case "parts":
+				s := newStorage(torrent, dir, nil)
+				err := os.WriteFile(s.partsPath(), make([]byte, len(piece)), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := newStorage(torrent, dir, selectAllBut(tt.reading...))
+			defer s.Close()
+			if got := s.HavePiece(0); got != tt.have {
+				t.Fatalf("HavePiece is %t, want %t", got, tt.have)
+			}
+			if !tt.have {
+				return
+			}
+			// the piece can now be read where the selection expects it
+			got, err := s.readPiece(0, func(span int) bool { return !s.selected(s.torrent.PieceSpans(0)[span].FileIndex) })
+			if err != nil || !bytes.Equal(got, piece) {
+				t.Fatalf("piece isn't where the selection expects it: %v", err)
+			}
+			if tt.movedToFile != "" {
+				got, err := os.ReadFile(filepath.Join(dir, "data", tt.movedToFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				want, err := os.ReadFile(filepath.Join(src, "data", tt.movedToFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s wasn't rewritten from the parts file", tt.movedToFile)
+				}
+			}
+		})
+	}
+}
+
+func TestHavePieceManySpans(t *testing.T) {
+	src := t.TempDir()
+	files := map[string][]byte{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		files[name] = pattern(2 << 10)
+	}
+	torrent := createdTorrent(t, src, torrentparser.MetaV1, files)
+	if n := len(torrent.PieceSpans(0)); n <= maxLayoutSpans {
+		t.Fatalf("piece 0 spans only %d files", n)
+	}
+	piece := pieceData(t, torrent, src, 0)
+
+	// with more spans than maxLayoutSpans only all files or all parts are
+	// tried besides the current selection
+	for _, tt := range []struct {
+		written []int
+		have    bool
+	}{
+		{[]int{0, 1, 2, 3, 4, 5}, true},
+		{nil, true},
+		{[]int{0, 2}, false},
+	} {
+		dir := t.TempDir()
+		s := newStorage(torrent, dir, selectAllBut(tt.written...))
+		err := s.WritePiece(0, piece)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		s = newStorage(torrent, dir, selectAllBut(1))
+		if got := s.HavePiece(0); got != tt.have {
+			t.Errorf("written with %v unselected: HavePiece is %t, want %t", tt.written, got, tt.have)
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,327 @@
+package bittorrent
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// FileResult is the result of verifying one file of a torrent
+type FileResult struct {
+	Path         string
+	Missing      bool
+	Size         int64 // size on disk
+	WantSize     int
+	FailedPieces []int // failed pieces overlapping the file, relative to the file in pure v2 torrents
+	SHA1Mismatch bool
+	MD5Mismatch  bool
+}
+
+// OK reports whether the file is complete and correct
+func (r FileResult) OK() bool {
+	return !r.Missing && r.Size == int64(r.WantSize) && len(r.FailedPieces) == 0 &&
+		!r.SHA1Mismatch && !r.MD5Mismatch
+}
+
+// VerifyResult is the result of checking a torrent's data on disk
+type VerifyResult struct {
+	Files            []FileResult // one per torrent file, padding files are skipped
+	PieceCount       int
+	FailedPieceCount int
+	FailedPieces     []int // v1 piece indexes, pure v2 failures are only per file
+}
+
+// OK reports whether every piece and file passed
+func (r VerifyResult) OK() bool {
+	if r.FailedPieceCount != 0 {
+		return false
+	}
+	for _, f := range r.Files {
+		if !f.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify hashes the data of a torrent stored in dir without downloading
+// anything, the same layout Run writes files in
+//
+// Pieces are checked against the v1 piece hashes, or the BEP0052 piece layers
+// of pure v2 torrents, on a pool of workers. Files with `sha1` or `md5` hashes
+// are also hashed in full
+func Verify(torrent torrentparser.TorrentFile, dir string, workers int) (VerifyResult, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	result := VerifyResult{}
+	store := newFileReader(torrent, dir)
+	defer store.Close()
+
+	// fileResults maps a torrent file index to its result
+	fileResults := map[int]*FileResult{}
+	for i, f := range torrent.Files {
+		if f.Padding || f.SymlinkPath != "" {
+			continue
+		}
+		res := &FileResult{Path: f.Path, WantSize: f.Length}
+		stat, err := os.Stat(filepath.Join(dir, f.Path))
+		if errors.Is(err, os.ErrNotExist) {
+			res.Missing = true
+		} else if err != nil {
+			return VerifyResult{}, err
+		} else {
+			res.Size = stat.Size()
+		}
+		fileResults[i] = res
+	}
+
+	var jobs []func()
+	var mut sync.Mutex
+	if len(torrent.PieceHashes) != 0 {
+		result.PieceCount = len(torrent.PieceHashes)
+		for i := range torrent.PieceHashes {
+			jobs = append(jobs, func() {
+				if !verifyPiece(torrent, store, i) {
+					mut.Lock()
+					result.FailedPieces = append(result.FailedPieces, i)
+					result.FailedPieceCount++
+					mut.Unlock()
+				}
+			})
+		}
+	} else {
+		// pure v2 torrents have pieces aligned to each file instead, failed
+		// pieces are only reported per file
+		for i, f := range torrent.Files {
+			res, ok := fileResults[i]
+			if !ok || f.Length == 0 {
+				continue
+			}
+			result.PieceCount += (f.Length + torrent.PieceLength - 1) / torrent.PieceLength
+			jobs = append(jobs, func() {
+				failed := verifyFileV2(torrent, store, i)
+				mut.Lock()
+				res.FailedPieces = failed
+				result.FailedPieceCount += len(failed)
+				mut.Unlock()
+			})
+		}
+	}
+
+	// whole file hashes are checked on the same pool as the pieces
+	for i, f := range torrent.Files {
+		res, ok := fileResults[i]
+		if !ok || res.Missing || (f.SHA1Hash == "" && f.MD5Hash == "") {
+			continue
+		}
+		jobs = append(jobs, func() {
+			sha1Mismatch, md5Mismatch := verifyFileHashes(store, i, f)
+			mut.Lock()
+			res.SHA1Mismatch, res.MD5Mismatch = sha1Mismatch, md5Mismatch
+			mut.Unlock()
+		})
+	}
+
+	jobQueue := make(chan func())
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobQueue {
+				job()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobQueue <- job
+	}
+	close(jobQueue)
+	wg.Wait()
+
+	// map failed pieces back onto the files they are stored in
+	sort.Ints(result.FailedPieces)
+	for _, index := range result.FailedPieces {
+		for _, span := range torrent.PieceSpans(index) {
+			if res, ok := fileResults[span.FileIndex]; ok {
+				res.FailedPieces = append(res.FailedPieces, index)
+			}
+		}
+	}
+
+	for i := range torrent.Files {
+		if res, ok := fileResults[i]; ok {
+			result.Files = append(result.Files, *res)
+		}
+	}
+	return result, nil
+}
+
+// verifyPiece reads a v1 piece from disk and checks its hash
+func verifyPiece(torrent torrentparser.TorrentFile, store *fileReader, index int) bool {
+	buf := make([]byte, torrent.PieceSize(index))
+	err := store.ReadAt(buf, index*torrent.PieceLength)
+	if err != nil {
+		return false
+	}
+	return sha1.Sum(buf) == torrent.PieceHashes[index]
+}
+
+// verifyFileV2 checks every piece of a file against its BEP0052 merkle tree,
+// returning the indexes of the failed pieces within the file
+func verifyFileV2(torrent torrentparser.TorrentFile, store *fileReader, fileIndex int) []int {
+	f := torrent.Files[fileIndex]
+	numPieces := (f.Length + torrent.PieceLength - 1) / torrent.PieceLength
+	var failed []int
+
+	// files of a single piece are checked against the pieces root
+	if numPieces == 1 {
+		buf := make([]byte, f.Length)
+		err := store.ReadFileAt(fileIndex, buf, 0)
+		root, _ := torrentparser.MerkleTree(torrentparser.BlockHashes(buf), torrent.PieceLength)
+		if err != nil || root != f.PiecesRoot {
+			failed = append(failed, 0)
+		}
+		return failed
+	}
+
+	blocksPerPiece := torrent.PieceLength / torrentparser.BlockSize
+	for p := 0; p < numPieces; p++ {
+		length := min(torrent.PieceLength, f.Length-p*torrent.PieceLength)
+		buf := make([]byte, length)
+		err := store.ReadFileAt(fileIndex, buf, p*torrent.PieceLength)
+		if err != nil || p >= len(f.PieceLayer) {
+			failed = append(failed, p)
+			continue
+		}
+		// the last piece is padded with zero hashes to a full piece
+		leaves := make([][32]byte, blocksPerPiece)
+		copy(leaves, torrentparser.BlockHashes(buf))
+		root, _ := torrentparser.MerkleTree(leaves, torrent.PieceLength)
+		if root != f.PieceLayer[p] {
+			failed = append(failed, p)
+		}
+	}
+	return failed
+}
+
+// verifyFileHashes hashes a whole file, reporting which of its optional
+// `sha1` and `md5` hashes don't match
+func verifyFileHashes(store *fileReader, fileIndex int, f torrentparser.File) (sha1Mismatch, md5Mismatch bool) {
+	sha1Hash, md5Hash := sha1.New(), md5.New()
+	var writers []io.Writer
+	if f.SHA1Hash != "" {
+		writers = append(writers, sha1Hash)
+	}
+	if f.MD5Hash != "" {
+		writers = append(writers, md5Hash)
+	}
+
+	file, err := store.open(fileIndex)
+	if err != nil {
+		return f.SHA1Hash != "", f.MD5Hash != ""
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), io.NewSectionReader(file, 0, int64(f.Length)))
+	if err != nil {
+		return f.SHA1Hash != "", f.MD5Hash != ""
+	}
+
+	return f.SHA1Hash != "" && !hashMatches(sha1Hash, f.SHA1Hash),
+		f.MD5Hash != "" && !hashMatches(md5Hash, f.MD5Hash)
+}
+
+// hashMatches compares a hash with a per file hash from a torrent, which is
+// usually the raw hash but some torrents have it hex encoded
+func hashMatches(h hash.Hash, want string) bool {
+	sum := h.Sum(nil)
+	if len(want) == len(sum) {
+		return bytes.Equal(sum, []byte(want))
+	}
+	return hex.EncodeToString(sum) == want
+}
+
+// fileReader reads a torrent's data from the files it is stored in, keeping
+// each file open for concurrent ReadAt calls
+type fileReader struct {
+	torrent torrentparser.TorrentFile
+	dir     string
+	mut     sync.Mutex
+	files   map[int]*os.File
+}
+
+func newFileReader(torrent torrentparser.TorrentFile, dir string) *fileReader {
+	return &fileReader{
+		torrent: torrent,
+		dir:     dir,
+		files:   map[int]*os.File{},
+	}
+}
+
+func (r *fileReader) open(fileIndex int) (*os.File, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if f, ok := r.files[fileIndex]; ok {
+		return f, nil
+	}
+	f, err := os.Open(filepath.Join(r.dir, r.torrent.Files[fileIndex].Path))
+	if err != nil {
+		return nil, err
+	}
+	r.files[fileIndex] = f
+	return f, nil
+}
+
+// ReadAt fills buf with the torrent's data starting at offset, padding files
+// are read as zeros
+func (r *fileReader) ReadAt(buf []byte, offset int) error {
+	var read int
+	for _, span := range r.torrent.FileSpans(offset, len(buf)) {
+		part := buf[read : read+span.Length]
+		read += span.Length
+		if r.torrent.Files[span.FileIndex].Padding {
+			clear(part)
+			continue
+		}
+		err := r.ReadFileAt(span.FileIndex, part, span.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	if read != len(buf) {
+		return fmt.Errorf("read %d bytes past the end of the torrent", len(buf)-read)
+	}
+	return nil
+}
+
+// ReadFileAt fills buf from a single file starting at offset
+func (r *fileReader) ReadFileAt(fileIndex int, buf []byte, offset int) error {
+	f, err := r.open(fileIndex)
+	if err != nil {
+		return err
+	}
+	_, err = f.ReadAt(buf, int64(offset))
+	return err
+}
+
+func (r *fileReader) Close() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, f := range r.files {
+		f.Close()
+	}
+	return nil
+}
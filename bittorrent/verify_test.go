@@ -0,0 +1,134 @@
+package bittorrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// pattern returns n bytes of test data that isn't all zeros
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+// createdTorrent creates a torrent of files in dir/data, returning it parsed
+func createdTorrent(t *testing.T, dir string, version torrentparser.MetaVersion, files map[string][]byte) torrentparser.TorrentFile {
+	t.Helper()
+	root := filepath.Join(dir, "data")
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := torrentparser.Create(root, torrentparser.CreateOptions{Version: version, PieceLength: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := torrentparser.ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrent
+}
+
+func TestVerifyDamagedFiles(t *testing.T) {
+	files := map[string][]byte{
+		"a":     pattern(40 << 10),
+		"b":     pattern(100),
+		"dir/c": pattern(20 << 10),
+		"dir/d": pattern(16 << 10),
+	}
+	// the failed pieces of each file once a is changed, c is deleted and d
+	// is truncated. v1 pieces run across files so they also fail the files
+	// they share, v2 pieces are counted within each file
+	tests := []struct {
+		version torrentparser.MetaVersion
+		failed  map[string][]int
+		count   int
+	}{
+		{torrentparser.MetaV1, map[string][]int{"a": {1, 2}, "b": {2}, "dir/c": {2, 3}, "dir/d": {3, 4}}, 4},
+		{torrentparser.MetaHybrid, map[string][]int{"a": {1}, "dir/c": {4, 5}, "dir/d": {6}}, 4},
+		{torrentparser.MetaV2, map[string][]int{"a": {1}, "dir/c": {0, 1}, "dir/d": {0}}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			torrent := createdTorrent(t, dir, tt.version, files)
+
+			result, err := Verify(torrent, dir, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.OK() || len(result.Files) != len(files) {
+				t.Fatalf("intact data: %+v", result)
+			}
+
+			f, err := os.OpenFile(filepath.Join(dir, "data", "a"), os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteAt([]byte{0xff}, 20000)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Remove(filepath.Join(dir, "data", "dir", "c"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Truncate(filepath.Join(dir, "data", "dir", "d"), 10000)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err = Verify(torrent, dir, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OK() || result.FailedPieceCount != tt.count {
+				t.Errorf("%d pieces failed, want %d", result.FailedPieceCount, tt.count)
+			}
+			for _, res := range result.Files {
+				name, _ := filepath.Rel("data", res.Path)
+				name = filepath.ToSlash(name)
+				if got, want := fmt.Sprint(res.FailedPieces), fmt.Sprint(tt.failed[name]); got != want {
+					t.Errorf("%s failed pieces %s, want %s", name, got, want)
+				}
+				if res.Missing != (name == "dir/c") {
+					t.Errorf("%s missing: %t", name, res.Missing)
+				}
+				wantSize := int64(len(files[name]))
+				switch name {
+				case "dir/c":
+					wantSize = 0
+				case "dir/d":
+					wantSize = 10000
+				}
+				if res.Size != wantSize || res.WantSize != len(files[name]) {
+					t.Errorf("%s is %d bytes of %d, want %d", name, res.Size, res.WantSize, wantSize)
+				}
+				if res.OK() != (name == "b" && tt.version != torrentparser.MetaV1) {
+					t.Errorf("%s OK: %t", name, res.OK())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// runCreate builds a .torrent file from a file or directory
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	var trackers, webSeeds, httpSeeds stringsFlag
+	fs.Var(&trackers, "tracker", "tracker url, repeat for each tier, comma separate trackers in the same tier")
+	fs.Var(&webSeeds, "webseed", "web seed url (BEP0019), can be repeated")
+	fs.Var(&httpSeeds, "httpseed", "http seed url (BEP0017), can be repeated")
+	out := fs.String("o", "", "path to write the torrent file to, defaults to <name>.torrent")
+	private := fs.Bool("private", false, "set the private flag (BEP0027)")
+	comment := fs.String("comment", "", "free form comment")
+	source := fs.String("source", "", "source tag, changes the info hash per tracker")
+	createdBy := fs.String("created-by", "bittorrent-client-go", "value of the `created by` field")
+	version := fs.String("version", "v1", "torrent version: v1, v2 (BEP0052) or hybrid")
+	pieceLength := fs.Int("piece-length", 0, "piece length in bytes, 0 picks one automatically")
+	workers := fs.Int("workers", 0, "number of hashing goroutines, 0 uses every CPU")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s create [flags] <file or directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one file or directory")
+	}
+	root := fs.Arg(0)
+
+	var metaVersion torrentparser.MetaVersion
+	switch *version {
+	case "v1":
+		metaVersion = torrentparser.MetaV1
+	case "v2":
+		metaVersion = torrentparser.MetaV2
+	case "hybrid":
+		metaVersion = torrentparser.MetaHybrid
+	default:
+		return fmt.Errorf("unknown version %q", *version)
+	}
+
+	var announceList [][]string
+	for _, tier := range trackers {
+		announceList = append(announceList, strings.Split(tier, ","))
+	}
+
+	raw, err := torrentparser.Create(root, torrentparser.CreateOptions{
+		AnnounceList: announceList,
+		WebSeeds:     webSeeds,
+		HTTPSeeds:    httpSeeds,
+		Private:      *private,
+		Comment:      *comment,
+		Source:       *source,
+		CreatedBy:    *createdBy,
+		Version:      metaVersion,
+		PieceLength:  *pieceLength,
+		Workers:      *workers,
+	})
+	if err != nil {
+		return fmt.Errorf("creating torrent: %w", err)
+	}
+
+	outPath := *out
+	if outPath == "" {
+		outPath = filepath.Base(filepath.Clean(root)) + ".torrent"
+	}
+	err = os.WriteFile(outPath, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("writing torrent: %w", err)
+	}
+
+	// parse the written file back so what's printed is what clients will see
+	torrent, err := torrentparser.ParseTorrentFile(outPath)
+	if err != nil {
+		return fmt.Errorf("parsing created torrent: %w", err)
+	}
+
+	fmt.Printf("created %s\n", outPath)
+	if len(torrent.PieceHashes) != 0 {
+		fmt.Printf("pieces: %d x %d bytes\n", len(torrent.PieceHashes), torrent.PieceLength)
+		fmt.Printf("info hash: %x\n", torrent.InfoHash)
+	}
+	if torrent.MetaVersion == 2 {
+		if len(torrent.PieceHashes) == 0 {
+			fmt.Printf("piece length: %d bytes\n", torrent.PieceLength)
+		}
+		fmt.Printf("info hash v2: %x\n", torrent.InfoHashV2)
+	}
+	fmt.Printf("magnet: %s\n", torrent.MagnetLink())
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+)
+
+// defaultAPIAddr is where the daemon serves its API unless told otherwise,
+// only reachable from the same machine
+const defaultAPIAddr = "localhost:9090"
+
+// apiTokenEnv is the environment variable the API token is read from unless
+// passed as a flag, flags show up in process listings
+const apiTokenEnv = "BITTORRENT_API_TOKEN"
+
+// runDaemon runs a session of many torrents until interrupted, managed over
+// the HTTP API of apiServer
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	apiAddr := fs.String("api", defaultAPIAddr, "address to serve the HTTP API on")
+	apiToken := fs.String("api-token", os.Getenv(apiTokenEnv), "token every API request must carry, required to serve the API beyond localhost, defaults to $"+apiTokenEnv)
+	var apiHosts, allowDirs stringsFlag
+	fs.Var(&apiHosts, "api-host", "host name the API can be reached by besides localhost and IP addresses, can be repeated")
+	fs.Var(&allowDirs, "allow-dir", "directory the API can add torrent files from and download into besides -out, can be repeated")
+	fetchPrivate := fs.Bool("rpc-fetch-private", false, "let the Transmission RPC fetch torrent files from loopback and private addresses")
+	listenAddr := fs.String("listen", ":6881", "address to accept peer connections on")
+	dhtAddr := fs.String("dht", "", "UDP address of the DHT node, the host and port of -listen when empty")
+	noDHT := fs.Bool("no-dht", false, "don't run a DHT node, peers only come from trackers and seeds")
+	var dhtRouters stringsFlag
+	fs.Var(&dhtRouters, "dht-router", "host:port of a DHT node to bootstrap from instead of the well known routers, can be repeated")
+	outDir := fs.String("out", "./", "directory torrents are downloaded into unless added with another")
+	stateDir := fs.String("state", defaultStateDir(), "directory uploaded torrent files are kept in")
+	cacheDir := fs.String("metadata-cache", bittorrent.DefaultMetadataCacheDir(), "directory to cache magnet link metadata in, empty disables it")
+	maxConns := fs.Int("max-connections", 200, "peer connections of all torrents together, 0 is unlimited")
+	maxPeers := fs.Int("max-peers", 50, "peers each torrent connects to at most")
+	maxHalfOpen := fs.Int("max-half-open", 8, "peers all torrents dial at once together")
+	var downloadLimit rateFlag
+	fs.Var(&downloadLimit, "download-limit", "bytes per second all torrents download together, like 2M, 0 is unlimited")
+	schedulePath := fs.String("schedule", "", "JSON file of times of the week to switch limits or pause torrents at, reloaded when it changes")
+	var logs logFlags
+	logs.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s daemon [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("unexpected arguments")
+	}
+	if *apiToken == "" && !loopbackAddr(*apiAddr) {
+		return fmt.Errorf("serving the API on %s needs -api-token, it's reachable from other machines", *apiAddr)
+	}
+	logger, err := logs.logger()
+	if err != nil {
+		return err
+	}
+
+	session, err := bittorrent.NewSession(bittorrent.SessionConfig{
+		ListenAddr:         *listenAddr,
+		MaxConnections:     *maxConns,
+		MaxPeersPerTorrent: *maxPeers,
+		MaxHalfOpen:        *maxHalfOpen,
+		DownloadLimit:      int64(downloadLimit),
+		MetadataCacheDir:   *cacheDir,
+		DHTAddr:            *dhtAddr,
+		DHTRouters:         dhtRouters,
+		NoDHT:              *noDHT,
+		Logger:             logger,
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var sched *scheduler
+	if *schedulePath != "" {
+		sched, err = newScheduler(session, *schedulePath, logger)
+		if err != nil {
+			return fmt.Errorf("loading schedule: %w", err)
+		}
+		stopSchedule := make(chan struct{})
+		scheduleDone := make(chan struct{})
+		go func() {
+			defer close(scheduleDone)
+			sched.run(stopSchedule)
+		}()
+		// stopped before the session is closed
+		defer func() {
+			close(stopSchedule)
+			<-scheduleDone
+		}()
+	}
+
+	ln, err := net.Listen("tcp", *apiAddr)
+	if err != nil {
+		return fmt.Errorf("starting api server: %w", err)
+	}
+	// event streams never end by themselves, they're stopped on shutdown
+	streams, stopStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler: newAPIServer(session, sched, apiConfig{
+			OutDir:       *outDir,
+			UploadDir:    filepath.Join(*stateDir, "uploads"),
+			Dirs:         allowDirs,
+			Token:        *apiToken,
+			Hosts:        apiHosts,
+			FetchPrivate: *fetchPrivate,
+		}),
+		BaseContext: func(net.Listener) context.Context { return streams },
+	}
+	server.RegisterOnShutdown(stopStreams)
+	go server.Serve(ln)
+	fmt.Printf("serving the dashboard and api on http://%s/, peers connect to %s\n", ln.Addr(), session.Addr())
+
+	ctx, stop := signalContext()
+	defer stop()
+	<-ctx.Done()
+
+	fmt.Println("stopping every torrent")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	return nil
+}
+
+// loopbackAddr reports whether a listening address is only reachable from the
+// same machine
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// defaultStateDir is where the daemon keeps its files, inside the user's
+// config directory
+func defaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bittorrent-client-go", "daemon")
+}
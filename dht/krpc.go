@@ -0,0 +1,139 @@
+package dht
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/zeebo/bencode"
+)
+
+// message is a KRPC message, a bencoded dictionary sent over UDP. A query
+// ("q") is answered with a response ("r") or an error ("e") carrying the same
+// transaction id
+type message struct {
+	T string             `bencode:"t"`
+	Y string             `bencode:"y"`
+	Q string             `bencode:"q,omitempty"`
+	A *queryArgs         `bencode:"a,omitempty"`
+	R *response          `bencode:"r,omitempty"`
+	E bencode.RawMessage `bencode:"e,omitempty"`
+	// RO is 1 for queries of read-only nodes (BEP0043), which can't be
+	// queried back so they're kept out of routing tables
+	RO int `bencode:"ro,omitempty"`
+}
+
+// queryArgs are the arguments of every query, each only uses some of them
+type queryArgs struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`       // find_node
+	InfoHash    string `bencode:"info_hash,omitempty"`    // get_peers and announce_peer
+	Port        int    `bencode:"port,omitempty"`         // announce_peer
+	ImpliedPort int    `bencode:"implied_port,omitempty"` // announce_peer, 1 uses the source port
+	Token       string `bencode:"token,omitempty"`        // announce_peer, from get_peers
+}
+
+type response struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`  // compact node info, find_node and get_peers
+	Values []string `bencode:"values,omitempty"` // compact peer info, get_peers
+	Token  string   `bencode:"token,omitempty"`  // get_peers
+}
+
+const (
+	queryPing         = "ping"
+	queryFindNode     = "find_node"
+	queryGetPeers     = "get_peers"
+	queryAnnouncePeer = "announce_peer"
+)
+
+// KRPC error codes
+const (
+	errGeneric       = 201
+	errProtocol      = 203
+	errMethodUnknown = 204
+)
+
+// krpcError is an error a node answered a query with
+type krpcError struct {
+	Code int
+	Msg  string
+}
+
+func (e *krpcError) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Msg)
+}
+
+// encodeError is the "e" list of an error message
+func encodeError(code int, msg string) bencode.RawMessage {
+	raw, _ := bencode.EncodeBytes([]any{code, msg})
+	return raw
+}
+
+// decodeError decodes the "e" list of an error message
+func decodeError(raw bencode.RawMessage) error {
+	var list []any
+	err := bencode.DecodeBytes(raw, &list)
+	if err != nil || len(list) != 2 {
+		return &krpcError{Code: errGeneric, Msg: "malformed error"}
+	}
+	code, _ := list[0].(int64)
+	msg, _ := list[1].(string)
+	return &krpcError{Code: int(code), Msg: msg}
+}
+
+// contact is a node of the DHT, only IPv4 nodes are supported as BEP0032
+// isn't implemented
+type contact struct {
+	ID   [20]byte
+	Addr netip.AddrPort
+}
+
+const compactNodeLen = 26 // node id, IPv4 address and port
+
+// encodeNodes encodes contacts as compact node info
+func encodeNodes(contacts []contact) string {
+	buf := make([]byte, 0, len(contacts)*compactNodeLen)
+	for _, c := range contacts {
+		buf = append(buf, c.ID[:]...)
+		buf = append(buf, encodePeer(c.Addr)...)
+	}
+	return string(buf)
+}
+
+// decodeNodes decodes compact node info, skipping nodes without a port
+func decodeNodes(s string) []contact {
+	var contacts []contact
+	for i := 0; i+compactNodeLen <= len(s); i += compactNodeLen {
+		var c contact
+		copy(c.ID[:], s[i:i+20])
+		addr, ok := decodePeer(s[i+20 : i+compactNodeLen])
+		if !ok {
+			continue
+		}
+		c.Addr = addr
+		contacts = append(contacts, c)
+	}
+	return contacts
+}
+
+// encodePeer encodes an IPv4 address as compact peer info
+func encodePeer(addr netip.AddrPort) string {
+	ip := addr.Addr().Unmap().As4()
+	buf := binary.BigEndian.AppendUint16(ip[:], addr.Port())
+	return string(buf)
+}
+
+// decodePeer decodes compact peer info, false when it's malformed or has no
+// port
+func decodePeer(s string) (netip.AddrPort, bool) {
+	if len(s) != 6 {
+		return netip.AddrPort{}, false
+	}
+	addr := netip.AddrFrom4([4]byte([]byte(s[:4])))
+	port := binary.BigEndian.Uint16([]byte(s[4:]))
+	if port == 0 || addr.IsUnspecified() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr, port), true
+}
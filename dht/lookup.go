@@ -0,0 +1,202 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// maxLookupPeers limits the peers a lookup collects
+const maxLookupPeers = 500
+
+// lookup walks the DHT towards a target: it queries the closest nodes it
+// knows, which answer with nodes closer to it, until the bucketSize closest
+// nodes answered. get_peers lookups also collect the peers those nodes know
+// and the tokens to announce to them
+type lookup struct {
+	self   [20]byte
+	target [20]byte
+	q      string
+
+	mut        sync.Mutex
+	candidates []*candidate // sorted by distance to target
+	seen       map[netip.AddrPort]bool
+	peers      map[netip.AddrPort]bool
+}
+
+type candidate struct {
+	contact
+	queried  bool
+	answered bool
+	token    string // for announce_peer
+}
+
+// lookup finds the nodes closest to target, starting from the routing table
+// and from the routers while the table is small
+func (n *Node) lookup(ctx context.Context, target [20]byte, q string) (*lookup, error) {
+	l := &lookup{
+		self:   n.id,
+		target: target,
+		q:      q,
+		seen:   map[netip.AddrPort]bool{},
+		peers:  map[netip.AddrPort]bool{},
+	}
+	l.add(n.table.closest(target, bucketSize), nil)
+
+	answered := false
+	var mut sync.Mutex
+	ask := func(addr netip.AddrPort) (*response, bool) {
+		args := queryArgs{Target: string(target[:])}
+		if q == queryGetPeers {
+			args = queryArgs{InfoHash: string(target[:])}
+		}
+		r, err := n.query(ctx, addr, q, args)
+		if err != nil {
+			return nil, false
+		}
+		l.add(decodeNodes(r.Nodes), r.Values)
+		mut.Lock()
+		answered = true
+		mut.Unlock()
+		return r, true
+	}
+
+	// routers are asked first while the table is small, their ids are only
+	// known once they answer
+	if len(l.candidates) < bucketSize {
+		var wg sync.WaitGroup
+		for _, router := range n.routers {
+			for _, addr := range n.resolve(ctx, router) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if r, ok := ask(addr); ok {
+						id, _ := nodeID(r)
+						l.addAnswered(contact{ID: id, Addr: addr}, r.Token)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+	}
+
+	for ctx.Err() == nil {
+		batch := l.next(alpha)
+		if len(batch) == 0 {
+			break
+		}
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, ok := ask(c.Addr)
+				l.mut.Lock()
+				c.answered = ok
+				if ok {
+					c.token = r.Token
+				}
+				l.mut.Unlock()
+			}()
+		}
+		wg.Wait()
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if !answered {
+		return nil, errors.New("no DHT node answered")
+	}
+	return l, nil
+}
+
+// add adds the nodes and peers a node answered with
+func (l *lookup) add(contacts []contact, values []string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	for _, c := range contacts {
+		if c.ID == l.self || l.seen[c.Addr] {
+			continue
+		}
+		l.seen[c.Addr] = true
+		l.candidates = append(l.candidates, &candidate{contact: c})
+	}
+	l.sortLocked()
+	for _, v := range values {
+		if addr, ok := decodePeer(v); ok && len(l.peers) < maxLookupPeers {
+			l.peers[addr] = true
+		}
+	}
+}
+
+// addAnswered adds a node that was queried outside of the lookup's rounds
+func (l *lookup) addAnswered(c contact, token string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if c.ID == l.self || l.seen[c.Addr] {
+		return
+	}
+	l.seen[c.Addr] = true
+	l.candidates = append(l.candidates, &candidate{contact: c, queried: true, answered: true, token: token})
+	l.sortLocked()
+}
+
+// next returns up to max nodes to query next, the closest that weren't
+// queried yet among the bucketSize closest that didn't fail to answer. None
+// means the lookup is done
+func (l *lookup) next(max int) []*candidate {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	var batch []*candidate
+	considered := 0
+	for _, c := range l.candidates {
+		if considered == bucketSize || len(batch) == max {
+			break
+		}
+		if c.queried && !c.answered {
+			continue
+		}
+		considered++
+		if !c.queried {
+			c.queried = true
+			batch = append(batch, c)
+		}
+	}
+	return batch
+}
+
+// closest returns the bucketSize closest nodes that answered
+func (l *lookup) closest() []candidate {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	var closest []candidate
+	for _, c := range l.candidates {
+		if len(closest) == bucketSize {
+			break
+		}
+		if c.answered {
+			closest = append(closest, *c)
+		}
+	}
+	return closest
+}
+
+func (l *lookup) sortLocked() {
+	sort.SliceStable(l.candidates, func(i, j int) bool {
+		return closer(l.candidates[i].ID, l.candidates[j].ID, l.target)
+	})
+}
+
+func (l *lookup) peerAddrs() []net.TCPAddr {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	addrs := make([]net.TCPAddr, 0, len(l.peers))
+	for addr := range l.peers {
+		addrs = append(addrs, *net.TCPAddrFromAddrPort(addr))
+	}
+	return addrs
+}
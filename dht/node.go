@@ -0,0 +1,492 @@
+// Package dht is a node of the mainline DHT (BEP0005), it finds the peers of
+// torrents without asking trackers and announces the ones we download
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+	"github.com/zeebo/bencode"
+)
+
+// DefaultRouters are well known nodes to bootstrap from
+var DefaultRouters = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+const (
+	queryTimeout    = 2 * time.Second
+	alpha           = 3                // queries a lookup sends at once
+	refreshInterval = 15 * time.Minute // how often a small routing table is filled again
+	tokenRotation   = 5 * time.Minute  // tokens are valid for two rotations
+	peerTTL         = 30 * time.Minute // how long announced peers are kept
+	maxInfoHashes   = 1000             // torrents peers are kept for
+	maxPeers        = 100              // peers kept per torrent
+	maxValues       = 50               // peers in a get_peers response
+)
+
+// Config configures a Node
+type Config struct {
+	// Addr is the UDP address the node listens on, ":6881" when empty. Only
+	// IPv4 is supported
+	Addr string
+
+	// Routers are asked for the first nodes of the DHT while the routing
+	// table is small, "host:port" like DefaultRouters. None when nil
+	Routers []string
+
+	// Logger logs the node, nil discards the logs
+	Logger *slog.Logger
+}
+
+// Node is a node of the DHT: it answers the queries of other nodes, keeps
+// the peers announced to it and looks up peers for us
+type Node struct {
+	id      [20]byte
+	conn    *net.UDPConn
+	routers []string
+	log     *slog.Logger
+	table   *table
+
+	ctx    context.Context // canceled when the node is closed
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mut     sync.Mutex
+	closed  bool
+	pending map[string]pendingQuery // by transaction id
+	nextTID uint16
+	peers   map[[20]byte]map[netip.AddrPort]time.Time // announced to us, with when they expire
+	secrets [2][8]byte                                // current and previous token secrets
+	rotated time.Time
+}
+
+// pendingQuery is a query waiting for its answer
+type pendingQuery struct {
+	addr   netip.AddrPort
+	answer chan message
+}
+
+// New starts a node listening on config.Addr and bootstraps it from the
+// routers in the background
+func New(config Config) (*Node, error) {
+	if config.Addr == "" {
+		config.Addr = ":6881"
+	}
+	addr, err := net.ResolveUDPAddr("udp4", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", config.Addr, err)
+	}
+	conn, err := net.ListenUDP("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for DHT nodes: %w", err)
+	}
+
+	n := &Node{
+		conn:    conn,
+		routers: config.Routers,
+		log:     logging.OrDiscard(config.Logger),
+		pending: map[string]pendingQuery{},
+		peers:   map[[20]byte]map[netip.AddrPort]time.Time{},
+		rotated: time.Now(),
+	}
+	rand.Read(n.id[:])
+	rand.Read(n.secrets[0][:])
+	n.secrets[1] = n.secrets[0]
+	n.table = newTable(n.id)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+
+	n.wg.Add(2)
+	go n.read()
+	go n.maintain()
+	return n, nil
+}
+
+// ID is the node id, random for every node
+func (n *Node) ID() [20]byte {
+	return n.id
+}
+
+// Addr is the UDP address the node listens on
+func (n *Node) Addr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// Len returns how many nodes the routing table has that answer
+func (n *Node) Len() int {
+	return n.table.len()
+}
+
+// Close stops the node and waits for its lookups and pings to end
+func (n *Node) Close() error {
+	n.mut.Lock()
+	if n.closed {
+		n.mut.Unlock()
+		return nil
+	}
+	n.closed = true
+	n.mut.Unlock()
+
+	n.cancel()
+	err := n.conn.Close()
+	n.wg.Wait()
+	return err
+}
+
+// AddNode pings a node in the background and adds it to the routing table
+// once it answers. addr is "host:port", torrent files list nodes and peers
+// send the port of theirs
+func (n *Node) AddNode(addr string) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if n.closed {
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for _, a := range n.resolve(n.ctx, addr) {
+			n.query(n.ctx, a, queryPing, queryArgs{})
+		}
+	}()
+}
+
+// GetPeers looks up the peers of a torrent on the DHT, and announces to the
+// nodes closest to its info hash that we download it on port. 0 doesn't
+// announce
+func (n *Node) GetPeers(ctx context.Context, infoHash [20]byte, port int) ([]net.TCPAddr, error) {
+	l, err := n.lookup(ctx, infoHash, queryGetPeers)
+	if err != nil {
+		return nil, err
+	}
+	if port > 0 && port <= 65535 {
+		var wg sync.WaitGroup
+		for _, c := range l.closest() {
+			if c.token == "" {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := n.query(ctx, c.Addr, queryAnnouncePeer, queryArgs{
+					InfoHash: string(infoHash[:]),
+					Port:     port,
+					Token:    c.token,
+				})
+				if err != nil {
+					n.log.Debug("announcing to DHT node failed", "addr", c.Addr.String(), "error", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	peers := l.peerAddrs()
+	n.log.Debug("DHT lookup done", logging.HashAttr(infoHash), "peers", len(peers))
+	return peers, nil
+}
+
+// read handles every message the node receives until it's closed
+func (n *Node) read() {
+	defer n.wg.Done()
+	buf := make([]byte, 64<<10)
+	for {
+		size, addr, err := n.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if n.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var m message
+		if bencode.DecodeBytes(buf[:size], &m) != nil {
+			continue
+		}
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		switch m.Y {
+		case "q":
+			n.handleQuery(addr, m)
+		case "r", "e":
+			n.handleAnswer(addr, m)
+		}
+	}
+}
+
+// maintain bootstraps the node while its routing table is small and expires
+// the peers announced to it, until it's closed
+func (n *Node) maintain() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		if n.table.len() < bucketSize && len(n.routers) > 0 {
+			_, err := n.lookup(n.ctx, n.id, queryFindNode)
+			if err != nil {
+				n.log.Debug("bootstrapping the DHT failed", "error", err)
+			} else {
+				n.log.Info("bootstrapped the DHT", "nodes", n.table.len())
+			}
+		}
+		select {
+		case now := <-ticker.C:
+			n.expirePeers(now)
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// send writes a message to addr
+func (n *Node) send(addr netip.AddrPort, m message) error {
+	raw, err := bencode.EncodeBytes(m)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDPAddrPort(raw, addr)
+	return err
+}
+
+// query sends a query to the node at addr and waits for its response, the
+// node is added to the routing table when it answers
+func (n *Node) query(ctx context.Context, addr netip.AddrPort, q string, args queryArgs) (*response, error) {
+	args.ID = string(n.id[:])
+	answer := make(chan message, 1)
+	n.mut.Lock()
+	n.nextTID++
+	tid := string(binary.BigEndian.AppendUint16(nil, n.nextTID))
+	n.pending[tid] = pendingQuery{addr: addr, answer: answer}
+	n.mut.Unlock()
+	defer func() {
+		n.mut.Lock()
+		delete(n.pending, tid)
+		n.mut.Unlock()
+	}()
+
+	err := n.send(addr, message{T: tid, Y: "q", Q: q, A: &args})
+	if err != nil {
+		return nil, fmt.Errorf("sending %s to %s: %w", q, addr, err)
+	}
+	timer := time.NewTimer(queryTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.ctx.Done():
+		return nil, net.ErrClosed
+	case <-timer.C:
+		n.table.failed(addr)
+		return nil, fmt.Errorf("%s to %s timed out", q, addr)
+	case m := <-answer:
+		if m.Y == "e" {
+			return nil, fmt.Errorf("%s to %s: %w", q, addr, decodeError(m.E))
+		}
+		id, ok := nodeID(m.R)
+		if !ok {
+			return nil, fmt.Errorf("malformed %s response from %s", q, addr)
+		}
+		n.table.seen(contact{ID: id, Addr: addr})
+		return m.R, nil
+	}
+}
+
+func nodeID(r *response) ([20]byte, bool) {
+	if r == nil {
+		return [20]byte{}, false
+	}
+	return hash(r.ID)
+}
+
+// hash converts a 20 bytes string from a message into an id or info hash
+func hash(s string) ([20]byte, bool) {
+	var h [20]byte
+	if len(s) != len(h) {
+		return h, false
+	}
+	copy(h[:], s)
+	return h, true
+}
+
+// handleAnswer hands a response or error to the query waiting for it, only
+// when it comes from the node the query went to
+func (n *Node) handleAnswer(addr netip.AddrPort, m message) {
+	n.mut.Lock()
+	p, ok := n.pending[m.T]
+	if ok && p.addr == addr {
+		delete(n.pending, m.T)
+	}
+	n.mut.Unlock()
+	if ok && p.addr == addr {
+		p.answer <- m
+	}
+}
+
+// handleQuery answers the query of another node
+func (n *Node) handleQuery(addr netip.AddrPort, m message) {
+	fail := func(code int, msg string) {
+		n.send(addr, message{T: m.T, Y: "e", E: encodeError(code, msg)})
+	}
+	if m.A == nil || len(m.A.ID) != 20 {
+		fail(errProtocol, "missing id")
+		return
+	}
+
+	r := &response{ID: string(n.id[:])}
+	switch m.Q {
+	case queryPing:
+	case queryFindNode:
+		target, ok := hash(m.A.Target)
+		if !ok {
+			fail(errProtocol, "invalid target")
+			return
+		}
+		r.Nodes = encodeNodes(n.table.closest(target, bucketSize))
+	case queryGetPeers:
+		infoHash, ok := hash(m.A.InfoHash)
+		if !ok {
+			fail(errProtocol, "invalid info_hash")
+			return
+		}
+		r.Token = n.tokens(addr.Addr())[0]
+		r.Values = n.storedPeers(infoHash)
+		if len(r.Values) == 0 {
+			r.Nodes = encodeNodes(n.table.closest(infoHash, bucketSize))
+		}
+	case queryAnnouncePeer:
+		infoHash, ok := hash(m.A.InfoHash)
+		if !ok {
+			fail(errProtocol, "invalid info_hash")
+			return
+		}
+		tokens := n.tokens(addr.Addr())
+		if m.A.Token == "" || (m.A.Token != tokens[0] && m.A.Token != tokens[1]) {
+			fail(errProtocol, "bad token")
+			return
+		}
+		port := m.A.Port
+		if m.A.ImpliedPort == 1 {
+			port = int(addr.Port())
+		}
+		if port <= 0 || port > 65535 {
+			fail(errProtocol, "invalid port")
+			return
+		}
+		n.store(infoHash, netip.AddrPortFrom(addr.Addr(), uint16(port)))
+	default:
+		fail(errMethodUnknown, "method unknown")
+		return
+	}
+	if m.RO != 1 {
+		id, _ := hash(m.A.ID)
+		n.table.seen(contact{ID: id, Addr: addr})
+	}
+	n.send(addr, message{T: m.T, Y: "r", R: r})
+}
+
+// tokens returns the tokens of an IP address for the current and the
+// previous secret. get_peers hands out the first, announce_peer takes both
+func (n *Node) tokens(ip netip.Addr) [2]string {
+	n.mut.Lock()
+	if time.Since(n.rotated) >= tokenRotation {
+		n.secrets[1] = n.secrets[0]
+		rand.Read(n.secrets[0][:])
+		n.rotated = time.Now()
+	}
+	secrets := n.secrets
+	n.mut.Unlock()
+
+	var tokens [2]string
+	raw := ip.As16()
+	for i, secret := range secrets {
+		sum := sha1.Sum(append(secret[:], raw[:]...))
+		tokens[i] = string(sum[:8])
+	}
+	return tokens
+}
+
+// store keeps a peer announced for a torrent, new ones are dropped once
+// there are too many
+func (n *Node) store(infoHash [20]byte, peer netip.AddrPort) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	peers, ok := n.peers[infoHash]
+	if !ok {
+		if len(n.peers) >= maxInfoHashes {
+			return
+		}
+		peers = map[netip.AddrPort]time.Time{}
+		n.peers[infoHash] = peers
+	}
+	if _, ok := peers[peer]; !ok && len(peers) >= maxPeers {
+		return
+	}
+	peers[peer] = time.Now().Add(peerTTL)
+}
+
+// storedPeers returns the peers announced for a torrent as compact peer info
+func (n *Node) storedPeers(infoHash [20]byte) []string {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	now := time.Now()
+	var values []string
+	for peer, expires := range n.peers[infoHash] {
+		if len(values) == maxValues {
+			break
+		}
+		if now.Before(expires) {
+			values = append(values, encodePeer(peer))
+		}
+	}
+	return values
+}
+
+func (n *Node) expirePeers(now time.Time) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	for infoHash, peers := range n.peers {
+		for peer, expires := range peers {
+			if !now.Before(expires) {
+				delete(peers, peer)
+			}
+		}
+		if len(peers) == 0 {
+			delete(n.peers, infoHash)
+		}
+	}
+}
+
+// resolve returns the IPv4 addresses of a "host:port" node
+func (n *Node) resolve(ctx context.Context, hostPort string) []netip.AddrPort {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		n.log.Debug("invalid DHT node address", "addr", hostPort, "error", err)
+		return nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		n.log.Debug("invalid DHT node port", "addr", hostPort)
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	if err != nil {
+		n.log.Debug("resolving DHT node failed", "addr", hostPort, "error", err)
+		return nil
+	}
+	var addrs []netip.AddrPort
+	for _, ip := range ips {
+		addrs = append(addrs, netip.AddrPortFrom(ip.Unmap(), uint16(port)))
+	}
+	return addrs
+}
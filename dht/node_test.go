@@ -0,0 +1,172 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+func newTestNode(t *testing.T, routers ...string) *Node {
+	t.Helper()
+	n, err := New(Config{Addr: "127.0.0.1:0", Routers: routers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// waitFor polls cond until it's true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetPeers(t *testing.T) {
+	router := newTestNode(t)
+	var nodes []*Node
+	for i := range 6 {
+		n := newTestNode(t, router.Addr().String())
+		waitFor(t, "a node to bootstrap", func() bool { return n.Len() >= 1 })
+		waitFor(t, "the router to learn the node", func() bool { return router.Len() >= i+1 })
+		nodes = append(nodes, n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	infoHash := [20]byte{1, 2, 3}
+	peers, err := nodes[0].GetPeers(ctx, infoHash, 6000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("got peers %v before anyone announced", peers)
+	}
+
+	peers, err = nodes[5].GetPeers(ctx, infoHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := net.TCPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 6000}
+	if len(peers) != 1 || !peers[0].IP.Equal(want.IP) || peers[0].Port != want.Port {
+		t.Fatalf("got peers %v, want %v", peers, want)
+	}
+}
+
+func TestGetPeersNoNodes(t *testing.T) {
+	n := newTestNode(t)
+	_, err := n.GetPeers(context.Background(), [20]byte{1}, 6000)
+	if err == nil {
+		t.Fatal("got no error without any node to ask")
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	a, b := newTestNode(t), newTestNode(t)
+	a.AddNode(b.Addr().String())
+	waitFor(t, "the nodes to know each other", func() bool { return a.Len() == 1 && b.Len() == 1 })
+	if got := a.table.closest(b.ID(), 1)[0].ID; got != b.ID() {
+		t.Fatalf("got node %x, want %x", got, b.ID())
+	}
+}
+
+// rawQuery sends a query to n from conn and returns the answer
+func rawQuery(t *testing.T, conn *net.UDPConn, n *Node, m message) message {
+	t.Helper()
+	raw, err := bencode.EncodeBytes(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := netip.MustParseAddrPort(n.Addr().String())
+	_, err = conn.WriteToUDPAddrPort(raw, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	size, _, err := conn.ReadFromUDPAddrPort(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer message
+	err = bencode.DecodeBytes(buf[:size], &answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.T != m.T {
+		t.Fatalf("got transaction id %q, want %q", answer.T, m.T)
+	}
+	return answer
+}
+
+func TestHandleQuery(t *testing.T) {
+	n := newTestNode(t)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	id := string(make([]byte, 20))
+	infoHash := "aaaaaaaaaaaaaaaaaaaa"
+
+	errCode := func(m message) int {
+		var kerr *krpcError
+		if m.Y != "e" || !errors.As(decodeError(m.E), &kerr) {
+			return 0
+		}
+		return kerr.Code
+	}
+	tests := []struct {
+		name string
+		m    message
+		code int
+	}{
+		{"missing id", message{Q: queryPing, A: &queryArgs{ID: "short"}}, errProtocol},
+		{"unknown method", message{Q: "vote", A: &queryArgs{ID: id}}, errMethodUnknown},
+		{"invalid target", message{Q: queryFindNode, A: &queryArgs{ID: id, Target: "x"}}, errProtocol},
+		{"bad token", message{Q: queryAnnouncePeer, A: &queryArgs{ID: id, InfoHash: infoHash, Port: 6000, Token: "nope"}}, errProtocol},
+	}
+	for i, tt := range tests {
+		tt.m.T, tt.m.Y = string(rune('a'+i)), "q"
+		if got := errCode(rawQuery(t, conn, n, tt.m)); got != tt.code {
+			t.Errorf("%s: got error %d, want %d", tt.name, got, tt.code)
+		}
+	}
+
+	// read-only nodes aren't added to the routing table
+	answer := rawQuery(t, conn, n, message{T: "ro", Y: "q", Q: queryPing, A: &queryArgs{ID: id}, RO: 1})
+	if got, ok := nodeID(answer.R); !ok || got != n.ID() {
+		t.Fatalf("got ping response %+v", answer.R)
+	}
+	if n.Len() != 0 {
+		t.Fatal("a read-only node was added to the routing table")
+	}
+
+	// a token from get_peers lets the implied port be announced
+	answer = rawQuery(t, conn, n, message{T: "gp", Y: "q", Q: queryGetPeers, A: &queryArgs{ID: id, InfoHash: infoHash}})
+	if answer.R == nil || answer.R.Token == "" {
+		t.Fatalf("got get_peers response %+v without a token", answer.R)
+	}
+	answer = rawQuery(t, conn, n, message{T: "ap", Y: "q", Q: queryAnnouncePeer, A: &queryArgs{
+		ID: id, InfoHash: infoHash, ImpliedPort: 1, Token: answer.R.Token,
+	}})
+	if answer.Y != "r" {
+		t.Fatalf("announce_peer failed with %v", decodeError(answer.E))
+	}
+	answer = rawQuery(t, conn, n, message{T: "gp", Y: "q", Q: queryGetPeers, A: &queryArgs{ID: id, InfoHash: infoHash}})
+	local := netip.MustParseAddrPort(conn.LocalAddr().String())
+	if answer.R == nil || len(answer.R.Values) != 1 || answer.R.Values[0] != encodePeer(local) {
+		t.Fatalf("got get_peers response %+v, want the announced peer %s", answer.R, local)
+	}
+}
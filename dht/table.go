@@ -0,0 +1,143 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// bucketSize is how many nodes a bucket holds, the K of BEP0005. Lookups
+// also stop once the bucketSize closest nodes answered
+const bucketSize = 8
+
+// maxFailures is how many queries in a row a node can fail to answer before
+// it's replaced by a new node
+const maxFailures = 2
+
+type entry struct {
+	contact
+	failures int
+}
+
+// table is the routing table of a node: its buckets hold the nodes sharing
+// the same number of leading bits with its own id, so it knows more nodes
+// close to itself than far away
+type table struct {
+	self [20]byte
+
+	mut     sync.Mutex
+	buckets [160][]*entry
+}
+
+func newTable(self [20]byte) *table {
+	return &table{self: self}
+}
+
+// bucket returns the index of the bucket of id, the length of the prefix it
+// shares with the table's own id
+func (t *table) bucket(id [20]byte) int {
+	for i := range id {
+		if x := id[i] ^ t.self[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(t.buckets) - 1
+}
+
+// seen adds a node that answered or queried us, or marks it as good again.
+// A full bucket only takes it in place of a node that stopped answering
+func (t *table) seen(c contact) {
+	if c.ID == t.self || !c.Addr.IsValid() {
+		return
+	}
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	i := t.bucket(c.ID)
+	for _, e := range t.buckets[i] {
+		if e.ID == c.ID {
+			e.Addr = c.Addr
+			e.failures = 0
+			return
+		}
+	}
+	if len(t.buckets[i]) < bucketSize {
+		t.buckets[i] = append(t.buckets[i], &entry{contact: c})
+		return
+	}
+	for j, e := range t.buckets[i] {
+		if e.failures >= maxFailures {
+			t.buckets[i][j] = &entry{contact: c}
+			return
+		}
+	}
+}
+
+// failed counts a query the node at addr didn't answer
+func (t *table) failed(addr netip.AddrPort) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for _, bucket := range t.buckets {
+		for _, e := range bucket {
+			if e.Addr == addr {
+				e.failures++
+			}
+		}
+	}
+}
+
+// closest returns at most n good nodes closest to target, the closest first
+func (t *table) closest(target [20]byte, n int) []contact {
+	t.mut.Lock()
+	var contacts []contact
+	for _, bucket := range t.buckets {
+		for _, e := range bucket {
+			if e.failures < maxFailures {
+				contacts = append(contacts, e.contact)
+			}
+		}
+	}
+	t.mut.Unlock()
+	sortByDistance(contacts, target)
+	if len(contacts) > n {
+		contacts = contacts[:n]
+	}
+	return contacts
+}
+
+// len returns how many good nodes the table has
+func (t *table) len() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	n := 0
+	for _, bucket := range t.buckets {
+		for _, e := range bucket {
+			if e.failures < maxFailures {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// distance is the XOR metric between two ids
+func distance(a, b [20]byte) [20]byte {
+	var d [20]byte
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// closer reports whether a is closer to target than b
+func closer(a, b, target [20]byte) bool {
+	da, db := distance(a, target), distance(b, target)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+func sortByDistance(contacts []contact, target [20]byte) {
+	sort.Slice(contacts, func(i, j int) bool {
+		return closer(contacts[i].ID, contacts[j].ID, target)
+	})
+}
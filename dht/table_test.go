@@ -0,0 +1,96 @@
+package dht
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func testContact(id byte, port uint16) contact {
+	return contact{
+		ID:   [20]byte{id},
+		Addr: netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), port),
+	}
+}
+
+func TestTableBucket(t *testing.T) {
+	tab := newTable([20]byte{})
+	tests := []struct {
+		id   [20]byte
+		want int
+	}{
+		{[20]byte{0x80}, 0},
+		{[20]byte{0x40}, 1},
+		{[20]byte{0x01}, 7},
+		{[20]byte{0, 0x80}, 8},
+		{[20]byte{19: 1}, 159},
+	}
+	for _, tt := range tests {
+		if got := tab.bucket(tt.id); got != tt.want {
+			t.Errorf("bucket of %x: got %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestTableFullBucket(t *testing.T) {
+	tab := newTable([20]byte{})
+	// 0x80 to 0xff all share no prefix with the zero id, so the same bucket
+	for i := range bucketSize {
+		tab.seen(testContact(0x80+byte(i), 1000+uint16(i)))
+	}
+	tab.seen(testContact(0xf0, 2000))
+	if tab.len() != bucketSize {
+		t.Fatalf("got %d nodes, want a full bucket of %d", tab.len(), bucketSize)
+	}
+	if got := tab.closest([20]byte{0xf0}, 1)[0]; got.ID[0] == 0xf0 {
+		t.Fatal("a full bucket took a new node")
+	}
+
+	// a node that stopped answering is replaced
+	failing := testContact(0x80, 1000)
+	for range maxFailures {
+		tab.failed(failing.Addr)
+	}
+	tab.seen(testContact(0xf0, 2000))
+	if got := tab.closest([20]byte{0xf0}, 1)[0]; got.ID[0] != 0xf0 {
+		t.Fatalf("got closest %x, want the new node", got.ID)
+	}
+	for _, c := range tab.closest([20]byte{0x80}, bucketSize) {
+		if c.ID == failing.ID {
+			t.Fatal("the failing node is still in the table")
+		}
+	}
+
+	// our own id is never added
+	tab.seen(contact{ID: tab.self, Addr: failing.Addr})
+	if tab.len() != bucketSize {
+		t.Fatalf("got %d nodes after adding ourselves", tab.len())
+	}
+}
+
+func TestTableClosest(t *testing.T) {
+	tab := newTable([20]byte{0xff})
+	for _, id := range []byte{0x01, 0x02, 0x03, 0x10, 0x80} {
+		tab.seen(testContact(id, uint16(id)+1000))
+	}
+	got := tab.closest([20]byte{0x03}, 3)
+	want := []byte{0x03, 0x02, 0x01}
+	if len(got) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID[0] != want[i] {
+			t.Errorf("closest %d: got %x, want %x", i, got[i].ID[0], want[i])
+		}
+	}
+}
+
+func TestCompactNodes(t *testing.T) {
+	contacts := []contact{testContact(1, 6881), testContact(2, 51413)}
+	got := decodeNodes(encodeNodes(contacts) + "short")
+	if len(got) != len(contacts) || got[0] != contacts[0] || got[1] != contacts[1] {
+		t.Fatalf("got %v, want %v", got, contacts)
+	}
+	if _, ok := decodePeer("\x7f\x00\x00\x01\x00\x00"); ok {
+		t.Fatal("decoded a peer without a port")
+	}
+}
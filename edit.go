@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// runEdit changes the trackers, web seeds and informational fields of a
+// torrent file without changing its info hash
+func runEdit(args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	out := fs.String("o", "", "path to write the edited torrent to, defaults to editing in place")
+	var trackers, addTrackers, removeTrackers stringsFlag
+	fs.Var(&trackers, "tracker", "replace all trackers, repeat for each tier, comma separate trackers in the same tier")
+	fs.Var(&addTrackers, "add-tracker", "add a tier of comma separated trackers, can be repeated")
+	fs.Var(&removeTrackers, "remove-tracker", "remove a tracker from every tier, can be repeated")
+	clearTrackers := fs.Bool("clear-trackers", false, "remove all trackers before adding any")
+	var webSeeds, addWebSeeds, removeWebSeeds stringsFlag
+	fs.Var(&webSeeds, "webseed", "replace all web seeds (BEP0019), can be repeated")
+	fs.Var(&addWebSeeds, "add-webseed", "add a web seed, can be repeated")
+	fs.Var(&removeWebSeeds, "remove-webseed", "remove a web seed, can be repeated")
+	clearWebSeeds := fs.Bool("clear-webseeds", false, "remove all web seeds before adding any")
+	comment := fs.String("comment", "", "set the comment, empty removes it")
+	createdBy := fs.String("created-by", "", "set `created by`, empty removes it")
+	creationDate := fs.String("creation-date", "", "set `creation date` as RFC3339, \"now\", or empty to remove it")
+	// info dictionary fields, these change the info hash
+	changeInfoHash := fs.Bool("change-info-hash", false, "allow editing info fields, which makes a new torrent with a new info hash")
+	private := fs.Bool("private", false, "set the private flag (BEP0027), changes the info hash")
+	name := fs.String("name", "", "set the torrent name, changes the info hash")
+	source := fs.String("source", "", "set the source tag, empty removes it, changes the info hash")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s edit [flags] <path to .torrent>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one torrent file")
+	}
+	path := fs.Arg(0)
+	if *out == "" {
+		*out = path
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if (set["private"] || set["name"] || set["source"]) && !*changeInfoHash {
+		return errors.New("-private, -name and -source change the info hash, pass -change-info-hash to allow it")
+	}
+
+	editor, err := torrentparser.OpenEditor(path)
+	if err != nil {
+		return fmt.Errorf("reading torrent: %w", err)
+	}
+	oldInfoHash := editor.InfoHash()
+
+	// trackers
+	if *clearTrackers || len(trackers)+len(addTrackers)+len(removeTrackers) != 0 {
+		tiers := editor.Tiers()
+		if *clearTrackers || len(trackers) != 0 {
+			tiers = nil
+		}
+		for _, tier := range append(trackers, addTrackers...) {
+			tiers = append(tiers, splitList(tier))
+		}
+		for i := range tiers {
+			tiers[i] = slices.DeleteFunc(tiers[i], func(tr string) bool {
+				return slices.Contains(removeTrackers, tr)
+			})
+		}
+		err = editor.SetTiers(tiers)
+		if err != nil {
+			return fmt.Errorf("setting trackers: %w", err)
+		}
+	}
+
+	// web seeds
+	if *clearWebSeeds || len(webSeeds)+len(addWebSeeds)+len(removeWebSeeds) != 0 {
+		urls := editor.WebSeeds()
+		if *clearWebSeeds || len(webSeeds) != 0 {
+			urls = nil
+		}
+		for _, u := range append(webSeeds, addWebSeeds...) {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		urls = slices.DeleteFunc(urls, func(u string) bool {
+			return slices.Contains(removeWebSeeds, u)
+		})
+		err = editor.SetWebSeeds(urls)
+		if err != nil {
+			return fmt.Errorf("setting web seeds: %w", err)
+		}
+	}
+
+	// informational fields, an empty value removes the key
+	if set["comment"] {
+		err = setOrDelete(editor, "comment", *comment)
+		if err != nil {
+			return err
+		}
+	}
+	if set["created-by"] {
+		err = setOrDelete(editor, "created by", *createdBy)
+		if err != nil {
+			return err
+		}
+	}
+	if set["creation-date"] {
+		var date time.Time
+		switch *creationDate {
+		case "":
+		case "now":
+			date = time.Now()
+		default:
+			date, err = time.Parse(time.RFC3339, *creationDate)
+			if err != nil {
+				return fmt.Errorf("parsing creation date: %w", err)
+			}
+		}
+		err = editor.SetCreationDate(date)
+		if err != nil {
+			return err
+		}
+	}
+
+	// info dictionary fields
+	if set["private"] {
+		var value any
+		if *private {
+			value = 1
+		}
+		err = editor.SetInfoField("private", value)
+		if err != nil {
+			return err
+		}
+	}
+	if set["name"] {
+		if *name == "" {
+			return errors.New("name can't be empty")
+		}
+		err = editor.SetInfoField("name", *name)
+		if err != nil {
+			return err
+		}
+	}
+	if set["source"] {
+		var value any
+		if *source != "" {
+			value = *source
+		}
+		err = editor.SetInfoField("source", value)
+		if err != nil {
+			return err
+		}
+	}
+
+	raw, err := editor.Bytes()
+	if err != nil {
+		return fmt.Errorf("marshalling torrent: %w", err)
+	}
+	err = os.WriteFile(*out, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("writing torrent: %w", err)
+	}
+
+	// parse the result to make sure the edit left a valid torrent
+	torrent, err := torrentparser.ParseTorrentFile(*out)
+	if err != nil {
+		return fmt.Errorf("parsing edited torrent: %w", err)
+	}
+
+	fmt.Printf("wrote %s\n", *out)
+	if newInfoHash := editor.InfoHash(); newInfoHash != oldInfoHash {
+		fmt.Printf("info hash changed: %x -> %x\n", oldInfoHash, newInfoHash)
+	} else {
+		fmt.Printf("info hash: %x\n", newInfoHash)
+	}
+	if torrent.MetaVersion == 2 {
+		fmt.Printf("info hash v2: %x\n", torrent.InfoHashV2)
+	}
+	return nil
+}
+
+// splitList splits comma separated values, dropping empty ones so "" or a
+// trailing comma doesn't add an empty URL
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// setOrDelete sets a top level string key, or removes it if value is empty
+func setOrDelete(editor *torrentparser.Editor, key, value string) error {
+	if value == "" {
+		editor.Delete(key)
+		return nil
+	}
+	return editor.Set(key, value)
+}
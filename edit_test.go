@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func TestRunEditDropsEmptyURLs(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+	err := os.WriteFile(root, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := torrentparser.Create(root, torrentparser.CreateOptions{
+		AnnounceList: [][]string{{"http://old.example/announce"}},
+		WebSeeds:     []string{"http://old.example/seed/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := torrentparser.ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runEdit([]string{
+		"-tracker", "http://a.example/announce,,udp://b.example:6969,",
+		"-tracker", ",",
+		"-add-tracker", "",
+		"-webseed", "",
+		"-add-webseed", " http://c.example/seed/ ",
+		"-comment", "edited",
+		path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := torrentparser.ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[[http://a.example/announce udp://b.example:6969]]"; fmt.Sprint(after.Tiers) != want {
+		t.Errorf("tiers %q, want %s", after.Tiers, want)
+	}
+	if want := "[http://c.example/seed/]"; fmt.Sprint(after.WebSeeds) != want {
+		t.Errorf("web seeds %q, want %s", after.WebSeeds, want)
+	}
+	if after.Comment != "edited" || !bytes.Equal(after.Metadata, before.Metadata) {
+		t.Errorf("comment %q, info dict changed: %t", after.Comment, !bytes.Equal(after.Metadata, before.Metadata))
+	}
+}
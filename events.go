@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/tracker"
+)
+
+// printEvents prints what happens to a download from its events until events
+// is closed, progress itself is shown by showProgress. done is closed once a
+// DownloadFinished event or every event has been printed
+func printEvents(events <-chan bittorrent.Event, con *console) (done <-chan struct{}) {
+	finished := make(chan struct{})
+	go func() {
+		var once sync.Once
+		defer once.Do(func() { close(finished) })
+
+		// sources currently used to download
+		active := map[string]bool{}
+		for ev := range events {
+			switch ev := ev.(type) {
+			case bittorrent.TrackerAnnounced:
+				switch {
+				case ev.Err != nil && (ev.Event == tracker.EventStarted || ev.Event == tracker.EventNone):
+					con.Printf("failed to get peers from tracker %s: %s\n", ev.URL, ev.Err)
+				case ev.Err != nil:
+					con.Printf("failed to announce %s to tracker %s: %s\n", ev.Event, ev.URL, ev.Err)
+				case ev.Event == tracker.EventStarted || ev.Event == tracker.EventNone:
+					con.Printf("peers from %s: %d\n", ev.URL, ev.Peers)
+				}
+			case bittorrent.PeerConnected:
+				active[ev.Addr] = true
+				con.Printf("connected to %s %s, %d sources\n", ev.Kind, ev.Addr, len(active))
+			case bittorrent.PeerDisconnected:
+				wasActive := active[ev.Addr]
+				delete(active, ev.Addr)
+				switch {
+				case !wasActive && ev.Reason != nil:
+					con.Printf("failed connecting to %s at %s: %s\n", ev.Kind, ev.Addr, ev.Reason)
+				case ev.Reason != nil:
+					con.Printf("disconnecting from %s after error: %s\n", ev.Addr, ev.Reason)
+				}
+			case bittorrent.MetadataReceived:
+				con.Printf("got metadata from %s\n", ev.Source)
+			case bittorrent.PieceFailed:
+				con.Printf("failed to get piece #%d from %s: %s\n", ev.Index+1, ev.Source, ev.Err)
+			case bittorrent.FileCompleted:
+				con.Printf("wrote %d bytes to %s\n", ev.Length, ev.Path)
+			case bittorrent.Warning:
+				con.Println(ev.Err)
+			case bittorrent.DownloadFinished:
+				once.Do(func() { close(finished) })
+			}
+		}
+	}()
+	return finished
+}
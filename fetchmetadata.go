@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+)
+
+// runFetchMetadata resolves a magnet link into a .torrent file and exits
+// without downloading any pieces
+func runFetchMetadata(args []string) error {
+	fs := flag.NewFlagSet("fetch-metadata", flag.ExitOnError)
+	out := fs.String("o", "", "path to write the torrent file to, defaults to <name>.torrent")
+	cacheDir := fs.String("metadata-cache", bittorrent.DefaultMetadataCacheDir(), "directory to cache magnet link metadata in, empty disables it")
+	var logs logFlags
+	logs.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s fetch-metadata [flags] <magnet link>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one magnet link")
+	}
+
+	logger, err := logs.logger()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	events := bittorrent.NewEvents()
+	sub, unsubscribe := events.Subscribe()
+	printed := printEvents(sub, newConsole(os.Stdout))
+	torrent, err := bittorrent.FetchMetadataContext(ctx, fs.Arg(0), bittorrent.Options{
+		MetadataCacheDir: *cacheDir,
+		Events:           events,
+		Logger:           logger,
+	})
+	unsubscribe()
+	<-printed
+	if err != nil {
+		return err
+	}
+
+	raw, err := torrent.MarshalTorrent()
+	if err != nil {
+		return err
+	}
+
+	outPath := *out
+	if outPath == "" {
+		outPath = filepath.Base(torrent.Name) + ".torrent"
+	}
+	err = os.WriteFile(outPath, raw, 0644)
+	if err != nil {
+		return fmt.Errorf("writing torrent: %w", err)
+	}
+
+	fmt.Printf("wrote %s\n", outPath)
+	fmt.Printf("info hash: %x\n", torrent.InfoHash)
+	return nil
+}
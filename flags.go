@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// stringsFlag collects every occurrence of a repeated string flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// rateFlag is a rate in bytes per second, written as a number of bytes with
+// an optional binary unit like 500K or 1.5M. 0 is unlimited
+type rateFlag int64
+
+func (r *rateFlag) String() string {
+	if *r == 0 {
+		return "0"
+	}
+	return formatBytes(int(*r)) + "/s"
+}
+
+func (r *rateFlag) Set(value string) error {
+	rate, err := parseRate(value)
+	if err != nil {
+		return err
+	}
+	*r = rateFlag(rate)
+	return nil
+}
+
+// UnmarshalJSON accepts a number of bytes or a string like the flag
+func (r *rateFlag) UnmarshalJSON(b []byte) error {
+	var rate int64
+	if json.Unmarshal(b, &rate) == nil {
+		*r = rateFlag(rate)
+		return nil
+	}
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("invalid rate %s, expected bytes per second like 500K or 2M", b)
+	}
+	return r.Set(s)
+}
+
+// parseRate parses a rate of rateFlag
+func parseRate(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "/S"), "IB")
+	number = strings.TrimSuffix(number, "B")
+	scale := 1.0
+	if i := strings.IndexAny(number, "KMG"); i >= 0 && i == len(number)-1 {
+		scale = map[byte]float64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}[number[i]]
+		number = number[:i]
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected bytes per second like 500K or 2M", s)
+	}
+	return int64(f * scale), nil
+}
+
+// logFlags are the flags configuring the logs of the download and swarm,
+// written to stderr so they don't mix with the progress output
+type logFlags struct {
+	level string
+	json  bool
+}
+
+// register adds the log flags to a flag set
+func (l *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&l.level, "log-level", "off", "log level written to stderr: debug, info, warn, error or off")
+	fs.BoolVar(&l.json, "log-json", false, "write logs as JSON lines instead of text")
+}
+
+// logger returns the logger the flags describe, nil when logs are off
+func (l *logFlags) logger() (*slog.Logger, error) {
+	if l.level == "off" {
+		return nil, nil
+	}
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -log-level %q", l.level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if l.json {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+}
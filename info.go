@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// torrentInfo is the JSON representation of a parsed torrent, hashes are hex
+// encoded and fields that a magnet link doesn't have are omitted
+type torrentInfo struct {
+	Name         string     `json:"name"`
+	InfoHash     string     `json:"info_hash"`
+	InfoHashV2   string     `json:"info_hash_v2,omitempty"`
+	MetaVersion  int        `json:"meta_version,omitempty"`
+	Length       int        `json:"length,omitempty"`
+	PieceCount   int        `json:"piece_count,omitempty"`
+	PieceLength  int        `json:"piece_length,omitempty"`
+	Tiers        [][]string `json:"tiers"`
+	Files        []fileInfo `json:"files,omitempty"`
+	WebSeeds     []string   `json:"url_list,omitempty"`
+	HTTPSeeds    []string   `json:"httpseeds,omitempty"`
+	Nodes        []string   `json:"nodes,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	CreationDate *time.Time `json:"creation_date,omitempty"`
+	Private      bool       `json:"private"`
+	Source       string     `json:"source,omitempty"`
+	Magnet       string     `json:"magnet"`
+}
+
+type fileInfo struct {
+	Path        string `json:"path"`
+	Length      int    `json:"length"`
+	SHA1        string `json:"sha1,omitempty"`
+	MD5         string `json:"md5,omitempty"`
+	PiecesRoot  string `json:"pieces_root,omitempty"`
+	Attr        string `json:"attr,omitempty"`
+	SymlinkPath string `json:"symlink_path,omitempty"`
+}
+
+// runInfo prints the contents of a torrent file or magnet link without
+// contacting any peers or trackers
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s info [flags] <path to .torrent or magnet link>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one torrent file or magnet link")
+	}
+
+	torrent, err := torrentparser.New(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("parsing torrent: %w", err)
+	}
+	info := newTorrentInfo(torrent)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(info)
+	}
+	printTorrentInfo(info)
+	return nil
+}
+
+func newTorrentInfo(t torrentparser.TorrentFile) torrentInfo {
+	info := torrentInfo{
+		Name:        t.Name,
+		InfoHash:    hex.EncodeToString(t.InfoHash[:]),
+		MetaVersion: t.MetaVersion,
+		Length:      t.Length,
+		PieceCount:  len(t.PieceHashes),
+		PieceLength: t.PieceLength,
+		Tiers:       t.Tiers,
+		WebSeeds:    t.WebSeeds,
+		HTTPSeeds:   t.HTTPSeeds,
+		Nodes:       t.Nodes,
+		Comment:     t.Comment,
+		CreatedBy:   t.CreatedBy,
+		Private:     t.Private,
+		Source:      t.Source,
+		Magnet:      t.MagnetLink(),
+	}
+	if t.InfoHashV2 != [32]byte{} {
+		info.InfoHashV2 = hex.EncodeToString(t.InfoHashV2[:])
+	}
+	if !t.CreationDate.IsZero() {
+		info.CreationDate = &t.CreationDate
+	}
+
+	for _, f := range t.Files {
+		fi := fileInfo{
+			Path:        f.Path,
+			Length:      f.Length,
+			SymlinkPath: f.SymlinkPath,
+		}
+		// per file hashes are raw bytes in the torrent, but some torrents
+		// have them hex encoded already
+		fi.SHA1 = hashString(f.SHA1Hash, 20)
+		fi.MD5 = hashString(f.MD5Hash, 16)
+		if f.PiecesRoot != [32]byte{} {
+			fi.PiecesRoot = hex.EncodeToString(f.PiecesRoot[:])
+		}
+		if f.Padding {
+			fi.Attr += "p"
+		}
+		if f.Executable {
+			fi.Attr += "x"
+		}
+		if f.Hidden {
+			fi.Attr += "h"
+		}
+		if f.SymlinkPath != "" {
+			fi.Attr += "l"
+		}
+		info.Files = append(info.Files, fi)
+	}
+
+	return info
+}
+
+// hashString hex encodes a raw hash of size bytes, anything else is returned as is
+func hashString(hash string, size int) string {
+	if len(hash) == size {
+		return hex.EncodeToString([]byte(hash))
+	}
+	return hash
+}
+
+func printTorrentInfo(info torrentInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", info.Name)
+	fmt.Fprintf(w, "info hash:\t%s\n", info.InfoHash)
+	if info.InfoHashV2 != "" {
+		fmt.Fprintf(w, "info hash v2:\t%s\n", info.InfoHashV2)
+	}
+	if info.MetaVersion != 0 {
+		fmt.Fprintf(w, "meta version:\t%d\n", info.MetaVersion)
+	}
+	if info.Length != 0 {
+		fmt.Fprintf(w, "size:\t%s (%d bytes)\n", formatBytes(info.Length), info.Length)
+	}
+	if info.PieceLength != 0 {
+		fmt.Fprintf(w, "pieces:\t%d x %s\n", info.PieceCount, formatBytes(info.PieceLength))
+	}
+	fmt.Fprintf(w, "private:\t%t\n", info.Private)
+	if info.Source != "" {
+		fmt.Fprintf(w, "source:\t%s\n", info.Source)
+	}
+	if info.Comment != "" {
+		fmt.Fprintf(w, "comment:\t%s\n", info.Comment)
+	}
+	if info.CreatedBy != "" {
+		fmt.Fprintf(w, "created by:\t%s\n", info.CreatedBy)
+	}
+	if info.CreationDate != nil {
+		fmt.Fprintf(w, "creation date:\t%s\n", info.CreationDate.Format(time.RFC3339))
+	}
+	for i, tier := range info.Tiers {
+		fmt.Fprintf(w, "tier %d:\t%s\n", i, strings.Join(tier, " "))
+	}
+	for _, ws := range info.WebSeeds {
+		fmt.Fprintf(w, "url-list:\t%s\n", ws)
+	}
+	for _, hs := range info.HTTPSeeds {
+		fmt.Fprintf(w, "httpseeds:\t%s\n", hs)
+	}
+	for _, node := range info.Nodes {
+		fmt.Fprintf(w, "node:\t%s\n", node)
+	}
+	fmt.Fprintf(w, "magnet:\t%s\n", info.Magnet)
+	w.Flush()
+
+	if len(info.Files) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tATTR\tPATH\tHASHES")
+	for _, f := range info.Files {
+		var hashes []string
+		if f.SHA1 != "" {
+			hashes = append(hashes, "sha1:"+f.SHA1)
+		}
+		if f.MD5 != "" {
+			hashes = append(hashes, "md5:"+f.MD5)
+		}
+		if f.PiecesRoot != "" {
+			hashes = append(hashes, "root:"+f.PiecesRoot)
+		}
+		path := f.Path
+		if f.SymlinkPath != "" {
+			path += " -> " + f.SymlinkPath
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatBytes(f.Length), f.Attr, path, strings.Join(hashes, " "))
+	}
+	w.Flush()
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,40 @@
+// Package logging holds what the packages of the client share to log through
+// log/slog, so their records can be filtered by the same attributes
+package logging
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+)
+
+// attribute keys used by every package
+const (
+	InfoHash = "infohash" // hex info hash of the torrent
+	Peer     = "peer"     // peer address or seed URL
+	Client   = "client"   // client name a peer sent in its extended handshake
+	Tracker  = "tracker"  // tracker URL
+	Piece    = "piece"    // piece index
+)
+
+// HashAttr returns the info hash attribute of a torrent
+func HashAttr(infoHash [20]byte) slog.Attr {
+	return slog.String(InfoHash, hex.EncodeToString(infoHash[:]))
+}
+
+// OrDiscard returns l, or a logger that discards every record when l is nil
+func OrDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discard
+	}
+	return l
+}
+
+var discard = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
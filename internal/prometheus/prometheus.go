@@ -0,0 +1,146 @@
+// Package prometheus writes metrics in the Prometheus text exposition format,
+// without depending on the Prometheus client libraries
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types of a family
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// Writer writes metric families, the first error is kept and returned by
+// Flush
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family starts a family of samples with its HELP and TYPE lines
+func (w *Writer) Family(name, typ, help string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a sample, labels are pairs of names and values
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	writeLabels(w.w, labels)
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+// Histogram writes the buckets, sum and count of a histogram
+func (w *Writer) Histogram(name string, h *HistogramValue, labels ...string) {
+	bounds, counts, sum, count := h.snapshot()
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		w.Sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", formatFloat(bound))...)
+	}
+	w.Sample(name+"_bucket", float64(count), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	w.Sample(name+"_sum", sum, labels...)
+	w.Sample(name+"_count", float64(count), labels...)
+}
+
+// Flush writes what is buffered and returns the first error
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func writeLabels(w *bufio.Writer, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[i])
+		w.WriteString(`="`)
+		w.WriteString(escapeLabel(labels[i+1]))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	case f == math.Trunc(f) && math.Abs(f) < 1e15:
+		// counts of bytes read better without an exponent
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// HistogramValue counts observations into buckets with upper bounds, it's safe
+// for concurrent use
+type HistogramValue struct {
+	mut    sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a histogram with buckets of these upper bounds, the
+// +Inf bucket is implied
+func NewHistogram(bounds ...float64) *HistogramValue {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &HistogramValue{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+// Observe adds an observation
+func (h *HistogramValue) Observe(v float64) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *HistogramValue) snapshot() (bounds []float64, counts []uint64, sum float64, count uint64) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return h.bounds, append([]uint64(nil), h.counts...), h.sum, h.count
+}
@@ -0,0 +1,107 @@
+package prometheus
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, f func(w *Writer)) string {
+	t.Helper()
+	var b strings.Builder
+	w := NewWriter(&b)
+	f(w)
+	err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFamilyEscapesHelp(t *testing.T) {
+	got := write(t, func(w *Writer) {
+		w.Family("x_total", Counter, `bytes of "a" \ b`+"\nsecond line")
+	})
+	want := `# HELP x_total bytes of "a" \\ b\nsecond line` + "\n" +
+		"# TYPE x_total counter\n"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSampleLabels(t *testing.T) {
+	got := write(t, func(w *Writer) {
+		w.Sample("plain", 1)
+		w.Sample("labeled", 2, "a", "x", "b", "y")
+		w.Sample("escaped", 3, "name", `say "hi"\`+"\n")
+	})
+	want := "plain 1\n" +
+		`labeled{a="x",b="y"} 2` + "\n" +
+		`escaped{name="say \"hi\"\\\n"} 3` + "\n"
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{0, "0"},
+		{-3, "-3"},
+		{1 << 40, "1099511627776"},
+		{0.25, "0.25"},
+		{1e20, "1e+20"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.f); got != tt.want {
+			t.Errorf("formatFloat(%v) is %s, want %s", tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	// bounds are sorted, and an observation on a bound counts in its bucket
+	h := NewHistogram(1, 0.1, 0.5)
+	for _, v := range []float64{0.1, 0.3, 0.05, 2, 100} {
+		h.Observe(v)
+	}
+	labels := []string{"tracker", "udp://t"}
+	got := write(t, func(w *Writer) {
+		w.Family("took_seconds", Histogram, "How long it took.")
+		w.Histogram("took_seconds", h, labels...)
+	})
+	want := `# HELP took_seconds How long it took.
+# TYPE took_seconds histogram
+took_seconds_bucket{tracker="udp://t",le="0.1"} 2
+took_seconds_bucket{tracker="udp://t",le="0.5"} 3
+took_seconds_bucket{tracker="udp://t",le="1"} 3
+took_seconds_bucket{tracker="udp://t",le="+Inf"} 5
+took_seconds_sum{tracker="udp://t"} 102.45
+took_seconds_count{tracker="udp://t"} 5
+`
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	if len(labels) != 2 {
+		t.Fatalf("labels were changed to %q", labels)
+	}
+}
+
+func TestEmptyHistogram(t *testing.T) {
+	got := write(t, func(w *Writer) {
+		w.Histogram("h", NewHistogram(1))
+	})
+	want := `h_bucket{le="1"} 0
+h_bucket{le="+Inf"} 0
+h_sum 0
+h_count 0
+`
+	if got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,27 +1,146 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/givxl33t/bittorrent-client-go/bittorrent"
 )
 
+// commands are the subcommands available in addition to the default download,
+// each one parses its own flags from the remaining arguments
+var commands = map[string]func(args []string) error{
+	"create":         runCreate,
+	"daemon":         runDaemon,
+	"edit":           runEdit,
+	"fetch-metadata": runFetchMetadata,
+	"info":           runInfo,
+	"remote":         runRemote,
+	"verify":         runVerify,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	source := flag.String("source", "", "path to torrent file or magnet link")
 	outDir := flag.String("out", "./", "path to output directory")
+	cacheDir := flag.String("metadata-cache", bittorrent.DefaultMetadataCacheDir(), "directory to cache magnet link metadata in, empty disables it")
+	var files stringsFlag
+	flag.Var(&files, "file", "only download files matching a glob or with this index, can be repeated")
+	var high, low stringsFlag
+	flag.Var(&high, "high", "download files matching a glob or with this index first, can be repeated")
+	flag.Var(&low, "low", "download files matching a glob or with this index last, can be repeated")
+	sequential := flag.Bool("sequential", false, "download pieces in order so files can be used while downloading")
+	maxPeers := flag.Int("max-peers", 50, "peers to connect to at most")
+	maxHalfOpen := flag.Int("max-half-open", 8, "peers to dial at once")
+	var downloadLimit rateFlag
+	flag.Var(&downloadLimit, "download-limit", "bytes per second to download at most, like 2M, 0 is unlimited")
+	serveAddr := flag.String("serve", "", "serve the torrent's files over HTTP on this address while downloading, e.g. localhost:8080")
+	var logs logFlags
+	logs.register(flag.CommandLine)
 	flag.Parse()
 
 	if *source == "" {
 		panic("source flag is required")
 	}
+	logger, err := logs.logger()
+	if err != nil {
+		panic(err.Error())
+	}
 
-	d, err := bittorrent.NewDownload(*source)
+	ctx, stop := signalContext()
+	defer stop()
+
+	// what happens is printed from the events of the download, and progress
+	// from its stats once it runs
+	con := newConsole(os.Stdout)
+	events := bittorrent.NewEvents()
+	sub, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	printed := printEvents(sub, con)
+
+	d, err := bittorrent.NewDownloadContext(ctx, *source, bittorrent.Options{
+		MetadataCacheDir: *cacheDir,
+		Events:           events,
+		Logger:           logger,
+		MaxPeers:         *maxPeers,
+		MaxHalfOpen:      *maxHalfOpen,
+	})
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("interrupted")
+		os.Exit(1)
+	}
 	if err != nil {
 		panic("starting download: " + err.Error())
 	}
 
-	err = d.Run(*outDir)
+	err = d.SelectFiles(files...)
+	if err != nil {
+		panic("selecting files: " + err.Error())
+	}
+	err = d.SetPriority(bittorrent.PriorityHigh, high...)
+	if err != nil {
+		panic("setting priorities: " + err.Error())
+	}
+	err = d.SetPriority(bittorrent.PriorityLow, low...)
+	if err != nil {
+		panic("setting priorities: " + err.Error())
+	}
+	d.SetSequential(*sequential)
+	d.SetDownloadLimit(int64(downloadLimit))
+
+	if *serveAddr != "" {
+		ln, err := net.Listen("tcp", *serveAddr)
+		if err != nil {
+			panic("starting server: " + err.Error())
+		}
+		con.Printf("serving files on http://%s/\n", ln.Addr())
+		go http.Serve(ln, newStreamServer(d))
+	}
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	shown := showProgress(progressCtx, con, d)
+	err = d.RunContext(ctx, *outDir)
+	stopProgress()
+	<-shown
+	// the channel closes once the queued events are printed
+	unsubscribe()
+	<-printed
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("download stopped, downloaded pieces are kept for the next run")
+		os.Exit(1)
+	}
 	if err != nil {
 		panic("running download: " + err.Error())
 	}
+
+	// keep serving the finished files until interrupted
+	if *serveAddr != "" {
+		fmt.Println("download finished, still serving files, press Ctrl-C to stop")
+		<-ctx.Done()
+	}
+}
+
+// signalContext returns a context canceled by SIGINT or SIGTERM, after the
+// first signal a second one kills the process as usual
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
 }
@@ -1,5 +1,7 @@
 package peer
 
+import "math/bits"
+
 // bitfield communicates which pieces a peer has and can send us
 type bitfield []byte
 
@@ -26,3 +28,12 @@ func (b bitfield) SetPiece(index int) {
 	mask := 1 << (7 - offset)
 	b[byteIndex] |= byte(mask)
 }
+
+// Count returns how many pieces are set
+func (b bitfield) Count() int {
+	var n int
+	for _, x := range b {
+		n += bits.OnesCount8(x)
+	}
+	return n
+}
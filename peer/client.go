@@ -2,12 +2,16 @@ package peer
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
 )
 
 type Client struct {
@@ -23,11 +27,39 @@ type Client struct {
 		messageID    int
 		metadataSize int
 	}
+	ClientName string       // `v` of the extended handshake (BEP0010), e.g. "qBittorrent/4.6.0"
+	Logger     *slog.Logger // logs protocol messages at debug level, with the peer's address and client name
+
+	// DownloadLimiter paces the piece messages received from the peer, other
+	// messages are never held back. nil is unlimited
+	DownloadLimiter Limiter
+}
+
+// Limiter paces the piece data exchanged with peers
+type Limiter interface {
+	// Wait takes n bytes from the budget and blocks until it isn't exceeded
+	Wait(n int)
 }
 
 func NewClient(addr net.TCPAddr, infoHash, peerID [20]byte) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", addr.String(), 3*time.Second)
+	return NewClientContext(context.Background(), addr, infoHash, peerID)
+}
+
+// NewClientContext is NewClient that gives up on the connection and handshake
+// when ctx is canceled
+func NewClientContext(ctx context.Context, addr net.TCPAddr, infoHash, peerID [20]byte) (*Client, error) {
+	return NewClientWithLogger(ctx, addr, infoHash, peerID, nil)
+}
+
+// NewClientWithLogger is NewClientContext that logs the connection to logger,
+// nil discards the logs
+func NewClientWithLogger(ctx context.Context, addr net.TCPAddr, infoHash, peerID [20]byte, logger *slog.Logger) (*Client, error) {
+	logger = logging.OrDiscard(logger).With(logging.Peer, addr.String())
+
+	dialer := net.Dialer{Timeout: 3 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
 	if err != nil {
+		logger.Debug("dialing peer failed", "err", err)
 		return nil, fmt.Errorf("dialing peer: %w", err)
 	}
 
@@ -35,43 +67,74 @@ func NewClient(addr net.TCPAddr, infoHash, peerID [20]byte) (*Client, error) {
 		Conn:    conn,
 		Address: addr,
 		Choked:  true,
+		Logger:  logger,
+	}
+
+	stop := client.watch(ctx)
+	defer stop()
+	err = client.setup(infoHash, peerID)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		client.Logger.Debug("peer handshake failed", "err", err)
+		return nil, err
 	}
+	client.Logger.Debug("connected to peer",
+		"extensions", client.ExtensionSupport,
+		"dht", client.DHTSupport,
+		"pieces", client.Bitfield.Count(),
+	)
+	return client, nil
+}
 
-	client.Conn.SetDeadline(time.Now().Add(3 * time.Second))
-	defer client.Conn.SetDeadline(time.Time{})
+// setup runs the handshakes after dialing a peer
+func (p *Client) setup(infoHash, peerID [20]byte) error {
+	p.Conn.SetDeadline(time.Now().Add(3 * time.Second))
+	defer p.Conn.SetDeadline(time.Time{})
 
-	err = client.handshake(infoHash, peerID)
+	err := p.handshake(infoHash, peerID)
 	if err != nil {
-		return nil, fmt.Errorf("sending handshake: %w", err)
+		return fmt.Errorf("sending handshake: %w", err)
 	}
+	return p.afterHandshake()
+}
 
-	if client.ExtensionSupport {
-		client.Conn.SetDeadline(time.Now().Add(3 * time.Second))
+// afterHandshake receives the extended handshake and bitfield of a peer and
+// gets it ready for requests
+func (p *Client) afterHandshake() error {
+	var err error
+	if p.ExtensionSupport {
+		p.Conn.SetDeadline(time.Now().Add(3 * time.Second))
 		// receive extension message
-		err = client.receiveExtendedHandshake()
+		err = p.receiveExtendedHandshake()
 		if err != nil {
-			return nil, fmt.Errorf("receiving extension handshake: %w", err)
+			return fmt.Errorf("receiving extension handshake: %w", err)
+		}
+		if p.ClientName != "" {
+			p.Logger = p.log().With(logging.Client, p.ClientName)
 		}
 	}
 
 	// receive bitfield message IF it hasn't been received already
-	if len(client.Bitfield) == 0 {
-		client.Conn.SetDeadline(time.Now().Add(3 * time.Second))
-		_, err = client.receiveMessage()
+	if len(p.Bitfield) == 0 {
+		p.Conn.SetDeadline(time.Now().Add(3 * time.Second))
+		_, err = p.receiveMessage()
 		if err != nil {
-			return nil, fmt.Errorf("receiving bitfield: %w", err)
+			return fmt.Errorf("receiving bitfield: %w", err)
 		}
-		if len(client.Bitfield) == 0 {
-			return nil, fmt.Errorf("received empty bitfield")
+		if len(p.Bitfield) == 0 {
+			return fmt.Errorf("received empty bitfield")
 		}
 	}
 
-	if client.DHTSupport {
-		client.Conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if p.DHTSupport {
+		p.Conn.SetDeadline(time.Now().Add(5 * time.Second))
 		// allow 50 retries to account for client that sends other messages first
 		// loop will likely exit successfullyy or an i/o timeout on receiveMessage()
-		for i := 0; i < 50 && client.DHTPort == 0; i++ {
-			_, err := client.receiveMessage()
+		for i := 0; i < 50 && p.DHTPort == 0; i++ {
+			_, err := p.receiveMessage()
 			if err != nil {
 				// this shouldn't invalidate the peer connection, just break
 				break
@@ -79,18 +142,74 @@ func NewClient(addr net.TCPAddr, infoHash, peerID [20]byte) (*Client, error) {
 		}
 	}
 
-	client.Conn.SetDeadline(time.Now().Add(3 * time.Second))
+	p.Conn.SetDeadline(time.Now().Add(3 * time.Second))
 	// send unchoke and interested message so the peer is ready for requests
-	err = client.sendMessage(msgUnchoke, nil)
+	err = p.sendMessage(msgUnchoke, nil)
 	if err != nil {
-		return nil, fmt.Errorf("sending unchoke: %w", err)
+		return fmt.Errorf("sending unchoke: %w", err)
 	}
-	err = client.sendMessage(msgInterested, nil)
+	err = p.sendMessage(msgInterested, nil)
 	if err != nil {
-		return nil, fmt.Errorf("sending interested: %w", err)
+		return fmt.Errorf("sending interested: %w", err)
 	}
 
-	return client, nil
+	return nil
+}
+
+// Accept runs the handshakes of an inbound connection, the info hash the peer
+// asks for is passed to accept which refuses it by returning false. The
+// connection is closed on error
+func Accept(ctx context.Context, conn net.Conn, peerID [20]byte, accept func(infoHash [20]byte) bool, logger *slog.Logger) (*Client, [20]byte, error) {
+	client := &Client{
+		Conn:   conn,
+		Choked: true,
+		Logger: logging.OrDiscard(logger).With(logging.Peer, conn.RemoteAddr().String()),
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		client.Address = *addr
+	}
+
+	stop := client.watch(ctx)
+	defer stop()
+	infoHash, err := client.acceptSetup(peerID, accept)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, infoHash, ctx.Err()
+		}
+		client.Logger.Debug("inbound peer handshake failed", "err", err)
+		return nil, infoHash, err
+	}
+	client.Logger.Debug("accepted peer",
+		logging.HashAttr(infoHash),
+		"extensions", client.ExtensionSupport,
+		"dht", client.DHTSupport,
+		"pieces", client.Bitfield.Count(),
+	)
+	return client, infoHash, nil
+}
+
+// ErrUnknownTorrent is returned by Accept when the info hash is refused
+var ErrUnknownTorrent = errors.New("peer asked for an unknown torrent")
+
+// acceptSetup runs the handshakes of an inbound connection, the peer sends
+// its handshake first
+func (p *Client) acceptSetup(peerID [20]byte, accept func(infoHash [20]byte) bool) ([20]byte, error) {
+	p.Conn.SetDeadline(time.Now().Add(3 * time.Second))
+	defer p.Conn.SetDeadline(time.Time{})
+
+	infoHash, err := p.readHandshake()
+	if err != nil {
+		return infoHash, fmt.Errorf("receiving handshake: %w", err)
+	}
+	if !accept(infoHash) {
+		return infoHash, fmt.Errorf("%w: %x", ErrUnknownTorrent, infoHash)
+	}
+	err = p.writeHandshake(infoHash, peerID)
+	if err != nil {
+		return infoHash, fmt.Errorf("sending handshake: %w", err)
+	}
+	return infoHash, p.afterHandshake()
 }
 
 // Address returns the address of the peer
@@ -98,19 +217,51 @@ func (p *Client) Addr() net.Addr {
 	return p.Conn.RemoteAddr()
 }
 
+func (p *Client) String() string {
+	return p.Addr().String()
+}
+
 func (p *Client) Close() error {
 	return p.Conn.Close()
 }
 
+// log returns the logger of the client, which discards logs if unset
+func (p *Client) log() *slog.Logger {
+	return logging.OrDiscard(p.Logger)
+}
+
+// watch closes the connection if ctx is canceled before stop is called, which
+// interrupts any read or write in progress. A canceled connection can't be
+// used anymore
+func (p *Client) watch(ctx context.Context) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { p.Conn.Close() })
+}
+
+// GetPieceContext is GetPiece that closes the connection and returns ctx's
+// error when ctx is canceled
+func (p *Client) GetPieceContext(ctx context.Context, index, length int, hash [20]byte) ([]byte, error) {
+	stop := p.watch(ctx)
+	defer stop()
+	pieceBuf, err := p.GetPiece(index, length, hash)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return pieceBuf, err
+}
+
 var ErrNotInBitfield = errors.New("client does not have piece")
 
+// ErrIntegrity is returned for pieces that don't match their hash
+var ErrIntegrity = errors.New("failed integrity check")
+
 func (p *Client) GetPiece(index, length int, hash [20]byte) ([]byte, error) {
 	if !p.Bitfield.HasPiece(index) {
 		return nil, ErrNotInBitfield
 	}
 
-	// set deadline to handle stuck peer
-	p.Conn.SetDeadline(time.Now().Add(15 * time.Second))
+	// set deadline to handle stuck peer, renewed for every message so a slow
+	// or rate limited peer that keeps sending isn't dropped
+	const stuckTimeout = 15 * time.Second
 	defer p.Conn.SetDeadline(time.Time{})
 
 	const maxBlockSize = 16384 // 16KiB
@@ -119,6 +270,9 @@ func (p *Client) GetPiece(index, length int, hash [20]byte) ([]byte, error) {
 	var requested, received, backlog int
 	pieceBuf := make([]byte, length)
 	for received < length {
+		// renewed before requesting too, waiting on the download limiter
+		// may have taken longer than the deadline
+		p.Conn.SetDeadline(time.Now().Add(stuckTimeout))
 		for !p.Choked && backlog < maxBacklog && requested < length {
 			payload := make([]byte, 12)
 			binary.BigEndian.PutUint32(payload[0:4], uint32(index))
@@ -175,14 +329,16 @@ func (p *Client) GetPiece(index, length int, hash [20]byte) ([]byte, error) {
 	// check integrity
 	pieceHash := sha1.Sum(pieceBuf)
 	if !bytes.Equal(pieceHash[:], hash[:]) {
+		p.log().Debug("piece failed integrity check", logging.Piece, index)
 		// disconnect from peer if hash doesn't match
-		return nil, fmt.Errorf("failed integrity check from %s", p.Conn.RemoteAddr())
+		return nil, fmt.Errorf("%w from %s", ErrIntegrity, p.Conn.RemoteAddr())
 	}
 
 	// inform peer we have received the piece
 	havePayload := make([]byte, 4)
 	binary.BigEndian.PutUint32(havePayload, uint32(index))
 	p.sendMessage(msgHave, havePayload)
+	p.log().Debug("received piece", logging.Piece, index)
 
 	return pieceBuf, nil
 }
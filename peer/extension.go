@@ -13,7 +13,8 @@ type extendedHandshake struct {
 		// value doubles as the extended message ID for metadata requests
 		Metadata int `bencode:"ut_metadata"`
 	} `bencode:"m"`
-	MetadataSize int `bencode:"metadata_size"`
+	MetadataSize int    `bencode:"metadata_size"`
+	Version      string `bencode:"v"`
 }
 
 func (p *Client) receiveExtendedHandshake() error {
@@ -53,6 +54,7 @@ func (p *Client) receiveExtendedHandshake() error {
 
 	p.ExtensionMetadata.messageID = extendedResp.M.Metadata
 	p.ExtensionMetadata.metadataSize = extendedResp.MetadataSize
+	p.ClientName = extendedResp.Version
 
 	return nil
 }
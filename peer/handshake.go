@@ -6,9 +6,28 @@ import (
 	"io"
 )
 
+const protocol = "BitTorrent protocol"
+
 // handshake completes the entire handshake process with the underlying peer
 func (p *Client) handshake(infoHash, peerId [20]byte) error {
-	const protocol = "BitTorrent protocol"
+	err := p.writeHandshake(infoHash, peerId)
+	if err != nil {
+		return err
+	}
+
+	responseInfoHash, err := p.readHandshake()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(responseInfoHash[:], infoHash[:]) {
+		return fmt.Errorf("invalid info hash: %x", responseInfoHash)
+	}
+
+	return nil
+}
+
+// writeHandshake sends our side of the handshake
+func (p *Client) writeHandshake(infoHash, peerId [20]byte) error {
 	var buf bytes.Buffer
 	buf.WriteByte(byte(len(protocol)))
 	buf.WriteString(protocol)
@@ -29,51 +48,53 @@ func (p *Client) handshake(infoHash, peerId [20]byte) error {
 	if err != nil {
 		return fmt.Errorf("writing handshake: %w", err)
 	}
+	return nil
+}
+
+// readHandshake receives the peer's side of the handshake and returns the
+// info hash it is for
+func (p *Client) readHandshake() ([20]byte, error) {
+	var responseInfoHash [20]byte
 
 	// read the protocol length
 	lengthBuf := make([]byte, 1)
-	_, err = io.ReadFull(p.Conn, lengthBuf)
+	_, err := io.ReadFull(p.Conn, lengthBuf)
 	if err != nil {
-		return err
+		return responseInfoHash, err
 	}
 	lengthProtocol := int(lengthBuf[0])
 	if lengthProtocol != 19 {
-		return fmt.Errorf("invalid protocol length: %d", lengthProtocol)
+		return responseInfoHash, fmt.Errorf("invalid protocol length: %d", lengthProtocol)
 	}
 
 	// read the handshake buffer
 	handShakeBuf := make([]byte, lengthProtocol+48)
 	_, err = io.ReadFull(p.Conn, handShakeBuf)
 	if err != nil {
-		return fmt.Errorf("reading handshake: %w", err)
+		return responseInfoHash, fmt.Errorf("reading handshake: %w", err)
 	}
 
 	// parse handshake details into handshake
 	responseProtocol := string(handShakeBuf[:lengthProtocol])
 	if responseProtocol != protocol {
-		return fmt.Errorf("invalid protocol: %s", responseProtocol)
+		return responseInfoHash, fmt.Errorf("invalid protocol: %s", responseProtocol)
 	}
 
 	// check reserved bytes for feature support
 	read := lengthProtocol
 	var responseExtensionBytes [8]byte
 	read += copy(responseExtensionBytes[:], handShakeBuf[read:read+8])
-	if responseExtensionBytes[7]|1 != 0 {
+	if responseExtensionBytes[7]&1 != 0 {
 		p.DHTSupport = true
 	}
 
 	// check for extension protocol support
-	if responseExtensionBytes[5]|0x10 != 0 {
+	if responseExtensionBytes[5]&0x10 != 0 {
 		p.ExtensionSupport = true
 	}
 
-	var responseInfoHash [20]byte
 	read += copy(responseInfoHash[:], handShakeBuf[read:read+20])
 	copy(p.PeerID[:], handShakeBuf[read:])
 
-	if !bytes.Equal(responseInfoHash[:], infoHash[:]) {
-		return fmt.Errorf("invalid info hash: %x", responseInfoHash)
-	}
-
-	return nil
+	return responseInfoHash, nil
 }
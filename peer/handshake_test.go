@@ -0,0 +1,79 @@
+package peer
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// handshakeWith makes a client read a handshake with the reserved bytes
+func handshakeWith(t *testing.T, reserved [8]byte) *Client {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	defer theirs.Close()
+
+	infoHash := [20]byte{1, 2, 3}
+	peerID := [20]byte{'-', 'T', 'T'}
+	go func() {
+		msg := append([]byte{byte(len(protocol))}, protocol...)
+		msg = append(msg, reserved[:]...)
+		msg = append(msg, infoHash[:]...)
+		msg = append(msg, peerID[:]...)
+		theirs.Write(msg)
+	}()
+
+	p := &Client{Conn: ours}
+	got, err := p.readHandshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != infoHash || p.PeerID != peerID {
+		t.Fatalf("got info hash %x and peer id %q", got, p.PeerID)
+	}
+	return p
+}
+
+func TestReadHandshakeReservedBits(t *testing.T) {
+	tests := []struct {
+		name          string
+		reserved      [8]byte
+		dht, extended bool
+	}{
+		{"none", [8]byte{}, false, false},
+		{"extension protocol", [8]byte{5: 0x10}, false, true},
+		{"dht", [8]byte{7: 1}, true, false},
+		{"both", [8]byte{5: 0x10, 7: 1}, true, true},
+		// bits for other extensions don't turn these on
+		{"other bits", [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xef, 0xff, 0xfe}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := handshakeWith(t, tt.reserved)
+			if p.DHTSupport != tt.dht || p.ExtensionSupport != tt.extended {
+				t.Fatalf("dht %t, extension protocol %t, want %t and %t",
+					p.DHTSupport, p.ExtensionSupport, tt.dht, tt.extended)
+			}
+		})
+	}
+}
+
+func TestWriteHandshakeReservedBits(t *testing.T) {
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	defer theirs.Close()
+
+	p := &Client{Conn: ours}
+	go p.writeHandshake([20]byte{1}, [20]byte{2})
+
+	msg := make([]byte, 68)
+	_, err := io.ReadFull(theirs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := msg[20:28]
+	want := []byte{0, 0, 0, 0, 0, 0x10, 0, 1}
+	if string(reserved) != string(want) {
+		t.Fatalf("reserved bytes are %x, want %x", reserved, want)
+	}
+}
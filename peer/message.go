@@ -99,6 +99,11 @@ func (p *Client) receiveMessage() (message, error) {
 	}
 	msgID := messageID(messageBuf[0])
 	messagePayload := messageBuf[1:]
+	// piece data is paid for once it's read, so the limiter never holds
+	// back the messages around it
+	if msgID == msgPiece && p.DownloadLimiter != nil {
+		p.DownloadLimiter.Wait(len(lengthBuf) + len(messageBuf))
+	}
 
 	// apply side effects to client if applicable
 	switch msgID {
@@ -114,6 +119,10 @@ func (p *Client) receiveMessage() (message, error) {
 	case msgPort:
 		p.DHTPort = int(binary.BigEndian.Uint16(messagePayload))
 	}
+	// blocks are logged per piece by GetPiece
+	if msgID != msgPiece {
+		p.log().Debug("received message", "message", msgID.String(), "length", len(messagePayload))
+	}
 
 	return message{
 		ID:      msgID,
@@ -2,6 +2,7 @@ package peer
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"time"
@@ -23,6 +24,18 @@ type metadataMessage struct {
 	TotalSize int                 `bencode:"total_size,omitempty"`
 }
 
+// GetMetadataContext is GetMetadata that closes the connection and returns
+// ctx's error when ctx is canceled
+func (p *Client) GetMetadataContext(ctx context.Context, infoHash [20]byte) ([]byte, error) {
+	stop := p.watch(ctx)
+	defer stop()
+	metadata, err := p.GetMetadata(infoHash)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return metadata, err
+}
+
 // GetMetadata requests and receives the raw metadata/info dictionary from peer
 func (p *Client) GetMetadata(infoHash [20]byte) ([]byte, error) {
 	if p.ExtensionMetadata.messageID == 0 || p.ExtensionMetadata.metadataSize == 0 {
@@ -120,5 +133,6 @@ func (p *Client) GetMetadata(infoHash [20]byte) ([]byte, error) {
 	if !bytes.Equal(hash[:], infoHash[:]) {
 		return nil, fmt.Errorf("metadata failed integrity check")
 	}
+	p.log().Debug("received metadata", "size", len(metadataBuf))
 	return metadataBuf, nil
 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+)
+
+const (
+	// how often the progress view is redrawn on a terminal
+	drawInterval = 500 * time.Millisecond
+	// how often a progress line is printed when stdout isn't a terminal
+	lineInterval = 5 * time.Second
+)
+
+// console is stdout shared by the event messages and the progress view. On a
+// terminal the view stays below the messages and is redrawn in place,
+// anywhere else it's printed as a plain line now and then
+type console struct {
+	mut   sync.Mutex
+	out   *os.File
+	tty   bool
+	view  []string // lines of the progress view
+	drawn int      // lines of the view currently on screen
+}
+
+func newConsole(out *os.File) *console {
+	_, _, tty := terminalSize(out)
+	if os.Getenv("TERM") == "dumb" {
+		tty = false
+	}
+	return &console{out: out, tty: tty}
+}
+
+// Printf prints a message above the progress view
+func (c *console) Printf(format string, args ...any) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	var buf bytes.Buffer
+	c.erase(&buf)
+	fmt.Fprintf(&buf, format, args...)
+	c.draw(&buf)
+	c.out.Write(buf.Bytes())
+}
+
+// Println prints a message line above the progress view
+func (c *console) Println(args ...any) {
+	c.Printf("%s", fmt.Sprintln(args...))
+}
+
+// show replaces the progress view, nil leaves the last one on screen and
+// stops managing it
+func (c *console) show(view []string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if view == nil {
+		c.view, c.drawn = nil, 0
+		return
+	}
+	var buf bytes.Buffer
+	c.erase(&buf)
+	c.view = view
+	c.draw(&buf)
+	c.out.Write(buf.Bytes())
+}
+
+// erase moves the cursor up to where the view starts and clears the screen
+// from there
+func (c *console) erase(buf *bytes.Buffer) {
+	if c.drawn > 0 {
+		fmt.Fprintf(buf, "\x1b[%dA\x1b[J", c.drawn)
+		c.drawn = 0
+	}
+}
+
+func (c *console) draw(buf *bytes.Buffer) {
+	for _, line := range c.view {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	c.drawn = len(c.view)
+}
+
+// showProgress shows the progress of a download until ctx is done, with a
+// last update before stopped is closed
+func showProgress(ctx context.Context, con *console, d *bittorrent.Download) (stopped <-chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		startBytes := d.Stats().Downloaded
+
+		interval := lineInterval
+		if con.tty {
+			interval = drawInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		update := func() {
+			stats := d.Stats()
+			if !con.tty {
+				con.Println(progressLine(stats))
+				return
+			}
+			average := float64(stats.Downloaded-startBytes) / max(time.Since(start).Seconds(), 1)
+			width, height, _ := terminalSize(con.out)
+			con.show(progressView(d.Torrent.Name, stats, average, width, height))
+		}
+		if con.tty {
+			update()
+		}
+		for {
+			select {
+			case <-ticker.C:
+				update()
+			case <-ctx.Done():
+				// the last update shows how the download ended
+				update()
+				con.show(nil)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// progressLine is a one line summary of the progress
+func progressLine(stats bittorrent.Stats) string {
+	return fmt.Sprintf("%s %s, %d/%d pieces, %s of %s, %s/s, ETA %s, %d sources",
+		time.Now().Format("2006/01/02 15:04:05"),
+		formatPercent(stats),
+		stats.Verified, stats.Wanted,
+		formatBytes(int(stats.VerifiedBytes)), formatBytes(int(stats.WantedBytes)),
+		formatBytes(int(stats.DownloadRate)),
+		formatStatsETA(stats),
+		len(stats.Peers),
+	)
+}
+
+func formatPercent(stats bittorrent.Stats) string {
+	if stats.WantedBytes == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(stats.VerifiedBytes)/float64(stats.WantedBytes)*100)
+}
+
+func formatStatsETA(stats bittorrent.Stats) string {
+	eta, ok := stats.ETA()
+	if !ok {
+		return "-"
+	}
+	return eta.Round(time.Second).String()
+}
+
+// progressView renders the progress of a download to fit a terminal:
+// an overall bar, the rates, the sources, the files and a piece map
+func progressView(name string, stats bittorrent.Stats, average float64, width, height int) []string {
+	if width <= 0 {
+		width = 80
+	}
+	if height <= 0 {
+		height = 24
+	}
+	// lines never wrap, or erasing the view would miss some of them
+	width--
+
+	lines := []string{
+		name,
+		progressBar(stats, width),
+		fmt.Sprintf("%d/%d pieces  %s of %s  %s/s now, %s/s average  ETA %s  %d sources",
+			stats.Verified, stats.Wanted,
+			formatBytes(int(stats.VerifiedBytes)), formatBytes(int(stats.WantedBytes)),
+			formatBytes(int(stats.DownloadRate)), formatBytes(int(average)),
+			formatStatsETA(stats), len(stats.Peers)),
+	}
+	lines = append(lines, pieceMap(stats.Pieces, width, 2)...)
+
+	// the tables share what's left of the screen, leaving room for messages
+	rows := max(height-len(lines)-8, 2)
+	peerRows := min(len(stats.Peers), max(rows/2, 1))
+	fileRows := max(rows-peerRows, 1)
+
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nSOURCE\tCLIENT\tRATE\tDOWNLOADED\tFLAGS")
+	peers := append([]bittorrent.PeerStats(nil), stats.Peers...)
+	sort.SliceStable(peers, func(i, j int) bool { return peers[i].DownloadRate > peers[j].DownloadRate })
+	for i, p := range peers {
+		if i == peerRows {
+			fmt.Fprintf(w, "... %d more\t\t\t\t\n", len(peers)-i)
+			break
+		}
+		client := p.Client
+		if client == "" {
+			client = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s/s\t%s\t%s\n", p.Addr, client, formatBytes(int(p.DownloadRate)), formatBytes(int(p.Downloaded)), peerFlags(p))
+	}
+	if len(peers) == 0 {
+		fmt.Fprintln(w, "none\t\t\t\t")
+	}
+
+	fmt.Fprintln(w, "\nFILE\tDONE\tSIZE\tPRIORITY\t")
+	var shown int
+	for i, f := range stats.Files {
+		if f.Padding {
+			continue
+		}
+		if shown == fileRows {
+			var left int
+			for _, f := range stats.Files[i:] {
+				if !f.Padding {
+					left++
+				}
+			}
+			fmt.Fprintf(w, "... %d more\t\t\t\t\n", left)
+			break
+		}
+		done := 100.0
+		if f.Length > 0 {
+			done = float64(f.Completed) / float64(f.Length) * 100
+		}
+		fmt.Fprintf(w, "%s\t%.1f%%\t%s\t%s\t\n", f.Path, done, formatBytes(int(f.Length)), f.Priority)
+		shown++
+	}
+	w.Flush()
+	lines = append(lines, strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")...)
+	lines = append(lines, "", "flags: P peer, W web seed, H http seed, D downloading")
+
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+	return lines
+}
+
+// progressBar is a bar of the share of the selected files that is verified
+func progressBar(stats bittorrent.Stats, width int) string {
+	percent := formatPercent(stats)
+	size := max(width-len(percent)-3, 1)
+	filled := size
+	if stats.WantedBytes > 0 {
+		filled = int(float64(stats.VerifiedBytes) / float64(stats.WantedBytes) * float64(size))
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", size-filled) + "] " + percent
+}
+
+// pieceMap draws the verified pieces in at most rows lines, a cell stands for
+// a few pieces when there are more pieces than cells: █ all verified, ▒ some
+// and · none
+func pieceMap(pieces []bool, width, rows int) []string {
+	if len(pieces) == 0 {
+		return nil
+	}
+	cells := min(len(pieces), width*rows)
+	var lines []string
+	var line strings.Builder
+	for cell := 0; cell < cells; cell++ {
+		from := cell * len(pieces) / cells
+		to := (cell + 1) * len(pieces) / cells
+		var have int
+		for _, verified := range pieces[from:to] {
+			if verified {
+				have++
+			}
+		}
+		switch {
+		case have == to-from:
+			line.WriteString("█")
+		case have > 0:
+			line.WriteString("▒")
+		default:
+			line.WriteString("·")
+		}
+		if (cell+1)%width == 0 {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// peerFlags describes a source in a few letters, see progressView
+func peerFlags(p bittorrent.PeerStats) string {
+	var flags string
+	switch p.Kind {
+	case bittorrent.SourceWebSeed:
+		flags = "W"
+	case bittorrent.SourceHTTPSeed:
+		flags = "H"
+	default:
+		flags = "P"
+	}
+	if p.DownloadRate > 0 {
+		flags += "D"
+	}
+	return flags
+}
+
+// truncate cuts a line to width characters
+func truncate(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	runes := []rune(line)
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "…"
+}
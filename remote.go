@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// remoteCommands are the commands of the daemon client
+var remoteCommands = map[string]func(c *apiClient, args []string) error{
+	"add":    remoteAdd,
+	"list":   remoteList,
+	"info":   remoteInfo,
+	"pause":  remoteAction("pause"),
+	"resume": remoteAction("resume"),
+	"remove": remoteRemove,
+	"select": remoteSelect,
+	"limit":  remoteLimit,
+	"events": remoteEvents,
+}
+
+// runRemote controls a daemon started with the daemon command over its API
+func runRemote(args []string) error {
+	fs := flag.NewFlagSet("remote", flag.ExitOnError)
+	apiAddr := fs.String("api", defaultAPIAddr, "address of the daemon's API")
+	token := fs.String("token", os.Getenv(apiTokenEnv), "token of the daemon's API, defaults to $"+apiTokenEnv)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s remote [flags] <command> [args]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "commands:")
+		fmt.Fprintln(fs.Output(), "  add [-out dir] [-path] <torrent file or magnet link>")
+		fmt.Fprintln(fs.Output(), "  list")
+		fmt.Fprintln(fs.Output(), "  info <hash>")
+		fmt.Fprintln(fs.Output(), "  pause <hash>")
+		fmt.Fprintln(fs.Output(), "  resume <hash>")
+		fmt.Fprintln(fs.Output(), "  remove [-delete] <hash>")
+		fmt.Fprintln(fs.Output(), "  select [-high pattern] [-low pattern] <hash> [patterns]")
+		fmt.Fprintln(fs.Output(), "  limit [-down rate] [hash]")
+		fmt.Fprintln(fs.Output(), "  events [hash]")
+		fmt.Fprintln(fs.Output(), "\n<hash> is an info hash or a prefix of one\n\nflags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected a command")
+	}
+	cmd, ok := remoteCommands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+
+	base := *apiAddr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return cmd(&apiClient{base: strings.TrimSuffix(base, "/"), token: *token}, fs.Args()[1:])
+}
+
+// apiClient sends requests to the API of a daemon
+type apiClient struct {
+	base  string
+	token string // sent as a bearer token when set
+}
+
+// send sends a request with the token of the API
+func (c *apiClient) send(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// do sends a request and decodes the JSON response into out, unless it's nil.
+// Error responses are returned as errors
+func (c *apiClient) do(method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return errors.New(apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// doJSON sends v as a JSON body
+func (c *apiClient) doJSON(method, path string, v any, out any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.do(method, path, "application/json", bytes.NewReader(body), out)
+}
+
+// hashPath is the API path of a torrent
+func hashPath(hash string, rest string) string {
+	return "/api/torrents/" + url.PathEscape(hash) + rest
+}
+
+// oneArg returns the only argument left after parsing fs
+func oneArg(fs *flag.FlagSet, what string) (string, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", fmt.Errorf("expected %s", what)
+	}
+	return fs.Arg(0), nil
+}
+
+func remoteAdd(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	outDir := fs.String("out", "", "directory to download into on the daemon's machine, defaults to the daemon's -out")
+	isPath := fs.Bool("path", false, "the torrent file is a path on the daemon's machine rather than a local file to upload")
+	fs.Parse(args)
+	source, err := oneArg(fs, "a torrent file or magnet link")
+	if err != nil {
+		return err
+	}
+
+	var status torrentJSON
+	switch {
+	case strings.HasPrefix(source, "magnet:"):
+		err = c.doJSON("POST", "/api/torrents", addRequest{Magnet: source, OutDir: *outDir}, &status)
+	case *isPath:
+		err = c.doJSON("POST", "/api/torrents", addRequest{Path: source, OutDir: *outDir}, &status)
+	default:
+		err = c.upload(source, *outDir, &status)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added %s %s\n", status.InfoHash, status.Name)
+	return nil
+}
+
+// upload sends a local torrent file as a multipart form
+func (c *apiClient) upload(path, outDir string, out any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("torrent", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	part.Write(raw)
+	if outDir != "" {
+		form.WriteField("out_dir", outDir)
+	}
+	form.Close()
+	return c.do("POST", "/api/torrents", form.FormDataContentType(), &body, out)
+}
+
+func remoteList(c *apiClient, args []string) error {
+	var torrents []torrentJSON
+	err := c.do("GET", "/api/torrents", "", nil, &torrents)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tSTATE\tDONE\tRATE\tETA\tPEERS\tNAME")
+	for _, t := range torrents {
+		fmt.Fprintf(w, "%s\t%s\t%.1f%%\t%s/s\t%s\t%d\t%s\n",
+			t.InfoHash[:8], t.State, t.Progress*100, formatBytes(int(t.DownloadRate)), formatETA(t.ETA), t.Peers, t.Name)
+	}
+	return w.Flush()
+}
+
+func remoteInfo(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Parse(args)
+	hash, err := oneArg(fs, "an info hash")
+	if err != nil {
+		return err
+	}
+	var t torrentJSON
+	err = c.do("GET", hashPath(hash, ""), "", nil, &t)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("name: %s\ninfo hash: %s\nstate: %s\n", t.Name, t.InfoHash, t.State)
+	if t.Error != "" {
+		fmt.Printf("error: %s\n", t.Error)
+	}
+	fmt.Printf("progress: %.1f%% of %s\nrate: %s/s, eta %s\n",
+		t.Progress*100, formatBytes(int(t.Size)), formatBytes(int(t.DownloadRate)), formatETA(t.ETA))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(t.Files) > 0 {
+		fmt.Fprintln(w, "\nINDEX\tPRIORITY\tDONE\tSIZE\tPATH")
+		for _, f := range t.Files {
+			done := 100.0
+			if f.Length > 0 {
+				done = float64(f.Completed) / float64(f.Length) * 100
+			}
+			fmt.Fprintf(w, "%d\t%s\t%.1f%%\t%s\t%s\n", f.Index, f.Priority, done, formatBytes(int(f.Length)), f.Path)
+		}
+	}
+	if len(t.PeerList) > 0 {
+		fmt.Fprintln(w, "\nPEER\tKIND\tCLIENT\tDOWNLOADED\tRATE")
+		for _, p := range t.PeerList {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s/s\n", p.Addr, p.Kind, p.Client, formatBytes(int(p.Downloaded)), formatBytes(int(p.DownloadRate)))
+		}
+	}
+	return w.Flush()
+}
+
+// remoteAction runs an action that only takes an info hash
+func remoteAction(action string) func(c *apiClient, args []string) error {
+	return func(c *apiClient, args []string) error {
+		fs := flag.NewFlagSet(action, flag.ExitOnError)
+		fs.Parse(args)
+		hash, err := oneArg(fs, "an info hash")
+		if err != nil {
+			return err
+		}
+		var t torrentJSON
+		err = c.do("POST", hashPath(hash, "/"+action), "", nil, &t)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s\n", t.State, t.Name)
+		return nil
+	}
+}
+
+func remoteRemove(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	deleteData := fs.Bool("delete", false, "also delete the downloaded files")
+	fs.Parse(args)
+	hash, err := oneArg(fs, "an info hash")
+	if err != nil {
+		return err
+	}
+	path := hashPath(hash, "")
+	if *deleteData {
+		path += "?delete=true"
+	}
+	return c.do("DELETE", path, "", nil, nil)
+}
+
+func remoteSelect(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("select", flag.ExitOnError)
+	var high, low stringsFlag
+	fs.Var(&high, "high", "download files matching a glob or with this index first, can be repeated")
+	fs.Var(&low, "low", "download files matching a glob or with this index last, can be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s remote select [flags] <hash> [patterns]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "only files matching a pattern are downloaded, every file without patterns")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected an info hash")
+	}
+
+	req := filesRequest{Select: fs.Args()[1:], High: high, Low: low}
+	return c.doJSON("PUT", hashPath(fs.Arg(0), "/files"), req, nil)
+}
+
+// remoteLimit shows or changes the download limit of every torrent, or of one
+// torrent on top of that
+func remoteLimit(c *apiClient, args []string) error {
+	fs := flag.NewFlagSet("limit", flag.ExitOnError)
+	var down rateFlag
+	fs.Var(&down, "down", "bytes per second to download at most, like 2M, 0 is unlimited")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s remote limit [flags] [hash]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "limits every torrent together, or one torrent when a hash is given, shows the limit without flags")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("expected at most an info hash")
+	}
+
+	// only the limit passed is changed
+	req := map[string]int64{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "down" {
+			req["download"] = int64(down)
+		}
+	})
+
+	var limits limitsJSON
+	var err error
+	switch {
+	case fs.NArg() == 1:
+		var t torrentJSON
+		if len(req) > 0 {
+			err = c.doJSON("PUT", hashPath(fs.Arg(0), "/limits"), req, &t)
+		} else {
+			err = c.do("GET", hashPath(fs.Arg(0), ""), "", nil, &t)
+		}
+		limits = t.Limits
+	case len(req) > 0:
+		err = c.doJSON("PUT", "/api/limits", req, &limits)
+	default:
+		err = c.do("GET", "/api/limits", "", nil, &limits)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("download %s\n", formatRate(limits.Download))
+	return nil
+}
+
+// formatRate formats a limit in bytes per second, 0 is unlimited
+func formatRate(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return formatBytes(int(rate)) + "/s"
+}
+
+func remoteEvents(c *apiClient, args []string) error {
+	path := "/api/events"
+	if len(args) > 0 {
+		path += "?hash=" + url.QueryEscape(args[0])
+	}
+	req, err := http.NewRequest("GET", c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var ev eventJSON
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			return fmt.Errorf("decoding event: %w", err)
+		}
+		fields, _ := json.Marshal(ev.Event)
+		fmt.Printf("%s %s %s %s\n", ev.Time.Format("2006/01/02 15:04:05"), ev.InfoHash[:8], ev.Type, fields)
+	}
+	return scanner.Err()
+}
+
+// formatETA formats an ETA in seconds, negative is unknown
+func formatETA(seconds int64) string {
+	if seconds < 0 {
+		return "-"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+)
+
+// schedule switches the download limit of the daemon's session between
+// profiles, and pauses its torrents, at times of the week. It's read from a
+// JSON file:
+//
+//	{
+//	  "profiles": {"work": {"download": "1M"}},
+//	  "rules": [
+//	    {"days": ["mon", "tue", "wed", "thu", "fri"], "from": "09:00", "to": "18:00", "profile": "work"},
+//	    {"days": ["sun"], "from": "02:00", "to": "04:00", "pause": true}
+//	  ]
+//	}
+//
+// The first rule matching the local time applies, outside of every rule the
+// limit set on the daemon is used. A rule ending before it starts runs past
+// midnight into the next day, no days is every day
+type schedule struct {
+	Profiles map[string]scheduleProfile `json:"profiles"`
+	Rules    []scheduleRule             `json:"rules"`
+}
+
+// scheduleProfile is a download limit in bytes per second, 0 is unlimited
+type scheduleProfile struct {
+	Download rateFlag `json:"download"`
+	// Upload is refused, pieces are never uploaded so there is nothing to
+	// limit
+	Upload *rateFlag `json:"upload,omitempty"`
+}
+
+type scheduleRule struct {
+	Days    []string `json:"days"`
+	From    string   `json:"from"` // HH:MM
+	To      string   `json:"to"`
+	Profile string   `json:"profile,omitempty"`
+	Pause   bool     `json:"pause,omitempty"` // stop every torrent
+
+	days     [7]bool // by time.Weekday
+	from, to int     // minutes since midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// loadSchedule reads and checks a schedule file
+func loadSchedule(path string) (schedule, error) {
+	var sch schedule
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return sch, err
+	}
+	err = json.Unmarshal(raw, &sch)
+	if err != nil {
+		return sch, fmt.Errorf("decoding schedule %s: %w", path, err)
+	}
+	for name, p := range sch.Profiles {
+		if p.Upload != nil {
+			return sch, fmt.Errorf("profile %q of schedule %s: %w", name, path, errUploadLimit)
+		}
+	}
+	for i := range sch.Rules {
+		err = sch.Rules[i].parse(sch.Profiles)
+		if err != nil {
+			return sch, fmt.Errorf("rule %d of schedule %s: %w", i+1, path, err)
+		}
+	}
+	return sch, nil
+}
+
+func (r *scheduleRule) parse(profiles map[string]scheduleProfile) error {
+	if r.Profile == "" && !r.Pause {
+		return errors.New("expected a profile or pause")
+	}
+	if _, ok := profiles[r.Profile]; r.Profile != "" && !ok {
+		return fmt.Errorf("unknown profile %q", r.Profile)
+	}
+	if len(r.Days) == 0 {
+		r.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, day := range r.Days {
+		wd, ok := parseWeekday(day)
+		if !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		r.days[wd] = true
+	}
+	var err error
+	r.from, err = parseClock(r.From)
+	if err != nil {
+		return err
+	}
+	r.to, err = parseClock(r.To)
+	if err != nil {
+		return err
+	}
+	if r.from == r.to {
+		return errors.New("from and to are the same time")
+	}
+	return nil
+}
+
+// parseWeekday parses a day by its three letter or full English name, in any
+// case
+func parseWeekday(day string) (time.Weekday, bool) {
+	for name, wd := range weekdays {
+		if strings.EqualFold(day, name) || strings.EqualFold(day, wd.String()) {
+			return wd, true
+		}
+	}
+	return 0, false
+}
+
+// parseClock parses a HH:MM time of day into minutes since midnight, 24:00
+// is the end of the day
+func parseClock(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	digits := len(hours) >= 1 && len(hours) <= 2 && len(minutes) == 2 &&
+		strings.Trim(hours+minutes, "0123456789") == ""
+	if !ok || !digits {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	if m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// matches reports whether the rule applies at t
+func (r *scheduleRule) matches(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if r.from < r.to {
+		return r.days[t.Weekday()] && minute >= r.from && minute < r.to
+	}
+	// past midnight, the part after it belongs to the day before
+	yesterday := (t.Weekday() + 6) % 7
+	return r.days[t.Weekday()] && minute >= r.from || r.days[yesterday] && minute < r.to
+}
+
+// at returns the rule that applies at t, nil when none does
+func (sch *schedule) at(t time.Time) *scheduleRule {
+	for i := range sch.Rules {
+		if sch.Rules[i].matches(t) {
+			return &sch.Rules[i]
+		}
+	}
+	return nil
+}
+
+// scheduleCheckInterval is how often the schedule is checked for a new rule,
+// for torrents added while it pauses them, and its file for changes
+const scheduleCheckInterval = 10 * time.Second
+
+// scheduler applies a schedule to a session until stopped
+type scheduler struct {
+	session *bittorrent.Session
+	path    string
+	log     *slog.Logger
+
+	mut      sync.Mutex
+	schedule schedule
+	modTime  time.Time
+	current  *scheduleRule
+	base     scheduleProfile   // limit outside of every rule
+	seen     map[[20]byte]bool // torrents the current rule has looked at
+	paused   map[[20]byte]bool // torrents the schedule paused, resumed once it's over
+}
+
+// newScheduler loads the schedule file at path for a session, nil log
+// discards what the schedule does
+func newScheduler(session *bittorrent.Session, path string, log *slog.Logger) (*scheduler, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	sch, err := loadSchedule(path)
+	if err != nil {
+		return nil, err
+	}
+	return &scheduler{
+		session:  session,
+		path:     path,
+		log:      logging.OrDiscard(log),
+		schedule: sch,
+		modTime:  info.ModTime(),
+		seen:     map[[20]byte]bool{},
+		paused:   map[[20]byte]bool{},
+	}, nil
+}
+
+// run applies the schedule now and whenever it changes until stop is closed
+func (s *scheduler) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.reload()
+		s.apply(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reload reads the schedule file again once it changed, a broken file keeps
+// the schedule as it was
+func (s *scheduler) reload() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return
+	}
+	s.mut.Lock()
+	changed := !info.ModTime().Equal(s.modTime)
+	s.mut.Unlock()
+	if !changed {
+		return
+	}
+	sch, err := loadSchedule(s.path)
+	s.mut.Lock()
+	s.modTime = info.ModTime()
+	if err != nil {
+		s.mut.Unlock()
+		s.log.Warn("keeping the last schedule", "error", err)
+		return
+	}
+	s.schedule = sch
+	// the rules are applied again from scratch
+	var resume []*bittorrent.Torrent
+	if s.current != nil {
+		resume = s.leave(nil)
+		s.current = nil
+	}
+	s.mut.Unlock()
+
+	s.log.Info("reloaded schedule", "path", s.path)
+	s.resume(resume)
+}
+
+// apply switches to the rule of time t, and pauses the torrents added since
+// the last check while the rule pauses them
+func (s *scheduler) apply(t time.Time) {
+	// torrents are paused and resumed once the lock is released, pausing
+	// waits for the torrent to stop
+	var pause, resume []*bittorrent.Torrent
+	defer func() {
+		s.resume(resume)
+		for _, t := range pause {
+			t.Pause()
+		}
+	}()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	rule := s.schedule.at(t)
+	if rule != s.current {
+		if s.current != nil {
+			resume = s.leave(rule)
+		}
+		if rule != nil {
+			s.enter(rule)
+		}
+		s.current = rule
+	}
+	if rule == nil || !rule.Pause {
+		return
+	}
+	// torrents resumed by hand while paused are left running
+	for _, t := range s.session.Torrents() {
+		infoHash := t.InfoHash()
+		if s.seen[infoHash] {
+			continue
+		}
+		s.seen[infoHash] = true
+		state, _ := t.State()
+		if state == bittorrent.StateConnecting || state == bittorrent.StateDownloading {
+			pause = append(pause, t)
+			s.paused[infoHash] = true
+		}
+	}
+}
+
+// enter starts a rule, the caller holds the lock
+func (s *scheduler) enter(rule *scheduleRule) {
+	s.base = scheduleProfile{Download: rateFlag(s.session.DownloadLimit())}
+	if rule.Profile != "" {
+		p := s.schedule.Profiles[rule.Profile]
+		s.session.SetDownloadLimit(int64(p.Download))
+		s.log.Info("switched to schedule profile", "profile", rule.Profile,
+			"download", formatRate(int64(p.Download)))
+	}
+	if rule.Pause {
+		s.log.Info("schedule is pausing every torrent")
+	}
+	s.seen = map[[20]byte]bool{}
+}
+
+// leave ends the current rule for next, the limit from before it is
+// restored and it returns the torrents it paused to be resumed, unless next
+// pauses them too. The caller holds the lock
+func (s *scheduler) leave(next *scheduleRule) []*bittorrent.Torrent {
+	if s.current.Profile != "" {
+		s.session.SetDownloadLimit(int64(s.base.Download))
+		s.log.Info("schedule profile is over", "profile", s.current.Profile,
+			"download", formatRate(int64(s.base.Download)))
+	}
+	if next != nil && next.Pause {
+		return nil
+	}
+	var resume []*bittorrent.Torrent
+	for infoHash := range s.paused {
+		// removed torrents are left alone
+		if t, ok := s.session.Torrent(infoHash); ok {
+			resume = append(resume, t)
+		}
+	}
+	s.paused = map[[20]byte]bool{}
+	return resume
+}
+
+// resume resumes the torrents a rule paused, without the lock held. Those
+// resumed by hand are left alone
+func (s *scheduler) resume(torrents []*bittorrent.Torrent) {
+	for _, t := range torrents {
+		if state, _ := t.State(); state == bittorrent.StatePaused {
+			t.Resume()
+			s.log.Info("schedule resumed torrent", "name", t.Name(), logging.HashAttr(t.InfoHash()))
+		}
+	}
+}
+
+// scheduleStatus is what the schedule is doing, for the API
+type scheduleStatus struct {
+	Enabled bool     `json:"enabled"`
+	Profile string   `json:"profile,omitempty"` // of the current rule
+	Pause   bool     `json:"pause"`             // whether the current rule pauses torrents
+	Paused  []string `json:"paused"`            // info hashes of the torrents it paused
+}
+
+func (s *scheduler) status() scheduleStatus {
+	status := scheduleStatus{Paused: []string{}}
+	if s == nil {
+		return status
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	status.Enabled = true
+	if s.current != nil {
+		status.Profile = s.current.Profile
+		status.Pause = s.current.Pause
+	}
+	for infoHash := range s.paused {
+		status.Paused = append(status.Paused, hex.EncodeToString(infoHash[:]))
+	}
+	return status
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseSchedule(t *testing.T, raw string) (schedule, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	err := os.WriteFile(path, []byte(raw), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadSchedule(path)
+}
+
+func TestParseRuleDays(t *testing.T) {
+	tests := []struct {
+		days string
+		want []time.Weekday
+		err  bool
+	}{
+		{`[]`, []time.Weekday{0, 1, 2, 3, 4, 5, 6}, false},
+		{`["mon", "Tue", "WEDNESDAY", "sunday"]`, []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday}, false},
+		{`["sat", "sat"]`, []time.Weekday{time.Saturday}, false},
+		{`["mond"]`, nil, true},
+		{`["mo"]`, nil, true},
+		{`[""]`, nil, true},
+		// the Kelvin sign is one byte once lowered
+		{`["K"]`, nil, true},
+		{`["Kmon"]`, nil, true},
+	}
+	for _, tt := range tests {
+		sch, err := parseSchedule(t, `{"rules": [{"days": `+tt.days+`, "from": "09:00", "to": "10:00", "pause": true}]}`)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.days)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.days, err)
+			continue
+		}
+		var want [7]bool
+		for _, wd := range tt.want {
+			want[wd] = true
+		}
+		if got := sch.Rules[0].days; got != want {
+			t.Errorf("%s: got days %v, want %v", tt.days, got, want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+		err  bool
+	}{
+		{"00:00", 0, false},
+		{"9:05", 9*60 + 5, false},
+		{"23:59", 23*60 + 59, false},
+		{"24:00", 24 * 60, false},
+		{"24:01", 0, true},
+		{"12:60", 0, true},
+		{"09:00xyz", 0, true},
+		{"09:00 ", 0, true},
+		{" 09:00", 0, true},
+		{"09:0", 0, true},
+		{"009:00", 0, true},
+		{"-1:00", 0, true},
+		{"+9:00", 0, true},
+		{"0900", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.s)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseClock(%q) = %d, %v, want %d and error %t", tt.s, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// at is a time in the week of Monday 2024-01-01
+func at(weekday time.Weekday, clock string) time.Time {
+	day := 1 + (int(weekday)+6)%7
+	h, _ := time.Parse("15:04", clock)
+	return time.Date(2024, 1, day, h.Hour(), h.Minute(), 0, 0, time.Local)
+}
+
+func TestRuleMatches(t *testing.T) {
+	sch, err := parseSchedule(t, `{"rules": [
+		{"days": ["fri"], "from": "22:00", "to": "02:00", "pause": true},
+		{"days": ["mon"], "from": "09:00", "to": "17:00", "pause": true},
+		{"from": "23:00", "to": "24:00", "pause": true}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overnight, daytime, lastHour := &sch.Rules[0], &sch.Rules[1], &sch.Rules[2]
+
+	tests := []struct {
+		rule *scheduleRule
+		t    time.Time
+		want bool
+	}{
+		{overnight, at(time.Friday, "21:59"), false},
+		{overnight, at(time.Friday, "22:00"), true},
+		{overnight, at(time.Friday, "23:59"), true},
+		// the part after midnight belongs to friday
+		{overnight, at(time.Saturday, "00:00"), true},
+		{overnight, at(time.Saturday, "01:59"), true},
+		{overnight, at(time.Saturday, "02:00"), false},
+		{overnight, at(time.Saturday, "22:30"), false},
+		{overnight, at(time.Friday, "01:00"), false},
+		{daytime, at(time.Monday, "08:59"), false},
+		{daytime, at(time.Monday, "09:00"), true},
+		{daytime, at(time.Monday, "16:59"), true},
+		{daytime, at(time.Monday, "17:00"), false},
+		{daytime, at(time.Tuesday, "12:00"), false},
+		{lastHour, at(time.Wednesday, "23:00"), true},
+		{lastHour, at(time.Wednesday, "23:59"), true},
+		{lastHour, at(time.Thursday, "00:00"), false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.t); got != tt.want {
+			t.Errorf("rule %s-%s on %s: got %t, want %t", tt.rule.From, tt.rule.To, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	sch, err := parseSchedule(t, `{
+		"profiles": {"night": {"download": "1M"}, "office": {"download": "100K"}},
+		"rules": [
+			{"days": ["mon"], "from": "12:00", "to": "13:00", "pause": true},
+			{"days": ["mon", "tue"], "from": "09:00", "to": "18:00", "profile": "office"},
+			{"from": "22:00", "to": "07:00", "profile": "night"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t    time.Time
+		want string // the rule's profile, "pause" or "" for none
+	}{
+		// the first matching rule wins
+		{at(time.Monday, "12:30"), "pause"},
+		{at(time.Monday, "11:00"), "office"},
+		{at(time.Tuesday, "12:30"), "office"},
+		{at(time.Wednesday, "12:30"), ""},
+		{at(time.Monday, "23:00"), "night"},
+		{at(time.Tuesday, "06:59"), "night"},
+		{at(time.Tuesday, "07:00"), ""},
+		{at(time.Monday, "18:00"), ""},
+	}
+	for _, tt := range tests {
+		var got string
+		if rule := sch.at(tt.t); rule != nil {
+			got = rule.Profile
+			if rule.Pause {
+				got = "pause"
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: got rule %q, want %q", tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestLoadScheduleErrors(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`{"rules": [{"from": "09:00", "to": "10:00"}]}`, "expected a profile or pause"},
+		{`{"rules": [{"from": "09:00", "to": "10:00", "profile": "x"}]}`, "unknown profile"},
+		{`{"rules": [{"from": "09:00", "to": "09:00", "pause": true}]}`, "same time"},
+		{`{"rules": [{"from": "09:00xyz", "to": "10:00", "pause": true}]}`, "invalid time"},
+		{`{"rules": [{"days": ["K"], "from": "09:00", "to": "10:00", "pause": true}]}`, "unknown day"},
+		{`{"rules": [`, "decoding schedule"},
+		{`{"profiles": {"x": {"download": "1M", "upload": "200K"}}, "rules": []}`, "upload limits aren't supported"},
+	}
+	for _, tt := range tests {
+		_, err := parseSchedule(t, tt.raw)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.raw, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+)
+
+// mediaExts are the extensions of files listed in the .m3u playlist
+var mediaExts = map[string]bool{
+	".mp4": true, ".mkv": true, ".webm": true, ".avi": true, ".mov": true,
+	".m4v": true, ".mpg": true, ".mpeg": true, ".ts": true, ".wmv": true,
+	".mp3": true, ".flac": true, ".ogg": true, ".opus": true, ".m4a": true,
+	".wav": true, ".aac": true,
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+{{if .HasMedia}}<p><a href="/playlist.m3u">playlist.m3u</a></p>{{end}}
+<table>
+{{range .Files}}<tr><td><a href="{{.URL}}">{{.Path}}</a></td><td>{{.Size}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type indexFile struct {
+	Path string
+	URL  string
+	Size string
+}
+
+// streamServer serves the files of a torrent over HTTP while it downloads,
+// requests wait for the pieces they read and move them to the front of the
+// download
+type streamServer struct {
+	d     *bittorrent.Download
+	paths map[string]int // slash separated file path to file index
+	start time.Time
+}
+
+func newStreamServer(d *bittorrent.Download) http.Handler {
+	s := &streamServer{
+		d:     d,
+		paths: map[string]int{},
+		start: time.Now(),
+	}
+	for i, f := range d.Torrent.Files {
+		if f.Padding || f.SymlinkPath != "" {
+			continue
+		}
+		s.paths[filepath.ToSlash(f.Path)] = i
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.index)
+	mux.HandleFunc("GET /playlist.m3u", s.playlist)
+	mux.HandleFunc("GET /files/{path...}", s.file)
+	return mux
+}
+
+// fileURL is the path a file is served at
+func fileURL(p string) string {
+	return (&url.URL{Path: "/files/" + p}).EscapedPath()
+}
+
+// servedPaths returns the paths of the served files in torrent order
+func (s *streamServer) servedPaths() []string {
+	var paths []string
+	for _, f := range s.d.Torrent.Files {
+		p := filepath.ToSlash(f.Path)
+		if _, ok := s.paths[p]; ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (s *streamServer) index(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Name     string
+		HasMedia bool
+		Files    []indexFile
+	}{Name: s.d.Torrent.Name}
+	for _, p := range s.servedPaths() {
+		f := s.d.Torrent.Files[s.paths[p]]
+		data.Files = append(data.Files, indexFile{
+			Path: p,
+			URL:  fileURL(p),
+			Size: formatBytes(f.Length),
+		})
+		data.HasMedia = data.HasMedia || mediaExts[strings.ToLower(path.Ext(p))]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, data)
+}
+
+// playlist lists the media files as an extended M3U playlist with absolute
+// URLs, so it can be opened by a player straight from the server
+func (s *streamServer) playlist(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, p := range s.servedPaths() {
+		if !mediaExts[strings.ToLower(path.Ext(p))] {
+			continue
+		}
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n", path.Base(p))
+		fmt.Fprintf(w, "%s://%s%s\n", scheme, r.Host, fileURL(p))
+	}
+}
+
+func (s *streamServer) file(w http.ResponseWriter, r *http.Request) {
+	i, ok := s.paths[r.PathValue("path")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// reads stop waiting for pieces once the client is gone
+	f, err := s.d.OpenFileContext(r.Context(), i)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// ServeContent handles Range requests, seeking the reader to the start
+	// of each range before reading it
+	http.ServeContent(w, r, path.Base(r.PathValue("path")), s.start, f)
+}
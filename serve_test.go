@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+func TestServeFileStopsWhenRequestIsGone(t *testing.T) {
+	d := &bittorrent.Download{Torrent: torrentparser.TorrentFile{
+		Name:        "t",
+		PieceLength: 16,
+		PieceHashes: make([][20]byte, 2),
+		Length:      32,
+		Files:       []torrentparser.File{{Path: "video.mkv", Length: 32}},
+	}}
+	handler := newStreamServer(d)
+
+	// the download isn't running, so the read waits until the client leaves
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/files/video.mkv", nil).WithContext(ctx)
+	req.Header.Set("Range", "bytes=16-")
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler kept waiting for pieces after the request was canceled")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// terminalSize returns the size of the terminal f writes to, false when it
+// isn't a terminal. The size isn't known on this platform so a common one is
+// assumed
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return 0, 0, false
+	}
+	return 80, 24, true
+}
@@ -2,9 +2,12 @@ package torrentparser
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/zeebo/bencode"
 )
@@ -21,6 +24,8 @@ func (t *TorrentFile) AppendMetadata(metadata []byte) error {
 
 	// SHA-1 hash the entire info dictionary to get the info_hash
 	t.InfoHash = sha1.Sum(metadata)
+	t.Metadata = metadata
+	t.MetaVersion = 1
 
 	// split the Pieces blob into the 20-byte SHA-1 hashes for comparison later
 	const hashLen = 20 // length of a SHA-1 hash
@@ -34,6 +39,52 @@ func (t *TorrentFile) AppendMetadata(metadata []byte) error {
 	}
 
 	t.PieceLength = info.PieceLength
+	// the name is the file or directory the torrent is downloaded into
+	err = checkPath([]string{info.Name})
+	if err != nil {
+		return fmt.Errorf("invalid name: %w", err)
+	}
+	// the info dictionary name takes precedence over a magnet's display name
+	if info.Name != "" {
+		t.Name = info.Name
+	}
+	t.Private = info.Private == 1
+	t.Source = info.Source
+
+	var treeFiles []File
+	if info.MetaVersion == 2 {
+		t.MetaVersion = 2
+		t.InfoHashV2 = sha256.Sum256(metadata)
+		treeFiles, err = parseFileTree(info.FileTree, nil)
+		if err != nil {
+			return fmt.Errorf("parsing file tree: %w", err)
+		}
+		if len(treeFiles) == 0 {
+			return errors.New("invalid torrent file info dict: empty file tree")
+		}
+		// a single file at the top of the tree named after the torrent is a
+		// single file torrent, otherwise the files are stored in a directory
+		// named after the torrent, like a directory holding just one file
+		if len(treeFiles) > 1 || treeFiles[0].Path != info.Name || len(info.Files) != 0 {
+			for i := range treeFiles {
+				treeFiles[i].Path = filepath.Join(info.Name, treeFiles[i].Path)
+			}
+		}
+
+		// pure v2 torrents use the truncated v2 info_hash in handshakes and
+		// announces, there are no v1 fields to read the files from
+		if len(info.Pieces) == 0 {
+			copy(t.InfoHash[:], t.InfoHashV2[:])
+			t.Files = treeFiles
+			t.Length = 0
+			for _, f := range treeFiles {
+				t.Length += f.Length
+			}
+			return nil
+		}
+	} else if info.MetaVersion != 0 {
+		return fmt.Errorf("unsupported meta version %d", info.MetaVersion)
+	}
 
 	// either Length OR Files field must be present (but not both)
 	if info.Length == 0 && len(info.Files) == 0 {
@@ -42,22 +93,171 @@ func (t *TorrentFile) AppendMetadata(metadata []byte) error {
 
 	if info.Length != 0 {
 		t.Files = append(t.Files, File{
-			Length: info.Length,
-			Path:   info.Name,
+			Length:     info.Length,
+			Path:       info.Name,
+			Executable: strings.Contains(info.Attr, "x"),
+			Hidden:     strings.Contains(info.Attr, "h"),
 		})
 		t.Length = info.Length
 	} else {
 		for _, f := range info.Files {
+			err := checkPath(f.Path)
+			if err != nil {
+				return fmt.Errorf("invalid file path: %w", err)
+			}
 			subPaths := append([]string{info.Name}, f.Path...)
-			t.Files = append(t.Files, File{
-				Length:   f.Length,
-				Path:     filepath.Join(subPaths...),
-				SHA1Hash: f.SHA1Hash,
-				MD5Hash:  f.MD5Hash,
-			})
+			file := File{
+				Length:     f.Length,
+				Path:       filepath.Join(subPaths...),
+				SHA1Hash:   f.SHA1Hash,
+				MD5Hash:    f.MD5Hash,
+				Executable: strings.Contains(f.Attr, "x"),
+				Hidden:     strings.Contains(f.Attr, "h"),
+				// BEP0047, older clients only name padding files `.pad/N`
+				Padding: strings.Contains(f.Attr, "p") || (len(f.Path) > 0 && f.Path[0] == ".pad"),
+			}
+			if strings.Contains(f.Attr, "l") {
+				err := checkPath(f.SymlinkPath)
+				if err != nil {
+					return fmt.Errorf("invalid symlink path of %q: %w", file.Path, err)
+				}
+				file.SymlinkPath = filepath.Join(f.SymlinkPath...)
+			}
+			t.Files = append(t.Files, file)
+			// padding files still take up space in the pieces
 			t.Length += f.Length
 		}
 	}
 
+	// hybrid torrents describe the same files twice, take the merkle roots from
+	// the v2 file tree and keep the v1 list which includes padding files
+	if len(treeFiles) != 0 {
+		roots := map[string][32]byte{}
+		for _, f := range treeFiles {
+			roots[f.Path] = f.PiecesRoot
+		}
+		for i, f := range t.Files {
+			if f.Padding {
+				continue
+			}
+			root, ok := roots[f.Path]
+			if !ok {
+				return fmt.Errorf("hybrid torrent file %q is missing from the file tree", f.Path)
+			}
+			t.Files[i].PiecesRoot = root
+		}
+	}
+
+	return nil
+}
+
+// appendPieceLayers attaches the `piece layers` of a v2 .torrent file to each
+// file, it must be called after AppendMetadata
+func (t *TorrentFile) appendPieceLayers(pieceLayers map[string]string) error {
+	if t.MetaVersion != 2 {
+		return nil
+	}
+
+	for i, f := range t.Files {
+		if f.Padding || f.Length <= t.PieceLength {
+			continue
+		}
+		raw, ok := pieceLayers[string(f.PiecesRoot[:])]
+		if !ok {
+			return fmt.Errorf("missing piece layer for %q", f.Path)
+		}
+		layer, err := splitPieceLayer(raw, f, t.PieceLength)
+		if err != nil {
+			return err
+		}
+		t.Files[i].PieceLayer = layer
+	}
+
+	return nil
+}
+
+// parseFileTree flattens a BEP0052 file tree into a list of files in the
+// order they are stored in the torrent, which is sorted by path
+func parseFileTree(raw bencode.RawMessage, path []string) ([]File, error) {
+	var dir map[string]bencode.RawMessage
+	err := bencode.DecodeBytes(raw, &dir)
+	if err != nil {
+		return nil, fmt.Errorf("decoding file tree: %w", err)
+	}
+
+	names := make([]string, 0, len(dir))
+	for name := range dir {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []File
+	for _, name := range names {
+		// the empty key marks the node as a file rather than a directory
+		if name == "" {
+			var entry bencodeFileTreeEntry
+			err := bencode.DecodeBytes(dir[name], &entry)
+			if err != nil {
+				return nil, fmt.Errorf("decoding %q: %w", filepath.Join(path...), err)
+			}
+
+			file := File{
+				Length:     entry.Length,
+				Path:       filepath.Join(path...),
+				Executable: strings.Contains(entry.Attr, "x"),
+				Hidden:     strings.Contains(entry.Attr, "h"),
+			}
+			err = checkPath(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file path: %w", err)
+			}
+			if strings.Contains(entry.Attr, "l") {
+				err := checkPath(entry.SymlinkPath)
+				if err != nil {
+					return nil, fmt.Errorf("invalid symlink path of %q: %w", file.Path, err)
+				}
+				file.SymlinkPath = filepath.Join(entry.SymlinkPath...)
+			}
+			if entry.Length > 0 {
+				if len(entry.PiecesRoot) != sha256.Size {
+					return nil, fmt.Errorf("invalid pieces root for %q", file.Path)
+				}
+				copy(file.PiecesRoot[:], entry.PiecesRoot)
+			}
+			files = append(files, file)
+			continue
+		}
+
+		subFiles, err := parseFileTree(dir[name], append(append([]string{}, path...), name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+
+	return files, nil
+}
+
+// ErrUnsafePath is returned for metadata with a file or symlink path that
+// could point outside of the directory the torrent is downloaded into
+var ErrUnsafePath = errors.New("path leaves the torrent's directory")
+
+// checkPath checks the components of a path from the metadata, each must name
+// a file or directory inside its parent. Paths are relative to the torrent's
+// directory, so ".." and absolute paths could reach anywhere
+func checkPath(components []string) error {
+	if len(components) == 0 {
+		return fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	for _, c := range components {
+		switch {
+		case c == "", c == ".", c == "..":
+		case strings.ContainsAny(c, "/\\\x00"):
+		case filepath.IsAbs(c), filepath.VolumeName(c) != "":
+		default:
+			continue
+		}
+		return fmt.Errorf("%w: %q in %q", ErrUnsafePath, c, strings.Join(components, "/"))
+	}
 	return nil
 }
@@ -0,0 +1,118 @@
+package torrentparser
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+// v1Info is the info dict of a multi-file torrent with one file at path
+func v1Info(name string, path []string, attr string, symlink []string) map[string]any {
+	file := map[string]any{"length": 1, "path": path}
+	if attr != "" {
+		file["attr"] = attr
+	}
+	if symlink != nil {
+		file["symlink path"] = symlink
+	}
+	return map[string]any{
+		"name":         name,
+		"piece length": 16384,
+		"pieces":       strings.Repeat("x", 20),
+		"files":        []any{file},
+	}
+}
+
+// v2Info is the info dict of a v2 torrent with an empty file at path
+func v2Info(path []string, attr string, symlink []string) map[string]any {
+	entry := map[string]any{"length": 0}
+	if attr != "" {
+		entry["attr"] = attr
+	}
+	if symlink != nil {
+		entry["symlink path"] = symlink
+	}
+	var node any = map[string]any{"": entry}
+	for i := len(path) - 1; i >= 0; i-- {
+		node = map[string]any{path[i]: node}
+	}
+	// a second file makes it a multi-file torrent
+	tree := node.(map[string]any)
+	tree["other"] = map[string]any{"": map[string]any{"length": 0}}
+	return map[string]any{
+		"name":         "t",
+		"piece length": 16384,
+		"meta version": 2,
+		"file tree":    tree,
+	}
+}
+
+func appendInfo(t *testing.T, info map[string]any) (TorrentFile, error) {
+	t.Helper()
+	metadata, err := bencode.EncodeBytes(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tf TorrentFile
+	err = tf.AppendMetadata(metadata)
+	return tf, err
+}
+
+func TestAppendMetadataRejectsUnsafePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		info map[string]any
+	}{
+		{"v1 parent dir", v1Info("t", []string{"..", "x"}, "", nil)},
+		{"v1 nested parent dir", v1Info("t", []string{"a", "..", "..", "x"}, "", nil)},
+		{"v1 absolute", v1Info("t", []string{"/etc/passwd"}, "", nil)},
+		{"v1 separator", v1Info("t", []string{"a/../../x"}, "", nil)},
+		{"v1 backslash", v1Info("t", []string{`..\x`}, "", nil)},
+		{"v1 empty component", v1Info("t", []string{"a", "", "x"}, "", nil)},
+		{"v1 dot", v1Info("t", []string{".", "x"}, "", nil)},
+		{"v1 no path", v1Info("t", []string{}, "", nil)},
+		{"v1 nul", v1Info("t", []string{"x\x00"}, "", nil)},
+		{"name parent dir", v1Info("..", []string{"x"}, "", nil)},
+		{"name absolute", v1Info("/tmp", []string{"x"}, "", nil)},
+		{"empty name", v1Info("", []string{"x"}, "", nil)},
+		{"v1 symlink parent dir", v1Info("t", []string{"x"}, "l", []string{"..", "..", "etc"})},
+		{"v1 symlink absolute", v1Info("t", []string{"x"}, "l", []string{"/etc"})},
+		{"v1 symlink empty", v1Info("t", []string{"x"}, "l", []string{})},
+		{"v2 parent dir", v2Info([]string{"..", "x"}, "", nil)},
+		{"v2 separator", v2Info([]string{"a/../../x"}, "", nil)},
+		{"v2 symlink parent dir", v2Info([]string{"x"}, "l", []string{"..", "etc"})},
+		{"v2 symlink absolute", v2Info([]string{"x"}, "l", []string{"/etc"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tf, err := appendInfo(t, tt.info)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("got error %v and files %+v, want ErrUnsafePath", err, tf.Files)
+			}
+		})
+	}
+}
+
+func TestAppendMetadataAcceptsPaths(t *testing.T) {
+	tf, err := appendInfo(t, v1Info("t", []string{"a", "..b", "x.y"}, "l", []string{"a", "target"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("t", "a", "..b", "x.y"); tf.Files[0].Path != want {
+		t.Errorf("path is %q, want %q", tf.Files[0].Path, want)
+	}
+	if want := filepath.Join("a", "target"); tf.Files[0].SymlinkPath != want {
+		t.Errorf("symlink path is %q, want %q", tf.Files[0].SymlinkPath, want)
+	}
+
+	tf, err = appendInfo(t, v2Info([]string{"dir", "x"}, "l", []string{"other"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("t", "dir", "x"); tf.Files[0].Path != want {
+		t.Errorf("path is %q, want %q", tf.Files[0].Path, want)
+	}
+}
@@ -0,0 +1,476 @@
+package torrentparser
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// MetaVersion is the kind of torrent built by Create
+type MetaVersion int
+
+const (
+	MetaV1     MetaVersion = iota // BEP0003 SHA-1 pieces
+	MetaV2                        // BEP0052 SHA-256 per file merkle trees
+	MetaHybrid                    // both, with v1 pieces aligned to files by padding files
+)
+
+func (v MetaVersion) String() string {
+	switch v {
+	case MetaV1:
+		return "v1"
+	case MetaV2:
+		return "v2"
+	case MetaHybrid:
+		return "hybrid"
+	default:
+		return fmt.Sprintf("MetaVersion(%d)", int(v))
+	}
+}
+
+// CreateOptions configures the torrent built by Create
+type CreateOptions struct {
+	// AnnounceList is a list of tracker tiers (BEP0012), the first tracker of
+	// the first tier is also used as `announce` for older clients
+	AnnounceList [][]string
+	WebSeeds     []string // BEP0019 `url-list`
+	HTTPSeeds    []string // BEP0017 `httpseeds`
+	Private      bool
+	Comment      string
+	Source       string
+	CreatedBy    string
+	Version      MetaVersion
+	// PieceLength must be a power of two, 0 picks one based on the total size
+	PieceLength int
+	// Workers is the number of goroutines hashing pieces, 0 uses every CPU
+	Workers int
+}
+
+const (
+	minPieceLength = 16 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	// target number of pieces when picking a piece length automatically,
+	// enough to spread pieces across peers but keep the torrent file small
+	targetPieceCount = 1500
+)
+
+// PieceLengthFor picks a power of two piece length for a torrent of totalLength
+// bytes, clamped between 16KiB and 16MiB
+func PieceLengthFor(totalLength int) int {
+	pieceLength := minPieceLength
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// sourceFile is a file on disk that will be included in a created torrent
+type sourceFile struct {
+	diskPath    string
+	path        []string // path relative to the torrent root
+	length      int
+	executable  bool
+	symlinkPath []string
+}
+
+// attr returns the BEP0047 attributes of the file
+func (f sourceFile) attr() string {
+	var attr string
+	if f.executable {
+		attr += "x"
+	}
+	if len(f.symlinkPath) != 0 {
+		attr += "l"
+	}
+	return attr
+}
+
+// Create walks the file or directory at root and returns a bencoded .torrent
+// with the hashed contents of every regular file in it
+func Create(root string, opts CreateOptions) ([]byte, error) {
+	root = filepath.Clean(os.ExpandEnv(root))
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Version < MetaV1 || opts.Version > MetaHybrid {
+		return nil, fmt.Errorf("unknown version %s", opts.Version)
+	}
+
+	files, err := walkSourceFiles(root, stat)
+	if err != nil {
+		return nil, fmt.Errorf("walking %s: %w", root, err)
+	}
+
+	var totalLength int
+	for _, f := range files {
+		totalLength += f.length
+	}
+	if totalLength == 0 {
+		return nil, errors.New("no data to create torrent from")
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = PieceLengthFor(totalLength)
+	}
+	if pieceLength < minPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two of at least %d, got %d", minPieceLength, pieceLength)
+	}
+
+	hashes, err := hashPieces(files, pieceLength, opts.Workers, opts.Version)
+	if err != nil {
+		return nil, fmt.Errorf("hashing pieces: %w", err)
+	}
+
+	info := bencodeInfo{
+		Pieces:      string(hashes.pieces),
+		PieceLength: pieceLength,
+		Name:        filepath.Base(root),
+		Source:      opts.Source,
+	}
+	if opts.Private {
+		info.Private = 1
+	}
+
+	if opts.Version != MetaV2 {
+		if stat.IsDir() {
+			for i, f := range files {
+				info.Files = append(info.Files, bencodeFile{
+					Length:      f.length,
+					Path:        f.path,
+					Attr:        f.attr(),
+					SymlinkPath: f.symlinkPath,
+				})
+				if pad := padLength(files, i, pieceLength, opts.Version); pad > 0 {
+					info.Files = append(info.Files, bencodeFile{
+						Length: pad,
+						Path:   []string{".pad", strconv.Itoa(pad)},
+						Attr:   "p",
+					})
+				}
+			}
+		} else {
+			info.Length = totalLength
+			info.Attr = files[0].attr()
+		}
+	}
+
+	var pieceLayers map[string]string
+	if opts.Version != MetaV1 {
+		info.MetaVersion = 2
+		var fileTree map[string]any
+		fileTree, pieceLayers = buildFileTree(files, hashes.leaves, pieceLength)
+		info.FileTree, err = bencode.EncodeBytes(fileTree)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling file tree: %w", err)
+		}
+	}
+
+	infoRaw, err := bencode.EncodeBytes(info)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling info dict: %w", err)
+	}
+
+	btor := bencodeTorrent{
+		Comment:      opts.Comment,
+		CreatedBy:    opts.CreatedBy,
+		CreationDate: time.Now().Unix(),
+		URLList:      opts.WebSeeds,
+		HTTPSeeds:    opts.HTTPSeeds,
+		PieceLayers:  pieceLayers,
+		Info:         infoRaw,
+	}
+	btor.setTiers(opts.AnnounceList)
+
+	raw, err := bencode.EncodeBytes(btor)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling torrent: %w", err)
+	}
+	return raw, nil
+}
+
+// buildFileTree builds the BEP0052 file tree and piece layers from the block
+// hashes of each file. Files with the same contents share a pieces root, so
+// their piece layer is only stored once
+func buildFileTree(files []sourceFile, leaves [][][32]byte, pieceLength int) (map[string]any, map[string]string) {
+	tree := map[string]any{}
+	pieceLayers := map[string]string{}
+	for i, f := range files {
+		entry := bencodeFileTreeEntry{
+			Length:      f.length,
+			Attr:        f.attr(),
+			SymlinkPath: f.symlinkPath,
+		}
+		if f.length > 0 {
+			root, layer := MerkleTree(leaves[i], pieceLength)
+			entry.PiecesRoot = string(root[:])
+			if len(layer) > 0 {
+				raw := make([]byte, 0, len(layer)*32)
+				for _, h := range layer {
+					raw = append(raw, h[:]...)
+				}
+				pieceLayers[entry.PiecesRoot] = string(raw)
+			}
+		}
+
+		// create the directories on the way to the file
+		dir := tree
+		for _, name := range f.path[:len(f.path)-1] {
+			sub, ok := dir[name].(map[string]any)
+			if !ok {
+				sub = map[string]any{}
+				dir[name] = sub
+			}
+			dir = sub
+		}
+		dir[f.path[len(f.path)-1]] = map[string]any{"": entry}
+	}
+
+	return tree, pieceLayers
+}
+
+// walkSourceFiles lists the files under root in the order they are stored in
+// the torrent, which is lexical order for directories
+func walkSourceFiles(root string, stat fs.FileInfo) ([]sourceFile, error) {
+	if !stat.IsDir() {
+		return []sourceFile{{
+			diskPath:   root,
+			path:       []string{filepath.Base(root)},
+			length:     int(stat.Size()),
+			executable: stat.Mode()&0111 != 0,
+		}}, nil
+	}
+
+	var files []sourceFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		f := sourceFile{
+			diskPath: path,
+			path:     strings.Split(filepath.ToSlash(rel), "/"),
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			// keep links that point inside the torrent as BEP0047 symlinks,
+			// anything else can't be represented so it is skipped
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			relTarget, err := filepath.Rel(root, target)
+			if err != nil || relTarget == ".." || strings.HasPrefix(relTarget, ".."+string(filepath.Separator)) {
+				return nil
+			}
+			f.symlinkPath = strings.Split(filepath.ToSlash(relTarget), "/")
+			files = append(files, f)
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f.length = int(fileInfo.Size())
+		f.executable = fileInfo.Mode()&0111 != 0
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("directory has no files")
+	}
+
+	return files, nil
+}
+
+// pieceBuf is a piece read from disk waiting to be hashed
+type pieceBuf struct {
+	index int // v1 piece index, unused for pure v2 torrents
+	data  []byte
+	pad   int // zeros after data that complete a hybrid torrent's v1 piece
+	file  int // index of the file the piece is in, -1 for v1 torrents
+	block int // index of the piece's first 16KiB block within the file
+}
+
+// fileHashes are the hashes that make up a created torrent
+type fileHashes struct {
+	pieces []byte       // concatenated SHA-1 hashes of every v1 piece
+	leaves [][][32]byte // SHA-256 hashes of every 16KiB block of each file
+}
+
+// hashPieces reads the files and hashes every piece on a pool of workers
+//
+// v1 pieces run across file boundaries as if the files were one continuous
+// stream. v2 and hybrid pieces are aligned to the start of each file, hybrid
+// v1 pieces are zero padded in place of the BEP0047 padding files
+func hashPieces(files []sourceFile, pieceLength, workers int, version MetaVersion) (fileHashes, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	hashes := fileHashes{leaves: make([][][32]byte, len(files))}
+	var totalLength int
+	for i, f := range files {
+		totalLength += f.length + padLength(files, i, pieceLength, version)
+		if version != MetaV1 {
+			hashes.leaves[i] = make([][32]byte, (f.length+BlockSize-1)/BlockSize)
+		}
+	}
+	if version != MetaV2 {
+		numPieces := (totalLength + pieceLength - 1) / pieceLength
+		hashes.pieces = make([]byte, numPieces*sha1.Size)
+	}
+
+	jobs := make(chan pieceBuf, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			zeros := make([]byte, pieceLength)
+			for job := range jobs {
+				if hashes.pieces != nil {
+					h := sha1.New()
+					h.Write(job.data)
+					h.Write(zeros[:job.pad])
+					copy(hashes.pieces[job.index*sha1.Size:], h.Sum(nil))
+				}
+				if job.file >= 0 {
+					copy(hashes.leaves[job.file][job.block:], BlockHashes(job.data))
+				}
+			}
+		}()
+	}
+
+	var err error
+	if version == MetaV1 {
+		err = readStreamPieces(files, pieceLength, totalLength, jobs)
+	} else {
+		err = readFilePieces(files, pieceLength, version, jobs)
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return fileHashes{}, err
+	}
+	return hashes, nil
+}
+
+// padLength is the length of the padding file that follows file i in a hybrid
+// torrent, so that the next file starts on a piece boundary
+func padLength(files []sourceFile, i, pieceLength int, version MetaVersion) int {
+	if version != MetaHybrid || files[i].length%pieceLength == 0 {
+		return 0
+	}
+	// no padding is needed if no more data follows
+	for _, f := range files[i+1:] {
+		if f.length > 0 {
+			return pieceLength - files[i].length%pieceLength
+		}
+	}
+	return 0
+}
+
+// readStreamPieces reads v1 pieces from the files as one continuous stream
+func readStreamPieces(files []sourceFile, pieceLength, totalLength int, jobs chan<- pieceBuf) error {
+	var readers []io.Reader
+	for _, f := range files {
+		if f.length == 0 {
+			continue
+		}
+		file, err := os.Open(f.diskPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// limit reads in case the file grew since it was walked
+		readers = append(readers, io.LimitReader(file, int64(f.length)))
+	}
+	stream := io.MultiReader(readers...)
+
+	numPieces := (totalLength + pieceLength - 1) / pieceLength
+	for i := 0; i < numPieces; i++ {
+		length := pieceLength
+		if i == numPieces-1 {
+			length = totalLength - pieceLength*(numPieces-1)
+		}
+		data := make([]byte, length)
+		_, err := io.ReadFull(stream, data)
+		if err != nil {
+			return fmt.Errorf("reading piece %d: %w", i, err)
+		}
+		jobs <- pieceBuf{index: i, data: data, file: -1}
+	}
+
+	return nil
+}
+
+// readFilePieces reads pieces that are aligned to the start of each file
+func readFilePieces(files []sourceFile, pieceLength int, version MetaVersion, jobs chan<- pieceBuf) error {
+	var index int
+	for i, f := range files {
+		if f.length == 0 {
+			continue
+		}
+		err := func() error {
+			file, err := os.Open(f.diskPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			for offset := 0; offset < f.length; offset += pieceLength {
+				length := min(pieceLength, f.length-offset)
+				data := make([]byte, length)
+				_, err := io.ReadFull(file, data)
+				if err != nil {
+					return fmt.Errorf("reading %s: %w", f.diskPath, err)
+				}
+
+				job := pieceBuf{index: index, data: data, file: i, block: offset / BlockSize}
+				// only the last piece of a file needs padding
+				if offset+length == f.length {
+					job.pad = padLength(files, i, pieceLength, version)
+				}
+				jobs <- job
+				index++
+			}
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package torrentparser
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// createTorrent creates a torrent of files in a "data" directory, returning
+// the path of the written .torrent
+func createTorrent(t *testing.T, files map[string][]byte, opts CreateOptions) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "data")
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return writeCreated(t, root, opts)
+}
+
+func writeCreated(t *testing.T, root string, opts CreateOptions) string {
+	t.Helper()
+	raw, err := Create(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pattern(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i*7)
+	}
+	return b
+}
+
+// merkleRoot is a plain recursive merkle root of leaves in a tree width
+// leaves wide, missing leaves are zero hashes
+func merkleRoot(leaves [][32]byte, width int) [32]byte {
+	if width == 1 {
+		if len(leaves) == 0 {
+			return [32]byte{}
+		}
+		return leaves[0]
+	}
+	half := width / 2
+	left := merkleRoot(leaves[:min(half, len(leaves))], half)
+	right := merkleRoot(leaves[min(half, len(leaves)):], half)
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+func blockLeaves(data []byte) [][32]byte {
+	var leaves [][32]byte
+	for ; len(data) > 0; data = data[min(BlockSize, len(data)):] {
+		leaves = append(leaves, sha256.Sum256(data[:min(BlockSize, len(data))]))
+	}
+	return leaves
+}
+
+func treeWidth(n int) int {
+	width := 1
+	for width < n {
+		width *= 2
+	}
+	return width
+}
+
+func TestMerkleTree(t *testing.T) {
+	for _, pieceLength := range []int{16 << 10, 32 << 10, 64 << 10} {
+		blocksPerPiece := pieceLength / BlockSize
+		for n := 1; n <= 9; n++ {
+			leaves := make([][32]byte, n)
+			for i := range leaves {
+				leaves[i] = sha256.Sum256([]byte{byte(i)})
+			}
+			root, layer := MerkleTree(leaves, pieceLength)
+			if want := merkleRoot(leaves, treeWidth(n)); root != want {
+				t.Errorf("%d leaves, %d byte pieces: root %x, want %x", n, pieceLength, root, want)
+			}
+			// a single block is its own root
+			if n == 1 && root != leaves[0] {
+				t.Errorf("single leaf root is %x", root)
+			}
+
+			if n <= blocksPerPiece {
+				if layer != nil {
+					t.Errorf("%d leaves, %d byte pieces: file of one piece has a piece layer", n, pieceLength)
+				}
+				continue
+			}
+			numPieces := (n + blocksPerPiece - 1) / blocksPerPiece
+			if len(layer) != numPieces {
+				t.Fatalf("%d leaves, %d byte pieces: %d piece hashes, want %d", n, pieceLength, len(layer), numPieces)
+			}
+			for i := range layer {
+				end := min((i+1)*blocksPerPiece, n)
+				if want := merkleRoot(leaves[i*blocksPerPiece:end], blocksPerPiece); layer[i] != want {
+					t.Errorf("%d leaves, %d byte pieces: piece %d hash %x, want %x", n, pieceLength, i, layer[i], want)
+				}
+			}
+			if PieceLayerRoot(layer, pieceLength) != root {
+				t.Errorf("%d leaves, %d byte pieces: piece layer doesn't hash to the root", n, pieceLength)
+			}
+		}
+	}
+}
+
+func TestBlockHashes(t *testing.T) {
+	for _, n := range []int{1, BlockSize - 1, BlockSize, BlockSize + 1, 3*BlockSize + 10} {
+		data := pattern(n, 9)
+		if got, want := BlockHashes(data), blockLeaves(data); !slices.Equal(got, want) {
+			t.Errorf("%d bytes: %d block hashes differ from the %d expected", n, len(got), len(want))
+		}
+	}
+}
+
+var createFiles = map[string][]byte{
+	"a":     pattern(5*BlockSize+100, 1), // six leaves, three pieces
+	"b":     pattern(100, 2),             // a single short block
+	"dir/c": pattern(BlockSize, 3),       // a single full block
+	"dir/d": pattern(2*BlockSize, 4),     // exactly one piece
+	"empty": {},
+}
+
+// checkCreated checks a created torrent's info hashes, and its v1 piece
+// hashes and v2 merkle roots against hashes computed from files
+func checkCreated(t *testing.T, path string, version MetaVersion, prefix string, files map[string][]byte) {
+	t.Helper()
+	tf, err := ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tf.InfoHashV2 != ([32]byte{}) != (version != MetaV1) {
+		t.Fatalf("v2 info hash %x", tf.InfoHashV2)
+	}
+	switch version {
+	case MetaV1, MetaHybrid:
+		if tf.InfoHash != sha1.Sum(tf.Metadata) {
+			t.Errorf("info hash %x isn't the SHA-1 of the info dict", tf.InfoHash)
+		}
+	case MetaV2:
+		if !bytes.Equal(tf.InfoHash[:], tf.InfoHashV2[:20]) {
+			t.Errorf("info hash %x isn't the truncated v2 one", tf.InfoHash)
+		}
+	}
+	if version != MetaV1 && tf.InfoHashV2 != sha256.Sum256(tf.Metadata) {
+		t.Errorf("v2 info hash %x isn't the SHA-256 of the info dict", tf.InfoHashV2)
+	}
+
+	var stream []byte
+	var names []string
+	for _, f := range tf.Files {
+		if f.Padding {
+			if version != MetaHybrid {
+				t.Errorf("%s torrent has padding file %s", version, f.Path)
+			}
+			stream = append(stream, make([]byte, f.Length)...)
+			continue
+		}
+		name := filepath.ToSlash(f.Path)
+		names = append(names, name)
+		rel, ok := strings.CutPrefix(name, prefix)
+		data, known := files[rel]
+		if !ok || !known || f.Length != len(data) {
+			t.Errorf("unexpected file %s of %d bytes", name, f.Length)
+			continue
+		}
+		// hybrid files start on a piece boundary
+		if version == MetaHybrid && len(data) > 0 && len(stream)%tf.PieceLength != 0 {
+			t.Errorf("%s starts %d bytes into a piece", name, len(stream)%tf.PieceLength)
+		}
+		stream = append(stream, data...)
+
+		if version == MetaV1 {
+			if f.PiecesRoot != ([32]byte{}) {
+				t.Errorf("%s has a pieces root in a v1 torrent", name)
+			}
+			continue
+		}
+		leaves := blockLeaves(data)
+		var want [32]byte
+		if len(leaves) > 0 {
+			want = merkleRoot(leaves, treeWidth(len(leaves)))
+		}
+		if f.PiecesRoot != want {
+			t.Errorf("%s has pieces root %x, want %x", name, f.PiecesRoot, want)
+		}
+		if numPieces := (len(data) + tf.PieceLength - 1) / tf.PieceLength; numPieces > 1 && len(f.PieceLayer) != numPieces {
+			t.Errorf("%s has %d piece layer hashes, want %d", name, len(f.PieceLayer), numPieces)
+		}
+	}
+	if len(names) != len(files) {
+		t.Errorf("torrent has files %q", names)
+	}
+	if tf.Length != len(stream) {
+		t.Errorf("length %d, want %d", tf.Length, len(stream))
+	}
+
+	if version == MetaV2 {
+		if len(tf.PieceHashes) != 0 {
+			t.Errorf("v2 torrent has %d v1 piece hashes", len(tf.PieceHashes))
+		}
+		return
+	}
+	var want [][20]byte
+	for ; len(stream) > 0; stream = stream[min(tf.PieceLength, len(stream)):] {
+		want = append(want, sha1.Sum(stream[:min(tf.PieceLength, len(stream))]))
+	}
+	if !slices.Equal(tf.PieceHashes, want) {
+		t.Errorf("%d piece hashes differ from the %d expected", len(tf.PieceHashes), len(want))
+	}
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	for _, version := range []MetaVersion{MetaV1, MetaV2, MetaHybrid} {
+		t.Run(version.String(), func(t *testing.T) {
+			path := createTorrent(t, createFiles, CreateOptions{Version: version, PieceLength: 32 << 10, Workers: 3})
+			checkCreated(t, path, version, "data/", createFiles)
+
+			// the same files make the same torrent
+			again := createTorrent(t, createFiles, CreateOptions{Version: version, PieceLength: 32 << 10, Workers: 1})
+			a, _ := ParseTorrentFile(path)
+			b, _ := ParseTorrentFile(again)
+			if a.InfoHash != b.InfoHash {
+				t.Errorf("info hash %x, then %x", a.InfoHash, b.InfoHash)
+			}
+		})
+	}
+}
+
+func TestCreateSingleFile(t *testing.T) {
+	for _, version := range []MetaVersion{MetaV1, MetaV2, MetaHybrid} {
+		for _, n := range []int{1, BlockSize, 3*BlockSize + 1} {
+			root := filepath.Join(t.TempDir(), "file.bin")
+			data := pattern(n, 5)
+			err := os.WriteFile(root, data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := writeCreated(t, root, CreateOptions{Version: version})
+			checkCreated(t, path, version, "", map[string][]byte{"file.bin": data})
+		}
+
+		// a directory with one file still keeps the directory
+		path := createTorrent(t, map[string][]byte{"only": pattern(100, 6)}, CreateOptions{Version: version})
+		checkCreated(t, path, version, "data/", map[string][]byte{"only": pattern(100, 6)})
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Create(dir, CreateOptions{}); err == nil {
+		t.Error("created a torrent of an empty directory")
+	}
+	err := os.WriteFile(filepath.Join(dir, "empty"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(dir, CreateOptions{}); err == nil {
+		t.Error("created a torrent with no data")
+	}
+	err = os.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pieceLength := range []int{1 << 13, 3 << 14} {
+		if _, err := Create(dir, CreateOptions{PieceLength: pieceLength}); err == nil {
+			t.Errorf("created a torrent with %d byte pieces", pieceLength)
+		}
+	}
+	if _, err := Create(dir, CreateOptions{Version: MetaHybrid + 1}); err == nil {
+		t.Error("created a torrent of an unknown version")
+	}
+}
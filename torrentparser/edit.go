@@ -0,0 +1,184 @@
+package torrentparser
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// Editor changes the keys of a .torrent file while keeping the bencoded info
+// dictionary byte for byte, so the info_hash only changes when an info field
+// is explicitly set with SetInfoField
+type Editor struct {
+	dict map[string]bencode.RawMessage
+}
+
+// NewEditor decodes the top level dictionary of a .torrent file
+func NewEditor(raw []byte) (*Editor, error) {
+	var dict map[string]bencode.RawMessage
+	err := bencode.DecodeBytes(raw, &dict)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling file: %w", err)
+	}
+	if _, ok := dict["info"]; !ok {
+		return nil, errors.New("torrent has no info dictionary")
+	}
+	return &Editor{dict: dict}, nil
+}
+
+// OpenEditor reads a .torrent file into an Editor
+func OpenEditor(path string) (*Editor, error) {
+	raw, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		return nil, err
+	}
+	return NewEditor(raw)
+}
+
+// Bytes bencodes the edited torrent, keys are written in sorted order
+func (e *Editor) Bytes() ([]byte, error) {
+	return bencode.EncodeBytes(e.dict)
+}
+
+// InfoHash returns the SHA-1 info_hash of the current info dictionary
+func (e *Editor) InfoHash() [20]byte {
+	return sha1.Sum(e.dict["info"])
+}
+
+// InfoHashV2 returns the SHA-256 info_hash of the current info dictionary,
+// which is only meaningful for v2 and hybrid torrents
+func (e *Editor) InfoHashV2() [32]byte {
+	return sha256.Sum256(e.dict["info"])
+}
+
+// Tiers returns the tracker tiers, falling back to `announce` per BEP0012
+func (e *Editor) Tiers() [][]string {
+	var tiers [][]string
+	if raw, ok := e.dict["announce-list"]; ok {
+		bencode.DecodeBytes(raw, &tiers)
+	}
+	if len(tiers) == 0 {
+		var announce string
+		if raw, ok := e.dict["announce"]; ok {
+			bencode.DecodeBytes(raw, &announce)
+		}
+		if announce != "" {
+			tiers = [][]string{{announce}}
+		}
+	}
+	return tiers
+}
+
+// SetTiers replaces every tracker, `announce` is set to the first tracker for
+// clients that don't support `announce-list`
+func (e *Editor) SetTiers(tiers [][]string) error {
+	var nonEmpty [][]string
+	for _, tier := range tiers {
+		if len(tier) != 0 {
+			nonEmpty = append(nonEmpty, tier)
+		}
+	}
+
+	delete(e.dict, "announce")
+	delete(e.dict, "announce-list")
+	if len(nonEmpty) == 0 {
+		return nil
+	}
+
+	err := e.Set("announce", nonEmpty[0][0])
+	if err != nil {
+		return err
+	}
+	// BEP0012, a single tracker doesn't need an announce-list
+	if len(nonEmpty) == 1 && len(nonEmpty[0]) == 1 {
+		return nil
+	}
+	return e.Set("announce-list", nonEmpty)
+}
+
+// WebSeeds returns the BEP0019 `url-list`
+func (e *Editor) WebSeeds() []string {
+	var urls stringList
+	if raw, ok := e.dict["url-list"]; ok {
+		bencode.DecodeBytes(raw, &urls)
+	}
+	return urls
+}
+
+// SetWebSeeds replaces the BEP0019 `url-list`
+func (e *Editor) SetWebSeeds(urls []string) error {
+	if len(urls) == 0 {
+		e.Delete("url-list")
+		return nil
+	}
+	return e.Set("url-list", urls)
+}
+
+// SetCreationDate sets the `creation date`, a zero time removes it
+func (e *Editor) SetCreationDate(t time.Time) error {
+	if t.IsZero() {
+		e.Delete("creation date")
+		return nil
+	}
+	return e.Set("creation date", t.Unix())
+}
+
+// Set bencodes value into a top level key, the info dictionary can only be
+// changed with SetInfoField
+func (e *Editor) Set(key string, value any) error {
+	if key == "info" {
+		return errors.New("the info dictionary can't be replaced")
+	}
+	raw, err := bencode.EncodeBytes(value)
+	if err != nil {
+		return fmt.Errorf("marshalling %q: %w", key, err)
+	}
+	e.dict[key] = raw
+	return nil
+}
+
+// Delete removes a top level key
+func (e *Editor) Delete(key string) {
+	if key == "info" {
+		return
+	}
+	delete(e.dict, key)
+}
+
+// SetInfoField sets a key in the info dictionary, or removes it if value is
+// nil. This re-encodes the info dictionary and changes the info_hash, which
+// makes the result a different torrent to every client and tracker
+func (e *Editor) SetInfoField(key string, value any) error {
+	switch key {
+	case "pieces", "piece length", "length", "files", "file tree", "meta version":
+		return fmt.Errorf("info field %q describes the data and can't be edited", key)
+	}
+
+	var info map[string]bencode.RawMessage
+	err := bencode.DecodeBytes(e.dict["info"], &info)
+	if err != nil {
+		return fmt.Errorf("unmarshalling info dict: %w", err)
+	}
+
+	if value == nil {
+		delete(info, key)
+	} else {
+		raw, err := bencode.EncodeBytes(value)
+		if err != nil {
+			return fmt.Errorf("marshalling %q: %w", key, err)
+		}
+		info[key] = raw
+	}
+
+	infoRaw, err := bencode.EncodeBytes(info)
+	if err != nil {
+		return fmt.Errorf("marshalling info dict: %w", err)
+	}
+	e.dict["info"] = infoRaw
+	return nil
+}
@@ -0,0 +1,88 @@
+package torrentparser
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditorKeepsInfo(t *testing.T) {
+	for _, version := range []MetaVersion{MetaV1, MetaV2, MetaHybrid} {
+		t.Run(version.String(), func(t *testing.T) {
+			path := createTorrent(t, map[string][]byte{"a": pattern(40<<10, 1), "dir/b": pattern(100, 2)}, CreateOptions{
+				Version:      version,
+				AnnounceList: [][]string{{"http://old.example/announce"}},
+				Comment:      "old",
+			})
+			before, err := ParseTorrentFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			editor, err := OpenEditor(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tiers := [][]string{{"http://a.example/announce", "udp://b.example:6969"}, {}, {"http://c.example/announce"}}
+			err = editor.SetTiers(tiers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = editor.Set("comment", "new")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = editor.SetWebSeeds([]string{"http://seed.example/"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the v1 info hash of pure v2 torrents is the truncated v2 one
+			if editor.InfoHash() != sha1.Sum(before.Metadata) || editor.InfoHashV2() != sha256.Sum256(before.Metadata) {
+				t.Fatal("editor has a different info hash")
+			}
+			raw, err := editor.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			edited := filepath.Join(t.TempDir(), "edited.torrent")
+			err = os.WriteFile(edited, raw, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after, err := ParseTorrentFile(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(after.Metadata, before.Metadata) {
+				t.Error("info dictionary bytes changed")
+			}
+			if after.InfoHash != before.InfoHash || after.InfoHashV2 != before.InfoHashV2 {
+				t.Errorf("info hash changed from %x/%x to %x/%x", before.InfoHash, before.InfoHashV2, after.InfoHash, after.InfoHashV2)
+			}
+			wantTiers := [][]string{tiers[0], tiers[2]}
+			if fmt.Sprint(after.Tiers) != fmt.Sprint(wantTiers) {
+				t.Errorf("tiers %q, want %q", after.Tiers, wantTiers)
+			}
+			if after.Comment != "new" || fmt.Sprint(after.WebSeeds) != "[http://seed.example/]" {
+				t.Errorf("comment %q and web seeds %q", after.Comment, after.WebSeeds)
+			}
+
+			// changing an info field is the only way to change the info hash
+			err = editor.SetInfoField("source", "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if editor.InfoHash() == sha1.Sum(before.Metadata) {
+				t.Error("setting an info field kept the info hash")
+			}
+			if editor.SetInfoField("pieces", "x") == nil {
+				t.Error("pieces could be edited")
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package torrentparser
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zeebo/bencode"
+)
+
+// MarshalTorrent bencodes the torrent as a .torrent file, with its trackers
+// and web seeds alongside the raw info dictionary so the info_hash is kept
+func (t TorrentFile) MarshalTorrent() ([]byte, error) {
+	if len(t.Metadata) == 0 {
+		return nil, errors.New("torrent has no metadata")
+	}
+
+	tiers := t.Tiers
+	if len(tiers) == 0 {
+		for _, tr := range t.TrackerURLs {
+			tiers = append(tiers, []string{tr})
+		}
+	}
+
+	btor := bencodeTorrent{
+		Comment:   t.Comment,
+		CreatedBy: t.CreatedBy,
+		URLList:   t.WebSeeds,
+		HTTPSeeds: t.HTTPSeeds,
+		Info:      t.Metadata,
+	}
+	// BEP0052 piece layers, which a magnet link's metadata doesn't have
+	for _, f := range t.Files {
+		if len(f.PieceLayer) == 0 {
+			continue
+		}
+		if btor.PieceLayers == nil {
+			btor.PieceLayers = map[string]string{}
+		}
+		layer := make([]byte, 0, len(f.PieceLayer)*32)
+		for _, h := range f.PieceLayer {
+			layer = append(layer, h[:]...)
+		}
+		btor.PieceLayers[string(f.PiecesRoot[:])] = string(layer)
+	}
+	if !t.CreationDate.IsZero() {
+		btor.CreationDate = t.CreationDate.Unix()
+	}
+	btor.setTiers(tiers)
+
+	raw, err := bencode.EncodeBytes(btor)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling torrent: %w", err)
+	}
+	return raw, nil
+}
+
+// setTiers sets the announce-list, with the first tracker as `announce` for
+// clients that don't support BEP0012
+func (btor *bencodeTorrent) setTiers(tiers [][]string) {
+	btor.Announce = ""
+	btor.AnnounceList = nil
+	for _, tier := range tiers {
+		if len(tier) == 0 || tier[0] == "" {
+			continue
+		}
+		if btor.Announce == "" {
+			btor.Announce = tier[0]
+		}
+		btor.AnnounceList = append(btor.AnnounceList, tier)
+	}
+	// a single tracker doesn't need an announce-list
+	if len(btor.AnnounceList) == 1 && len(btor.AnnounceList[0]) == 1 {
+		btor.AnnounceList = nil
+	}
+}
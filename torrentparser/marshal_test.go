@@ -0,0 +1,53 @@
+package torrentparser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMarshalTorrentKeepsInfo(t *testing.T) {
+	path := createTorrent(t, map[string][]byte{"a": pattern(40<<10, 3), "b": pattern(10, 4)}, CreateOptions{Version: MetaHybrid})
+	before, err := ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := before
+	edited.Tiers = [][]string{{"udp://a.example:1337"}, {"http://b.example/announce"}}
+	edited.Comment = "edited"
+	raw, err := edited.MarshalTorrent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(t.TempDir(), "marshalled.torrent")
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := ParseTorrentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after.Metadata, before.Metadata) || after.InfoHash != before.InfoHash {
+		t.Errorf("info hash changed from %x to %x", before.InfoHash, after.InfoHash)
+	}
+	// the v2 piece layers are kept, or the torrent doesn't parse
+	for i, f := range after.Files {
+		if fmt.Sprint(f.PieceLayer) != fmt.Sprint(before.Files[i].PieceLayer) {
+			t.Errorf("%s has piece layer %x, want %x", f.Path, f.PieceLayer, before.Files[i].PieceLayer)
+		}
+	}
+	if len(after.Files[0].PieceLayer) != 3 {
+		t.Errorf("%s has %d piece hashes, want 3", after.Files[0].Path, len(after.Files[0].PieceLayer))
+	}
+	if fmt.Sprint(after.Tiers) != fmt.Sprint(edited.Tiers) || after.Comment != "edited" {
+		t.Errorf("tiers %q and comment %q", after.Tiers, after.Comment)
+	}
+
+	if _, err := (TorrentFile{}).MarshalTorrent(); err == nil {
+		t.Error("torrent without metadata was marshalled")
+	}
+}
@@ -0,0 +1,105 @@
+package torrentparser
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// BlockSize is the size of the leaves of a BEP0052 merkle tree, the last
+// block of a file may be shorter
+const BlockSize = 16384 // 16KiB
+
+// BlockHashes SHA-256 hashes every 16KiB block of data, which are the
+// leaves of a file's merkle tree
+func BlockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for start := 0; start < len(data); start += BlockSize {
+		end := min(start+BlockSize, len(data))
+		hashes = append(hashes, sha256.Sum256(data[start:end]))
+	}
+	return hashes
+}
+
+// MerkleTree builds the merkle tree of a file from its block hashes
+//
+// It returns the `pieces root` of the file and, for files larger than one piece,
+// the layer of the tree where each hash covers pieceLength bytes which is what
+// goes in `piece layers`. Leaves beyond the end of the file are zero hashes
+func MerkleTree(leaves [][32]byte, pieceLength int) (root [32]byte, layer [][32]byte) {
+	if len(leaves) == 0 {
+		return root, nil
+	}
+
+	blocksPerPiece := pieceLength / BlockSize
+	numLeaves := 1
+	for numLeaves < len(leaves) {
+		numLeaves *= 2
+	}
+	numPieces := (len(leaves) + blocksPerPiece - 1) / blocksPerPiece
+
+	nodes := make([][32]byte, numLeaves)
+	copy(nodes, leaves)
+	for covered := 1; len(nodes) > 1; covered *= 2 {
+		if covered == blocksPerPiece && len(leaves) > blocksPerPiece {
+			layer = append([][32]byte(nil), nodes[:numPieces]...)
+		}
+		nodes = hashLayer(nodes)
+	}
+
+	return nodes[0], layer
+}
+
+// PieceLayerRoot computes the `pieces root` from a piece layer, padding it with
+// the hashes of zero filled pieces up to a power of two
+func PieceLayerRoot(layer [][32]byte, pieceLength int) [32]byte {
+	numNodes := 1
+	for numNodes < len(layer) {
+		numNodes *= 2
+	}
+
+	// the hash of a piece that is entirely past the end of the file
+	padding := make([][32]byte, pieceLength/BlockSize)
+	for len(padding) > 1 {
+		padding = hashLayer(padding)
+	}
+
+	nodes := make([][32]byte, numNodes)
+	copy(nodes, layer)
+	for i := len(layer); i < numNodes; i++ {
+		nodes[i] = padding[0]
+	}
+	for len(nodes) > 1 {
+		nodes = hashLayer(nodes)
+	}
+	return nodes[0]
+}
+
+// hashLayer hashes each pair of nodes into the layer above them
+func hashLayer(nodes [][32]byte) [][32]byte {
+	parents := make([][32]byte, len(nodes)/2)
+	var pair [64]byte
+	for i := range parents {
+		copy(pair[:32], nodes[2*i][:])
+		copy(pair[32:], nodes[2*i+1][:])
+		parents[i] = sha256.Sum256(pair[:])
+	}
+	return parents
+}
+
+// splitPieceLayer splits a `piece layers` value into individual hashes and
+// checks that they hash up to the file's root
+func splitPieceLayer(raw string, file File, pieceLength int) ([][32]byte, error) {
+	numPieces := (file.Length + pieceLength - 1) / pieceLength
+	if len(raw) != numPieces*sha256.Size {
+		return nil, fmt.Errorf("piece layer for %q has %d bytes, want %d", file.Path, len(raw), numPieces*sha256.Size)
+	}
+
+	layer := make([][32]byte, numPieces)
+	for i := range layer {
+		copy(layer[i][:], raw[i*sha256.Size:])
+	}
+	if PieceLayerRoot(layer, pieceLength) != file.PiecesRoot {
+		return nil, fmt.Errorf("piece layer for %q does not match pieces root", file.Path)
+	}
+	return layer, nil
+}
@@ -3,6 +3,7 @@ package torrentparser
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/zeebo/bencode"
 )
@@ -14,6 +15,7 @@ func ParseTorrentFile(path string) (TorrentFile, error) {
 	if err != nil {
 		return TorrentFile{}, err
 	}
+	defer f.Close()
 
 	var btor bencodeTorrent
 	err = bencode.NewDecoder(f).Decode(&btor)
@@ -26,12 +28,24 @@ func ParseTorrentFile(path string) (TorrentFile, error) {
 		trackerURLs = append(trackerURLs, list...)
 	}
 	// BEP0012, only use `announce` if `announce-list` is not present
-	if len(trackerURLs) == 0 {
+	if len(trackerURLs) == 0 && btor.Announce != "" {
 		trackerURLs = append(trackerURLs, btor.Announce)
 	}
 	tf := TorrentFile{
 		TrackerURLs: trackerURLs,
 		Name:        path,
+		Tiers:       btor.AnnounceList,
+		WebSeeds:    btor.URLList,
+		HTTPSeeds:   btor.HTTPSeeds,
+		Nodes:       btor.Nodes,
+		Comment:     btor.Comment,
+		CreatedBy:   btor.CreatedBy,
+	}
+	if btor.CreationDate != 0 {
+		tf.CreationDate = time.Unix(btor.CreationDate, 0)
+	}
+	if len(tf.Tiers) == 0 && btor.Announce != "" {
+		tf.Tiers = [][]string{{btor.Announce}}
 	}
 
 	err = tf.AppendMetadata(btor.Info)
@@ -39,5 +53,10 @@ func ParseTorrentFile(path string) (TorrentFile, error) {
 		return TorrentFile{}, fmt.Errorf("parsing metadata: %w", err)
 	}
 
+	err = tf.appendPieceLayers(btor.PieceLayers)
+	if err != nil {
+		return TorrentFile{}, fmt.Errorf("parsing piece layers: %w", err)
+	}
+
 	return tf, nil
 }
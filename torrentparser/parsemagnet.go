@@ -22,8 +22,19 @@ func ParseMagnetLink(magnetLink string) (TorrentFile, error) {
 	}
 
 	var infoHash [20]byte
+	var infoHashV2 [32]byte
 	for _, xt := range xts {
-		// todo implement v2 btmh (multihash)
+		// BEP0052 v2 info hashes are a sha2-256 multihash, 0x12 0x20 prefixed
+		if strings.HasPrefix(xt, "urn:btmh:") {
+			raw, err := hex.DecodeString(strings.TrimPrefix(xt, "urn:btmh:"))
+			if err != nil {
+				return TorrentFile{}, fmt.Errorf("hex decoding xt field: %w", err)
+			}
+			if len(raw) != 34 || raw[0] != 0x12 || raw[1] != 0x20 {
+				return TorrentFile{}, fmt.Errorf("unsupported multihash in xt field")
+			}
+			copy(infoHashV2[:], raw[2:])
+		}
 		if strings.HasPrefix(xt, "urn:btih:") {
 			encodedInfoHash := strings.TrimPrefix(xt, "urn:btih:")
 
@@ -46,18 +57,61 @@ func ParseMagnetLink(magnetLink string) (TorrentFile, error) {
 		}
 	}
 
+	// pure v2 magnets only have the v2 info hash, which is truncated for the swarm
+	if bytes.Equal(infoHash[:], make([]byte, 20)) {
+		copy(infoHash[:], infoHashV2[:])
+	}
 	if bytes.Equal(infoHash[:], make([]byte, 20)) {
-		return TorrentFile{}, fmt.Errorf("no xt field found, btmh unimplemented")
+		return TorrentFile{}, fmt.Errorf("no xt field found")
 	}
 
+	// links without trackers rely on the DHT for peers
 	trs := u.Query()["tr"]
-	if len(trs) == 0 {
-		return TorrentFile{}, fmt.Errorf("no tracker urls in magnet link, DHT/PEX unimplemented")
+
+	// each `tr` is its own tier, BEP0009 has no way to group trackers
+	var tiers [][]string
+	for _, tr := range trs {
+		tiers = append(tiers, []string{tr})
 	}
 
 	return TorrentFile{
 		InfoHash:    infoHash,
+		InfoHashV2:  infoHashV2,
 		TrackerURLs: trs,
 		Name:        u.Query().Get("dn"),
+		Tiers:       tiers,
+		WebSeeds:    u.Query()["ws"],
 	}, nil
 }
+
+// MagnetLink returns the magnet link equivalent of the torrent
+func (t TorrentFile) MagnetLink() string {
+	v := url.Values{}
+	if t.Name != "" {
+		v.Add("dn", t.Name)
+	}
+	for _, tr := range t.TrackerURLs {
+		if tr != "" {
+			v.Add("tr", tr)
+		}
+	}
+	for _, ws := range t.WebSeeds {
+		v.Add("ws", ws)
+	}
+
+	// `xt` is left unescaped, most clients won't parse "urn%3Abtih%3A"
+	// pure v2 torrents have no v1 info hash, only the truncated v2 one
+	hasV2 := t.InfoHashV2 != [32]byte{}
+	var xts []string
+	if !hasV2 || !bytes.Equal(t.InfoHash[:], t.InfoHashV2[:20]) {
+		xts = append(xts, "xt=urn:btih:"+hex.EncodeToString(t.InfoHash[:]))
+	}
+	if hasV2 {
+		xts = append(xts, "xt=urn:btmh:1220"+hex.EncodeToString(t.InfoHashV2[:]))
+	}
+	link := "magnet:?" + strings.Join(xts, "&")
+	if len(v) > 0 {
+		link += "&" + v.Encode()
+	}
+	return link
+}
@@ -0,0 +1,61 @@
+package torrentparser
+
+// FileSpan is the part of a file that a range of the torrent's data covers
+type FileSpan struct {
+	FileIndex int // index into TorrentFile.Files
+	Offset    int // offset of the span within the file
+	Length    int
+}
+
+// FileSpans maps length bytes starting at offset in the torrent, as if every
+// file was concatenated, onto the files they are stored in. Padding and empty
+// files are included like any other file
+func (t TorrentFile) FileSpans(offset, length int) []FileSpan {
+	var spans []FileSpan
+	var fileStart int
+	for i, f := range t.Files {
+		fileEnd := fileStart + f.Length
+		if length <= 0 || fileStart >= offset+length {
+			break
+		}
+		if fileEnd > offset && f.Length > 0 {
+			start := max(offset, fileStart)
+			end := min(offset+length, fileEnd)
+			spans = append(spans, FileSpan{
+				FileIndex: i,
+				Offset:    start - fileStart,
+				Length:    end - start,
+			})
+		}
+		fileStart = fileEnd
+	}
+	return spans
+}
+
+// PieceSize returns the length of a piece, all pieces are the full size
+// except for the last piece
+func (t TorrentFile) PieceSize(index int) int {
+	if index == len(t.PieceHashes)-1 {
+		return t.Length - t.PieceLength*(len(t.PieceHashes)-1)
+	}
+	return t.PieceLength
+}
+
+// PieceSpans maps a piece onto the files it is stored in
+func (t TorrentFile) PieceSpans(index int) []FileSpan {
+	return t.FileSpans(index*t.PieceLength, t.PieceSize(index))
+}
+
+// FilePieces returns the range of pieces [first, last] that overlap a file,
+// last is less than first for empty files
+func (t TorrentFile) FilePieces(fileIndex int) (first, last int) {
+	var fileStart int
+	for _, f := range t.Files[:fileIndex] {
+		fileStart += f.Length
+	}
+	length := t.Files[fileIndex].Length
+	if length == 0 || t.PieceLength == 0 {
+		return 0, -1
+	}
+	return fileStart / t.PieceLength, (fileStart + length - 1) / t.PieceLength
+}
@@ -2,7 +2,10 @@ package torrentparser
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	// bencode
 	"github.com/zeebo/bencode"
@@ -14,31 +17,70 @@ import (
 // 20-bytes SHA1 hashes are formatted as 20-byte arrays for easy
 // comparison of piece hashes
 //
-// The Infohash is the SHA1 hash of the info dictionary
+// The Infohash is the SHA1 hash of the info dictionary. For BEP0052 v2
+// torrents InfoHashV2 is the SHA-256 hash, and pure v2 torrents (which have no
+// PieceHashes) use it truncated to 20 bytes as the InfoHash
 type TorrentFile struct {
 	TrackerURLs []string
 	InfoHash    [20]byte
+	InfoHashV2  [32]byte
+	MetaVersion int // 1 for v1 torrents, 2 for both v2 and hybrid torrents
 	PieceHashes [][20]byte
 	PieceLength int
 	Files       []File
 	Length      int
 	Name        string
+	// Metadata is the raw bencoded info dictionary, nil for a magnet link
+	// until AppendMetadata is called
+	Metadata []byte
+
+	// optional fields that aren't needed to download the torrent
+	Tiers        [][]string // BEP0012 tracker tiers, flattened into TrackerURLs
+	WebSeeds     []string   // BEP0019 `url-list`
+	HTTPSeeds    []string   // BEP0017 `httpseeds`
+	Nodes        []string   // BEP0005 DHT nodes as "host:port"
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	Private      bool // BEP0027, peers must only come from the trackers
+	Source       string
 }
 
 // File contains metadata about the downloaded files, such as length and path
+//
+// The attribute fields are set from the BEP0047 `attr` string, padding files
+// are only present to align pieces and are never written to disk
 type File struct {
-	Length   int
-	Path     string
-	SHA1Hash string
-	MD5Hash  string
+	Length      int
+	Path        string
+	SHA1Hash    string
+	MD5Hash     string
+	Padding     bool   // "p" attr or a file inside the `.pad/` directory
+	Executable  bool   // "x" attr
+	Hidden      bool   // "h" attr
+	SymlinkPath string // "l" attr, link target relative to the torrent root
+	// BEP0052 merkle root of the file's 16KiB blocks, zero for v1 and empty files
+	PiecesRoot [32]byte
+	// BEP0052 hashes of each piece of the file, only present in .torrent files
+	// for files larger than one piece
+	PieceLayer [][32]byte
 }
 
 // serialization struct the represents the structure of a .torrent file
 // it is not immediately usable, so it can be converted to a TorrentFile struct
 type bencodeTorrent struct {
 	// URL of tracker server to get peers from
-	Announce     string     `bencode:"announce"`
-	AnnounceList [][]string `bencode:"announce-list"`
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	// optional informational fields, not covered by the info_hash
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	URLList      stringList `bencode:"url-list,omitempty"`  // BEP0019 web seeds
+	HTTPSeeds    stringList `bencode:"httpseeds,omitempty"` // BEP0017 http seeds
+	Nodes        nodeList   `bencode:"nodes,omitempty"`     // BEP0005 DHT nodes
+	// BEP0052 piece layers of each file, keyed by the file's pieces root
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
 	// Info is parsed as a RawMessage to ensure that the final info_hash is
 	// correct even in the case of the info dictionary being an unexpected shape
 	Info bencode.RawMessage `bencode:"info"`
@@ -47,16 +89,78 @@ type bencodeTorrent struct {
 // Only Length OR Files will be present per BEP0003
 // spec: http://bittorrent.org/beps/bep_0003.html#info-dictionary
 type bencodeInfo struct {
-	Pieces      string `bencode:"pieces"`       // binary blob of all SHA1 hash of each piece
-	PieceLength int    `bencode:"piece length"` // length in bytes of each piece
-	Name        string `bencode:"name"`         // Name of file (or folder if there are multiple files)
-	Length      int    `bencode:"length"`       // total length of file (in single file case)
-	Files       []struct {
-		Length   int      `bencode:"length"` // length of this file
-		Path     []string `bencode:"path"`   // list of subdirectories, last element is file name
-		SHA1Hash string   `bencode:"sha1"`   // optional, to validate this file
-		MD5Hash  string   `bencode:"md5"`    // optional, to validate this file
-	} `bencode:"files"`
+	Pieces      string        `bencode:"pieces,omitempty"`  // binary blob of all SHA1 hash of each piece
+	PieceLength int           `bencode:"piece length"`      // length in bytes of each piece
+	Name        string        `bencode:"name"`              // Name of file (or folder if there are multiple files)
+	Length      int           `bencode:"length,omitempty"`  // total length of file (in single file case)
+	Attr        string        `bencode:"attr,omitempty"`    // BEP0047 attributes (in single file case)
+	Private     int           `bencode:"private,omitempty"` // BEP0027, 1 disables DHT and PEX
+	Source      string        `bencode:"source,omitempty"`  // makes the info_hash unique per tracker
+	Files       []bencodeFile `bencode:"files,omitempty"`
+	// BEP0052 v2 fields, hybrid torrents have these along with the v1 fields
+	MetaVersion int                `bencode:"meta version,omitempty"`
+	FileTree    bencode.RawMessage `bencode:"file tree,omitempty"`
+}
+
+// bencodeFileTreeEntry is the value of the empty key that marks a file in a
+// BEP0052 file tree, directories are nested dictionaries of their contents
+type bencodeFileTreeEntry struct {
+	Length      int      `bencode:"length"`
+	PiecesRoot  string   `bencode:"pieces root,omitempty"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+}
+
+type bencodeFile struct {
+	Length      int      `bencode:"length"`                 // length of this file
+	Path        []string `bencode:"path"`                   // list of subdirectories, last element is file name
+	SHA1Hash    string   `bencode:"sha1,omitempty"`         // optional, to validate this file
+	MD5Hash     string   `bencode:"md5,omitempty"`          // optional, to validate this file
+	Attr        string   `bencode:"attr,omitempty"`         // BEP0047 attributes, any of "l", "x", "h", "p"
+	SymlinkPath []string `bencode:"symlink path,omitempty"` // BEP0047 link target when attr contains "l"
+}
+
+// stringList is a list of strings that may also be bencoded as a single string,
+// which is common for `url-list` when a torrent only has one web seed
+type stringList []string
+
+func (l *stringList) UnmarshalBencode(raw []byte) error {
+	var single string
+	if err := bencode.DecodeBytes(raw, &single); err == nil {
+		if single != "" {
+			*l = stringList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := bencode.DecodeBytes(raw, &list); err != nil {
+		return fmt.Errorf("decoding string list: %w", err)
+	}
+	*l = list
+	return nil
+}
+
+// nodeList is a list of DHT nodes, each bencoded as a [host, port] list
+type nodeList []string
+
+func (l *nodeList) UnmarshalBencode(raw []byte) error {
+	var nodes [][]any
+	if err := bencode.DecodeBytes(raw, &nodes); err != nil {
+		return fmt.Errorf("decoding nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if len(node) != 2 {
+			continue
+		}
+		host, ok := node[0].(string)
+		port, ok2 := node[1].(int64)
+		if !ok || !ok2 {
+			continue
+		}
+		*l = append(*l, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+	}
+	return nil
 }
 
 // New returns a new TorrentFile
@@ -5,17 +5,80 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
 	"github.com/zeebo/bencode"
 )
 
+// Event is the reason for an announce, sent to let the tracker know when a
+// download starts, completes and stops
+type Event int
+
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+var eventStrings = map[Event]string{
+	EventNone:      "",
+	EventCompleted: "completed",
+	EventStarted:   "started",
+	EventStopped:   "stopped",
+}
+
+func (e Event) String() string {
+	return eventStrings[e]
+}
+
+// Announce is what a client tells a tracker about a download
+type Announce struct {
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       int
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+
+	Logger *slog.Logger // logs the announce at debug level, nil discards the logs
+}
+
 // GetPeers will attempt to contact the tracker and return a list of peers
 func GetPeers(trackerURL string, infoHash, peerID [20]byte, port int) ([]net.TCPAddr, error) {
+	return AnnounceContext(context.Background(), trackerURL, Announce{
+		InfoHash: infoHash,
+		PeerID:   peerID,
+		Port:     port,
+	})
+}
+
+// AnnounceContext sends an announce to the tracker and returns the peers it
+// answers with, canceling ctx abandons the request. A stopped event doesn't
+// need any peers back
+func AnnounceContext(ctx context.Context, trackerURL string, a Announce) ([]net.TCPAddr, error) {
+	log := logging.OrDiscard(a.Logger).With(logging.Tracker, trackerURL)
+	log.Debug("announcing", "event", a.Event.String(), "left", a.Left, "downloaded", a.Downloaded)
+
+	start := time.Now()
+	addrs, err := announce(ctx, trackerURL, a)
+	if err != nil {
+		log.Debug("announce failed", "err", err, "duration", time.Since(start))
+		return nil, err
+	}
+	log.Debug("announced", "peers", len(addrs), "duration", time.Since(start))
+	return addrs, nil
+}
+
+// announce sends an announce with the protocol of the tracker URL
+func announce(ctx context.Context, trackerURL string, a Announce) ([]net.TCPAddr, error) {
 	u, err := url.Parse(trackerURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse tracker url: %w", err)
@@ -23,9 +86,9 @@ func GetPeers(trackerURL string, infoHash, peerID [20]byte, port int) ([]net.TCP
 
 	switch u.Scheme {
 	case "http", "https":
-		return getPeersFromHTTPTracker(u, infoHash, peerID, port)
+		return getPeersFromHTTPTracker(ctx, u, a)
 	case "udp":
-		return getPeersFromUDPTracker(u, infoHash, peerID, port)
+		return getPeersFromUDPTracker(ctx, u, a)
 	default:
 		return nil, fmt.Errorf("unsupported tracker protocol: %s", u.Scheme)
 	}
@@ -51,21 +114,24 @@ type httpTrackerResponse struct {
 	Event      string `bencode:"event"`
 }
 
-func getPeersFromHTTPTracker(u *url.URL, infoHash, peerID [20]byte, port int) ([]net.TCPAddr, error) {
+func getPeersFromHTTPTracker(ctx context.Context, u *url.URL, a Announce) ([]net.TCPAddr, error) {
 	v := url.Values{}
-	v.Add("info_hash", string(infoHash[:]))
-	v.Add("peer_id", string(peerID[:]))
-	v.Add("port", strconv.Itoa(port))
-	v.Add("uploaded", "0")
-	v.Add("downloaded", "0")
-	v.Add("left", "0")
+	v.Add("info_hash", string(a.InfoHash[:]))
+	v.Add("peer_id", string(a.PeerID[:]))
+	v.Add("port", strconv.Itoa(a.Port))
+	v.Add("uploaded", strconv.FormatInt(a.Uploaded, 10))
+	v.Add("downloaded", strconv.FormatInt(a.Downloaded, 10))
+	v.Add("left", strconv.FormatInt(a.Left, 10))
 	v.Add("compact", "1")
+	if a.Event != EventNone {
+		v.Add("event", a.Event.String())
+	}
 
 	// set url query params
 	u.RawQuery = v.Encode()
 
 	// context for 3 seconds timeout of http request
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// make http request
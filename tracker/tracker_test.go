@@ -0,0 +1,231 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+var testAnnounce = Announce{
+	InfoHash:   [20]byte{1, '&', '='},
+	PeerID:     [20]byte{'-', 'G', 'O'},
+	Port:       6881,
+	Uploaded:   1,
+	Downloaded: 2,
+	Left:       3,
+}
+
+func TestHTTPAnnounce(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{EventNone, ""},
+		{EventStarted, "started"},
+		{EventCompleted, "completed"},
+		{EventStopped, "stopped"},
+	}
+	for _, tt := range tests {
+		var events []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if q.Get("info_hash") != string(testAnnounce.InfoHash[:]) || q.Get("peer_id") != string(testAnnounce.PeerID[:]) ||
+				q.Get("port") != "6881" || q.Get("uploaded") != "1" || q.Get("downloaded") != "2" || q.Get("left") != "3" {
+				http.Error(w, "bad announce", http.StatusBadRequest)
+				return
+			}
+			events = append(events, fmt.Sprintf("%t %s", q.Has("event"), q.Get("event")))
+			raw, _ := bencode.EncodeBytes(compactHTTPTrackerResponse{Interval: 60, Peers: "\x7f\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x00\x50"})
+			w.Write(raw)
+		}))
+
+		a := testAnnounce
+		a.Event = tt.event
+		peers, err := AnnounceContext(context.Background(), srv.URL+"/announce", a)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.event, err)
+		}
+		if fmt.Sprint(peers) != "[{127.0.0.1 6881 } {10.0.0.2 80 }]" {
+			t.Errorf("%s: peers %v", tt.event, peers)
+		}
+		if want := fmt.Sprintf("[%t %s]", tt.want != "", tt.want); fmt.Sprint(events) != want {
+			t.Errorf("%s: tracker got events %q, want %s", tt.event, events, want)
+		}
+	}
+}
+
+func TestHTTPAnnounceVerbose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali60e5:peersld2:ip4:\x7f\x00\x00\x014:porti6881eeee"))
+	}))
+	defer srv.Close()
+	peers, err := AnnounceContext(context.Background(), srv.URL, testAnnounce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(peers) != "[{127.0.0.1 6881 }]" {
+		t.Errorf("peers %v", peers)
+	}
+}
+
+func TestHTTPAnnounceErrors(t *testing.T) {
+	for _, body := range []string{"d5:peers5:abcdee", "not bencode"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		_, err := AnnounceContext(context.Background(), srv.URL, testAnnounce)
+		srv.Close()
+		if err == nil {
+			t.Errorf("%q was accepted", body)
+		}
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := AnnounceContext(context.Background(), srv.URL, testAnnounce); err == nil {
+		t.Error("404 was accepted")
+	}
+	if _, err := AnnounceContext(context.Background(), "wss://tracker.example", testAnnounce); err == nil {
+		t.Error("unknown protocol was accepted")
+	}
+}
+
+func TestHTTPAnnounceCanceled(t *testing.T) {
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := AnnounceContext(ctx, srv.URL, testAnnounce)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want the ctx's", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("canceled announce took %s", took)
+	}
+}
+
+// udpTracker is a BEP0015 tracker answering announces with peers, it records
+// the event of every announce
+type udpTracker struct {
+	conn   *net.UDPConn
+	peers  []byte
+	events chan uint32
+	silent bool // never answers
+}
+
+func newUDPTracker(t *testing.T, peers []byte, silent bool) *udpTracker {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &udpTracker{conn: conn, peers: peers, events: make(chan uint32, 10), silent: silent}
+	t.Cleanup(func() { conn.Close() })
+	go tr.serve()
+	return tr
+}
+
+func (tr *udpTracker) url() string {
+	return "udp://" + tr.conn.LocalAddr().String()
+}
+
+func (tr *udpTracker) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := tr.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if tr.silent || n < 16 {
+			continue
+		}
+		msg := buf[:n]
+		action := binary.BigEndian.Uint32(msg[8:12])
+		resp := binary.BigEndian.AppendUint32(nil, action)
+		resp = append(resp, msg[12:16]...) // transaction id
+		switch udpMessageAction(action) {
+		case ConnectAction:
+			resp = binary.BigEndian.AppendUint64(resp, 42)
+		case AnnounceAction:
+			if n < 98 || binary.BigEndian.Uint64(msg[0:8]) != 42 {
+				continue
+			}
+			tr.events <- binary.BigEndian.Uint32(msg[80:84])
+			resp = binary.BigEndian.AppendUint32(resp, 60) // interval
+			resp = binary.BigEndian.AppendUint64(resp, 0)  // leechers and seeders
+			resp = append(resp, tr.peers...)
+		}
+		tr.conn.WriteToUDP(resp, addr)
+	}
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	tr := newUDPTracker(t, []byte("\x7f\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x00\x50\xff"), false)
+	a := testAnnounce
+	a.Event = EventStarted
+	peers, err := AnnounceContext(context.Background(), tr.url(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trailing partial peer is ignored
+	if fmt.Sprint(peers) != "[{127.0.0.1 6881 } {10.0.0.2 80 }]" {
+		t.Errorf("peers %v", peers)
+	}
+	if event := <-tr.events; event != 2 {
+		t.Errorf("started announce sent event %d", event)
+	}
+}
+
+func TestUDPAnnounceStopped(t *testing.T) {
+	tr := newUDPTracker(t, nil, false)
+
+	// only a stopped announce is fine with no peers
+	_, err := AnnounceContext(context.Background(), tr.url(), testAnnounce)
+	if err == nil {
+		t.Error("announce without peers succeeded")
+	}
+	if event := <-tr.events; event != 0 {
+		t.Errorf("announce sent event %d", event)
+	}
+
+	a := testAnnounce
+	a.Event = EventStopped
+	_, err = AnnounceContext(context.Background(), tr.url(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := <-tr.events; event != 3 {
+		t.Errorf("stopped announce sent event %d", event)
+	}
+}
+
+func TestUDPAnnounceCanceled(t *testing.T) {
+	tr := newUDPTracker(t, nil, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := AnnounceContext(ctx, tr.url(), testAnnounce)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want the ctx's", err)
+	}
+	// without the cancel it waits out the 3s connect deadline
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("canceled announce took %s", took)
+	}
+}
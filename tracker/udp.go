@@ -1,6 +1,7 @@
 package tracker
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"math/rand"
@@ -9,12 +10,13 @@ import (
 	"time"
 )
 
-func getPeersFromUDPTracker(u *url.URL, infoHash, peerID [20]byte, port int) ([]net.TCPAddr, error) {
-	udpClient, err := NewUDPClient(u, infoHash, peerID, port)
+func getPeersFromUDPTracker(ctx context.Context, u *url.URL, a Announce) ([]net.TCPAddr, error) {
+	udpClient, err := NewUDPClient(u, a.InfoHash, a.PeerID, a.Port)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create udp client: %w", err)
 	}
-	return udpClient.GetPeers()
+	defer udpClient.Conn.Close()
+	return udpClient.AnnounceContext(ctx, a)
 }
 
 // udpMessageAction is sent in BigEndian
@@ -74,13 +76,32 @@ func NewUDPClient(trackerURL *url.URL, infoHash, peerID [20]byte, port int) (*UD
 }
 
 func (u *UDPClient) GetPeers() ([]net.TCPAddr, error) {
+	return u.AnnounceContext(context.Background(), Announce{
+		InfoHash: u.InfoHash,
+		PeerID:   u.PeerID,
+		Port:     u.Port,
+	})
+}
+
+// AnnounceContext connects and sends an announce, canceling ctx closes the
+// connection to interrupt it
+func (u *UDPClient) AnnounceContext(ctx context.Context, a Announce) ([]net.TCPAddr, error) {
+	stop := context.AfterFunc(ctx, func() { u.Conn.Close() })
+	defer stop()
+
 	err := u.connect()
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	err = u.announce()
+	err = u.announce(a)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("failed to announce: %w", err)
 	}
 
@@ -126,7 +147,15 @@ func (u *UDPClient) connect() error {
 	return nil
 }
 
-func (u *UDPClient) announce() error {
+// udpEvents are the BEP0015 event codes, which differ from the Event order
+var udpEvents = map[Event]uint32{
+	EventNone:      0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+func (u *UDPClient) announce(a Announce) error {
 	announceMsg := make([]byte, 98)
 
 	binary.BigEndian.PutUint64(announceMsg[0:8], u.ConnectionID)
@@ -136,12 +165,12 @@ func (u *UDPClient) announce() error {
 	copy(announceMsg[16:36], u.InfoHash[:])
 	copy(announceMsg[36:56], u.PeerID[:])
 
-	binary.BigEndian.PutUint64(announceMsg[56:64], 0)             // downloaded
-	binary.BigEndian.PutUint64(announceMsg[64:72], 0)             // left, unknown to magnet links
-	binary.BigEndian.PutUint64(announceMsg[72:80], 0)             // uploaded
-	binary.BigEndian.PutUint32(announceMsg[80:84], 0)             // event 0:none; 1:completed; 2:started; 3:stopped
-	binary.BigEndian.PutUint32(announceMsg[84:88], 0)             // IP address, default
-	binary.BigEndian.PutUint32(announceMsg[88:92], rand.Uint32()) // key - for tracker statistics
+	binary.BigEndian.PutUint64(announceMsg[56:64], uint64(a.Downloaded)) // downloaded
+	binary.BigEndian.PutUint64(announceMsg[64:72], uint64(a.Left))       // left, unknown to magnet links
+	binary.BigEndian.PutUint64(announceMsg[72:80], uint64(a.Uploaded))   // uploaded
+	binary.BigEndian.PutUint32(announceMsg[80:84], udpEvents[a.Event])   // event 0:none; 1:completed; 2:started; 3:stopped
+	binary.BigEndian.PutUint32(announceMsg[84:88], 0)                    // IP address, default
+	binary.BigEndian.PutUint32(announceMsg[88:92], rand.Uint32())        // key - for tracker statistics
 
 	neg1 := -1
 	binary.BigEndian.PutUint32(announceMsg[92:96], uint32(neg1))   // num_want
@@ -168,7 +197,7 @@ func (u *UDPClient) announce() error {
 	}
 
 	var peers []net.TCPAddr
-	for i := 12; i < len(announceResp); i += 6 {
+	for i := 12; i+6 <= len(announceResp); i += 6 {
 		// parse 6 bytes for peer's ip (5 bytes) and port (2 bytes)
 		peers = append(peers, net.TCPAddr{
 			IP:   net.IP(announceResp[i : i+4]),
@@ -176,7 +205,8 @@ func (u *UDPClient) announce() error {
 		})
 	}
 
-	if len(peers) == 0 {
+	// a stopped announce is only a goodbye
+	if len(peers) == 0 && a.Event != EventStopped {
 		return fmt.Errorf("no peers found in announce response")
 	}
 
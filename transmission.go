@@ -0,0 +1,799 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// The daemon also serves the commonly used subset of Transmission's RPC
+// protocol at /transmission/rpc, so tools like transmission-remote work
+// against it: torrent-add, torrent-get, torrent-set (file selection,
+// priorities and download limits), torrent-start, torrent-stop,
+// torrent-remove, session-get and session-set (download limits). Pieces are
+// never uploaded, so upload limits are reported as disabled and can't be
+// enabled.
+// See https://github.com/transmission/transmission/blob/main/docs/rpc-spec.md
+
+// sessionIDHeader is the header of Transmission's CSRF protection, requests
+// without the current session ID are answered with 409 and the ID to retry
+// with
+const sessionIDHeader = "X-Transmission-Session-Id"
+
+const (
+	// rpcVersion is the version of the RPC protocol of Transmission 4.0,
+	// whose fields are served
+	rpcVersion        = 17
+	rpcVersionMinimum = 14
+	rpcVersionName    = "4.0.0 (bittorrent-client-go)"
+)
+
+// torrent status codes of the protocol
+const (
+	rpcStopped     = 0
+	rpcDownloading = 4
+)
+
+// rpcLocalError is the error code of a torrent that failed
+const rpcLocalError = 3
+
+// speed units of session-get, in bytes of a kB like Transmission's default
+const rpcSpeedBytes = 1000
+
+type rpcRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       any             `json:"tag,omitempty"`
+}
+
+type rpcResponse struct {
+	Result    string `json:"result"` // "success" or an error message
+	Arguments any    `json:"arguments"`
+	Tag       any    `json:"tag,omitempty"`
+}
+
+// rpcMethods are the supported RPC methods, the result is the response's
+// arguments
+var rpcMethods = map[string]func(s *apiServer, args json.RawMessage) (any, error){
+	"torrent-add":       (*apiServer).rpcTorrentAdd,
+	"torrent-get":       (*apiServer).rpcTorrentGet,
+	"torrent-set":       (*apiServer).rpcTorrentSet,
+	"torrent-start":     (*apiServer).rpcTorrentStart,
+	"torrent-start-now": (*apiServer).rpcTorrentStart,
+	"torrent-stop":      (*apiServer).rpcTorrentStop,
+	"torrent-remove":    (*apiServer).rpcTorrentRemove,
+	"session-get":       (*apiServer).rpcSessionGet,
+	"session-set":       (*apiServer).rpcSessionSet,
+}
+
+// newRPCSessionID returns a random session ID for the CSRF handshake
+func newRPCSessionID() string {
+	var id [24]byte
+	rand.Read(id[:])
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+func (s *apiServer) transmissionRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(sessionIDHeader, s.rpcSessionID)
+	if r.Header.Get(sessionIDHeader) != s.rpcSessionID {
+		http.Error(w, "409: Conflict, retry with the "+sessionIDHeader+" header of this response", http.StatusConflict)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "405: Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rpcRequest
+	// metainfo is base64, a third larger than the torrent file
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxUpload)).Decode(&req)
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeJSON(w, code, rpcResponse{Result: fmt.Sprintf("decoding request: %s", err), Arguments: struct{}{}})
+		return
+	}
+	resp := rpcResponse{Result: "success", Arguments: struct{}{}, Tag: req.Tag}
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		resp.Result = "method name not recognized"
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	args, err := method(s, req.Arguments)
+	if err != nil {
+		resp.Result = err.Error()
+	} else if args != nil {
+		resp.Arguments = args
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// decodeArgs decodes the arguments of a request, they're optional
+func decodeArgs(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// rpcID returns the numeric ID of a torrent, IDs are handed out the first time
+// a torrent is seen and never reused
+func (s *apiServer) rpcID(t *bittorrent.Torrent) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	id, ok := s.rpcIDs[t.InfoHash()]
+	if !ok {
+		s.nextRPCID++
+		id = s.nextRPCID
+		s.rpcIDs[t.InfoHash()] = id
+	}
+	return id
+}
+
+// rpcTorrents returns the torrents of an `ids` argument: absent for every
+// torrent, an ID, an info hash or a list of them, or "recently-active" for
+// the torrents that are running
+func (s *apiServer) rpcTorrents(ids json.RawMessage) ([]*bittorrent.Torrent, error) {
+	all := s.session.Torrents()
+	for _, t := range all {
+		// torrents added through the JSON API get an ID too
+		s.rpcID(t)
+	}
+	if len(ids) == 0 || string(ids) == "null" {
+		return all, nil
+	}
+
+	var selectors []any
+	var one any
+	err := json.Unmarshal(ids, &one)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ids: %w", err)
+	}
+	switch one := one.(type) {
+	case []any:
+		selectors = one
+	case string:
+		if one == "recently-active" {
+			var active []*bittorrent.Torrent
+			for _, t := range all {
+				state, _ := t.State()
+				if state == bittorrent.StateConnecting || state == bittorrent.StateDownloading {
+					active = append(active, t)
+				}
+			}
+			return active, nil
+		}
+		selectors = []any{one}
+	default:
+		selectors = []any{one}
+	}
+
+	var torrents []*bittorrent.Torrent
+	for _, t := range all {
+		infoHash := t.InfoHash()
+		hash := hex.EncodeToString(infoHash[:])
+		id := s.rpcID(t)
+		for _, sel := range selectors {
+			switch sel := sel.(type) {
+			case float64:
+				if int(sel) != id {
+					continue
+				}
+			case string:
+				if !strings.EqualFold(sel, hash) {
+					continue
+				}
+			default:
+				return nil, fmt.Errorf("invalid id %v", sel)
+			}
+			torrents = append(torrents, t)
+			break
+		}
+	}
+	return torrents, nil
+}
+
+func (s *apiServer) rpcTorrentAdd(raw json.RawMessage) (any, error) {
+	var args struct {
+		Filename    string `json:"filename"`
+		Metainfo    string `json:"metainfo"`
+		DownloadDir string `json:"download-dir"`
+		Paused      bool   `json:"paused"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+
+	var source string
+	var uploaded bool
+	switch {
+	case args.Metainfo != "":
+		metainfo, err := base64.StdEncoding.DecodeString(args.Metainfo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metainfo: %w", err)
+		}
+		if len(metainfo) > maxUpload {
+			return nil, fmt.Errorf("metainfo can't be larger than %d bytes", maxUpload)
+		}
+		source, err = s.saveUpload(bytes.NewReader(metainfo))
+		if err != nil {
+			return nil, err
+		}
+		uploaded = true
+	case strings.HasPrefix(args.Filename, "http://") || strings.HasPrefix(args.Filename, "https://"):
+		source, err = s.fetchTorrent(args.Filename)
+		if err != nil {
+			return nil, err
+		}
+		uploaded = true
+	case args.Filename != "":
+		// torrent files are read with environment variables expanded
+		if strings.Contains(args.Filename, "$") {
+			return nil, errors.New("filename can't contain $")
+		}
+		source, err = s.inDirs(args.Filename)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("no filename or metainfo specified")
+	}
+	if args.DownloadDir != "" {
+		_, err = s.inDirs(args.DownloadDir)
+		if err != nil {
+			if uploaded {
+				os.Remove(source)
+			}
+			return nil, err
+		}
+	}
+
+	// duplicates aren't an error in the protocol
+	torrent, err := torrentparser.New(source)
+	if err == nil {
+		if existing, ok := s.session.Torrent(torrent.InfoHash); ok {
+			if uploaded {
+				os.Remove(source)
+			}
+			return map[string]any{"torrent-duplicate": s.rpcAdded(existing)}, nil
+		}
+	}
+
+	outDir := args.DownloadDir
+	if outDir == "" {
+		outDir = s.outDir
+	}
+	t, err := s.addTorrent(source, outDir, uploaded)
+	if err != nil {
+		return nil, err
+	}
+	if args.Paused {
+		t.Pause()
+	}
+	return map[string]any{"torrent-added": s.rpcAdded(t)}, nil
+}
+
+// rpcAdded describes a torrent in the response of torrent-add
+func (s *apiServer) rpcAdded(t *bittorrent.Torrent) map[string]any {
+	infoHash := t.InfoHash()
+	return map[string]any{
+		"id":         s.rpcID(t),
+		"name":       t.Name(),
+		"hashString": hex.EncodeToString(infoHash[:]),
+	}
+}
+
+// fetchTimeout limits how long fetching a torrent file from a URL takes
+const fetchTimeout = 30 * time.Second
+
+// fetchTorrent downloads a torrent file from a URL into the upload directory
+func (s *apiServer) fetchTorrent(url string) (string, error) {
+	resp, err := s.fetch.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("fetching torrent: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching torrent: %s", resp.Status)
+	}
+	path, err := s.saveUpload(http.MaxBytesReader(nil, resp.Body, maxUpload))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return "", fmt.Errorf("fetching torrent: it's larger than %d bytes", maxUpload)
+	}
+	return path, err
+}
+
+// newFetchClient returns the client torrent files are fetched from URLs with.
+// Unless private is set it refuses to connect to loopback, private and link
+// local addresses, so torrent-add can't reach the services next to the
+// daemon. Addresses are checked as they're dialed, after names are resolved
+// and for every redirect
+func newFetchClient(private bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !private {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(addrPort.Addr()) {
+				return fmt.Errorf("refusing to connect to %s, it isn't a public address", addrPort.Addr())
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: fetchTimeout,
+		// a proxy would be dialed instead of the checked address
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// sharedAddrSpace is the address space of carrier-grade NAT (RFC 6598)
+var sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether an address is reachable on the internet
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddrSpace.Contains(addr)
+}
+
+func (s *apiServer) rpcTorrentGet(raw json.RawMessage) (any, error) {
+	var args struct {
+		IDs    json.RawMessage `json:"ids"`
+		Fields []string        `json:"fields"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := s.rpcTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]any{}
+	for _, t := range torrents {
+		fields := s.rpcTorrentFields(t)
+		if len(args.Fields) > 0 {
+			requested := map[string]any{}
+			for _, name := range args.Fields {
+				if v, ok := fields[name]; ok {
+					requested[name] = v
+				}
+			}
+			fields = requested
+		}
+		list = append(list, fields)
+	}
+	return map[string]any{"torrents": list}, nil
+}
+
+// rpcTorrentFields maps the state of a torrent onto the fields of torrent-get,
+// fields without an equivalent aren't included
+func (s *apiServer) rpcTorrentFields(t *bittorrent.Torrent) map[string]any {
+	infoHash := t.InfoHash()
+	state, err := t.State()
+	fields := map[string]any{
+		"id":                      s.rpcID(t),
+		"hashString":              hex.EncodeToString(infoHash[:]),
+		"name":                    t.Name(),
+		"downloadDir":             t.OutDir(),
+		"status":                  rpcStopped,
+		"error":                   0,
+		"errorString":             "",
+		"isFinished":              state == bittorrent.StateFinished,
+		"isStalled":               false,
+		"eta":                     -1,
+		"percentDone":             0.0,
+		"metadataPercentComplete": 0.0,
+		"sizeWhenDone":            int64(0),
+		"leftUntilDone":           int64(0),
+		"totalSize":               int64(0),
+		"haveValid":               int64(0),
+		"haveUnchecked":           0,
+		"downloadedEver":          int64(0),
+		"uploadedEver":            0,
+		"uploadRatio":             -1,
+		"rateDownload":            0,
+		"rateUpload":              0,
+		"uploadLimit":             0,
+		"uploadLimited":           false,
+		"peersConnected":          0,
+		"peersSendingToUs":        0,
+		"peersGettingFromUs":      0,
+		"webseedsSendingToUs":     0,
+		"queuePosition":           0,
+		"recheckProgress":         0,
+		"files":                   []any{},
+		"fileStats":               []any{},
+		"priorities":              []int{},
+		"wanted":                  []bool{},
+		"peers":                   []any{},
+		"trackers":                []any{},
+	}
+	download := t.DownloadLimit()
+	fields["downloadLimit"] = download / rpcSpeedBytes
+	fields["downloadLimited"] = download > 0
+	if state == bittorrent.StateConnecting || state == bittorrent.StateDownloading {
+		fields["status"] = rpcDownloading
+	}
+	if err != nil {
+		fields["error"] = rpcLocalError
+		fields["errorString"] = err.Error()
+	}
+
+	d := t.Download()
+	if d == nil {
+		// the metadata of a magnet link is still being fetched
+		return fields
+	}
+	stats := d.Stats()
+	var have int64
+	files := []any{}
+	fileStats := []any{}
+	priorities := []int{}
+	wanted := []bool{}
+	for _, f := range stats.Files {
+		if f.Padding {
+			// Transmission lists padding files as regular files
+			f.Priority = bittorrent.PrioritySkip
+		}
+		have += f.Completed
+		files = append(files, map[string]any{
+			"name":           filepath.ToSlash(f.Path),
+			"length":         f.Length,
+			"bytesCompleted": f.Completed,
+		})
+		fileStats = append(fileStats, map[string]any{
+			"bytesCompleted": f.Completed,
+			"wanted":         f.Priority != bittorrent.PrioritySkip,
+			"priority":       rpcPriority(f.Priority),
+		})
+		priorities = append(priorities, rpcPriority(f.Priority))
+		wanted = append(wanted, f.Priority != bittorrent.PrioritySkip)
+	}
+
+	var peers []any
+	var fromPeers, fromSeeds int
+	for _, p := range stats.Peers {
+		if p.Kind != bittorrent.SourcePeer {
+			fromSeeds++
+			continue
+		}
+		fromPeers++
+		host, port, _ := net.SplitHostPort(p.Addr)
+		peers = append(peers, map[string]any{
+			"address":           host,
+			"port":              port,
+			"clientName":        p.Client,
+			"rateToClient":      int64(p.DownloadRate),
+			"rateToPeer":        0,
+			"isDownloadingFrom": true,
+			"isUploadingTo":     false,
+			"flagStr":           "D",
+		})
+	}
+	if peers == nil {
+		peers = []any{}
+	}
+
+	trackers := []any{}
+	tiers := d.Torrent.Tiers
+	if len(tiers) == 0 {
+		for _, url := range d.Torrent.TrackerURLs {
+			tiers = append(tiers, []string{url})
+		}
+	}
+	for tier, urls := range tiers {
+		for _, url := range urls {
+			trackers = append(trackers, map[string]any{"id": len(trackers), "announce": url, "scrape": "", "tier": tier})
+		}
+	}
+
+	fields["metadataPercentComplete"] = 1.0
+	fields["totalSize"] = int64(d.Torrent.Length)
+	fields["sizeWhenDone"] = stats.WantedBytes
+	fields["leftUntilDone"] = stats.WantedBytes - stats.VerifiedBytes
+	fields["haveValid"] = have
+	fields["downloadedEver"] = stats.Downloaded
+	if stats.Downloaded > 0 {
+		fields["uploadRatio"] = 0
+	}
+	fields["rateDownload"] = int64(stats.DownloadRate)
+	fields["peersConnected"] = fromPeers
+	fields["peersSendingToUs"] = fromPeers
+	fields["webseedsSendingToUs"] = fromSeeds
+	if stats.WantedBytes > 0 {
+		fields["percentDone"] = float64(stats.VerifiedBytes) / float64(stats.WantedBytes)
+	} else {
+		fields["percentDone"] = 1.0
+	}
+	if fields["status"] == rpcDownloading {
+		if eta, ok := stats.ETA(); ok {
+			fields["eta"] = int64(eta.Seconds())
+		} else {
+			// unknown rather than not available
+			fields["eta"] = -2
+		}
+		fields["isStalled"] = len(stats.Peers) == 0
+	}
+	fields["pieceCount"] = len(stats.Pieces)
+	fields["pieceSize"] = d.Torrent.PieceLength
+	fields["pieces"] = rpcPieces(stats.Pieces)
+	fields["files"] = files
+	fields["fileStats"] = fileStats
+	fields["priorities"] = priorities
+	fields["wanted"] = wanted
+	fields["peers"] = peers
+	fields["trackers"] = trackers
+	return fields
+}
+
+// rpcPriority maps a priority onto Transmission's -1, 0 and 1, skipped files
+// are unwanted but keep a normal priority
+func rpcPriority(p bittorrent.Priority) int {
+	switch p {
+	case bittorrent.PriorityLow:
+		return -1
+	case bittorrent.PriorityHigh:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// rpcPieces is the base64 bitfield of the verified pieces
+func rpcPieces(pieces []bool) string {
+	bitfield := make([]byte, (len(pieces)+7)/8)
+	for i, have := range pieces {
+		if have {
+			bitfield[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(bitfield)
+}
+
+// rpcTorrentSet changes which files are downloaded, their priorities and the
+// download limit, other torrent-set arguments aren't supported and are
+// ignored. Enabling an upload limit fails
+func (s *apiServer) rpcTorrentSet(raw json.RawMessage) (any, error) {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		DownloadLimit   *int64          `json:"downloadLimit"`
+		DownloadLimited *bool           `json:"downloadLimited"`
+		UploadLimited   *bool           `json:"uploadLimited"`
+		FilesWanted     []int           `json:"files-wanted"`
+		FilesUnwanted   []int           `json:"files-unwanted"`
+		PriorityHigh    []int           `json:"priority-high"`
+		PriorityNormal  []int           `json:"priority-normal"`
+		PriorityLow     []int           `json:"priority-low"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	if args.UploadLimited != nil && *args.UploadLimited {
+		return nil, errUploadLimit
+	}
+	torrents, err := s.rpcTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	setsFiles := args.FilesWanted != nil || args.FilesUnwanted != nil ||
+		args.PriorityHigh != nil || args.PriorityNormal != nil || args.PriorityLow != nil
+	for _, t := range torrents {
+		t.SetDownloadLimit(rpcLimit(t.DownloadLimit(), args.DownloadLimit, args.DownloadLimited))
+		if !setsFiles {
+			continue
+		}
+		d := t.Download()
+		if d == nil {
+			return nil, fmt.Errorf("the files of %s aren't known until it has connected", t.Name())
+		}
+		// an empty list means every file
+		all := func(indexes []int, set bool) []int {
+			if !set || len(indexes) > 0 {
+				return indexes
+			}
+			indexes = make([]int, len(d.Torrent.Files))
+			for i := range indexes {
+				indexes[i] = i
+			}
+			return indexes
+		}
+		set := func(indexes []int, priority func(old bittorrent.Priority) bittorrent.Priority) error {
+			for _, i := range indexes {
+				if i < 0 || i >= len(d.Torrent.Files) {
+					return fmt.Errorf("file index %d out of range, torrent has %d files", i, len(d.Torrent.Files))
+				}
+				err := d.SetFilePriority(i, priority(d.FilePriority(i)))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		// unwanted files keep no priority, wanted ones start at normal
+		prioritize := func(p bittorrent.Priority) func(old bittorrent.Priority) bittorrent.Priority {
+			return func(old bittorrent.Priority) bittorrent.Priority {
+				if old == bittorrent.PrioritySkip {
+					return old
+				}
+				return p
+			}
+		}
+		err := errors.Join(
+			set(all(args.FilesUnwanted, args.FilesUnwanted != nil), func(bittorrent.Priority) bittorrent.Priority { return bittorrent.PrioritySkip }),
+			set(all(args.FilesWanted, args.FilesWanted != nil), func(old bittorrent.Priority) bittorrent.Priority {
+				if old == bittorrent.PrioritySkip {
+					return bittorrent.PriorityNormal
+				}
+				return old
+			}),
+			set(all(args.PriorityHigh, args.PriorityHigh != nil), prioritize(bittorrent.PriorityHigh)),
+			set(all(args.PriorityNormal, args.PriorityNormal != nil), prioritize(bittorrent.PriorityNormal)),
+			set(all(args.PriorityLow, args.PriorityLow != nil), prioritize(bittorrent.PriorityLow)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		// newly wanted files of a finished torrent still need downloading
+		if state, _ := t.State(); state == bittorrent.StateFinished && args.FilesWanted != nil {
+			t.Resume()
+		}
+	}
+	return nil, nil
+}
+
+func (s *apiServer) rpcTorrentStart(raw json.RawMessage) (any, error) {
+	return s.rpcEach(raw, (*bittorrent.Torrent).Resume)
+}
+
+func (s *apiServer) rpcTorrentStop(raw json.RawMessage) (any, error) {
+	return s.rpcEach(raw, (*bittorrent.Torrent).Pause)
+}
+
+// rpcEach calls action for every torrent of the ids argument
+func (s *apiServer) rpcEach(raw json.RawMessage, action func(t *bittorrent.Torrent)) (any, error) {
+	var args struct {
+		IDs json.RawMessage `json:"ids"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := s.rpcTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range torrents {
+		action(t)
+	}
+	return nil, nil
+}
+
+func (s *apiServer) rpcTorrentRemove(raw json.RawMessage) (any, error) {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := s.rpcTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, t := range torrents {
+		errs = append(errs, s.removeTorrent(t, args.DeleteLocalData))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *apiServer) rpcSessionGet(raw json.RawMessage) (any, error) {
+	config := s.session.Config()
+	var port int
+	if addr, ok := s.session.Addr().(*net.TCPAddr); ok {
+		port = addr.Port
+	}
+	units := map[string]any{
+		"speed-units":  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+		"speed-bytes":  rpcSpeedBytes,
+		"size-units":   []string{"kB", "MB", "GB", "TB"},
+		"size-bytes":   1000,
+		"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+		"memory-bytes": 1024,
+	}
+	return map[string]any{
+		"version":                  rpcVersionName,
+		"rpc-version":              rpcVersion,
+		"rpc-version-minimum":      rpcVersionMinimum,
+		"session-id":               s.rpcSessionID,
+		"download-dir":             s.outDir,
+		"peer-port":                port,
+		"peer-limit-global":        config.MaxConnections,
+		"peer-limit-per-torrent":   config.MaxPeersPerTorrent,
+		"speed-limit-down":         config.DownloadLimit / rpcSpeedBytes,
+		"speed-limit-down-enabled": config.DownloadLimit > 0,
+		"speed-limit-up":           0,
+		"speed-limit-up-enabled":   false,
+		"alt-speed-enabled":        s.schedule.status().Profile != "",
+		"dht-enabled":              s.session.DHT() != nil,
+		"pex-enabled":              false,
+		"lpd-enabled":              false,
+		"start-added-torrents":     true,
+		"units":                    units,
+	}, nil
+}
+
+// rpcSessionSet changes the download limit of every torrent together, other
+// session-set arguments aren't supported and are ignored. Enabling an upload
+// limit fails
+func (s *apiServer) rpcSessionSet(raw json.RawMessage) (any, error) {
+	var args struct {
+		SpeedLimitDown        *int64 `json:"speed-limit-down"`
+		SpeedLimitDownEnabled *bool  `json:"speed-limit-down-enabled"`
+		SpeedLimitUpEnabled   *bool  `json:"speed-limit-up-enabled"`
+	}
+	err := decodeArgs(raw, &args)
+	if err != nil {
+		return nil, err
+	}
+	if args.SpeedLimitUpEnabled != nil && *args.SpeedLimitUpEnabled {
+		return nil, errUploadLimit
+	}
+	s.session.SetDownloadLimit(rpcLimit(s.session.DownloadLimit(), args.SpeedLimitDown, args.SpeedLimitDownEnabled))
+	return nil, nil
+}
+
+// rpcDefaultSpeedLimit is the limit in kB/s of enabling a limit that was never
+// set, like Transmission's default
+const rpcDefaultSpeedLimit = 100
+
+// rpcLimit applies a limit in kB/s and whether it's enabled, either of which
+// can be left out, to a limit in bytes per second. A disabled limit is
+// unlimited and isn't remembered
+func rpcLimit(current int64, limit *int64, enabled *bool) int64 {
+	if enabled != nil && !*enabled {
+		return 0
+	}
+	if limit != nil && (enabled != nil || current > 0) {
+		return max(*limit, 0) * rpcSpeedBytes
+	}
+	if enabled != nil && current == 0 {
+		return rpcDefaultSpeedLimit * rpcSpeedBytes
+	}
+	return current
+}
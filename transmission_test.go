@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// rpc calls a method of the Transmission RPC and decodes the arguments of the
+// response into out unless it's nil, returning the result
+func (d *testDaemon) rpc(t *testing.T, method string, args any, out any) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{"method": method, "arguments": args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(sessionID string) *http.Response {
+		req, err := http.NewRequest("POST", d.url+"/transmission/rpc", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(sessionIDHeader, sessionID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := send(d.rpcSessionID)
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		d.rpcSessionID = resp.Header.Get(sessionIDHeader)
+		resp = send(d.rpcSessionID)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: got status %d", method, resp.StatusCode)
+	}
+	var rpcResp struct {
+		Result    string          `json:"result"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if err != nil {
+		t.Fatalf("%s: decoding response: %s", method, err)
+	}
+	if out != nil && rpcResp.Result == "success" {
+		err = json.Unmarshal(rpcResp.Arguments, out)
+		if err != nil {
+			t.Fatalf("%s: decoding arguments: %s", method, err)
+		}
+	}
+	return rpcResp.Result
+}
+
+type rpcAddedJSON struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	HashString string `json:"hashString"`
+}
+
+// rpcAdd adds a torrent file by its content
+func (d *testDaemon) rpcAdd(t *testing.T, raw []byte) (added, duplicate *rpcAddedJSON) {
+	t.Helper()
+	var resp struct {
+		Added     *rpcAddedJSON `json:"torrent-added"`
+		Duplicate *rpcAddedJSON `json:"torrent-duplicate"`
+	}
+	result := d.rpc(t, "torrent-add", map[string]any{"metainfo": base64.StdEncoding.EncodeToString(raw)}, &resp)
+	if result != "success" {
+		t.Fatalf("torrent-add: %s", result)
+	}
+	return resp.Added, resp.Duplicate
+}
+
+// otherTorrent is the torrent of d with another info hash
+func otherTorrent(t *testing.T, d *testDaemon) []byte {
+	t.Helper()
+	e, err := torrentparser.NewEditor(d.raw)
+	if err == nil {
+		err = e.SetInfoField("source", "other")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := e.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRPCSessionID(t *testing.T) {
+	d := newTestDaemon(t)
+	body := `{"method": "session-get"}`
+	resp, err := http.Post(d.url+"/transmission/rpc", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	sessionID := resp.Header.Get(sessionIDHeader)
+	if resp.StatusCode != http.StatusConflict || sessionID == "" {
+		t.Fatalf("got status %d and session id %q, want 409 and an id", resp.StatusCode, sessionID)
+	}
+
+	req, _ := http.NewRequest("POST", d.url+"/transmission/rpc", strings.NewReader(body))
+	req.Header.Set(sessionIDHeader, sessionID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var rpcResp struct {
+		Result    string         `json:"result"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if resp.StatusCode != http.StatusOK || rpcResp.Result != "success" || rpcResp.Arguments["session-id"] != sessionID {
+		t.Fatalf("got status %d and response %+v", resp.StatusCode, rpcResp)
+	}
+
+	req, _ = http.NewRequest("POST", d.url+"/transmission/rpc", strings.NewReader(body))
+	req.Header.Set(sessionIDHeader, "stale")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("got status %d with a wrong session id, want 409", resp.StatusCode)
+	}
+}
+
+func TestRPCTorrentAddDuplicate(t *testing.T) {
+	d := newTestDaemon(t)
+	added, _ := d.rpcAdd(t, d.raw)
+	if added == nil || added.ID != 1 || added.Name != "data" || len(added.HashString) != 40 {
+		t.Fatalf("got torrent-added %+v", added)
+	}
+	again, duplicate := d.rpcAdd(t, d.raw)
+	if again != nil || duplicate == nil || *duplicate != *added {
+		t.Fatalf("adding again: got torrent-added %+v and torrent-duplicate %+v", again, duplicate)
+	}
+	// the upload of the duplicate isn't kept
+	uploads, _ := os.ReadDir(d.uploadDir)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploaded files, want 1", len(uploads))
+	}
+}
+
+func TestRPCTorrentAddPaths(t *testing.T) {
+	d := newTestDaemon(t)
+	outside := filepath.Join(t.TempDir(), "other.torrent")
+	os.WriteFile(outside, d.raw, 0644)
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"file outside", map[string]any{"filename": outside}, "isn't in the directories"},
+		{"variable", map[string]any{"filename": "$HOME/x.torrent"}, "can't contain $"},
+		{"download dir outside", map[string]any{"filename": d.torrent, "download-dir": t.TempDir()}, "isn't in the directories"},
+		{"metainfo into a dir outside", map[string]any{"metainfo": base64.StdEncoding.EncodeToString(d.raw), "download-dir": t.TempDir()}, "isn't in the directories"},
+		{"too large", map[string]any{"metainfo": base64.StdEncoding.EncodeToString(make([]byte, maxUpload+1))}, "can't be larger"},
+	}
+	for _, tt := range tests {
+		if result := d.rpc(t, "torrent-add", tt.args, nil); !strings.Contains(result, tt.want) {
+			t.Errorf("%s: got result %q, want %q", tt.name, result, tt.want)
+		}
+	}
+	uploads, _ := os.ReadDir(d.uploadDir)
+	if len(uploads) != 0 {
+		t.Fatalf("got %d uploaded files, want none", len(uploads))
+	}
+
+	var resp struct {
+		Added *rpcAddedJSON `json:"torrent-added"`
+	}
+	result := d.rpc(t, "torrent-add", map[string]any{"filename": d.torrent, "download-dir": filepath.Join(d.outDir, "sub")}, &resp)
+	if result != "success" || resp.Added == nil {
+		t.Fatalf("adding a file in the directories: got %q", result)
+	}
+}
+
+func TestRPCTorrentGetFields(t *testing.T) {
+	d := newTestDaemon(t)
+	added, _ := d.rpcAdd(t, d.raw)
+
+	var resp struct {
+		Torrents []map[string]any `json:"torrents"`
+	}
+	result := d.rpc(t, "torrent-get", map[string]any{"fields": []string{"id", "name", "no-such-field"}}, &resp)
+	if result != "success" || len(resp.Torrents) != 1 {
+		t.Fatalf("got %q and %+v", result, resp.Torrents)
+	}
+	want := map[string]any{"id": float64(added.ID), "name": "data"}
+	if !reflect.DeepEqual(resp.Torrents[0], want) {
+		t.Fatalf("got fields %v, want %v", resp.Torrents[0], want)
+	}
+
+	// every field without a list
+	d.rpc(t, "torrent-get", map[string]any{}, &resp)
+	for _, field := range []string{"id", "hashString", "status", "percentDone", "wanted", "priorities", "downloadLimited", "uploadLimited"} {
+		if _, ok := resp.Torrents[0][field]; !ok {
+			t.Errorf("field %s is missing", field)
+		}
+	}
+}
+
+func TestRPCTorrentSet(t *testing.T) {
+	d := newTestDaemon(t)
+	added, _ := d.rpcAdd(t, d.raw)
+	d.waitFiles(t, added.HashString)
+
+	get := func() (wanted []bool, priorities []int, limit float64, limited bool) {
+		var resp struct {
+			Torrents []struct {
+				Wanted          []bool  `json:"wanted"`
+				Priorities      []int   `json:"priorities"`
+				DownloadLimit   float64 `json:"downloadLimit"`
+				DownloadLimited bool    `json:"downloadLimited"`
+			} `json:"torrents"`
+		}
+		d.rpc(t, "torrent-get", map[string]any{"ids": added.ID, "fields": []string{"wanted", "priorities", "downloadLimit", "downloadLimited"}}, &resp)
+		tr := resp.Torrents[0]
+		return tr.Wanted, tr.Priorities, tr.DownloadLimit, tr.DownloadLimited
+	}
+
+	result := d.rpc(t, "torrent-set", map[string]any{"ids": []int{added.ID}, "files-unwanted": []int{0}, "priority-high": []int{1}}, nil)
+	if result != "success" {
+		t.Fatalf("torrent-set: %s", result)
+	}
+	wanted, priorities, _, _ := get()
+	if !reflect.DeepEqual(wanted, []bool{false, true}) || !reflect.DeepEqual(priorities, []int{0, 1}) {
+		t.Fatalf("got wanted %v and priorities %v", wanted, priorities)
+	}
+
+	// an empty list is every file, unwanted files keep no priority
+	d.rpc(t, "torrent-set", map[string]any{"ids": added.ID, "priority-low": []int{}}, nil)
+	wanted, priorities, _, _ = get()
+	if !reflect.DeepEqual(wanted, []bool{false, true}) || !reflect.DeepEqual(priorities, []int{0, -1}) {
+		t.Fatalf("got wanted %v and priorities %v after lowering every file", wanted, priorities)
+	}
+	d.rpc(t, "torrent-set", map[string]any{"ids": added.ID, "files-wanted": []int{}}, nil)
+	wanted, priorities, _, _ = get()
+	if !reflect.DeepEqual(wanted, []bool{true, true}) || !reflect.DeepEqual(priorities, []int{0, -1}) {
+		t.Fatalf("got wanted %v and priorities %v after wanting every file", wanted, priorities)
+	}
+
+	if result := d.rpc(t, "torrent-set", map[string]any{"ids": added.ID, "files-wanted": []int{2}}, nil); !strings.Contains(result, "out of range") {
+		t.Fatalf("wanting a file out of range: got %q", result)
+	}
+
+	d.rpc(t, "torrent-set", map[string]any{"ids": added.ID, "downloadLimit": 50, "downloadLimited": true}, nil)
+	if _, _, limit, limited := get(); limit != 50 || !limited {
+		t.Fatalf("got download limit %v, limited %t", limit, limited)
+	}
+}
+
+func TestRPCTorrentIDs(t *testing.T) {
+	d := newTestDaemon(t)
+	first, _ := d.rpcAdd(t, d.raw)
+	second, _ := d.rpcAdd(t, otherTorrent(t, d))
+	if first == nil || second == nil || first.ID != 1 || second.ID != 2 {
+		t.Fatalf("got torrents %+v and %+v", first, second)
+	}
+
+	tests := []struct {
+		name string
+		ids  any // nil leaves ids out
+		want []int
+		err  bool
+	}{
+		{"every torrent", nil, []int{1, 2}, false},
+		{"an id", 2, []int{2}, false},
+		{"a list of ids", []int{2, 1}, []int{1, 2}, false},
+		{"a hash", second.HashString, []int{2}, false},
+		{"an upper case hash", strings.ToUpper(first.HashString), []int{1}, false},
+		{"ids and hashes", []any{1, second.HashString}, []int{1, 2}, false},
+		{"an unknown id", 3, nil, false},
+		{"a hash prefix", first.HashString[:8], nil, false},
+		{"recently active", "recently-active", []int{1, 2}, false},
+		{"an object", map[string]any{}, nil, true},
+		{"a list with a bool", []any{true}, nil, true},
+	}
+	for _, tt := range tests {
+		args := map[string]any{"fields": []string{"id"}}
+		if tt.ids != nil {
+			args["ids"] = tt.ids
+		}
+		var resp struct {
+			Torrents []struct {
+				ID int `json:"id"`
+			} `json:"torrents"`
+		}
+		result := d.rpc(t, "torrent-get", args, &resp)
+		if (result != "success") != tt.err {
+			t.Errorf("%s: got result %q", tt.name, result)
+			continue
+		}
+		var got []int
+		for _, tr := range resp.Torrents {
+			got = append(got, tr.ID)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got ids %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// stopped torrents aren't recently active
+	d.rpc(t, "torrent-stop", map[string]any{"ids": 1}, nil)
+	var resp struct {
+		Torrents []struct {
+			ID int `json:"id"`
+		} `json:"torrents"`
+	}
+	d.rpc(t, "torrent-get", map[string]any{"ids": "recently-active", "fields": []string{"id"}}, &resp)
+	if len(resp.Torrents) != 1 || resp.Torrents[0].ID != 2 {
+		t.Fatalf("got recently active %+v after stopping 1", resp.Torrents)
+	}
+}
+
+func TestRPCLimit(t *testing.T) {
+	on, off := true, false
+	limit := func(v int64) *int64 { return &v }
+	tests := []struct {
+		name    string
+		current int64
+		limit   *int64
+		enabled *bool
+		want    int64
+	}{
+		{"nothing", 5000, nil, nil, 5000},
+		{"disabled", 5000, limit(10), &off, 0},
+		{"disabled with a limit", 5000, nil, &off, 0},
+		{"enabled with a limit", 0, limit(10), &on, 10 * rpcSpeedBytes},
+		{"limit while enabled", 5000, limit(10), nil, 10 * rpcSpeedBytes},
+		// Transmission remembers a limit while disabled, it isn't here
+		{"limit while disabled", 0, limit(10), nil, 0},
+		{"enabled without a limit", 0, nil, &on, rpcDefaultSpeedLimit * rpcSpeedBytes},
+		{"enabled again", 5000, nil, &on, 5000},
+		{"negative", 0, limit(-1), &on, 0},
+	}
+	for _, tt := range tests {
+		if got := rpcLimit(tt.current, tt.limit, tt.enabled); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("publicAddr(%s) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestFetchTorrent(t *testing.T) {
+	raw := []byte("d4:infod4:name1:xee")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.torrent":
+			w.Write(raw)
+		case "/redirect.torrent":
+			http.Redirect(w, r, "/a.torrent", http.StatusFound)
+		case "/large.torrent":
+			w.Write(make([]byte, maxUpload+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// the test server is on loopback
+	s := &apiServer{uploadDir: t.TempDir(), fetch: newFetchClient(false)}
+	_, err := s.fetchTorrent(srv.URL + "/a.torrent")
+	if err == nil || !strings.Contains(err.Error(), "isn't a public address") {
+		t.Fatalf("fetching from loopback: got error %v", err)
+	}
+
+	s.fetch = newFetchClient(true)
+	if s.fetch.Timeout != fetchTimeout {
+		t.Fatalf("got timeout %s, want %s", s.fetch.Timeout, fetchTimeout)
+	}
+	path, err := s.fetchTorrent(srv.URL + "/redirect.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+	if !bytes.Equal(saved, raw) {
+		t.Fatalf("saved %q, want %q", saved, raw)
+	}
+	os.Remove(path)
+
+	_, err = s.fetchTorrent(srv.URL + "/large.torrent")
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("fetching a large file: got error %v", err)
+	}
+	_, err = s.fetchTorrent(srv.URL + "/missing.torrent")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("fetching a missing file: got error %v", err)
+	}
+	uploads, _ := os.ReadDir(s.uploadDir)
+	if len(uploads) != 0 {
+		t.Fatalf("got %d files left in the upload directory", len(uploads))
+	}
+}
+
+func TestFetchTorrentTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := &apiServer{uploadDir: t.TempDir(), fetch: newFetchClient(true)}
+	s.fetch.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := s.fetchTorrent(srv.URL + "/slow.torrent")
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("got error %v after %s", err, time.Since(start))
+	}
+}
+
+func TestRPCUploadLimit(t *testing.T) {
+	d := newTestDaemon(t)
+	added, _ := d.rpcAdd(t, d.raw)
+
+	// pieces are never uploaded, so upload limits are always off
+	tests := []struct {
+		method string
+		args   map[string]any
+		want   string
+	}{
+		{"torrent-set", map[string]any{"ids": added.ID, "uploadLimited": true, "uploadLimit": 10}, errUploadLimit.Error()},
+		{"torrent-set", map[string]any{"ids": added.ID, "uploadLimited": false}, "success"},
+		{"session-set", map[string]any{"speed-limit-up-enabled": true}, errUploadLimit.Error()},
+		{"session-set", map[string]any{"speed-limit-up": 10, "speed-limit-up-enabled": false}, "success"},
+	}
+	for _, tt := range tests {
+		if result := d.rpc(t, tt.method, tt.args, nil); result != tt.want {
+			t.Errorf("%s %v: got result %q, want %q", tt.method, tt.args, result, tt.want)
+		}
+	}
+
+	var session map[string]any
+	d.rpc(t, "session-get", nil, &session)
+	if session["speed-limit-up-enabled"] != false || session["speed-limit-up"] != float64(0) {
+		t.Fatalf("session-get has upload limit %v, enabled %v", session["speed-limit-up"], session["speed-limit-up-enabled"])
+	}
+}
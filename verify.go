@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/givxl33t/bittorrent-client-go/bittorrent"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// runVerify checks data on disk against a torrent without downloading anything,
+// any missing file, wrong size or failed hash is an error
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	workers := fs.Int("workers", 0, "number of hashing goroutines, 0 uses every CPU")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s verify [flags] <path to .torrent> <directory>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a torrent file and a directory")
+	}
+
+	torrent, err := torrentparser.ParseTorrentFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("parsing torrent: %w", err)
+	}
+
+	result, err := bittorrent.Verify(torrent, fs.Arg(1), *workers)
+	if err != nil {
+		return fmt.Errorf("verifying: %w", err)
+	}
+
+	var failedFiles int
+	for _, f := range result.Files {
+		if f.OK() {
+			continue
+		}
+		failedFiles++
+
+		if f.Missing {
+			fmt.Printf("%s: missing\n", f.Path)
+			continue
+		}
+		var problems []string
+		if f.Size != int64(f.WantSize) {
+			problems = append(problems, fmt.Sprintf("size %d, want %d", f.Size, f.WantSize))
+		}
+		if len(f.FailedPieces) != 0 {
+			problems = append(problems, fmt.Sprintf("%d failed pieces (%s)", len(f.FailedPieces), formatRanges(f.FailedPieces)))
+		}
+		if f.SHA1Mismatch {
+			problems = append(problems, "sha1 mismatch")
+		}
+		if f.MD5Mismatch {
+			problems = append(problems, "md5 mismatch")
+		}
+		fmt.Printf("%s: %s\n", f.Path, strings.Join(problems, ", "))
+	}
+
+	fmt.Printf("%d/%d pieces ok, %d/%d files ok\n",
+		result.PieceCount-result.FailedPieceCount, result.PieceCount,
+		len(result.Files)-failedFiles, len(result.Files),
+	)
+	if !result.OK() {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// formatRanges formats sorted piece indexes as compact ranges, "1-3,7"
+func formatRanges(indexes []int) string {
+	var parts []string
+	for i := 0; i < len(indexes); {
+		j := i
+		for j+1 < len(indexes) && indexes[j+1] == indexes[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(indexes[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indexes[i], indexes[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles is the dashboard of the daemon, plain HTML, CSS and JavaScript
+// using the JSON API, so it works without any external assets
+//
+//go:embed web
+var webFiles embed.FS
+
+// webUI serves the dashboard
+func webUI() http.Handler {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
@@ -0,0 +1,300 @@
+// Dashboard of the daemon, it polls the JSON API and renders the list of
+// torrents or the details of the one in the URL fragment (#<info hash>)
+"use strict";
+
+const refreshInterval = 1000;
+
+const $ = (id) => document.getElementById(id);
+
+function formatBytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return i === 0 ? `${n} B` : `${n.toFixed(1)} ${units[i]}`;
+}
+
+function formatRate(bytesPerSecond) {
+  return `${formatBytes(Math.round(bytesPerSecond))}/s`;
+}
+
+function formatETA(seconds) {
+  if (seconds < 0) return "-";
+  if (seconds === 0) return "done";
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  const s = seconds % 60;
+  if (h > 0) return `${h}h ${m}m`;
+  if (m > 0) return `${m}m ${s}s`;
+  return `${s}s`;
+}
+
+// el creates an element with text content, so names are never parsed as HTML
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (className) e.className = className;
+  return e;
+}
+
+function progressBar(fraction) {
+  const bar = el("div", undefined, fraction >= 1 ? "bar done" : "bar");
+  const fill = el("div");
+  fill.style.width = `${(fraction * 100).toFixed(1)}%`;
+  bar.append(fill, el("span", `${(fraction * 100).toFixed(1)}%`));
+  return bar;
+}
+
+function button(label, onClick, className) {
+  const b = el("button", label, className);
+  b.type = "button";
+  b.addEventListener("click", (e) => {
+    e.stopPropagation();
+    onClick();
+  });
+  return b;
+}
+
+function showMessage(text, isError) {
+  const m = $("message");
+  m.textContent = text;
+  m.className = isError ? "error" : "";
+}
+
+async function api(method, path, body, contentType) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.body = body;
+    if (contentType) opts.headers["Content-Type"] = contentType;
+  }
+  const resp = await fetch(path, opts);
+  if (!resp.ok) {
+    let message = resp.statusText;
+    try {
+      message = (await resp.json()).error || message;
+    } catch (_) {}
+    throw new Error(message);
+  }
+  if (resp.status === 204) return null;
+  return resp.json();
+}
+
+function torrentPath(hash, rest = "") {
+  return `/api/torrents/${encodeURIComponent(hash)}${rest}`;
+}
+
+async function act(action) {
+  try {
+    await action();
+    refresh();
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+}
+
+function actionButtons(t) {
+  const buttons = [];
+  if (t.state === "connecting" || t.state === "downloading") {
+    buttons.push(button("Pause", () => act(() => api("POST", torrentPath(t.info_hash, "/pause")))));
+  } else {
+    buttons.push(button("Resume", () => act(() => api("POST", torrentPath(t.info_hash, "/resume")))));
+  }
+  buttons.push(button("Remove", () => {
+    if (!confirm(`Remove ${t.name}? Downloaded files are kept.`)) return;
+    act(async () => {
+      await api("DELETE", torrentPath(t.info_hash));
+      location.hash = "";
+    });
+  }, "danger"));
+  buttons.push(button("Delete", () => {
+    if (!confirm(`Remove ${t.name} and delete its files?`)) return;
+    act(async () => {
+      await api("DELETE", torrentPath(t.info_hash, "?delete=true"));
+      location.hash = "";
+    });
+  }, "danger"));
+  return buttons;
+}
+
+function stateCell(t) {
+  const td = el("td", t.state, `state-${t.state}`);
+  if (t.error) td.title = t.error;
+  return td;
+}
+
+function renderList(torrents) {
+  const body = $("torrents");
+  body.replaceChildren();
+  let rate = 0;
+  for (const t of torrents) {
+    rate += t.download_rate;
+    const row = el("tr");
+    const name = el("td", undefined, "name");
+    const link = el("a", t.name || t.info_hash);
+    link.href = `#${t.info_hash}`;
+    name.append(link);
+    const progress = el("td");
+    progress.append(progressBar(t.progress));
+    const actions = el("td", undefined, "actions");
+    actions.append(...actionButtons(t));
+    row.append(
+      name,
+      stateCell(t),
+      progress,
+      el("td", t.size ? formatBytes(t.size) : "-"),
+      el("td", formatRate(t.download_rate)),
+      el("td", formatETA(t.eta)),
+      el("td", String(t.peers)),
+      actions,
+    );
+    body.append(row);
+  }
+  $("empty").hidden = torrents.length > 0;
+  $("totals").textContent = `${torrents.length} torrents, ${formatRate(rate)}`;
+}
+
+function renderPieceMap(pieceMap) {
+  const canvas = $("piece-map");
+  const width = canvas.clientWidth || 800;
+  canvas.width = width;
+  const ctx = canvas.getContext("2d");
+  ctx.fillStyle = "#dde1e7";
+  ctx.fillRect(0, 0, width, canvas.height);
+  if (!pieceMap) return;
+  // every pixel column shows the share of its pieces that are verified
+  const n = pieceMap.length;
+  for (let x = 0; x < width; x++) {
+    const from = Math.floor((x * n) / width);
+    const to = Math.max(from + 1, Math.floor(((x + 1) * n) / width));
+    let have = 0;
+    for (let i = from; i < to; i++) {
+      if (pieceMap[i] === "1") have++;
+    }
+    if (have === 0) continue;
+    ctx.globalAlpha = have / (to - from);
+    ctx.fillStyle = "#2e9a5b";
+    ctx.fillRect(x, 0, 1, canvas.height);
+  }
+  ctx.globalAlpha = 1;
+}
+
+function renderDetail(t) {
+  $("detail-name").textContent = t.name || t.info_hash;
+  document.title = `${t.name} - bittorrent-client-go`;
+
+  const summary = $("detail-summary");
+  summary.replaceChildren();
+  const rows = [
+    ["Info hash", t.info_hash],
+    ["State", t.error ? `${t.state}: ${t.error}` : t.state],
+    ["Directory", t.out_dir],
+    ["Progress", `${(t.progress * 100).toFixed(1)}% of ${formatBytes(t.size)}`],
+    ["Downloaded", formatBytes(t.downloaded)],
+    ["Rate", formatRate(t.download_rate)],
+    ["ETA", formatETA(t.eta)],
+    ["Pieces", String(t.pieces)],
+  ];
+  for (const [k, v] of rows) summary.append(el("dt", k), el("dd", v));
+
+  $("detail-actions").replaceChildren(...actionButtons(t));
+  renderPieceMap(t.piece_map);
+
+  const files = $("files");
+  files.replaceChildren();
+  for (const f of t.files || []) {
+    const progress = el("td");
+    progress.append(progressBar(f.length ? f.completed / f.length : 1));
+    const row = el("tr");
+    row.append(el("td", String(f.index)), el("td", f.path, "name"), el("td", formatBytes(f.length)), progress, el("td", f.priority));
+    files.append(row);
+  }
+  if (!t.files) {
+    const row = el("tr");
+    const td = el("td", "The files are known once the torrent has connected.", "muted");
+    td.colSpan = 5;
+    row.append(td);
+    files.append(row);
+  }
+
+  const peers = $("peers");
+  peers.replaceChildren();
+  for (const p of t.peer_list || []) {
+    const row = el("tr");
+    row.append(
+      el("td", p.addr, "name"),
+      el("td", p.kind),
+      el("td", p.client || "-"),
+      el("td", formatBytes(p.downloaded)),
+      el("td", formatRate(p.download_rate)),
+    );
+    peers.append(row);
+  }
+  if (!t.peer_list) {
+    const row = el("tr");
+    const td = el("td", "Not downloading from anyone.", "muted");
+    td.colSpan = 5;
+    row.append(td);
+    peers.append(row);
+  }
+
+  const trackers = $("trackers");
+  trackers.replaceChildren();
+  for (const url of t.trackers || []) trackers.append(el("li", url));
+  if (!t.trackers) trackers.append(el("li", "none", "muted"));
+}
+
+let refreshing = false;
+
+async function refresh() {
+  if (refreshing) return;
+  refreshing = true;
+  const hash = location.hash.slice(1);
+  $("list-view").hidden = hash !== "";
+  $("detail-view").hidden = hash === "";
+  try {
+    if (hash) {
+      renderDetail(await api("GET", torrentPath(hash)));
+    } else {
+      document.title = "bittorrent-client-go";
+      renderList(await api("GET", "/api/torrents"));
+    }
+  } catch (err) {
+    showMessage(err.message, true);
+  } finally {
+    refreshing = false;
+  }
+}
+
+$("add-magnet").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const magnet = $("magnet").value.trim();
+  if (!magnet) return;
+  act(async () => {
+    const t = await api("POST", "/api/torrents", JSON.stringify({ magnet, out_dir: $("out-dir").value.trim() }), "application/json");
+    $("magnet").value = "";
+    showMessage(`Added ${t.name || t.info_hash}`);
+  });
+});
+
+$("add-file").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const file = $("torrent-file").files[0];
+  if (!file) return;
+  const form = new FormData();
+  form.append("torrent", file);
+  form.append("out_dir", $("out-dir").value.trim());
+  act(async () => {
+    const t = await api("POST", "/api/torrents", form);
+    $("torrent-file").value = "";
+    showMessage(`Added ${t.name}`);
+  });
+});
+
+window.addEventListener("hashchange", () => {
+  showMessage("");
+  refresh();
+});
+refresh();
+setInterval(refresh, refreshInterval);
@@ -0,0 +1,162 @@
+package webseed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getFTPRange fills buf from offset in the file at u, with a minimal passive
+// mode FTP session (RFC 959, REST from RFC 3659) that is closed afterwards,
+// or as soon as ctx is canceled
+func getFTPRange(ctx context.Context, u *url.URL, offset int, buf []byte) error {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("dialing ftp server: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	ctrl := textproto.NewConn(conn)
+	if _, _, err := ctrl.ReadResponse(220); err != nil {
+		return fmt.Errorf("reading ftp greeting: %w", err)
+	}
+
+	user, pass := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			pass = p
+		}
+	}
+	code, _, err := ftpCmd(ctrl, "USER "+user)
+	if err != nil {
+		return err
+	}
+	// 230 means no password is needed
+	if code == 331 {
+		if _, err := ftpExpect(ctrl, 230, "PASS "+pass); err != nil {
+			return err
+		}
+	} else if code != 230 {
+		return fmt.Errorf("ftp login rejected with %d", code)
+	}
+
+	if _, err := ftpExpect(ctrl, 200, "TYPE I"); err != nil {
+		return err
+	}
+
+	dataAddr, err := ftpPassive(ctrl, conn)
+	if err != nil {
+		return err
+	}
+	data, err := dialer.DialContext(ctx, "tcp", dataAddr)
+	if err != nil {
+		return fmt.Errorf("dialing ftp data connection: %w", err)
+	}
+	defer data.Close()
+	stopData := context.AfterFunc(ctx, func() { data.Close() })
+	defer stopData()
+	data.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if offset > 0 {
+		if _, err := ftpExpect(ctrl, 350, "REST "+strconv.Itoa(offset)); err != nil {
+			return err
+		}
+	}
+	code, msg, err := ftpCmd(ctrl, "RETR "+u.Path)
+	if err != nil {
+		return err
+	}
+	if code != 150 && code != 125 {
+		return fmt.Errorf("ftp RETR failed: %d %s", code, msg)
+	}
+
+	_, err = io.ReadFull(data, buf)
+	if err != nil {
+		return fmt.Errorf("reading ftp data: %w", err)
+	}
+	// the rest of the file isn't needed, closing the data connection aborts it
+	return nil
+}
+
+// ftpPassive switches to passive mode and returns the data connection address,
+// trying EPSV (RFC 2428) before PASV
+func ftpPassive(ctrl *textproto.Conn, conn net.Conn) (string, error) {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	code, msg, err := ftpCmd(ctrl, "EPSV")
+	if err != nil {
+		return "", err
+	}
+	if code == 229 {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			return net.JoinHostPort(host, msg[start+4:end]), nil
+		}
+	}
+
+	code, msg, err = ftpCmd(ctrl, "PASV")
+	if err != nil {
+		return "", err
+	}
+	if code != 227 {
+		return "", fmt.Errorf("ftp PASV failed: %d %s", code, msg)
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2), the host is ignored in
+	// favor of the control connection's to avoid servers behind NAT
+	start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("malformed PASV response: %s", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("malformed PASV response: %s", msg)
+	}
+	p1, err1 := strconv.Atoi(parts[4])
+	p2, err2 := strconv.Atoi(parts[5])
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("malformed PASV response: %s", msg)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p1*256+p2)), nil
+}
+
+// ftpCmd sends a command and reads the response, whatever its code
+func ftpCmd(ctrl *textproto.Conn, cmd string) (int, string, error) {
+	if err := ctrl.PrintfLine("%s", cmd); err != nil {
+		return 0, "", fmt.Errorf("sending ftp command: %w", err)
+	}
+	// any code is accepted, the caller decides which ones are errors
+	code, msg, err := ctrl.ReadResponse(0)
+	if err != nil {
+		return 0, "", fmt.Errorf("reading ftp response: %w", err)
+	}
+	return code, msg, nil
+}
+
+// ftpExpect sends a command and fails unless the response has the wanted code
+func ftpExpect(ctrl *textproto.Conn, want int, cmd string) (string, error) {
+	code, msg, err := ftpCmd(ctrl, cmd)
+	if err != nil {
+		return "", err
+	}
+	if code != want {
+		verb, _, _ := strings.Cut(cmd, " ")
+		return "", fmt.Errorf("ftp %s failed: %d %s", verb, code, msg)
+	}
+	return msg, nil
+}
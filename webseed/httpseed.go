@@ -0,0 +1,140 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+)
+
+// RetryAfterError is returned when a seed is busy, the piece should be
+// requested again from it after the wait
+type RetryAfterError struct {
+	Seed string
+	Wait time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s is busy, retry after %s", e.Seed, e.Wait)
+}
+
+// defaultRetryAfter is used when a busy seed doesn't say how long to wait
+const defaultRetryAfter = 30 * time.Second
+
+// HTTPSeed downloads pieces from a BEP0017 (Hoffman style) HTTP seed, a
+// script that serves pieces by info hash and index rather than by file
+type HTTPSeed struct {
+	URL        *url.URL
+	InfoHash   [20]byte
+	HTTPClient *http.Client
+	Logger     *slog.Logger // logs requests at debug level, nil discards the logs
+}
+
+// NewHTTPSeed returns a client for an `httpseeds` entry of the torrent
+func NewHTTPSeed(rawURL string, infoHash [20]byte) (*HTTPSeed, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing http seed url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported http seed protocol: %s", u.Scheme)
+	}
+
+	return &HTTPSeed{
+		URL:        u,
+		InfoHash:   infoHash,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *HTTPSeed) String() string {
+	return s.URL.String()
+}
+
+// Close is a no-op, requests don't share a connection that needs closing
+func (s *HTTPSeed) Close() error {
+	return nil
+}
+
+// GetPiece requests a whole piece with the `info_hash` and `piece` parameters
+// and checks its integrity like a piece from a peer
+//
+// A 503 response means the seed is busy, its body is the number of seconds to
+// wait which is returned as a *RetryAfterError
+func (s *HTTPSeed) GetPiece(index, length int, hash [20]byte) ([]byte, error) {
+	return s.GetPieceContext(context.Background(), index, length, hash)
+}
+
+// GetPieceContext is GetPiece that abandons the request when ctx is canceled
+func (s *HTTPSeed) GetPieceContext(ctx context.Context, index, length int, hash [20]byte) ([]byte, error) {
+	u := *s.URL
+	v := u.Query()
+	v.Set("info_hash", string(s.InfoHash[:]))
+	v.Set("piece", strconv.Itoa(index))
+	u.RawQuery = v.Encode()
+
+	log := logging.OrDiscard(s.Logger).With(logging.Peer, s.URL.Redacted(), logging.Piece, index)
+	log.Debug("requesting piece")
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		wait := retryAfter(resp)
+		log.Debug("http seed is busy", "wait", wait)
+		return nil, &RetryAfterError{Seed: s.URL.Redacted(), Wait: wait}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response status code: %d", resp.StatusCode)
+	}
+
+	pieceBuf := make([]byte, length)
+	_, err = io.ReadFull(resp.Body, pieceBuf)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading piece %d: %w", index, err)
+	}
+
+	// check integrity
+	pieceHash := sha1.Sum(pieceBuf)
+	if !bytes.Equal(pieceHash[:], hash[:]) {
+		return nil, fmt.Errorf("%w from %s", ErrIntegrity, s.URL.Redacted())
+	}
+
+	return pieceBuf, nil
+}
+
+// retryAfter reads the wait from a busy response's body, falling back to the
+// standard Retry-After header
+func retryAfter(resp *http.Response) time.Duration {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 32))
+	if seconds, err := strconv.Atoi(strings.TrimSpace(string(body))); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	// the header may also be a date
+	if date, err := http.ParseTime(header); err == nil && time.Until(date) > 0 {
+		return time.Until(date).Round(time.Second)
+	}
+	return defaultRetryAfter
+}
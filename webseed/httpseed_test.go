@@ -0,0 +1,104 @@
+package webseed
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPSeedGetPiece(t *testing.T) {
+	infoHash := [20]byte{1, 2, '&', '%'}
+	piece := data(100, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("info_hash") != string(infoHash[:]) || q.Get("piece") != "4" || q.Get("key") != "v" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(piece)
+	}))
+	defer srv.Close()
+
+	// the seed's own query is kept
+	s, err := NewHTTPSeed(srv.URL+"/seed.php?key=v", infoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetPiece(4, len(piece), sha1.Sum(piece))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, piece) {
+		t.Fatalf("piece is %x, want %x", got, piece)
+	}
+
+	_, err = s.GetPiece(4, len(piece), [20]byte{})
+	if !errors.Is(err, ErrIntegrity) {
+		t.Errorf("got error %v for the wrong hash", err)
+	}
+	_, err = s.GetPiece(5, len(piece), sha1.Sum(piece))
+	if err == nil || !strings.Contains(err.Error(), "status code: 404") {
+		t.Errorf("got error %v for a missing piece", err)
+	}
+	_, err = s.GetPiece(4, len(piece)+1, sha1.Sum(piece))
+	if err == nil || errors.Is(err, ErrIntegrity) {
+		t.Errorf("got error %v for a short piece", err)
+	}
+}
+
+func TestHTTPSeedBusy(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		retryAfter string
+		want       time.Duration
+	}{
+		{"seconds in body", "5\n", "", 5 * time.Second},
+		{"body before header", "5", "60", 5 * time.Second},
+		{"header", "", "7", 7 * time.Second},
+		{"header after junk body", "busy", "7", 7 * time.Second},
+		{"header date", "", time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 90 * time.Second},
+		{"past date", "", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), defaultRetryAfter},
+		{"zero", "0", "0", defaultRetryAfter},
+		{"nothing", "", "", defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			s, err := NewHTTPSeed(srv.URL+"/", [20]byte{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.GetPiece(0, 10, [20]byte{})
+			var busy *RetryAfterError
+			if !errors.As(err, &busy) {
+				t.Fatalf("got error %v, want a *RetryAfterError", err)
+			}
+			// dates are rounded to the second
+			if diff := busy.Wait - tt.want; diff < -time.Second || diff > time.Second {
+				t.Errorf("wait %s, want %s", busy.Wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPSeedSchemes(t *testing.T) {
+	for _, url := range []string{"ftp://a/", "file:///seed", "://"} {
+		if _, err := NewHTTPSeed(url, [20]byte{}); err == nil {
+			t.Errorf("%s was accepted", url)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/internal/logging"
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// ErrIntegrity is returned for pieces that don't match their hash
+var ErrIntegrity = errors.New("failed integrity check")
+
+// Client downloads pieces from a BEP0019 web seed, a plain HTTP or FTP server
+// hosting the torrent's files under the same layout they are downloaded to
+type Client struct {
+	URL        *url.URL
+	Torrent    torrentparser.TorrentFile
+	HTTPClient *http.Client
+	Logger     *slog.Logger // logs requests at debug level, nil discards the logs
+}
+
+// NewClient returns a client for a `url-list` entry of the torrent
+func NewClient(rawURL string, torrent torrentparser.TorrentFile) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing web seed url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ftp":
+	default:
+		return nil, fmt.Errorf("unsupported web seed protocol: %s", u.Scheme)
+	}
+
+	return &Client{
+		URL:        u,
+		Torrent:    torrent,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (c *Client) String() string {
+	return c.URL.String()
+}
+
+// Close is a no-op, requests don't share a connection that needs closing
+func (c *Client) Close() error {
+	return nil
+}
+
+// fileURL returns the URL of a file in the torrent
+//
+// A web seed URL ending in a slash is a directory that the torrent's name is
+// appended to, otherwise a single file torrent's URL is the file itself
+func (c *Client) fileURL(file torrentparser.File) *url.URL {
+	u := *c.URL
+	if len(c.Torrent.Files) == 1 && !strings.HasSuffix(u.Path, "/") {
+		return &u
+	}
+
+	// File.Path already starts with the torrent name
+	segments := strings.Split(file.Path, string(filepath.Separator))
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + "/" + strings.Join(segments, "/")
+	u.RawPath = ""
+	return &u
+}
+
+// GetPiece downloads a piece, with one range request for each file it spans,
+// and checks its integrity like a piece from a peer
+func (c *Client) GetPiece(index, length int, hash [20]byte) ([]byte, error) {
+	return c.GetPieceContext(context.Background(), index, length, hash)
+}
+
+// GetPieceContext is GetPiece that abandons its requests when ctx is canceled
+func (c *Client) GetPieceContext(ctx context.Context, index, length int, hash [20]byte) ([]byte, error) {
+	pieceBuf := make([]byte, length)
+	var read int
+	for _, span := range c.Torrent.FileSpans(index*c.Torrent.PieceLength, length) {
+		part := pieceBuf[read : read+span.Length]
+		read += span.Length
+
+		// padding files are zeros and never hosted by the web seed
+		file := c.Torrent.Files[span.FileIndex]
+		if file.Padding {
+			continue
+		}
+
+		u := c.fileURL(file)
+		logging.OrDiscard(c.Logger).Debug("requesting range",
+			logging.Peer, c.URL.Redacted(),
+			logging.Piece, index,
+			"url", u.Redacted(),
+			"offset", span.Offset,
+			"length", span.Length,
+		)
+		var err error
+		if u.Scheme == "ftp" {
+			err = getFTPRange(ctx, u, span.Offset, part)
+		} else {
+			err = c.getHTTPRange(ctx, u, span.Offset, part)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: %w", u.Redacted(), err)
+		}
+	}
+
+	// check integrity
+	pieceHash := sha1.Sum(pieceBuf)
+	if !bytes.Equal(pieceHash[:], hash[:]) {
+		return nil, fmt.Errorf("%w from %s", ErrIntegrity, c.URL.Redacted())
+	}
+
+	return pieceBuf, nil
+}
+
+// getHTTPRange fills buf from offset in the file at u with a Range request
+func (c *Client) getHTTPRange(ctx context.Context, u *url.URL, offset int, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create http request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(buf)-1))
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range and is sending the whole file
+		_, err = io.CopyN(io.Discard, resp.Body, int64(offset))
+		if err != nil {
+			return fmt.Errorf("skipping to range: %w", err)
+		}
+	default:
+		return fmt.Errorf("http response status code: %d", resp.StatusCode)
+	}
+
+	_, err = io.ReadFull(resp.Body, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("file is shorter than expected: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,254 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/givxl33t/bittorrent-client-go/torrentparser"
+)
+
+// testFile is a file of a test torrent, with its content
+type testFile struct {
+	path    string // slash separated, starting with the torrent name
+	data    []byte
+	padding bool
+}
+
+func data(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i)
+	}
+	return b
+}
+
+// testTorrent lays out files in pieces of pieceLength, returning the torrent
+// and the pieces' data
+func testTorrent(pieceLength int, files ...testFile) (torrentparser.TorrentFile, [][]byte) {
+	var all []byte
+	t := torrentparser.TorrentFile{Name: "t", PieceLength: pieceLength}
+	for _, f := range files {
+		t.Files = append(t.Files, torrentparser.File{
+			Path:    filepath.FromSlash(f.path),
+			Length:  len(f.data),
+			Padding: f.padding,
+		})
+		all = append(all, f.data...)
+	}
+	t.Length = len(all)
+	var pieces [][]byte
+	for len(all) > 0 {
+		n := min(pieceLength, len(all))
+		pieces = append(pieces, all[:n])
+		t.PieceHashes = append(t.PieceHashes, sha1.Sum(all[:n]))
+		all = all[n:]
+	}
+	return t, pieces
+}
+
+// fileServer serves files by path with Range support, recording the path and
+// range of every request
+type fileServer struct {
+	files map[string][]byte
+
+	mut      sync.Mutex
+	requests []string
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mut.Lock()
+	s.requests = append(s.requests, r.URL.Path+" "+r.Header.Get("Range"))
+	s.mut.Unlock()
+	data, ok := s.files[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *fileServer) took() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func newFileServer(t *testing.T, files ...testFile) (*fileServer, *httptest.Server) {
+	fs := &fileServer{files: map[string][]byte{}}
+	for _, f := range files {
+		if !f.padding {
+			fs.files["/seed/"+f.path] = f.data
+		}
+	}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+func TestGetPieceSpansFiles(t *testing.T) {
+	files := []testFile{
+		{path: "t/a", data: data(10, 0)},
+		{path: "t/.pad/6", data: make([]byte, 6), padding: true},
+		{path: "t/b", data: data(12, 50)},
+		{path: "t/dir/c d", data: data(20, 100)},
+	}
+	torrent, pieces := testTorrent(16, files...)
+	fs, srv := newFileServer(t, files...)
+	c, err := NewClient(srv.URL+"/seed/", torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one range request for every file a piece spans, padding is never
+	// requested
+	want := [][]string{
+		{"/seed/t/a bytes=0-9"},
+		{"/seed/t/b bytes=0-11", "/seed/t/dir/c d bytes=0-3"},
+		{"/seed/t/dir/c d bytes=4-19"},
+	}
+	for i, piece := range pieces {
+		got, err := c.GetPiece(i, len(piece), torrent.PieceHashes[i])
+		if err != nil {
+			t.Fatalf("piece %d: %s", i, err)
+		}
+		if !bytes.Equal(got, piece) {
+			t.Fatalf("piece %d is %x, want %x", i, got, piece)
+		}
+		if requests := fs.took(); fmt.Sprint(requests) != fmt.Sprint(want[i]) {
+			t.Errorf("piece %d requested %q, want %q", i, requests, want[i])
+		}
+	}
+}
+
+func TestGetPieceSingleFile(t *testing.T) {
+	files := []testFile{{path: "t", data: data(40, 7)}}
+	torrent, pieces := testTorrent(16, files...)
+	fs, srv := newFileServer(t, files...)
+
+	// a URL without a trailing slash is the file itself
+	fs.files["/mirror/renamed.bin"] = files[0].data
+	for _, url := range []string{srv.URL + "/seed/", srv.URL + "/mirror/renamed.bin"} {
+		c, err := NewClient(url, torrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.GetPiece(2, len(pieces[2]), torrent.PieceHashes[2])
+		if err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+		if !bytes.Equal(got, pieces[2]) {
+			t.Fatalf("%s: piece is %x, want %x", url, got, pieces[2])
+		}
+	}
+	if requests := fs.took(); requests[0] != "/seed/t bytes=32-39" || requests[1] != "/mirror/renamed.bin bytes=32-39" {
+		t.Errorf("requested %q", requests)
+	}
+}
+
+func TestGetPieceServerIgnoresRange(t *testing.T) {
+	files := []testFile{{path: "t", data: data(40, 7)}}
+	torrent, pieces := testTorrent(16, files...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[0].data)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL+"/", torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetPiece(1, len(pieces[1]), torrent.PieceHashes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, pieces[1]) {
+		t.Fatalf("piece is %x, want %x", got, pieces[1])
+	}
+}
+
+func TestGetPieceErrors(t *testing.T) {
+	files := []testFile{{path: "t", data: data(40, 7)}}
+	torrent, pieces := testTorrent(16, files...)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"not found", http.NotFound, "status code: 404"},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}, "status code: 500"},
+		{"range not satisfiable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		}, "status code: 416"},
+		{"short file", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(files[0].data[:20]))
+		}, "shorter than expected"},
+		{"wrong data", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(make([]byte, 40)))
+		}, ErrIntegrity.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			c, err := NewClient(srv.URL+"/", torrent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.GetPiece(1, len(pieces[1]), torrent.PieceHashes[1])
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPieceContextCanceled(t *testing.T) {
+	files := []testFile{{path: "t", data: data(40, 7)}}
+	torrent, pieces := testTorrent(16, files...)
+	stop := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer srv.Close()
+	defer close(stop)
+
+	c, err := NewClient(srv.URL+"/", torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = c.GetPieceContext(ctx, 0, len(pieces[0]), torrent.PieceHashes[0])
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the ctx's", err)
+	}
+}
+
+func TestNewClientSchemes(t *testing.T) {
+	for _, url := range []string{"http://a/", "https://a/", "ftp://a/"} {
+		if _, err := NewClient(url, torrentparser.TorrentFile{}); err != nil {
+			t.Errorf("%s: %s", url, err)
+		}
+	}
+	if _, err := NewClient("file:///etc/", torrentparser.TorrentFile{}); err == nil {
+		t.Error("file URL was accepted")
+	}
+}